@@ -0,0 +1,209 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// APNG在普通PNG的基础上增加了三种块：
+//   acTL 动画控制（帧数、循环次数），必须位于第一个IDAT之前
+//   fcTL 帧控制（尺寸、位置、延时等），每帧一个
+//   fdAT 帧数据（4字节序列号 + 压缩数据），用于第一帧之后的各帧
+// fcTL和fdAT共用一个从0开始连续递增的序列号。默认图像（IDAT）前有fcTL时即为动画的第一帧。
+// image/png 只解码默认图像，直接重新编码会丢失全部动画帧，因此APNG只替换第一帧的图像数据
+// （默认图像属于动画时为IDAT块，否则为第一组fdAT块），其余块（包括fcTL及全部序列号）保持逐字节不变
+
+// ErrUnsafeAPNGEdit APNG结构异常，无法在保证动画完整的前提下修改
+var ErrUnsafeAPNGEdit = errors.New("无法安全修改APNG动画")
+
+// apngInfo APNG的结构信息
+type apngInfo struct {
+	numFrames    int  // acTL中声明的帧数
+	defaultFrame bool // 默认图像是否为动画的第一帧
+}
+
+// isAPNG 判断PNG数据是否为APNG（第一个IDAT之前存在acTL块）
+func (m *ImageModifier) isAPNG(chunks []pngChunk) bool {
+	for _, chunk := range chunks {
+		switch chunk.chunkType {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+	}
+	return false
+}
+
+// checkAPNG 校验APNG结构：序列号连续、帧数与acTL一致、IDAT连续
+// 任一条件不满足时返回 ErrUnsafeAPNGEdit
+func (m *ImageModifier) checkAPNG(chunks []pngChunk) (*apngInfo, error) {
+	info := &apngInfo{numFrames: -1}
+	unsafe := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrUnsafeAPNGEdit, fmt.Sprintf(format, args...))
+	}
+
+	sequence := uint32(0)
+	frames := 0
+	idatSeen, idatEnded := false, false
+	// pendingFrame 最近一个fcTL之后是否还没有出现帧数据
+	pendingFrame := false
+
+	for _, chunk := range chunks {
+		if idatSeen && chunk.chunkType != "IDAT" {
+			idatEnded = true
+		}
+
+		switch chunk.chunkType {
+		case "acTL":
+			if info.numFrames >= 0 || idatSeen || len(chunk.data) != 8 {
+				return nil, unsafe("acTL块重复、位置错误或长度无效")
+			}
+			info.numFrames = int(binary.BigEndian.Uint32(chunk.data[0:4]))
+		case "fcTL", "fdAT":
+			if len(chunk.data) < 4 || (chunk.chunkType == "fcTL" && len(chunk.data) != 26) {
+				return nil, unsafe("%s块长度无效", chunk.chunkType)
+			}
+			if seq := binary.BigEndian.Uint32(chunk.data[0:4]); seq != sequence {
+				return nil, unsafe("%s块序列号为 %d，应为 %d", chunk.chunkType, seq, sequence)
+			}
+			sequence++
+
+			if chunk.chunkType == "fcTL" {
+				if pendingFrame {
+					return nil, unsafe("第 %d 帧缺少帧数据", frames)
+				}
+				frames++
+				pendingFrame = true
+				if !idatSeen {
+					info.defaultFrame = true
+				}
+			} else {
+				if !idatSeen || frames == 0 {
+					return nil, unsafe("fdAT块位于帧控制块或IDAT之前")
+				}
+				pendingFrame = false
+			}
+		case "IDAT":
+			if idatEnded {
+				return nil, unsafe("IDAT块不连续")
+			}
+			idatSeen = true
+			if info.defaultFrame && frames == 1 {
+				pendingFrame = false
+			}
+		}
+	}
+
+	if info.numFrames < 0 {
+		return nil, unsafe("缺少acTL块")
+	}
+	if pendingFrame {
+		return nil, unsafe("最后一帧缺少帧数据")
+	}
+	if frames != info.numFrames {
+		return nil, unsafe("acTL声明 %d 帧，实际有 %d 个fcTL块", info.numFrames, frames)
+	}
+
+	return info, nil
+}
+
+// modifyAPNGFirstFrame 默认图像不属于动画（第一个fcTL位于IDAT之后）时，改为微调动画第一帧（第一组fdAT）的像素
+// 第一帧与默认图像共用位深度、颜色类型、PLTE和tRNS，因此构造一个只包含该帧的PNG按普通PNG修改，
+// 再把新的图像数据按原有的fdAT块数拆分写回：序列号、fcTL以及其他块保持不变。
+// 像素坐标（包括 PixelMask）相对于第一帧的区域
+func (m *ImageModifier) modifyAPNGFirstFrame(data []byte, chunks []pngChunk, result *PixelModifyResult) ([]byte, error) {
+	unsafe := func(reason string) error {
+		return fmt.Errorf("%w: %s", ErrUnsafeAPNGEdit, reason)
+	}
+
+	// 第一个fcTL及其后的fdAT块（必须连续）
+	var fctl []byte
+	var frames []pngChunk
+	gap := false
+	for _, chunk := range chunks {
+		if fctl == nil {
+			if chunk.chunkType == "fcTL" {
+				fctl = chunk.data
+			}
+			continue
+		}
+		if chunk.chunkType == "fcTL" {
+			break
+		}
+		if chunk.chunkType != "fdAT" {
+			gap = len(frames) > 0
+			continue
+		}
+		if gap {
+			return nil, unsafe("第一帧的fdAT块不连续")
+		}
+		frames = append(frames, chunk)
+	}
+	if len(frames) == 0 {
+		return nil, unsafe("第一帧缺少fdAT块")
+	}
+
+	// 只包含第一帧的PNG：IHDR改为帧的尺寸，保留IDAT之前除动画控制块以外的块
+	ihdr := append([]byte(nil), chunks[0].data...)
+	copy(ihdr[0:8], fctl[4:12])
+	var frame bytes.Buffer
+	frame.Write(pngSignature)
+	frame.Write(buildPNGChunk("IHDR", ihdr))
+	for _, chunk := range chunks[1:] {
+		if chunk.chunkType == "IDAT" {
+			break
+		}
+		if chunk.chunkType != "acTL" {
+			frame.Write(data[chunk.start:chunk.end])
+		}
+	}
+	var zdata []byte
+	for _, chunk := range frames {
+		zdata = append(zdata, chunk.data[4:]...)
+	}
+	frame.Write(buildPNGChunk("IDAT", zdata))
+	frame.Write(buildPNGChunk("IEND", nil))
+
+	modified, err := m.modifyPNGPixel(frame.Bytes(), result)
+	if err != nil {
+		return nil, err
+	}
+	modifiedChunks, err := m.parsePNGChunks(modified)
+	if err != nil {
+		return nil, err
+	}
+	zdata = nil
+	for _, chunk := range modifiedChunks {
+		if chunk.chunkType == "IDAT" {
+			zdata = append(zdata, chunk.data...)
+		}
+	}
+	if len(zdata) < len(frames) {
+		return nil, unsafe("新的帧数据无法拆分为原有数量的fdAT块")
+	}
+	// 透明像素策略可能改写了透明调色板项的RGB，PLTE为所有帧共用，同样写回
+	for _, chunk := range modifiedChunks {
+		if chunk.chunkType == "PLTE" {
+			if data, err = replacePNGChunkData(data, chunks, "PLTE", chunk.data); err != nil {
+				return nil, unsafe(err.Error())
+			}
+		}
+	}
+
+	// 按原有的fdAT块数拆分，每块沿用原来的序列号
+	var buf bytes.Buffer
+	buf.Write(data[:frames[0].start])
+	size := len(zdata) / len(frames)
+	for i, chunk := range frames {
+		piece := zdata[i*size:]
+		if i < len(frames)-1 {
+			piece = piece[:size]
+		}
+		buf.Write(buildPNGChunk("fdAT", append(append([]byte(nil), chunk.data[:4]...), piece...)))
+	}
+	buf.Write(data[frames[len(frames)-1].end:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,373 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encodeTestPNGFrame 使用 image/png 编码一帧，返回其全部块
+func encodeTestPNGFrame(t testing.TB, shade uint8) []pngChunk {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{shade, uint8(x * 30), uint8(y * 30), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+	chunks, err := NewImageModifier().parsePNGChunks(buf.Bytes())
+	if err != nil {
+		t.Fatalf("解析PNG失败: %v", err)
+	}
+	return chunks
+}
+
+// createTestAPNG 创建两帧的APNG：默认图像为第一帧，第二帧存放在fdAT中
+func createTestAPNG(t testing.TB) []byte {
+	first := encodeTestPNGFrame(t, 10)
+	second := encodeTestPNGFrame(t, 200)
+
+	fcTL := func(sequence uint32) []byte {
+		data := make([]byte, 26)
+		binary.BigEndian.PutUint32(data[0:4], sequence)
+		binary.BigEndian.PutUint32(data[4:8], 8)
+		binary.BigEndian.PutUint32(data[8:12], 8)
+		binary.BigEndian.PutUint16(data[20:22], 1)
+		binary.BigEndian.PutUint16(data[22:24], 10)
+		return buildPNGChunk("fcTL", data)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	for _, chunk := range first {
+		switch chunk.chunkType {
+		case "IHDR":
+			buf.Write(buildPNGChunk("IHDR", chunk.data))
+			buf.Write(buildPNGChunk("acTL", []byte{0, 0, 0, 2, 0, 0, 0, 0}))
+		case "IDAT":
+			buf.Write(fcTL(0))
+			buf.Write(buildPNGChunk("IDAT", chunk.data))
+		}
+	}
+	buf.Write(fcTL(1))
+	for _, chunk := range second {
+		if chunk.chunkType == "IDAT" {
+			buf.Write(buildPNGChunk("fdAT", append([]byte{0, 0, 0, 2}, chunk.data...)))
+		}
+	}
+	buf.Write(buildPNGChunk("IEND", nil))
+	return buf.Bytes()
+}
+
+// animationChunks 返回除IDAT以外的全部块（用于比较动画结构是否保持不变）
+func animationChunks(t *testing.T, data []byte) [][]byte {
+	chunks, err := NewImageModifier().parsePNGChunks(data)
+	if err != nil {
+		t.Fatalf("解析PNG失败: %v", err)
+	}
+	var result [][]byte
+	for _, chunk := range chunks {
+		if chunk.chunkType != "IDAT" {
+			result = append(result, data[chunk.start:chunk.end])
+		}
+	}
+	return result
+}
+
+func TestModifyAPNGPixelKeepsAnimation(t *testing.T) {
+	modifier := NewImageModifier()
+	data := createTestAPNG(t)
+
+	chunks, err := modifier.parsePNGChunks(data)
+	if err != nil {
+		t.Fatalf("解析APNG失败: %v", err)
+	}
+	if !modifier.isAPNG(chunks) {
+		t.Fatal("未识别为APNG")
+	}
+
+	modified, err := modifier.modifyPNGPixel(data, &PixelModifyResult{})
+	if err != nil {
+		t.Fatalf("APNG像素微调失败: %v", err)
+	}
+
+	before := animationChunks(t, data)
+	after := animationChunks(t, modified)
+	if len(before) != len(after) {
+		t.Fatalf("块数量变化: %d -> %d", len(before), len(after))
+	}
+	for i := range before {
+		if !bytes.Equal(before[i], after[i]) {
+			t.Errorf("第 %d 个非IDAT块发生了变化", i)
+		}
+	}
+
+	newChunks, _ := modifier.parsePNGChunks(modified)
+	if _, err := modifier.checkAPNG(newChunks); err != nil {
+		t.Errorf("修改后的APNG结构无效: %v", err)
+	}
+
+	original, _ := png.Decode(bytes.NewReader(data))
+	result, err := png.Decode(bytes.NewReader(modified))
+	if err != nil {
+		t.Fatalf("解码修改后的APNG失败: %v", err)
+	}
+	diff := 0
+	bounds := original.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if original.At(x, y) != result.At(x, y) {
+				diff++
+			}
+		}
+	}
+	if diff != 1 {
+		t.Errorf("应恰好修改1个像素，实际修改了 %d 个", diff)
+	}
+}
+
+// decodeTestAPNGFrame 把fdAT块中的帧数据放入IDAT后解码
+func decodeTestAPNGFrame(t *testing.T, chunks []pngChunk) image.Image {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	buf.Write(buildPNGChunk("IHDR", chunks[0].data))
+	var zdata []byte
+	for _, chunk := range chunks {
+		if chunk.chunkType == "fdAT" {
+			zdata = append(zdata, chunk.data[4:]...)
+		}
+	}
+	buf.Write(buildPNGChunk("IDAT", zdata))
+	buf.Write(buildPNGChunk("IEND", nil))
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("解码帧数据失败: %v", err)
+	}
+	return img
+}
+
+func TestModifyAPNGPixelHiddenDefaultImage(t *testing.T) {
+	// 默认图像不属于动画，唯一的一帧拆分在两个fdAT块中
+	var idat, fdat []byte
+	for _, chunk := range encodeTestPNGFrame(t, 10) {
+		if chunk.chunkType == "IDAT" {
+			idat = chunk.data
+		}
+	}
+	for _, chunk := range encodeTestPNGFrame(t, 200) {
+		if chunk.chunkType == "IDAT" {
+			fdat = chunk.data
+		}
+	}
+	fcTL := make([]byte, 26)
+	binary.BigEndian.PutUint32(fcTL[4:8], 8)
+	binary.BigEndian.PutUint32(fcTL[8:12], 8)
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	buf.Write(buildPNGChunk("IHDR", encodeTestPNGFrame(t, 0)[0].data))
+	buf.Write(buildPNGChunk("acTL", []byte{0, 0, 0, 1, 0, 0, 0, 0}))
+	buf.Write(buildPNGChunk("IDAT", idat))
+	buf.Write(buildPNGChunk("fcTL", fcTL))
+	buf.Write(buildPNGChunk("fdAT", append([]byte{0, 0, 0, 1}, fdat[:10]...)))
+	buf.Write(buildPNGChunk("fdAT", append([]byte{0, 0, 0, 2}, fdat[10:]...)))
+	buf.Write(buildPNGChunk("IEND", nil))
+	data := buf.Bytes()
+
+	modifier := NewImageModifier()
+	modified, err := modifier.modifyPNGPixel(data, &PixelModifyResult{})
+	if err != nil {
+		t.Fatalf("APNG像素微调失败: %v", err)
+	}
+
+	chunks, _ := modifier.parsePNGChunks(data)
+	newChunks, err := modifier.parsePNGChunks(modified)
+	if err != nil {
+		t.Fatalf("解析修改后的APNG失败: %v", err)
+	}
+	if _, err := modifier.checkAPNG(newChunks); err != nil {
+		t.Errorf("修改后的APNG结构无效: %v", err)
+	}
+	if len(newChunks) != len(chunks) {
+		t.Fatalf("块数量变化: %d -> %d", len(chunks), len(newChunks))
+	}
+	for i, chunk := range chunks {
+		if chunk.chunkType != "fdAT" && !bytes.Equal(data[chunk.start:chunk.end], modified[newChunks[i].start:newChunks[i].end]) {
+			t.Errorf("%s 块发生了变化", chunk.chunkType)
+		}
+	}
+
+	original, result := decodeTestAPNGFrame(t, chunks), decodeTestAPNGFrame(t, newChunks)
+	diff := 0
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if original.At(x, y) != result.At(x, y) {
+				diff++
+			}
+		}
+	}
+	if diff != 1 {
+		t.Errorf("第一帧应恰好修改1个像素，实际修改了 %d 个", diff)
+	}
+}
+
+func TestModifyAPNGPixelRefusesBrokenSequence(t *testing.T) {
+	data := createTestAPNG(t)
+	modifier := NewImageModifier()
+	chunks, _ := modifier.parsePNGChunks(data)
+
+	// 将fdAT的序列号改为3，使序列号不连续
+	var broken []byte
+	broken = append(broken, pngSignature...)
+	for _, chunk := range chunks {
+		if chunk.chunkType == "fdAT" {
+			payload := append([]byte{0, 0, 0, 3}, chunk.data[4:]...)
+			broken = append(broken, buildPNGChunk("fdAT", payload)...)
+			continue
+		}
+		broken = append(broken, data[chunk.start:chunk.end]...)
+	}
+
+	testFile := filepath.Join(t.TempDir(), "broken.png")
+	if err := os.WriteFile(testFile, broken, 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+
+	_, err := modifier.ModifyImageSHA1ByPixel(testFile)
+	if !errors.Is(err, ErrUnsafeAPNGEdit) {
+		t.Fatalf("应返回 ErrUnsafeAPNGEdit，实际为: %v", err)
+	}
+
+	after, _ := os.ReadFile(testFile)
+	if !bytes.Equal(after, broken) {
+		t.Error("拒绝修改时文件不应被改写")
+	}
+}
+
+func TestAPNGTextChunkBeforeFirstFrame(t *testing.T) {
+	modifier := NewImageModifier()
+	data := createTestAPNG(t)
+
+	modified, err := modifier.modifyPNGSHA1(data)
+	if err != nil {
+		t.Fatalf("随机数据模式失败: %v", err)
+	}
+	metadataModified, err := modifier.modifyPNGMetadata(data, &ImageMetadata{Title: "动画"})
+	if err != nil {
+		t.Fatalf("元数据模式失败: %v", err)
+	}
+
+	for _, result := range [][]byte{modified, metadataModified} {
+		chunks, err := modifier.parsePNGChunks(result)
+		if err != nil {
+			t.Fatalf("解析结果失败: %v", err)
+		}
+		textIndex, frameIndex := -1, -1
+		for i, chunk := range chunks {
+			if chunk.chunkType == "tEXt" && textIndex < 0 {
+				textIndex = i
+			}
+			if chunk.chunkType == "fcTL" && frameIndex < 0 {
+				frameIndex = i
+			}
+		}
+		if textIndex < 0 || textIndex > frameIndex {
+			t.Errorf("tEXt块应位于第一个fcTL之前，实际位置 %d（fcTL位于 %d）", textIndex, frameIndex)
+		}
+		if _, err := modifier.checkAPNG(chunks); err != nil {
+			t.Errorf("插入文本块后APNG结构无效: %v", err)
+		}
+	}
+}
+
+func TestEncodePNGImageDataPreservesFormat(t *testing.T) {
+	modifier := NewImageModifier()
+	palette := color.Palette{
+		color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 0, 0, 255},
+		color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 0, 255, 128},
+	}
+
+	tests := []struct {
+		name   string
+		header pngHeader
+		img    image.Image
+	}{
+		{"gray1", pngHeader{width: 13, height: 5, bitDepth: 1, colorType: pngColorGray}, image.NewGray(image.Rect(0, 0, 13, 5))},
+		{"gray2 interlaced", pngHeader{width: 11, height: 9, bitDepth: 2, colorType: pngColorGray, interlace: 1}, image.NewGray(image.Rect(0, 0, 11, 9))},
+		{"gray4", pngHeader{width: 7, height: 3, bitDepth: 4, colorType: pngColorGray}, image.NewGray(image.Rect(0, 0, 7, 3))},
+		{"gray16", pngHeader{width: 6, height: 6, bitDepth: 16, colorType: pngColorGray}, image.NewGray16(image.Rect(0, 0, 6, 6))},
+		{"palette2", pngHeader{width: 9, height: 4, bitDepth: 2, colorType: pngColorPalette}, image.NewPaletted(image.Rect(0, 0, 9, 4), palette)},
+		{"rgb16 interlaced", pngHeader{width: 10, height: 10, bitDepth: 16, colorType: pngColorRGB, interlace: 1}, image.NewRGBA64(image.Rect(0, 0, 10, 10))},
+		{"gray alpha", pngHeader{width: 5, height: 5, bitDepth: 8, colorType: pngColorGrayAlpha}, image.NewNRGBA(image.Rect(0, 0, 5, 5))},
+		{"rgba16", pngHeader{width: 4, height: 4, bitDepth: 16, colorType: pngColorRGBA}, image.NewNRGBA64(image.Rect(0, 0, 4, 4))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &tt.header
+			bounds := tt.img.Bounds()
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					samples := make([]int, h.channels())
+					for c := range samples {
+						samples[c] = (x*7 + y*13 + c*29) % (h.maxSample() + 1)
+					}
+					if h.colorType == pngColorPalette {
+						samples[0] %= len(palette)
+					}
+					h.setSamples(tt.img, x, y, samples)
+				}
+			}
+
+			zdata, err := modifier.encodePNGImageData(h, tt.img)
+			if err != nil {
+				t.Fatalf("编码失败: %v", err)
+			}
+
+			ihdr := make([]byte, 13)
+			binary.BigEndian.PutUint32(ihdr[0:4], uint32(h.width))
+			binary.BigEndian.PutUint32(ihdr[4:8], uint32(h.height))
+			ihdr[8], ihdr[9], ihdr[12] = byte(h.bitDepth), byte(h.colorType), byte(h.interlace)
+
+			var file bytes.Buffer
+			file.Write(pngSignature)
+			file.Write(buildPNGChunk("IHDR", ihdr))
+			if h.colorType == pngColorPalette {
+				var plte, trns []byte
+				for _, c := range palette {
+					n := c.(color.NRGBA)
+					plte = append(plte, n.R, n.G, n.B)
+					trns = append(trns, n.A)
+				}
+				file.Write(buildPNGChunk("PLTE", plte))
+				file.Write(buildPNGChunk("tRNS", trns))
+			}
+			file.Write(modifier.buildIDATChunks(zdata, pngIDATChunkSize))
+			file.Write(buildPNGChunk("IEND", nil))
+
+			decoded, err := png.Decode(bytes.NewReader(file.Bytes()))
+			if err != nil {
+				t.Fatalf("解码失败: %v", err)
+			}
+			if err := h.checkImageType(decoded); err != nil {
+				t.Fatal(err)
+			}
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					if !equalSamples(h.samples(decoded, x, y), h.samples(tt.img, x, y)) {
+						t.Fatalf("像素 (%d,%d) 不一致", x, y)
+					}
+				}
+			}
+		})
+	}
+}
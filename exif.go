@@ -0,0 +1,249 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EXIF（TIFF结构）中使用的标签
+const (
+	exifTagImageDescription = 0x010E
+	exifTagMake             = 0x010F
+	exifTagModel            = 0x0110
+	exifTagSoftware         = 0x0131
+	exifTagDateTime         = 0x0132
+	exifTagArtist           = 0x013B
+	exifTagCopyright        = 0x8298
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+	exifTagPixelXDimension  = 0xA002
+	exifTagPixelYDimension  = 0xA003
+
+	exifTypeASCII = 2
+	exifTypeShort = 3
+	exifTypeLong  = 4
+
+	exifDateTimeLayout = "2006:01:02 15:04:05"
+)
+
+// exifEntry 待写入的IFD项
+type exifEntry struct {
+	tag      uint16
+	dataType uint16
+	count    uint32
+	value    []byte // 已按大端序编码的值
+}
+
+// buildEXIF 将元数据构造成TIFF格式的EXIF数据（大端序）
+// 结构：TIFF头 + IFD0（基本信息）+ Exif子IFD（拍摄时间和尺寸）
+func (m *ImageModifier) buildEXIF(metadata *ImageMetadata) []byte {
+	ascii := func(tag uint16, value string) *exifEntry {
+		if value == "" {
+			return nil
+		}
+		data := append([]byte(value), 0)
+		return &exifEntry{tag: tag, dataType: exifTypeASCII, count: uint32(len(data)), value: data}
+	}
+	long := func(tag uint16, value uint32) *exifEntry {
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, value)
+		return &exifEntry{tag: tag, dataType: exifTypeLong, count: 1, value: data}
+	}
+
+	var ifd0, exifIFD []*exifEntry
+	ifd0 = append(ifd0,
+		ascii(exifTagImageDescription, metadata.Description),
+		ascii(exifTagMake, metadata.CameraMake),
+		ascii(exifTagModel, metadata.CameraModel),
+		ascii(exifTagSoftware, metadata.Software),
+		ascii(exifTagArtist, metadata.Artist),
+		ascii(exifTagCopyright, metadata.Copyright),
+	)
+	if metadata.DateTime != nil {
+		dateTime := metadata.DateTime.Format(exifDateTimeLayout)
+		ifd0 = append(ifd0, ascii(exifTagDateTime, dateTime))
+		exifIFD = append(exifIFD, ascii(exifTagDateTimeOriginal, dateTime))
+	}
+	if metadata.ImageWidth > 0 {
+		exifIFD = append(exifIFD, long(exifTagPixelXDimension, uint32(metadata.ImageWidth)))
+	}
+	if metadata.ImageHeight > 0 {
+		exifIFD = append(exifIFD, long(exifTagPixelYDimension, uint32(metadata.ImageHeight)))
+	}
+
+	ifd0 = compactEXIFEntries(ifd0)
+	exifIFD = compactEXIFEntries(exifIFD)
+
+	// 先占位Exif子IFD指针，写完IFD0后再回填
+	var exifPointer *exifEntry
+	if len(exifIFD) > 0 {
+		exifPointer = long(exifTagExifIFD, 0)
+		ifd0 = append(ifd0, exifPointer)
+	}
+	sortEXIFEntries(ifd0)
+
+	var buf bytes.Buffer
+	buf.Write([]byte{'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08}) // TIFF头，IFD0紧随其后
+
+	ifd0Start := buf.Len()
+	ifd0Bytes := m.encodeEXIFIFD(ifd0, uint32(ifd0Start))
+	if exifPointer != nil {
+		exifStart := uint32(ifd0Start + len(ifd0Bytes))
+		binary.BigEndian.PutUint32(exifPointer.value, exifStart)
+		ifd0Bytes = m.encodeEXIFIFD(ifd0, uint32(ifd0Start))
+		buf.Write(ifd0Bytes)
+		buf.Write(m.encodeEXIFIFD(exifIFD, exifStart))
+	} else {
+		buf.Write(ifd0Bytes)
+	}
+
+	return buf.Bytes()
+}
+
+// compactEXIFEntries 去掉空项
+func compactEXIFEntries(entries []*exifEntry) []*exifEntry {
+	result := entries[:0]
+	for _, entry := range entries {
+		if entry != nil {
+			result = append(result, entry)
+		}
+	}
+	sortEXIFEntries(result)
+	return result
+}
+
+// sortEXIFEntries 按标签排序（TIFF规范要求IFD项按标签升序排列）
+func sortEXIFEntries(entries []*exifEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].tag < entries[j].tag
+	})
+}
+
+// encodeEXIFIFD 编码单个IFD，offset 为该IFD在TIFF数据中的起始位置
+// 超过4字节的值存放在IFD之后的数据区
+func (m *ImageModifier) encodeEXIFIFD(entries []*exifEntry, offset uint32) []byte {
+	ifdSize := 2 + len(entries)*12 + 4
+	dataOffset := offset + uint32(ifdSize)
+
+	var ifd, extra bytes.Buffer
+	binary.Write(&ifd, binary.BigEndian, uint16(len(entries)))
+	for _, entry := range entries {
+		binary.Write(&ifd, binary.BigEndian, entry.tag)
+		binary.Write(&ifd, binary.BigEndian, entry.dataType)
+		binary.Write(&ifd, binary.BigEndian, entry.count)
+		if len(entry.value) <= 4 {
+			value := make([]byte, 4)
+			copy(value, entry.value)
+			ifd.Write(value)
+		} else {
+			binary.Write(&ifd, binary.BigEndian, dataOffset+uint32(extra.Len()))
+			extra.Write(entry.value)
+			if extra.Len()%2 == 1 {
+				extra.WriteByte(0) // 值的偏移按字对齐
+			}
+		}
+	}
+	binary.Write(&ifd, binary.BigEndian, uint32(0)) // 没有下一个IFD
+
+	ifd.Write(extra.Bytes())
+	return ifd.Bytes()
+}
+
+// parseEXIF 从TIFF格式的EXIF数据中解析元数据（已有值的字段不覆盖）
+func (m *ImageModifier) parseEXIF(data []byte, metadata *ImageMetadata) {
+	if len(data) < 8 {
+		return
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	if order.Uint16(data[2:4]) != 0x2A {
+		return
+	}
+
+	values := make(map[uint16][]byte)
+	longs := make(map[uint16]uint32)
+
+	// readIFD 读取一个IFD中的ASCII和整数值
+	readIFD := func(offset uint32) {
+		if uint64(offset)+2 > uint64(len(data)) {
+			return
+		}
+		count := int(order.Uint16(data[offset:]))
+		for i := 0; i < count; i++ {
+			pos := uint64(offset) + 2 + uint64(i)*12
+			if pos+12 > uint64(len(data)) {
+				return
+			}
+			entry := data[pos : pos+12]
+			tag := order.Uint16(entry[0:2])
+			dataType := order.Uint16(entry[2:4])
+			valueCount := order.Uint32(entry[4:8])
+
+			switch dataType {
+			case exifTypeASCII:
+				value := entry[8:12]
+				if valueCount > 4 {
+					valueOffset := uint64(order.Uint32(entry[8:12]))
+					if valueOffset+uint64(valueCount) > uint64(len(data)) {
+						continue
+					}
+					value = data[valueOffset : valueOffset+uint64(valueCount)]
+				} else {
+					value = value[:valueCount]
+				}
+				values[tag] = value
+			case exifTypeShort:
+				longs[tag] = uint32(order.Uint16(entry[8:10]))
+			case exifTypeLong:
+				longs[tag] = order.Uint32(entry[8:12])
+			}
+		}
+	}
+
+	readIFD(order.Uint32(data[4:8]))
+	if exifOffset, ok := longs[exifTagExifIFD]; ok {
+		readIFD(exifOffset)
+	}
+
+	text := func(tag uint16) string {
+		return strings.TrimSpace(strings.TrimRight(string(values[tag]), "\x00"))
+	}
+	setString := func(field *string, tag uint16) {
+		if *field == "" {
+			*field = text(tag)
+		}
+	}
+
+	setString(&metadata.Description, exifTagImageDescription)
+	setString(&metadata.CameraMake, exifTagMake)
+	setString(&metadata.CameraModel, exifTagModel)
+	setString(&metadata.Software, exifTagSoftware)
+	setString(&metadata.Artist, exifTagArtist)
+	setString(&metadata.Copyright, exifTagCopyright)
+
+	if metadata.DateTime == nil {
+		for _, tag := range []uint16{exifTagDateTimeOriginal, exifTagDateTime} {
+			if t, err := time.Parse(exifDateTimeLayout, text(tag)); err == nil {
+				metadata.DateTime = &t
+				break
+			}
+		}
+	}
+	if width, ok := longs[exifTagPixelXDimension]; ok && metadata.ImageWidth == 0 {
+		metadata.ImageWidth = int(width)
+	}
+	if height, ok := longs[exifTagPixelYDimension]; ok && metadata.ImageHeight == 0 {
+		metadata.ImageHeight = int(height)
+	}
+}
@@ -0,0 +1,343 @@
+package imagemodify
+
+import (
+	"bytes"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"image/gif"
+	"io"
+)
+
+// GIF文件结构：文件头（6字节）+ 逻辑屏幕描述符（7字节）+ 可选的全局颜色表，
+// 之后是若干扩展块（0x21）和图像块（0x2C），以0x3B结束。
+// 图像块由图像描述符、可选的局部颜色表、LZW最小码长和LZW数据子块组成。
+
+// gifImageInfo 一个图像块的位置和参数
+type gifImageInfo struct {
+	descriptor  int // 图像描述符（0x2C）的位置
+	localTable  int // 局部颜色表的位置（没有时为-1）
+	localSize   int // 局部颜色表的项数
+	dataStart   int // LZW最小码长字节的位置
+	dataEnd     int // 数据子块（含结束块）之后的位置
+	width       int
+	height      int
+	control     int // 之前最近的图形控制扩展的位置（没有时为-1）
+	transparent bool
+}
+
+// gifFile GIF文件的结构信息
+type gifFile struct {
+	globalTable int // 全局颜色表的位置（没有时为-1）
+	globalSize  int
+	images      []gifImageInfo
+	trailer     int // 结束符的位置
+}
+
+// parseGIF 解析GIF文件的块结构
+// 扩展块和图像块的数量受 MaxChunks 限制，每个图像块的尺寸在解压之前按 MaxPixels 检查
+func (m *ImageModifier) parseGIF(data []byte) (*gifFile, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, fmt.Errorf("不是有效的GIF文件")
+	}
+
+	file := &gifFile{globalTable: -1}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		file.globalTable = pos
+		file.globalSize = 1 << uint(data[10]&0x07+1)
+		pos += 3 * file.globalSize
+	}
+
+	control, transparent := -1, false
+	blocks := int64(0)
+	for {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("GIF文件缺少结束符")
+		}
+		if data[pos] != 0x3B {
+			blocks++
+			if err := checkLimit("MaxChunks", blocks, resolveLimit(m.Limits.MaxChunks, defaultMaxChunks)); err != nil {
+				return nil, err
+			}
+		}
+		switch data[pos] {
+		case 0x3B:
+			file.trailer = pos
+			return file, nil
+		case 0x21:
+			if pos+2 > len(data) {
+				return nil, fmt.Errorf("GIF扩展块不完整")
+			}
+			if data[pos+1] == 0xF9 {
+				if pos+8 > len(data) || data[pos+2] != 4 {
+					return nil, fmt.Errorf("GIF图形控制扩展无效")
+				}
+				control, transparent = pos, data[pos+3]&0x01 != 0
+			}
+			end, err := skipGIFSubBlocks(data, pos+2)
+			if err != nil {
+				return nil, err
+			}
+			pos = end
+		case 0x2C:
+			if pos+10 > len(data) {
+				return nil, fmt.Errorf("GIF图像描述符不完整")
+			}
+			img := gifImageInfo{
+				descriptor:  pos,
+				localTable:  -1,
+				width:       int(data[pos+5]) | int(data[pos+6])<<8,
+				height:      int(data[pos+7]) | int(data[pos+8])<<8,
+				control:     control,
+				transparent: transparent,
+			}
+			if err := m.checkPixels(img.width, img.height); err != nil {
+				return nil, err
+			}
+			packed := data[pos+9]
+			pos += 10
+			if packed&0x80 != 0 {
+				img.localTable = pos
+				img.localSize = 1 << uint(packed&0x07+1)
+				pos += 3 * img.localSize
+			}
+			if pos >= len(data) {
+				return nil, fmt.Errorf("GIF图像数据不完整")
+			}
+			img.dataStart = pos
+			end, err := skipGIFSubBlocks(data, pos+1)
+			if err != nil {
+				return nil, err
+			}
+			img.dataEnd = end
+			pos = end
+			file.images = append(file.images, img)
+			control, transparent = -1, false
+		default:
+			return nil, fmt.Errorf("GIF块类型无效（位置 %d）", pos)
+		}
+	}
+}
+
+// skipGIFSubBlocks 跳过从 pos 开始的数据子块，返回结束块之后的位置
+func skipGIFSubBlocks(data []byte, pos int) (int, error) {
+	for {
+		if pos >= len(data) {
+			return 0, fmt.Errorf("GIF数据子块不完整")
+		}
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos, nil
+		}
+		pos += size
+	}
+}
+
+// readGIFSubBlocks 拼接从 pos 开始的数据子块
+func readGIFSubBlocks(data []byte, pos int) []byte {
+	var result []byte
+	for pos < len(data) && data[pos] != 0 {
+		size := int(data[pos])
+		result = append(result, data[pos+1:pos+1+size]...)
+		pos += 1 + size
+	}
+	return result
+}
+
+// decodeGIFIndices 解压一个图像块的像素索引
+func decodeGIFIndices(data []byte, img *gifImageInfo) ([]byte, int, error) {
+	litWidth := int(data[img.dataStart])
+	if litWidth < 2 || litWidth > 8 {
+		return nil, 0, fmt.Errorf("GIF的LZW最小码长无效: %d", litWidth)
+	}
+	r := lzw.NewReader(bytes.NewReader(readGIFSubBlocks(data, img.dataStart+1)), lzw.LSB, litWidth)
+	defer r.Close()
+	indices := make([]byte, img.width*img.height)
+	if _, err := io.ReadFull(r, indices); err != nil {
+		return nil, 0, fmt.Errorf("解压GIF图像数据失败: %v", err)
+	}
+	return indices, litWidth, nil
+}
+
+// encodeGIFIndices 压缩像素索引，写成LZW最小码长 + 数据子块
+func encodeGIFIndices(indices []byte, litWidth int) ([]byte, error) {
+	var compressed bytes.Buffer
+	w := lzw.NewWriter(&compressed, lzw.LSB, litWidth)
+	if _, err := w.Write(indices); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(litWidth))
+	rest := compressed.Bytes()
+	for len(rest) > 0 {
+		n := len(rest)
+		if n > 255 {
+			n = 255
+		}
+		buf.WriteByte(byte(n))
+		buf.Write(rest[:n])
+		rest = rest[n:]
+	}
+	buf.WriteByte(0)
+	return buf.Bytes(), nil
+}
+
+// reorderGIFPalette 重排GIF的全局和局部颜色表并改写全部帧的像素索引
+func (m *ImageModifier) reorderGIFPalette(data []byte) ([]byte, error) {
+	file, err := m.parseGIF(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkDecodeConfig(data); err != nil {
+		return nil, err
+	}
+	if len(file.images) == 0 {
+		return nil, fmt.Errorf("GIF文件中没有图像")
+	}
+
+	rng := m.paletteRand()
+	result := append([]byte(nil), data...)
+	var globalPerm []int
+	if file.globalTable >= 0 {
+		if globalPerm, err = palettePermutation(rng, file.globalSize); err != nil {
+			return nil, err
+		}
+		table := result[file.globalTable : file.globalTable+3*file.globalSize]
+		copy(table, permuteEntries(table, 3, globalPerm, nil))
+		if background := int(result[11]); background < len(globalPerm) {
+			result[11] = byte(globalPerm[background])
+		}
+	}
+
+	// 图像数据长度可能改变，从后往前替换以保持前面的位置不变
+	for i := len(file.images) - 1; i >= 0; i-- {
+		img := &file.images[i]
+		perm := globalPerm
+		if img.localTable >= 0 {
+			if perm, err = palettePermutation(rng, img.localSize); err != nil {
+				return nil, err
+			}
+			table := result[img.localTable : img.localTable+3*img.localSize]
+			copy(table, permuteEntries(table, 3, perm, nil))
+		}
+		if perm == nil {
+			continue // 没有可用的颜色表
+		}
+
+		if img.control >= 0 && img.transparent {
+			if index := int(result[img.control+6]); index < len(perm) {
+				result[img.control+6] = byte(perm[index])
+			}
+		}
+
+		indices, litWidth, err := decodeGIFIndices(data, img)
+		if err != nil {
+			return nil, err
+		}
+		for j, index := range indices {
+			if int(index) < len(perm) {
+				indices[j] = byte(perm[index])
+			}
+		}
+		encoded, err := encodeGIFIndices(indices, litWidth)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result[:img.dataStart:img.dataStart], append(encoded, result[img.dataEnd:]...)...)
+	}
+
+	if err := verifyGIFColors(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// verifyGIFColors 验证两个GIF每一帧解码后的颜色以及背景色完全相同
+func verifyGIFColors(a, b []byte) error {
+	before, err := gif.DecodeAll(bytes.NewReader(a))
+	if err != nil {
+		return fmt.Errorf("解码GIF图片失败: %v", err)
+	}
+	after, err := gif.DecodeAll(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("修改后的GIF无法解码: %v", err)
+	}
+	if len(before.Image) != len(after.Image) {
+		return fmt.Errorf("修改后GIF帧数发生变化")
+	}
+	for i := range before.Image {
+		if !samePixels(before.Image[i], after.Image[i]) {
+			return fmt.Errorf("修改后第 %d 帧像素发生变化", i)
+		}
+	}
+	// 背景色
+	beforePalette, _ := before.Config.ColorModel.(color.Palette)
+	afterPalette, _ := after.Config.ColorModel.(color.Palette)
+	if int(before.BackgroundIndex) < len(beforePalette) && int(after.BackgroundIndex) < len(afterPalette) &&
+		beforePalette[before.BackgroundIndex] != afterPalette[after.BackgroundIndex] {
+		return fmt.Errorf("修改后GIF背景色发生变化")
+	}
+	return nil
+}
+
+// modifyGIFPixel 修改GIF中透明色对应的颜色表项的RGB值，每一帧渲染出的像素都不变
+// 局部颜色表的透明色总是可以修改；全局颜色表的透明色只有在所有使用全局颜色表的帧
+// 都把它作为透明色、且它不是背景色时才能修改。没有这样的颜色表项时返回 ErrNoTransparentPixel
+func (m *ImageModifier) modifyGIFPixel(data []byte) ([]byte, error) {
+	file, err := m.parseGIF(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkDecodeConfig(data); err != nil {
+		return nil, err
+	}
+	if len(file.images) == 0 {
+		return nil, fmt.Errorf("GIF文件中没有图像")
+	}
+
+	// 可以修改的颜色表项位置
+	var entries []int
+	globalIndex, globalUsed := -1, false
+	for _, img := range file.images {
+		index := -1
+		if img.control >= 0 && img.transparent {
+			index = int(data[img.control+6])
+		}
+		if img.localTable >= 0 {
+			if index >= 0 && index < img.localSize {
+				entries = append(entries, img.localTable+3*index)
+			}
+			continue
+		}
+		if !globalUsed {
+			globalIndex, globalUsed = index, true
+		} else if globalIndex != index {
+			globalIndex = -1
+		}
+	}
+	if file.globalTable >= 0 && globalIndex >= 0 && globalIndex < file.globalSize && globalIndex != int(data[11]) {
+		entries = append(entries, file.globalTable+3*globalIndex)
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoTransparentPixel
+	}
+
+	// 随机选择一项和一个颜色分量，改成另一个值
+	randomBytes := m.generateRandomBytes(4)
+	entry := entries[int(binary.BigEndian.Uint16(randomBytes[0:2]))%len(entries)]
+	result := append([]byte(nil), data...)
+	channel := entry + int(randomBytes[2])%3
+	result[channel] += 1 + randomBytes[3]%255
+
+	if err := verifyGIFColors(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
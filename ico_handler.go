@@ -0,0 +1,314 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ICO/CUR 文件结构：
+// ICONDIR (6字节): [保留 0][类型 1=ICO 2=CUR][图像数量]
+// ICONDIRENTRY (16字节/项): [宽][高][颜色数][保留][平面数/热点X][位数/热点Y][数据大小][数据偏移]
+// 之后是各图像数据，每个图像是完整的PNG文件或不带文件头的BMP（DIB）数据
+
+const (
+	icoHeaderSize = 6
+	icoEntrySize  = 16
+)
+
+// pngSignature PNG文件签名
+var pngSignature = []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
+
+// icoImage ICO/CUR中的一个内嵌图像
+type icoImage struct {
+	entry [icoEntrySize]byte // 原始目录项（大小和偏移在写回时重新计算）
+	data  []byte             // 图像数据（PNG或DIB）
+}
+
+// icoFile 解析后的ICO/CUR文件
+type icoFile struct {
+	fileType uint16 // 1=ICO 2=CUR
+	images   []icoImage
+}
+
+// isPNG 判断图像数据是否为PNG
+func (img *icoImage) isPNG() bool {
+	return bytes.HasPrefix(img.data, pngSignature)
+}
+
+// parseICO 解析ICO/CUR文件
+func (m *ImageModifier) parseICO(data []byte) (*icoFile, error) {
+	if len(data) < icoHeaderSize {
+		return nil, fmt.Errorf("不是有效的ICO/CUR文件")
+	}
+
+	reserved := binary.LittleEndian.Uint16(data[0:2])
+	fileType := binary.LittleEndian.Uint16(data[2:4])
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if reserved != 0 || (fileType != 1 && fileType != 2) || count == 0 {
+		return nil, fmt.Errorf("不是有效的ICO/CUR文件")
+	}
+
+	if icoHeaderSize+count*icoEntrySize > len(data) {
+		return nil, fmt.Errorf("ICO目录超出文件范围")
+	}
+
+	file := &icoFile{fileType: fileType}
+	for i := 0; i < count; i++ {
+		var icon icoImage
+		copy(icon.entry[:], data[icoHeaderSize+i*icoEntrySize:])
+
+		size := int64(binary.LittleEndian.Uint32(icon.entry[8:12]))
+		offset := int64(binary.LittleEndian.Uint32(icon.entry[12:16]))
+		if offset < int64(icoHeaderSize+count*icoEntrySize) || offset+size > int64(len(data)) {
+			return nil, fmt.Errorf("第%d个图像的数据超出文件范围", i+1)
+		}
+
+		icon.data = data[offset : offset+size]
+		file.images = append(file.images, icon)
+	}
+
+	return file, nil
+}
+
+// encode 将ICO/CUR重新序列化，并重写每个目录项的数据大小和偏移
+func (f *icoFile) encode() []byte {
+	count := len(f.images)
+	offset := icoHeaderSize + count*icoEntrySize
+
+	totalSize := offset
+	for _, icon := range f.images {
+		totalSize += len(icon.data)
+	}
+
+	result := make([]byte, offset, totalSize)
+	binary.LittleEndian.PutUint16(result[0:2], 0)
+	binary.LittleEndian.PutUint16(result[2:4], f.fileType)
+	binary.LittleEndian.PutUint16(result[4:6], uint16(count))
+
+	for i, icon := range f.images {
+		entry := icon.entry
+		binary.LittleEndian.PutUint32(entry[8:12], uint32(len(icon.data)))
+		binary.LittleEndian.PutUint32(entry[12:16], uint32(offset))
+		copy(result[icoHeaderSize+i*icoEntrySize:], entry[:])
+		offset += len(icon.data)
+	}
+
+	for _, icon := range f.images {
+		result = append(result, icon.data...)
+	}
+
+	return result
+}
+
+// modifyICOImages 对ICO/CUR中的内嵌图像逐个应用修改函数并重建文件
+// 默认只修改第一个图像，ICOModifyAllImages 为true时修改全部图像
+func (m *ImageModifier) modifyICOImages(data []byte, modify func(icon *icoImage) ([]byte, error)) ([]byte, error) {
+	file, err := m.parseICO(data)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := 1
+	if m.ICOModifyAllImages {
+		targets = len(file.images)
+	}
+
+	for i := 0; i < targets; i++ {
+		modified, err := modify(&file.images[i])
+		if err != nil {
+			return nil, fmt.Errorf("修改第%d个图像失败: %w", i+1, err)
+		}
+		file.images[i].data = modified
+	}
+
+	return file.encode(), nil
+}
+
+// modifyICOSHA1 通过在内嵌图像中加入随机数据修改ICO/CUR文件
+// PNG图像插入文本块，BMP图像在位图数据之后追加随机字节（读取时按头部尺寸解析，不影响显示）
+func (m *ImageModifier) modifyICOSHA1(data []byte) ([]byte, error) {
+	return m.modifyICOImages(data, func(icon *icoImage) ([]byte, error) {
+		if icon.isPNG() {
+			return m.insertPNGTextChunk(icon.data, "Random", string(m.generateRandomBytes(32)))
+		}
+		if _, err := m.parseDIBHeader(icon.data); err != nil {
+			return nil, err
+		}
+		result := make([]byte, 0, len(icon.data)+16)
+		result = append(result, icon.data...)
+		result = append(result, m.generateRandomBytes(16)...)
+		return result, nil
+	})
+}
+
+// modifyICOPixel 通过微调内嵌图像的像素修改ICO/CUR文件，PNG图像的纹理强度记录在 result 中
+func (m *ImageModifier) modifyICOPixel(data []byte, result *PixelModifyResult) ([]byte, error) {
+	return m.modifyICOImages(data, func(icon *icoImage) ([]byte, error) {
+		if icon.isPNG() {
+			return m.modifyPNGPixel(icon.data, result)
+		}
+		if err := m.checkTransparentSupport("ICO中的BMP图像"); err != nil {
+			return nil, err
+		}
+		return m.modifyDIBPixel(icon.data)
+	})
+}
+
+// dibHeader ICO中BMP图像的BITMAPINFOHEADER信息
+type dibHeader struct {
+	headerSize  int
+	width       int
+	height      int // XOR位图的高度（目录中记录的是XOR与AND掩码高度之和）
+	bitCount    int
+	colorsUsed  int
+	compression uint32
+}
+
+// parseDIBHeader 解析DIB的BITMAPINFOHEADER
+func (m *ImageModifier) parseDIBHeader(data []byte) (*dibHeader, error) {
+	if len(data) < 40 {
+		return nil, fmt.Errorf("不是有效的BMP图像数据")
+	}
+
+	header := &dibHeader{
+		headerSize:  int(binary.LittleEndian.Uint32(data[0:4])),
+		width:       int(int32(binary.LittleEndian.Uint32(data[4:8]))),
+		height:      int(int32(binary.LittleEndian.Uint32(data[8:12]))) / 2,
+		bitCount:    int(binary.LittleEndian.Uint16(data[14:16])),
+		compression: binary.LittleEndian.Uint32(data[16:20]),
+		colorsUsed:  int(binary.LittleEndian.Uint32(data[32:36])),
+	}
+
+	// ICO中的图像尺寸不超过256，这里放宽到65535以容忍非标准文件，同时避免后续计算溢出
+	if header.headerSize < 40 || header.headerSize > len(data) ||
+		header.width <= 0 || header.height <= 0 || header.width > 65535 || header.height > 65535 {
+		return nil, fmt.Errorf("不是有效的BMP图像数据")
+	}
+
+	return header, nil
+}
+
+// modifyDIBPixel 通过微调边缘像素修改ICO中的BMP图像
+// 24/32位图像直接修改像素的BGR值；索引色图像修改该像素所用调色板项的颜色，
+// 设置了 PixelMask 时只选择没有被排除的像素使用的索引。设置了 MaxDeltaE 时在ΔE2000约束下调整
+func (m *ImageModifier) modifyDIBPixel(data []byte) ([]byte, error) {
+	header, err := m.parseDIBHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.compression != 0 {
+		return nil, fmt.Errorf("不支持压缩的BMP图像")
+	}
+
+	// 调色板
+	paletteSize := 0
+	if header.bitCount <= 8 {
+		paletteSize = header.colorsUsed
+		if paletteSize == 0 {
+			paletteSize = 1 << header.bitCount
+		}
+	}
+	paletteOffset := header.headerSize
+	pixelOffset := paletteOffset + paletteSize*4
+
+	// 每行按4字节对齐，数据自下而上存储
+	rowSize := (header.width*header.bitCount + 31) / 32 * 4
+	if pixelOffset+rowSize*header.height > len(data) {
+		return nil, fmt.Errorf("BMP图像数据不完整")
+	}
+
+	// 按 PixelDistribution 和 PixelMask 随机选择一个像素，平坦区域按像素的原始位比较
+	pixelBits := func(x, y int) []byte {
+		rowStart := pixelOffset + (header.height-1-y)*rowSize
+		if header.bitCount >= 8 {
+			size := header.bitCount / 8
+			return data[rowStart+x*size : rowStart+(x+1)*size]
+		}
+		bitPos := x * header.bitCount
+		shift := 8 - header.bitCount - bitPos%8
+		return []byte{data[rowStart+bitPos/8] >> uint(shift) & byte(1<<header.bitCount-1)}
+	}
+	candidates, err := m.pixelCandidates(m.pixelRand(), header.width, header.height, 1, pixelProbe{
+		uniform: uniformFunc(header.width, header.height, func(x1, y1, x2, y2 int) bool {
+			return bytes.Equal(pixelBits(x1, y1), pixelBits(x2, y2))
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	selectedPixel := candidates[0]
+	if header.bitCount <= 8 && m.PixelMask != nil {
+		// 调色板图像修改的是颜色表项，使用同一索引的所有像素都会改变：
+		// 只能选择没有被 PixelMask 排除的像素使用的索引
+		excluded := make(map[byte]bool)
+		for y := 0; y < header.height; y++ {
+			for x := 0; x < header.width; x++ {
+				if !m.PixelMask.allows(x, y) {
+					excluded[pixelBits(x, y)[0]] = true
+				}
+			}
+		}
+		found := false
+		for _, pixel := range candidates {
+			if !excluded[pixelBits(pixel.X, pixel.Y)[0]] {
+				selectedPixel, found = pixel, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: 候选像素的颜色索引都被 PixelMask 排除的像素使用", ErrNoEligiblePixel)
+		}
+	}
+	randomBytes := m.generateRandomBytes(4)
+
+	// 微调亮度（-2、-1、+1、+2，确保数据一定发生变化）
+	adjustment := int(randomBytes[2]%2) + 1
+	if randomBytes[3]%2 == 0 {
+		adjustment = -adjustment
+	}
+
+	result := make([]byte, len(data))
+	copy(result, data)
+
+	rowStart := pixelOffset + (header.height-1-selectedPixel.Y)*rowSize
+
+	// 定位要修改的BGR三个字节
+	var colorPos int
+	switch header.bitCount {
+	case 24, 32:
+		colorPos = rowStart + selectedPixel.X*header.bitCount/8
+	case 1, 4, 8:
+		bitPos := selectedPixel.X * header.bitCount
+		b := result[rowStart+bitPos/8]
+		shift := 8 - header.bitCount - bitPos%8
+		index := int(b>>uint(shift)) & (1<<header.bitCount - 1)
+		if index >= paletteSize {
+			return nil, fmt.Errorf("BMP调色板索引越界")
+		}
+		colorPos = paletteOffset + index*4
+	default:
+		return nil, fmt.Errorf("不支持的BMP位深度: %d", header.bitCount)
+	}
+
+	if m.MaxDeltaE > 0 {
+		// 按BGR顺序存储
+		adjusted, err := m.perceptualAdjust([]int{int(result[colorPos+2]), int(result[colorPos+1]), int(result[colorPos])}, 255, nil)
+		if err != nil {
+			return nil, err
+		}
+		result[colorPos], result[colorPos+1], result[colorPos+2] = uint8(adjusted[2]), uint8(adjusted[1]), uint8(adjusted[0])
+		return result, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		original := result[colorPos+i]
+		result[colorPos+i] = m.clampUint8(int(original) + adjustment)
+		if result[colorPos+i] == original {
+			// 已处于边界值，向相反方向调整
+			result[colorPos+i] = m.clampUint8(int(original) - adjustment)
+		}
+	}
+
+	return result, nil
+}
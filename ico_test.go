@@ -0,0 +1,151 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestDIB 创建ICO中使用的BMP（DIB）数据，包含XOR位图和AND掩码
+func createTestDIB(width, height, bitCount int) []byte {
+	paletteSize := 0
+	if bitCount <= 8 {
+		paletteSize = 1 << bitCount
+	}
+	rowSize := (width*bitCount + 31) / 32 * 4
+	maskRowSize := (width + 31) / 32 * 4
+
+	data := make([]byte, 40+paletteSize*4+rowSize*height+maskRowSize*height)
+	binary.LittleEndian.PutUint32(data[0:4], 40)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(width))
+	binary.LittleEndian.PutUint32(data[8:12], uint32(height*2))
+	binary.LittleEndian.PutUint16(data[12:14], 1)
+	binary.LittleEndian.PutUint16(data[14:16], uint16(bitCount))
+
+	// 调色板和像素数据填充为中间灰度
+	for i := 40; i < 40+paletteSize*4+rowSize*height; i++ {
+		data[i] = 0x80
+	}
+	return data
+}
+
+// createTestICO 创建包含一个PNG图像和一个BMP图像的ICO文件
+func createTestICO(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	var pngData bytes.Buffer
+	encoder := &png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&pngData, img); err != nil {
+		t.Fatalf("创建PNG图像失败: %v", err)
+	}
+
+	file := &icoFile{fileType: 1}
+	file.images = append(file.images, icoImage{data: pngData.Bytes()})
+	file.images = append(file.images, icoImage{data: createTestDIB(16, 16, 32)})
+	file.images = append(file.images, icoImage{data: createTestDIB(16, 16, 4)})
+	for i := range file.images {
+		file.images[i].entry[0] = 16
+		file.images[i].entry[1] = 16
+	}
+	return file.encode()
+}
+
+// verifyICO 验证ICO文件结构以及其中的PNG图像可以正常解码
+func verifyICO(t *testing.T, modifier *ImageModifier, data []byte, count int) {
+	file, err := modifier.parseICO(data)
+	if err != nil {
+		t.Fatalf("修改后的文件不是有效的ICO: %v", err)
+	}
+	if len(file.images) != count {
+		t.Fatalf("图像数量不一致: %d", len(file.images))
+	}
+	for i, icon := range file.images {
+		if icon.isPNG() {
+			if _, err := png.Decode(bytes.NewReader(icon.data)); err != nil {
+				t.Errorf("第%d个PNG图像无法解码: %v", i+1, err)
+			}
+		} else if _, err := modifier.parseDIBHeader(icon.data); err != nil {
+			t.Errorf("第%d个BMP图像无效: %v", i+1, err)
+		}
+	}
+}
+
+// TestModifyICO 测试ICO格式的随机数据和像素微调修改
+func TestModifyICO(t *testing.T) {
+	for _, all := range []bool{false, true} {
+		for _, pixel := range []bool{false, true} {
+			tempDir := t.TempDir()
+			testICO := filepath.Join(tempDir, "test.ico")
+			original := createTestICO(t)
+			if err := os.WriteFile(testICO, original, 0644); err != nil {
+				t.Fatalf("创建测试ICO失败: %v", err)
+			}
+
+			modifier := NewImageModifier()
+			modifier.ICOModifyAllImages = all
+
+			var err error
+			if pixel {
+				_, err = modifier.ModifyImageSHA1ByPixel(testICO)
+			} else {
+				_, err = modifier.ModifyImageSHA1(testICO)
+			}
+			if err != nil {
+				t.Fatalf("修改ICO失败 (all=%v, pixel=%v): %v", all, pixel, err)
+			}
+
+			data, err := os.ReadFile(testICO)
+			if err != nil {
+				t.Fatalf("读取修改后的文件失败: %v", err)
+			}
+			verifyICO(t, modifier, data, 3)
+
+			// 只修改第一个图像时，其余图像数据保持不变
+			before, _ := modifier.parseICO(original)
+			after, _ := modifier.parseICO(data)
+			for i := 1; i < 3; i++ {
+				changed := !bytes.Equal(before.images[i].data, after.images[i].data)
+				if changed != all {
+					t.Errorf("第%d个图像的修改状态不正确 (all=%v, pixel=%v)", i+1, all, pixel)
+				}
+			}
+		}
+	}
+}
+
+func TestModifyDIBPixelMaskedPalette(t *testing.T) {
+	// 4位图像中偶数列使用索引8，奇数列使用索引0
+	data := createTestDIB(16, 16, 4)
+	modifier := &ImageModifier{
+		PixelDistribution: PixelDistributionUniform,
+		PixelMask:         &PixelMask{Exclude: []image.Rectangle{image.Rect(1, 0, 2, 1)}},
+	}
+	for i := 0; i < 20; i++ {
+		modified, err := modifier.modifyDIBPixel(data)
+		if err != nil {
+			t.Fatalf("像素微调失败: %v", err)
+		}
+		if !bytes.Equal(modified[40:44], data[40:44]) {
+			t.Fatal("修改了被排除的像素使用的调色板项")
+		}
+		if bytes.Equal(modified[40+8*4:44+8*4], data[40+8*4:44+8*4]) {
+			t.Fatal("调色板项8没有变化")
+		}
+	}
+
+	// 两个索引都被排除的像素使用
+	modifier.PixelMask.Exclude = []image.Rectangle{image.Rect(0, 0, 2, 1)}
+	if _, err := modifier.modifyDIBPixel(data); !errors.Is(err, ErrNoEligiblePixel) {
+		t.Errorf("应返回 ErrNoEligiblePixel，实际为 %v", err)
+	}
+}
@@ -1,14 +1,9 @@
 package imagemodify
 
 import (
-	"bytes"
 	"crypto/rand"
 	"crypto/sha1"
 	"fmt"
-	"image"
-	"image/color"
-	"image/jpeg"
-	"image/png"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,6 +11,65 @@ import (
 
 // ImageModifier 图片修改器
 type ImageModifier struct {
+	// ICOModifyAllImages 为true时修改ICO/CUR中的全部内嵌图像，默认只修改第一个
+	ICOModifyAllImages bool
+
+	// JXLWrapCodestream 为true时允许将JPEG XL裸码流转换为容器格式以便添加box
+	JXLWrapCodestream bool
+
+	// JPEGStandardTables 为true时JPEG像素微调使用按原图等效质量缩放的标准量化表，默认原样沿用原图的量化表
+	JPEGStandardTables bool
+
+	// JPEGCoefficientMode 为true时JPEG像素微调只把一个边缘块的一个量化DCT系数改动±1，
+	// 其余块和全部标记段保持不变，反复修改不会累积画质损失；此时忽略 JPEGStandardTables
+	JPEGCoefficientMode bool
+
+	// JPEGCommentPlacement 随机数据模式和元数据模式插入JPEG注释段的位置，
+	// 默认在开头的APPn段（JFIF、EXIF等）之后，也可以放在第一个SOS之前或EOI之后
+	JPEGCommentPlacement JPEGCommentPlacement
+
+	// PaletteSeed 非0时调色板重排使用该随机种子，相同的种子得到相同的排列；默认每次随机
+	PaletteSeed int64
+
+	// PixelStrategy 像素微调选择像素的方式，默认微调边缘像素亮度；
+	// PixelStrategyTransparent 只修改完全透明像素的颜色通道，视觉上没有任何变化；
+	// GIF不受该设置影响，总是修改透明色对应的颜色表项
+	PixelStrategy PixelStrategy
+
+	// MaxDeltaE 大于0时，像素微调在CIELAB空间计算调整量：保证像素确实发生变化，
+	// 且与原颜色的ΔE2000不超过该值（1.0左右约为人眼可察觉的最小色差）。默认0表示按固定幅度微调。
+	// 适用于PNG、ICO/CUR、Netpbm和QOI；JPEG对修改后的整个块做逆DCT检查，块内像素超出上限时改试其他像素
+	// （CMYK/YCCK的JPEG不支持该设置）
+	MaxDeltaE float64
+
+	// PixelCount 像素微调一次修改的像素数量，默认1；可修改的像素不足时修改尽可能多的像素。
+	// 修改的像素越多，SHA1变化对重新压缩等处理越稳健，但也越容易被察觉。
+	// PixelCount 和 PixelMaxDelta 适用于JPEG和PNG（含ICO中的PNG），其余格式只修改一个像素
+	PixelCount int
+
+	// PixelMaxDelta 每个像素的最大调整幅度（按8位精度计），默认2；
+	// JPEG要求修改后整个块的采样都不超过该幅度，低质量图片的量化步长较大时返回错误
+	PixelMaxDelta int
+
+	// PixelDistribution 被修改像素的空间分布：边缘（默认）、角落、均匀随机或纹理丰富的区域，适用于全部支持像素微调的格式
+	PixelDistribution PixelDistribution
+
+	// PixelMask 不为nil时像素微调只修改掩码允许的区域（包含/排除矩形、掩码图像、避开平坦区域），
+	// 没有允许修改的像素时返回 ErrNoEligiblePixel。JPEG按所在的整个8x8块判断，
+	// 修改颜色表项的格式只选择没有被排除的像素使用的索引
+	PixelMask *PixelMask
+
+	// TextureMetric 衡量局部纹理强度的方式：梯度幅值（默认）或邻域方差
+	TextureMetric PixelTextureMetric
+
+	// Limits 处理不可信输入时的资源上限（文件大小、像素数量、块长度和数量、压缩文本大小），
+	// 超出时返回 *LimitError（errors.Is(err, ErrLimitExceeded) 成立）。零值使用默认上限
+	Limits ResourceLimits
+
+	// PNGStreaming 为true时PNG像素微调逐行解压、修改并重新压缩图像数据，边读边写，
+	// 内存占用只与几行扫描行有关，适合超大图片。透明像素策略、纹理分布和 AvoidUniform
+	// 需要完整的图像，设置了这些选项时仍完整解码
+	PNGStreaming bool
 }
 
 // NewImageModifier 创建新的图片修改器
@@ -33,9 +87,9 @@ func (m *ImageModifier) ModifyImageSHA1(imagePath string) (string, error) {
 	}
 
 	// 读取原始文件
-	originalData, err := os.ReadFile(imagePath)
+	originalData, err := m.readImageFile(imagePath)
 	if err != nil {
-		return "", fmt.Errorf("读取图片文件失败: %v", err)
+		return "", fmt.Errorf("读取图片文件失败: %w", err)
 	}
 
 	// 计算原始SHA1
@@ -47,15 +101,27 @@ func (m *ImageModifier) ModifyImageSHA1(imagePath string) (string, error) {
 
 	switch ext {
 	case ".jpg", ".jpeg":
-		modifiedData = m.insertJPEGComment(originalData, m.generateRandomBytes(16))
+		modifiedData, err = m.insertJPEGComment(originalData, m.generateRandomBytes(16))
 	case ".png":
-		modifiedData = m.insertPNGTextChunk(originalData, "Random", string(m.generateRandomBytes(32)))
+		modifiedData, err = m.insertPNGTextChunk(originalData, "Random", string(m.generateRandomBytes(32)))
+	case ".svg":
+		modifiedData, err = m.insertSVGComment(originalData, m.generateRandomBytes(16))
+	case ".ico", ".cur":
+		modifiedData, err = m.modifyICOSHA1(originalData)
+	case ".pbm", ".pgm", ".ppm", ".pnm", ".pam":
+		modifiedData, err = m.insertNetpbmComment(originalData, m.generateRandomBytes(16))
+	case ".qoi":
+		modifiedData, err = m.appendQOITrailer(originalData, m.generateRandomBytes(16))
+	case ".jxl":
+		modifiedData, err = m.modifyJXLSHA1(originalData)
+	case ".mp4", ".m4v", ".mov":
+		modifiedData, err = m.modifyMP4SHA1(originalData)
 	default:
 		return "", fmt.Errorf("不支持的图片格式: %s", ext)
 	}
 
 	if err != nil {
-		return "", fmt.Errorf("修改图片SHA1失败: %v", err)
+		return "", fmt.Errorf("修改图片SHA1失败: %w", err)
 	}
 
 	// 验证修改后的数据与原始数据不同
@@ -82,179 +148,207 @@ func (m *ImageModifier) GetImageSHA1(imagePath string) (string, error) {
 	return fmt.Sprintf("%x", sha1.Sum(data)), nil
 }
 
+// PixelModifyResult 像素微调的结果
+type PixelModifyResult struct {
+	SHA1 string // 修改后的SHA1值
+
+	// 以下仅对JPEG有效
+	JPEGQuality      int  // 原图量化表估算出的等效质量（1~100）
+	JPEGSourceTables bool // 是否原样沿用了原图的量化表（否则为按等效质量缩放的标准量化表）
+	JPEGProgressive  bool // 输出是否为渐进式（与原图一致）
+
+	// TextureScore 被修改像素中最小的局部纹理强度（按 TextureMetric 计算，8位亮度），
+	// 越大修改越不易察觉；仅JPEG、PNG和QOI的边缘微调有效
+	TextureScore float64
+}
+
 // ModifyImageSHA1ByPixel 通过微调边缘像素亮度来修改图片SHA1值
+// 通过 PixelStrategy 可以改为只修改完全透明像素的颜色通道（PNG）；GIF不受 PixelStrategy 影响，总是修改透明色对应的颜色表项
 // imagePath: 图片文件路径
 // 返回: 修改后的SHA1值和错误信息
 func (m *ImageModifier) ModifyImageSHA1ByPixel(imagePath string) (string, error) {
+	result, err := m.ModifyImageSHA1ByPixelResult(imagePath)
+	if err != nil {
+		return "", err
+	}
+	return result.SHA1, nil
+}
+
+// ModifyImageSHA1ByPixelResult 与 ModifyImageSHA1ByPixel 相同，同时返回编码参数等详细结果
+func (m *ImageModifier) ModifyImageSHA1ByPixelResult(imagePath string) (*PixelModifyResult, error) {
 	// 检查文件是否存在
 	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-		return "", fmt.Errorf("图片文件不存在: %s", imagePath)
+		return nil, fmt.Errorf("图片文件不存在: %s", imagePath)
+	}
+
+	// 根据文件扩展名确定图片格式
+	ext := strings.ToLower(filepath.Ext(imagePath))
+	if ext == ".png" && m.pngStreamable() {
+		return m.modifyPNGPixelFile(imagePath)
 	}
 
 	// 读取原始文件
-	originalData, err := os.ReadFile(imagePath)
+	originalData, err := m.readImageFile(imagePath)
 	if err != nil {
-		return "", fmt.Errorf("读取图片文件失败: %v", err)
+		return nil, fmt.Errorf("读取图片文件失败: %w", err)
 	}
 
 	// 计算原始SHA1
 	originalSHA1 := fmt.Sprintf("%x", sha1.Sum(originalData))
 
-	// 根据文件扩展名确定图片格式
-	ext := strings.ToLower(filepath.Ext(imagePath))
+	result := &PixelModifyResult{}
 	var modifiedData []byte
 
 	switch ext {
 	case ".jpg", ".jpeg":
-		modifiedData, err = m.modifyJPEGPixel(originalData)
+		if err = m.checkTransparentSupport("JPEG"); err == nil {
+			modifiedData, err = m.modifyJPEGPixel(originalData, result)
+		}
 	case ".png":
-		modifiedData, err = m.modifyPNGPixel(originalData)
+		modifiedData, err = m.modifyPNGPixel(originalData, result)
+	case ".gif":
+		modifiedData, err = m.modifyGIFPixel(originalData)
+	case ".ico", ".cur":
+		modifiedData, err = m.modifyICOPixel(originalData, result)
+	case ".pbm", ".pgm", ".ppm", ".pnm", ".pam":
+		if err = m.checkTransparentSupport("Netpbm"); err == nil {
+			modifiedData, err = m.modifyNetpbmPixel(originalData)
+		}
+	case ".qoi":
+		if err = m.checkTransparentSupport("QOI"); err == nil {
+			modifiedData, err = m.modifyQOIPixel(originalData, result)
+		}
 	default:
-		return "", fmt.Errorf("不支持的图片格式: %s", ext)
+		return nil, fmt.Errorf("不支持的图片格式: %s", ext)
 	}
 
 	if err != nil {
-		return "", fmt.Errorf("像素微调失败: %v", err)
+		return nil, fmt.Errorf("像素微调失败: %w", err)
 	}
 
 	// 验证修改后的数据与原始数据不同
-	newSHA1 := fmt.Sprintf("%x", sha1.Sum(modifiedData))
-	if newSHA1 == originalSHA1 {
-		return "", fmt.Errorf("SHA1修改失败，值未发生变化")
+	result.SHA1 = fmt.Sprintf("%x", sha1.Sum(modifiedData))
+	if result.SHA1 == originalSHA1 {
+		return nil, fmt.Errorf("SHA1修改失败，值未发生变化")
 	}
 
 	// 写回文件
 	err = os.WriteFile(imagePath, modifiedData, 0644)
 	if err != nil {
-		return "", fmt.Errorf("写入修改后的图片失败: %v", err)
+		return nil, fmt.Errorf("写入修改后的图片失败: %v", err)
 	}
 
-	return newSHA1, nil
+	return result, nil
 }
 
-// generateRandomBytes 生成随机字节
-func (m *ImageModifier) generateRandomBytes(length int) []byte {
-	bytes := make([]byte, length)
-	rand.Read(bytes)
-	return bytes
-}
+// ModifyImageSHA1ByTranscode 通过无损重新编码修改图片SHA1值，解码后的像素与原图完全相同
+// JPEG在系数级转码（最优霍夫曼表、基线与渐进式互换、重启间隔），
+// PNG重新压缩图像数据（过滤方式、zlib压缩级别、IDAT分块），其余块不变
+// imagePath: 图片文件路径
+// 返回: 修改后的SHA1值和错误信息
+func (m *ImageModifier) ModifyImageSHA1ByTranscode(imagePath string) (string, error) {
+	// 检查文件是否存在
+	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+		return "", fmt.Errorf("图片文件不存在: %s", imagePath)
+	}
 
-// modifyJPEGPixel 通过微调像素修改JPEG图片
-func (m *ImageModifier) modifyJPEGPixel(data []byte) ([]byte, error) {
-	// 解码JPEG图片
-	img, err := jpeg.Decode(bytes.NewReader(data))
+	// 读取原始文件
+	originalData, err := m.readImageFile(imagePath)
 	if err != nil {
-		return nil, fmt.Errorf("解码JPEG图片失败: %v", err)
+		return "", fmt.Errorf("读取图片文件失败: %w", err)
 	}
 
-	// 获取图片边界
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
+	// 计算原始SHA1
+	originalSHA1 := fmt.Sprintf("%x", sha1.Sum(originalData))
 
-	// 创建一个可编辑的图片副本
-	newImg := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			newImg.Set(x, y, img.At(x, y))
-		}
+	// 根据文件扩展名确定图片格式
+	ext := strings.ToLower(filepath.Ext(imagePath))
+	var modifiedData []byte
+
+	switch ext {
+	case ".jpg", ".jpeg":
+		modifiedData, err = m.transcodeJPEG(originalData)
+	case ".png":
+		modifiedData, err = m.recompressPNG(originalData)
+	default:
+		return "", fmt.Errorf("不支持的图片格式: %s", ext)
 	}
 
-	// 随机选择边缘像素进行微调
-	edgePixels := m.getEdgePixels(width, height)
-	if len(edgePixels) == 0 {
-		return nil, fmt.Errorf("找不到边缘像素")
+	if err != nil {
+		return "", fmt.Errorf("无损重新编码失败: %w", err)
 	}
 
-	// 随机选择一个边缘像素
-	randomBytes := m.generateRandomBytes(4)
-	pixelIndex := int(randomBytes[0]) % len(edgePixels)
-	selectedPixel := edgePixels[pixelIndex]
-
-	// 获取原像素颜色
-	origColor := newImg.RGBAAt(selectedPixel.X, selectedPixel.Y)
-
-	// 微调亮度（对RGB值进行微小调整）
-	adjustment := int8(randomBytes[1]%5) - 2 // -2 到 +2 的微调
-	newR := m.clampUint8(int(origColor.R) + int(adjustment))
-	newG := m.clampUint8(int(origColor.G) + int(adjustment))
-	newB := m.clampUint8(int(origColor.B) + int(adjustment))
-
-	// 设置新颜色
-	newImg.SetRGBA(selectedPixel.X, selectedPixel.Y, color.RGBA{
-		R: newR,
-		G: newG,
-		B: newB,
-		A: origColor.A,
-	})
-
-	// 重新编码为JPEG
-	var buf bytes.Buffer
-	err = jpeg.Encode(&buf, newImg, &jpeg.Options{Quality: 95})
+	// 验证修改后的数据与原始数据不同
+	newSHA1 := fmt.Sprintf("%x", sha1.Sum(modifiedData))
+	if newSHA1 == originalSHA1 {
+		return "", fmt.Errorf("SHA1修改失败，值未发生变化")
+	}
+
+	// 写回文件
+	err = os.WriteFile(imagePath, modifiedData, 0644)
 	if err != nil {
-		return nil, fmt.Errorf("重新编码JPEG失败: %v", err)
+		return "", fmt.Errorf("写入修改后的图片失败: %v", err)
 	}
 
-	return buf.Bytes(), nil
+	return newSHA1, nil
 }
 
-// modifyPNGPixel 通过微调像素修改PNG图片
-func (m *ImageModifier) modifyPNGPixel(data []byte) ([]byte, error) {
-	// 解码PNG图片
-	img, err := png.Decode(bytes.NewReader(data))
+// ModifyImageSHA1ByPalette 通过重排调色板修改图片SHA1值，每个像素渲染出的颜色保持不变
+// 支持调色板PNG（同时调整tRNS、hIST、bKGD）和GIF（全局及局部颜色表、透明色和背景色索引）
+// imagePath: 图片文件路径
+// 返回: 修改后的SHA1值和错误信息
+func (m *ImageModifier) ModifyImageSHA1ByPalette(imagePath string) (string, error) {
+	// 检查文件是否存在
+	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+		return "", fmt.Errorf("图片文件不存在: %s", imagePath)
+	}
+
+	// 读取原始文件
+	originalData, err := m.readImageFile(imagePath)
 	if err != nil {
-		return nil, fmt.Errorf("解码PNG图片失败: %v", err)
+		return "", fmt.Errorf("读取图片文件失败: %w", err)
 	}
 
-	// 获取图片边界
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
+	// 计算原始SHA1
+	originalSHA1 := fmt.Sprintf("%x", sha1.Sum(originalData))
 
-	// 创建一个可编辑的图片副本
-	newImg := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			newImg.Set(x, y, img.At(x, y))
-		}
+	// 根据文件扩展名确定图片格式
+	ext := strings.ToLower(filepath.Ext(imagePath))
+	var modifiedData []byte
+
+	switch ext {
+	case ".png":
+		modifiedData, err = m.reorderPNGPalette(originalData)
+	case ".gif":
+		modifiedData, err = m.reorderGIFPalette(originalData)
+	default:
+		return "", fmt.Errorf("不支持的图片格式: %s", ext)
 	}
 
-	// 随机选择边缘像素进行微调
-	edgePixels := m.getEdgePixels(width, height)
-	if len(edgePixels) == 0 {
-		return nil, fmt.Errorf("找不到边缘像素")
+	if err != nil {
+		return "", fmt.Errorf("调色板重排失败: %w", err)
 	}
 
-	// 随机选择一个边缘像素
-	randomBytes := m.generateRandomBytes(4)
-	pixelIndex := int(randomBytes[0]) % len(edgePixels)
-	selectedPixel := edgePixels[pixelIndex]
-
-	// 获取原像素颜色
-	origColor := newImg.RGBAAt(selectedPixel.X, selectedPixel.Y)
-
-	// 微调亮度（对RGB值进行微小调整）
-	adjustment := int8(randomBytes[1]%5) - 2 // -2 到 +2 的微调
-	newR := m.clampUint8(int(origColor.R) + int(adjustment))
-	newG := m.clampUint8(int(origColor.G) + int(adjustment))
-	newB := m.clampUint8(int(origColor.B) + int(adjustment))
-
-	// 设置新颜色
-	newImg.SetRGBA(selectedPixel.X, selectedPixel.Y, color.RGBA{
-		R: newR,
-		G: newG,
-		B: newB,
-		A: origColor.A,
-	})
-
-	// 重新编码为PNG
-	var buf bytes.Buffer
-	err = png.Encode(&buf, newImg)
+	// 验证修改后的数据与原始数据不同
+	newSHA1 := fmt.Sprintf("%x", sha1.Sum(modifiedData))
+	if newSHA1 == originalSHA1 {
+		return "", fmt.Errorf("SHA1修改失败，值未发生变化")
+	}
+
+	// 写回文件
+	err = os.WriteFile(imagePath, modifiedData, 0644)
 	if err != nil {
-		return nil, fmt.Errorf("重新编码PNG失败: %v", err)
+		return "", fmt.Errorf("写入修改后的图片失败: %v", err)
 	}
 
-	return buf.Bytes(), nil
+	return newSHA1, nil
+}
+
+// generateRandomBytes 生成随机字节
+func (m *ImageModifier) generateRandomBytes(length int) []byte {
+	bytes := make([]byte, length)
+	rand.Read(bytes)
+	return bytes
 }
 
 // PixelCoord 像素坐标
@@ -262,25 +356,6 @@ type PixelCoord struct {
 	X, Y int
 }
 
-// getEdgePixels 获取边缘像素坐标列表
-func (m *ImageModifier) getEdgePixels(width, height int) []PixelCoord {
-	var edgePixels []PixelCoord
-
-	// 上下边界
-	for x := 0; x < width; x++ {
-		edgePixels = append(edgePixels, PixelCoord{X: x, Y: 0})          // 上边界
-		edgePixels = append(edgePixels, PixelCoord{X: x, Y: height - 1}) // 下边界
-	}
-
-	// 左右边界（避免重复角落像素）
-	for y := 1; y < height-1; y++ {
-		edgePixels = append(edgePixels, PixelCoord{X: 0, Y: y})         // 左边界
-		edgePixels = append(edgePixels, PixelCoord{X: width - 1, Y: y}) // 右边界
-	}
-
-	return edgePixels
-}
-
 // clampUint8 将数值限制在 0-255 范围内
 func (m *ImageModifier) clampUint8(value int) uint8 {
 	if value < 0 {
@@ -0,0 +1,103 @@
+package imagemodify
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ISO基础媒体文件格式（ISOBMFF）的box结构：
+// [4字节大小][4字节类型][数据]
+// 大小为1时类型之后是8字节的实际大小，大小为0表示box延伸到文件（或父box）末尾
+// JPEG XL容器和MP4/MOV都使用这种结构
+
+// isoBox 一个box在数据中的位置
+type isoBox struct {
+	boxType    string // box类型
+	start      int    // box起始位置
+	headerSize int    // 头部大小（8或16）
+	end        int    // box结束位置
+	toEnd      bool   // 大小字段为0（延伸到末尾）
+}
+
+// payload 返回box的数据部分
+func (b isoBox) payload(data []byte) []byte {
+	return data[b.start+b.headerSize : b.end]
+}
+
+// parseISOBoxes 解析 data[start:end] 范围内的同级box
+func (m *ImageModifier) parseISOBoxes(data []byte, start, end int) ([]isoBox, error) {
+	var boxes []isoBox
+	pos := start
+
+	for pos < end {
+		if pos+8 > end {
+			return nil, fmt.Errorf("box头部不完整（位置 %d）", pos)
+		}
+
+		box := isoBox{
+			boxType:    string(data[pos+4 : pos+8]),
+			start:      pos,
+			headerSize: 8,
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		switch size {
+		case 0:
+			size = uint64(end - pos)
+			box.toEnd = true
+		case 1:
+			if pos+16 > end {
+				return nil, fmt.Errorf("box头部不完整（位置 %d）", pos)
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			box.headerSize = 16
+		}
+
+		if size < uint64(box.headerSize) || size > uint64(end-pos) {
+			return nil, fmt.Errorf("%s box大小无效（位置 %d）", box.boxType, pos)
+		}
+
+		box.end = pos + int(size)
+		boxes = append(boxes, box)
+		pos = box.end
+	}
+
+	return boxes, nil
+}
+
+// buildISOBox 构造一个box（数据过大时使用64位大小）
+func buildISOBox(boxType string, payload []byte) []byte {
+	size := uint64(len(payload)) + 8
+	if size > 0xFFFFFFFF {
+		result := make([]byte, 16, size+8)
+		binary.BigEndian.PutUint32(result[0:4], 1)
+		copy(result[4:8], boxType)
+		binary.BigEndian.PutUint64(result[8:16], size+8)
+		return append(result, payload...)
+	}
+
+	result := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(result[0:4], uint32(size))
+	copy(result[4:8], boxType)
+	return append(result, payload...)
+}
+
+// rewriteISOBoxSize 以显式大小重写box头部
+// 用于大小为0（延伸到末尾）的box在其后追加新box之前
+func rewriteISOBoxSize(data []byte, box isoBox) []byte {
+	return buildISOBox(box.boxType, box.payload(data))
+}
+
+// appendISOBox 在顶层box序列之后追加一个box
+// boxes 为 data 的顶层box，最后一个box若大小为0需要先改写为显式大小
+func appendISOBox(data []byte, boxes []isoBox, box []byte) []byte {
+	result := make([]byte, 0, len(data)+len(box)+16)
+	if len(boxes) > 0 && boxes[len(boxes)-1].toEnd {
+		last := boxes[len(boxes)-1]
+		result = append(result, data[:last.start]...)
+		result = append(result, rewriteISOBoxSize(data, last)...)
+	} else {
+		result = append(result, data...)
+	}
+	return append(result, box...)
+}
@@ -0,0 +1,643 @@
+package imagemodify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// 系数级JPEG编解码：熵解码得到每个块量化后的DCT系数，而不是解码成像素。
+// 像素模式在系数上重新编码可以保留原有的分量数、颜色变换和色度采样，
+// 也为只修改单个系数、无损转码等操作提供基础。
+// 支持基线（SOF0）、扩展顺序（SOF1）和渐进式（SOF2）的霍夫曼编码，8位精度；
+// 算术编码、无损和分层模式不支持。
+
+// JPEG标记
+const (
+	jpegMarkerSOF0  = 0xC0 // 基线
+	jpegMarkerSOF1  = 0xC1 // 扩展顺序
+	jpegMarkerSOF2  = 0xC2 // 渐进式
+	jpegMarkerDHT   = 0xC4
+	jpegMarkerRST0  = 0xD0
+	jpegMarkerRST7  = 0xD7
+	jpegMarkerSOI   = 0xD8
+	jpegMarkerEOI   = 0xD9
+	jpegMarkerSOS   = 0xDA
+	jpegMarkerDQT   = 0xDB
+	jpegMarkerDRI   = 0xDD
+	jpegMarkerAPP0  = 0xE0
+	jpegMarkerAPP14 = 0xEE
+	jpegMarkerAPP15 = 0xEF
+	jpegMarkerCOM   = 0xFE
+)
+
+// jpegUnzig 之字形顺序到自然顺序（行优先）的映射
+var jpegUnzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegSegment JPEG文件中的一个标记段
+type jpegSegment struct {
+	marker byte   // 标记（0xFF之后的字节）
+	data   []byte // 段数据（不含标记和长度字段）
+}
+
+// jpegBlock 一个8x8块量化后的DCT系数（之字形顺序）
+type jpegBlock [64]int16
+
+// jpegComponent 图像分量
+type jpegComponent struct {
+	id              byte // 分量标识
+	h, v            int  // 水平/垂直采样因子
+	tq              int  // 量化表编号
+	blocksPerLine   int  // 每行块数（按MCU补齐）
+	blocksPerColumn int  // 每列块数（按MCU补齐）
+	blocks          []jpegBlock
+}
+
+// jpegScanComponent 扫描中的分量及其霍夫曼表
+type jpegScanComponent struct {
+	index int // 分量在帧中的序号
+	td    int // DC霍夫曼表编号
+	ta    int // AC霍夫曼表编号
+}
+
+// jpegScan 一次扫描的参数
+type jpegScan struct {
+	components      []jpegScanComponent
+	ss, se          int // 频谱选择起止
+	ah, al          int // 逐次逼近位
+	restartInterval int
+	dcTables        [4]*jpegHuffmanSpec // 扫描时生效的霍夫曼表
+	acTables        [4]*jpegHuffmanSpec
+	headerStart     int // SOS标记在文件中的位置
+	dataStart       int // 熵编码数据的起止位置
+	dataEnd         int
+}
+
+// jpegImage 系数级解码结果
+type jpegImage struct {
+	sofMarker       byte
+	width, height   int
+	components      []*jpegComponent
+	hmax, vmax      int
+	mcusX, mcusY    int
+	quant           [4][64]uint16 // 量化表（之字形顺序）
+	quantSet        [4]bool
+	restartInterval int
+	segments        []jpegSegment // 除DQT/DHT/SOF/DRI/SOS外的标记段（APPn、COM等），按原顺序
+	trailing        []byte        // EOI之后的数据
+	scans           []jpegScan
+}
+
+// progressive 是否为渐进式JPEG
+func (img *jpegImage) progressive() bool {
+	return img.sofMarker == jpegMarkerSOF2
+}
+
+// componentSize 分量的实际采样尺寸（未补齐）
+func (img *jpegImage) componentSize(c *jpegComponent) (int, int) {
+	return (img.width*c.h + img.hmax - 1) / img.hmax, (img.height*c.v + img.vmax - 1) / img.vmax
+}
+
+// decodeJPEG 解码JPEG文件中全部块的量化DCT系数
+func (m *ImageModifier) decodeJPEG(data []byte) (*jpegImage, error) {
+	img := &jpegImage{}
+	var dcTables, acTables [4]*jpegHuffmanSpec
+	r := m.newJPEGSegmentReader(data)
+	r.requireEOI = true
+
+	for {
+		segment, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		marker := segment.marker
+		if jpegStandalone(marker) {
+			continue
+		}
+
+		switch {
+		case marker == jpegMarkerDQT:
+			err = img.parseDQT(segment.data)
+		case marker == jpegMarkerDHT:
+			err = parseDHT(segment.data, &dcTables, &acTables)
+		case marker == jpegMarkerDRI:
+			if len(segment.data) != 2 {
+				err = fmt.Errorf("DRI段长度无效")
+			} else {
+				img.restartInterval = int(binary.BigEndian.Uint16(segment.data))
+			}
+		case marker == jpegMarkerSOF0 || marker == jpegMarkerSOF1 || marker == jpegMarkerSOF2:
+			if img.components != nil {
+				return nil, fmt.Errorf("JPEG文件包含多个帧")
+			}
+			if err = img.parseSOF(marker, segment.data); err == nil {
+				err = m.checkPixels(img.width, img.height)
+			}
+		case marker >= 0xC3 && marker <= 0xCF && marker != jpegMarkerDHT && marker != 0xC8 && marker != 0xCC:
+			return nil, fmt.Errorf("不支持的JPEG编码方式（SOF%d）", marker-0xC0)
+		case marker == jpegMarkerSOS:
+			if img.components == nil {
+				return nil, fmt.Errorf("SOS段位于SOF之前")
+			}
+			var scan *jpegScan
+			scan, err = img.parseSOS(segment.data, dcTables, acTables)
+			if err == nil {
+				scan.headerStart = segment.start
+				scan.dataStart = segment.segmentEnd
+				scan.dataEnd = segment.end
+				err = img.decodeScan(scan, data[scan.dataStart:scan.dataEnd])
+				img.scans = append(img.scans, *scan)
+			}
+		default:
+			img.segments = append(img.segments, jpegSegment{marker: marker, data: segment.data})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(img.scans) == 0 {
+		return nil, fmt.Errorf("JPEG文件缺少图像数据")
+	}
+	img.trailing = r.trailing()
+	for _, c := range img.components {
+		if !img.quantSet[c.tq] {
+			return nil, fmt.Errorf("JPEG文件缺少 %d 号量化表", c.tq)
+		}
+	}
+	return img, nil
+}
+
+// parseDQT 解析量化表段
+func (img *jpegImage) parseDQT(segment []byte) error {
+	for len(segment) > 0 {
+		pq, tq := int(segment[0]>>4), int(segment[0]&0x0F)
+		if pq > 1 || tq > 3 {
+			return fmt.Errorf("DQT段参数无效")
+		}
+		size := 64 * (pq + 1)
+		if len(segment) < 1+size {
+			return fmt.Errorf("DQT段数据不完整")
+		}
+		for i := 0; i < 64; i++ {
+			if pq == 0 {
+				img.quant[tq][i] = uint16(segment[1+i])
+			} else {
+				img.quant[tq][i] = binary.BigEndian.Uint16(segment[1+2*i:])
+			}
+			if img.quant[tq][i] == 0 {
+				return fmt.Errorf("量化表中存在0值")
+			}
+		}
+		img.quantSet[tq] = true
+		segment = segment[1+size:]
+	}
+	return nil
+}
+
+// parseSOF 解析帧头
+func (img *jpegImage) parseSOF(marker byte, segment []byte) error {
+	if len(segment) < 6 {
+		return fmt.Errorf("SOF段数据不完整")
+	}
+	if segment[0] != 8 {
+		return fmt.Errorf("不支持 %d 位精度的JPEG", segment[0])
+	}
+
+	img.sofMarker = marker
+	img.height = int(binary.BigEndian.Uint16(segment[1:3]))
+	img.width = int(binary.BigEndian.Uint16(segment[3:5]))
+	count := int(segment[5])
+	if img.width == 0 || img.height == 0 {
+		return fmt.Errorf("JPEG图像尺寸无效")
+	}
+	if count != 1 && count != 3 && count != 4 {
+		return fmt.Errorf("不支持 %d 个分量的JPEG", count)
+	}
+	if len(segment) < 6+3*count {
+		return fmt.Errorf("SOF段数据不完整")
+	}
+
+	img.components = make([]*jpegComponent, count)
+	img.hmax, img.vmax = 1, 1
+	for i := range img.components {
+		c := &jpegComponent{
+			id: segment[6+3*i],
+			h:  int(segment[7+3*i] >> 4),
+			v:  int(segment[7+3*i] & 0x0F),
+			tq: int(segment[8+3*i]),
+		}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return fmt.Errorf("SOF段分量参数无效")
+		}
+		if c.h > img.hmax {
+			img.hmax = c.h
+		}
+		if c.v > img.vmax {
+			img.vmax = c.v
+		}
+		img.components[i] = c
+	}
+	// 单分量图像的MCU就是一个块，采样因子不起作用
+	if count == 1 {
+		img.components[0].h, img.components[0].v = 1, 1
+		img.hmax, img.vmax = 1, 1
+	}
+
+	img.mcusX = (img.width + 8*img.hmax - 1) / (8 * img.hmax)
+	img.mcusY = (img.height + 8*img.vmax - 1) / (8 * img.vmax)
+	for _, c := range img.components {
+		c.blocksPerLine = img.mcusX * c.h
+		c.blocksPerColumn = img.mcusY * c.v
+		c.blocks = make([]jpegBlock, c.blocksPerLine*c.blocksPerColumn)
+	}
+	return nil
+}
+
+// parseSOS 解析扫描头
+func (img *jpegImage) parseSOS(segment []byte, dcTables, acTables [4]*jpegHuffmanSpec) (*jpegScan, error) {
+	if len(segment) < 1 {
+		return nil, fmt.Errorf("SOS段数据不完整")
+	}
+	count := int(segment[0])
+	if count < 1 || count > 4 || len(segment) != 4+2*count {
+		return nil, fmt.Errorf("SOS段长度无效")
+	}
+
+	scan := &jpegScan{
+		ss:              int(segment[1+2*count]),
+		se:              int(segment[2+2*count]),
+		ah:              int(segment[3+2*count] >> 4),
+		al:              int(segment[3+2*count] & 0x0F),
+		restartInterval: img.restartInterval,
+		dcTables:        dcTables,
+		acTables:        acTables,
+	}
+
+	blocksPerMCU := 0
+	for i := 0; i < count; i++ {
+		id := segment[1+2*i]
+		index := -1
+		for j, c := range img.components {
+			if c.id == id {
+				index = j
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("SOS段引用了不存在的分量 %d", id)
+		}
+		sc := jpegScanComponent{index: index, td: int(segment[2+2*i] >> 4), ta: int(segment[2+2*i] & 0x0F)}
+		if sc.td > 3 || sc.ta > 3 {
+			return nil, fmt.Errorf("SOS段霍夫曼表编号无效")
+		}
+		scan.components = append(scan.components, sc)
+		blocksPerMCU += img.components[index].h * img.components[index].v
+	}
+	if count > 1 && blocksPerMCU > 10 {
+		return nil, fmt.Errorf("MCU中的块数超过10")
+	}
+
+	if img.progressive() {
+		if scan.se > 63 || scan.ss > scan.se || (scan.ss == 0) != (scan.se == 0) || (scan.ss > 0 && count != 1) || scan.al > 13 {
+			return nil, fmt.Errorf("渐进式扫描参数无效")
+		}
+	} else if scan.ss != 0 || scan.se != 63 || scan.ah != 0 || scan.al != 0 {
+		return nil, fmt.Errorf("顺序扫描参数无效")
+	}
+	return scan, nil
+}
+
+// jpegBlockRef 扫描顺序中的一个块
+type jpegBlockRef struct {
+	scanIndex int // 分量在扫描中的序号
+	block     *jpegBlock
+}
+
+// scanMCUs 按扫描顺序返回各MCU包含的块
+// 交错扫描按MCU补齐遍历；单分量扫描只遍历覆盖实际图像的块，每个块即一个MCU
+func (img *jpegImage) scanMCUs(scan *jpegScan) [][]jpegBlockRef {
+	var mcus [][]jpegBlockRef
+
+	if len(scan.components) == 1 {
+		c := img.components[scan.components[0].index]
+		width, height := img.componentSize(c)
+		for by := 0; by < (height+7)/8; by++ {
+			for bx := 0; bx < (width+7)/8; bx++ {
+				mcus = append(mcus, []jpegBlockRef{{0, &c.blocks[by*c.blocksPerLine+bx]}})
+			}
+		}
+		return mcus
+	}
+
+	for my := 0; my < img.mcusY; my++ {
+		for mx := 0; mx < img.mcusX; mx++ {
+			var mcu []jpegBlockRef
+			for si, sc := range scan.components {
+				c := img.components[sc.index]
+				for y := 0; y < c.v; y++ {
+					for x := 0; x < c.h; x++ {
+						mcu = append(mcu, jpegBlockRef{si, &c.blocks[(my*c.v+y)*c.blocksPerLine+mx*c.h+x]})
+					}
+				}
+			}
+			mcus = append(mcus, mcu)
+		}
+	}
+	return mcus
+}
+
+// decodeScan 熵解码一次扫描，将结果写入各分量的系数
+func (img *jpegImage) decodeScan(scan *jpegScan, data []byte) error {
+	dc := make([]*jpegHuffmanDecoder, len(scan.components))
+	ac := make([]*jpegHuffmanDecoder, len(scan.components))
+	for i, sc := range scan.components {
+		var err error
+		if scan.ss == 0 && scan.ah == 0 {
+			if dc[i], err = newJPEGHuffmanDecoder(scan.dcTables[sc.td]); err != nil {
+				return err
+			}
+		}
+		if scan.se > 0 {
+			if ac[i], err = newJPEGHuffmanDecoder(scan.acTables[sc.ta]); err != nil {
+				return err
+			}
+		}
+	}
+
+	reader := &jpegBitReader{data: data}
+	preds := make([]int, len(scan.components))
+	eobrun := 0
+
+	for n, mcu := range img.scanMCUs(scan) {
+		if scan.restartInterval > 0 && n > 0 && n%scan.restartInterval == 0 {
+			if err := reader.restart(); err != nil {
+				return err
+			}
+			for i := range preds {
+				preds[i] = 0
+			}
+			eobrun = 0
+		}
+
+		for _, ref := range mcu {
+			var err error
+			switch {
+			case !img.progressive():
+				err = decodeJPEGSequentialBlock(reader, ref.block, dc[ref.scanIndex], ac[ref.scanIndex], &preds[ref.scanIndex])
+			case scan.ss == 0 && scan.ah == 0:
+				err = decodeJPEGDCFirst(reader, ref.block, dc[ref.scanIndex], &preds[ref.scanIndex], scan.al)
+			case scan.ss == 0:
+				err = decodeJPEGDCRefine(reader, ref.block, scan.al)
+			case scan.ah == 0:
+				err = decodeJPEGACFirst(reader, ref.block, ac[ref.scanIndex], scan, &eobrun)
+			default:
+				err = decodeJPEGACRefine(reader, ref.block, ac[ref.scanIndex], scan, &eobrun)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeJPEGSequentialBlock 解码顺序模式的一个块
+func decodeJPEGSequentialBlock(r *jpegBitReader, block *jpegBlock, dc, ac *jpegHuffmanDecoder, pred *int) error {
+	s, err := dc.decode(r)
+	if err != nil {
+		return err
+	}
+	if s > 11 {
+		return fmt.Errorf("DC系数长度无效")
+	}
+	*pred += r.receiveExtend(int(s))
+	block[0] = int16(*pred)
+
+	for k := 1; k < 64; k++ {
+		rs, err := ac.decode(r)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), int(rs&0x0F)
+		if size == 0 {
+			if run != 15 {
+				break // EOB
+			}
+			k += 15 // ZRL
+			continue
+		}
+		k += run
+		if k > 63 {
+			return fmt.Errorf("AC系数位置越界")
+		}
+		block[k] = int16(r.receiveExtend(size))
+	}
+	return nil
+}
+
+// decodeJPEGDCFirst 渐进式DC首次扫描
+func decodeJPEGDCFirst(r *jpegBitReader, block *jpegBlock, dc *jpegHuffmanDecoder, pred *int, al int) error {
+	s, err := dc.decode(r)
+	if err != nil {
+		return err
+	}
+	if s > 11 {
+		return fmt.Errorf("DC系数长度无效")
+	}
+	*pred += r.receiveExtend(int(s))
+	block[0] = int16(*pred << uint(al))
+	return nil
+}
+
+// decodeJPEGDCRefine 渐进式DC细化扫描
+func decodeJPEGDCRefine(r *jpegBitReader, block *jpegBlock, al int) error {
+	if r.readBit() != 0 {
+		block[0] |= 1 << uint(al)
+	}
+	return nil
+}
+
+// decodeJPEGACFirst 渐进式AC首次扫描
+func decodeJPEGACFirst(r *jpegBitReader, block *jpegBlock, ac *jpegHuffmanDecoder, scan *jpegScan, eobrun *int) error {
+	if *eobrun > 0 {
+		*eobrun--
+		return nil
+	}
+	for k := scan.ss; k <= scan.se; k++ {
+		rs, err := ac.decode(r)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), int(rs&0x0F)
+		if size == 0 {
+			if run < 15 {
+				*eobrun = 1<<uint(run) - 1
+				if run > 0 {
+					*eobrun += r.readBits(run)
+				}
+				break
+			}
+			k += 15
+			continue
+		}
+		k += run
+		if k > 63 {
+			return fmt.Errorf("AC系数位置越界")
+		}
+		block[k] = int16(r.receiveExtend(size) * (1 << uint(scan.al)))
+	}
+	return nil
+}
+
+// decodeJPEGACRefine 渐进式AC细化扫描
+func decodeJPEGACRefine(r *jpegBitReader, block *jpegBlock, ac *jpegHuffmanDecoder, scan *jpegScan, eobrun *int) error {
+	p1 := int16(1) << uint(scan.al)
+	m1 := int16(-1) << uint(scan.al)
+
+	// refine 为已有的非零系数追加一位
+	refine := func(k int) {
+		if r.readBit() != 0 && block[k]&p1 == 0 {
+			if block[k] >= 0 {
+				block[k] += p1
+			} else {
+				block[k] += m1
+			}
+		}
+	}
+
+	k := scan.ss
+	if *eobrun == 0 {
+		for ; k <= scan.se; k++ {
+			rs, err := ac.decode(r)
+			if err != nil {
+				return err
+			}
+			run, size := int(rs>>4), int(rs&0x0F)
+			var value int16
+			if size != 0 {
+				if size != 1 {
+					return fmt.Errorf("AC细化扫描系数长度无效")
+				}
+				if r.readBit() != 0 {
+					value = p1
+				} else {
+					value = m1
+				}
+			} else if run != 15 {
+				*eobrun = 1 << uint(run)
+				if run > 0 {
+					*eobrun += r.readBits(run)
+				}
+				break
+			}
+
+			// 跳过 run 个零系数，途经的非零系数各追加一位
+			for ; k <= scan.se; k++ {
+				if block[k] != 0 {
+					refine(k)
+				} else {
+					if run == 0 {
+						break
+					}
+					run--
+				}
+			}
+			if value != 0 && k <= scan.se {
+				block[k] = value
+			}
+		}
+	}
+
+	if *eobrun > 0 {
+		for ; k <= scan.se; k++ {
+			if block[k] != 0 {
+				refine(k)
+			}
+		}
+		*eobrun--
+	}
+	return nil
+}
+
+// jpegBitReader 熵编码数据的位读取器，处理0xFF00填充和RST标记
+type jpegBitReader struct {
+	data []byte
+	pos  int
+	acc  uint32
+	n    int
+}
+
+// fill 至少补足25位（遇到标记或数据结束时补0）
+func (r *jpegBitReader) fill() {
+	for r.n <= 24 {
+		b := byte(0)
+		if r.pos < len(r.data) {
+			b = r.data[r.pos]
+			if b == 0xFF {
+				if r.pos+1 < len(r.data) && r.data[r.pos+1] == 0x00 {
+					r.pos += 2
+				} else {
+					b = 0 // 标记，不再前进
+				}
+			} else {
+				r.pos++
+			}
+		}
+		r.acc |= uint32(b) << uint(24-r.n)
+		r.n += 8
+	}
+}
+
+// readBit 读取一位
+func (r *jpegBitReader) readBit() int {
+	return r.readBits(1)
+}
+
+// readBits 读取 n 位（n 不超过16）
+func (r *jpegBitReader) readBits(n int) int {
+	if n == 0 {
+		return 0
+	}
+	r.fill()
+	v := int(r.acc >> uint(32-n))
+	r.acc <<= uint(n)
+	r.n -= n
+	return v
+}
+
+// receiveExtend 读取 s 位并按JPEG规则扩展为有符号数
+func (r *jpegBitReader) receiveExtend(s int) int {
+	if s == 0 {
+		return 0
+	}
+	v := r.readBits(s)
+	if v < 1<<uint(s-1) {
+		v += -1<<uint(s) + 1
+	}
+	return v
+}
+
+// restart 丢弃剩余位并跳过RST标记（及其前的填充字节）
+func (r *jpegBitReader) restart() error {
+	r.acc, r.n = 0, 0
+	for r.pos+1 < len(r.data) && r.data[r.pos] == 0xFF && r.data[r.pos+1] == 0xFF {
+		r.pos++
+	}
+	if r.pos+1 < len(r.data) && r.data[r.pos] == 0xFF && r.data[r.pos+1] >= jpegMarkerRST0 && r.data[r.pos+1] <= jpegMarkerRST7 {
+		r.pos += 2
+		return nil
+	}
+	return fmt.Errorf("缺少RST标记（位置 %d）", r.pos)
+}
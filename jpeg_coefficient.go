@@ -0,0 +1,140 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// JPEG系数微调：不做DCT往返，也不重新量化，只把一个边缘块中的一个量化系数改动 ±1，
+// 再用原有的霍夫曼表重新熵编码受影响的扫描。SOS之外的全部字节（标记段、量化表、霍夫曼表、
+// 其余扫描）原样复制，其余块的系数不变，因此反复修改不会累积画质损失。
+// 基线和渐进式JPEG都适用；渐进式文件中只重写实际编码了该系数变化的扫描。
+
+// jpegCoefficientEdit 一次候选修改
+type jpegCoefficientEdit struct {
+	k     int   // 系数位置（之字形顺序）
+	delta int16 // +1 或 -1
+}
+
+// modifyCoefficient 修改一个边缘块的一个系数，返回重写后的文件
+// 修改后的符号必须能用原霍夫曼表编码，否则换下一个候选；全部候选都无法编码时返回错误
+func (img *jpegImage) modifyCoefficient(data []byte, randomBytes []byte) ([]byte, error) {
+	blocks := img.edgeBlocks()
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("找不到边缘块")
+	}
+
+	start := int(binary.BigEndian.Uint16(randomBytes[0:2])) % len(blocks)
+	for i := range blocks {
+		block := blocks[(start+i)%len(blocks)]
+		for _, edit := range coefficientEdits(block, randomBytes[2], randomBytes[3]%2 == 1) {
+			original := *block
+			block[edit.k] += edit.delta
+			encoded, err := img.rewriteScans(data, &original, block, edit.k)
+			if err == nil {
+				return encoded, nil
+			}
+			*block = original
+		}
+	}
+	return nil, fmt.Errorf("原霍夫曼表无法编码任何系数修改")
+}
+
+// edgeBlocks 第一个分量中位于图像边缘的块（按实际图像尺寸，不含补齐的块）
+func (img *jpegImage) edgeBlocks() []*jpegBlock {
+	c := img.components[0]
+	width, height := img.componentSize(c)
+	cols, rows := (width+7)/8, (height+7)/8
+
+	var blocks []*jpegBlock
+	for by := 0; by < rows; by++ {
+		for bx := 0; bx < cols; bx++ {
+			if bx == 0 || by == 0 || bx == cols-1 || by == rows-1 {
+				blocks = append(blocks, &c.blocks[by*c.blocksPerLine+bx])
+			}
+		}
+	}
+	return blocks
+}
+
+// coefficientEdits 按优先级列出一个块的候选修改：先是非零AC系数（改动最不明显），然后是DC，最后是零AC系数
+// offset 决定同类系数的起始位置，negative 决定优先尝试的方向；修改后超出可编码范围的候选被排除
+func coefficientEdits(block *jpegBlock, offset byte, negative bool) []jpegCoefficientEdit {
+	var nonzero, zero []int
+	for i := 0; i < 63; i++ {
+		k := 1 + (int(offset)+i)%63
+		if block[k] != 0 {
+			nonzero = append(nonzero, k)
+		} else {
+			zero = append(zero, k)
+		}
+	}
+	order := append(append(nonzero, 0), zero...)
+
+	deltas := []int16{1, -1}
+	if negative {
+		deltas[0], deltas[1] = -1, 1
+	}
+	var edits []jpegCoefficientEdit
+	for _, k := range order {
+		for _, delta := range deltas {
+			if value := block[k] + delta; value >= -1023 && value <= 1023 {
+				edits = append(edits, jpegCoefficientEdit{k: k, delta: delta})
+			}
+		}
+	}
+	return edits
+}
+
+// rewriteScans 重新熵编码编码了第 k 个系数变化的扫描，其余字节原样复制
+func (img *jpegImage) rewriteScans(data []byte, before, after *jpegBlock, k int) ([]byte, error) {
+	var buf bytes.Buffer
+	cursor, rewritten := 0, 0
+	for i := range img.scans {
+		scan := &img.scans[i]
+		if !img.scanCodes(scan, before, after, k) {
+			continue
+		}
+		buf.Write(data[cursor:scan.dataStart])
+		if err := img.encodeScan(&buf, scan, img.progressive()); err != nil {
+			return nil, err
+		}
+		cursor = scan.dataEnd
+		rewritten++
+	}
+	if rewritten == 0 {
+		return nil, fmt.Errorf("没有扫描编码该系数")
+	}
+	buf.Write(data[cursor:])
+	return buf.Bytes(), nil
+}
+
+// scanCodes 判断扫描编码的内容是否因系数变化而改变
+// 渐进式扫描只编码系数右移 Al 位后的部分；±1 的变化只有进位到第 Al 位时才影响该扫描
+func (img *jpegImage) scanCodes(scan *jpegScan, before, after *jpegBlock, k int) bool {
+	found := false
+	for _, sc := range scan.components {
+		found = found || sc.index == 0
+	}
+	if !found {
+		return false
+	}
+	if k < scan.ss || k > scan.se {
+		return false
+	}
+	if !img.progressive() {
+		return true
+	}
+
+	shift := func(value int16) int {
+		if k == 0 {
+			return int(value) >> uint(scan.al)
+		}
+		if value < 0 {
+			return -(-int(value) >> uint(scan.al))
+		}
+		return int(value) >> uint(scan.al)
+	}
+	return shift(before[k]) != shift(after[k])
+}
@@ -0,0 +1,159 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// rebuildTestJPEG 按原系数重新编码为渐进式或顺序模式（最优霍夫曼表），可设置重启间隔
+func rebuildTestJPEG(t testing.TB, source []byte, progressive bool, restartInterval int) []byte {
+	img, err := NewImageModifier().decodeJPEG(source)
+	if err != nil {
+		t.Fatalf("系数解码失败: %v", err)
+	}
+	data, err := img.encode(&jpegEncodeOptions{progressive: progressive, optimize: true, restartInterval: restartInterval})
+	if err != nil {
+		t.Fatalf("重新编码失败: %v", err)
+	}
+	return data
+}
+
+// testCoefficientSources 测试用的基线、渐进式及带重启间隔的JPEG
+func testCoefficientSources(t *testing.T) map[string][]byte {
+	src := image.NewYCbCr(image.Rect(0, 0, 45, 31), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = uint8(i * 7)
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = uint8(i*3), uint8(255-i)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 85}); err != nil {
+		t.Fatalf("编码JPEG失败: %v", err)
+	}
+	gray := buildTestJPEG(t, 30, 17, [][2]int{{1, 1}}, nil)
+	// 标准库对非交错扫描按MCU而不是按块计算重启间隔，色度抽样时与规范不符，带重启间隔的渐进式文件使用4:4:4
+	full := buildTestJPEG(t, 27, 21, [][2]int{{1, 1}, {1, 1}, {1, 1}}, nil)
+
+	return map[string][]byte{
+		"baseline":            buf.Bytes(),
+		"baseline-restart":    rebuildTestJPEG(t, buf.Bytes(), false, 3),
+		"progressive":         rebuildTestJPEG(t, buf.Bytes(), true, 0),
+		"full":                full,
+		"progressive-restart": rebuildTestJPEG(t, full, true, 2),
+		"progressive-gray":    rebuildTestJPEG(t, gray, true, 0),
+	}
+}
+
+func TestEncodeScanReproducesSource(t *testing.T) {
+	modifier := NewImageModifier()
+	sources := testCoefficientSources(t)
+	for name, data := range sources {
+		img, err := modifier.decodeJPEG(data)
+		if err != nil {
+			t.Fatalf("%s: 系数解码失败: %v", name, err)
+		}
+		for i := range img.scans {
+			scan := &img.scans[i]
+			var buf bytes.Buffer
+			if err := img.encodeScan(&buf, scan, img.progressive()); err != nil {
+				t.Fatalf("%s: 编码扫描失败: %v", name, err)
+			}
+			if !bytes.Equal(buf.Bytes(), data[scan.dataStart:scan.dataEnd]) {
+				t.Errorf("%s: 第 %d 个扫描重新编码后与原数据不同", name, i)
+			}
+		}
+	}
+
+	// 渐进式文件由标准库解码后应与基线文件像素相同
+	assertJPEGPixelsEqual(t, sources["baseline"], sources["progressive"])
+	assertJPEGPixelsEqual(t, sources["full"], sources["progressive-restart"])
+}
+
+// diffCoefficients 返回两个文件各分量中不同的系数个数，以及变化的绝对值之和
+func diffCoefficients(t *testing.T, a, b []byte) (int, int) {
+	modifier := NewImageModifier()
+	imgA, err := modifier.decodeJPEG(a)
+	if err != nil {
+		t.Fatalf("系数解码失败: %v", err)
+	}
+	imgB, err := modifier.decodeJPEG(b)
+	if err != nil {
+		t.Fatalf("系数解码失败: %v", err)
+	}
+	count, total := 0, 0
+	for ci, c := range imgA.components {
+		for i := range c.blocks {
+			for k := range c.blocks[i] {
+				if d := int(c.blocks[i][k]) - int(imgB.components[ci].blocks[i][k]); d != 0 {
+					count++
+					total += abs(d)
+				}
+			}
+		}
+	}
+	return count, total
+}
+
+func TestModifyJPEGCoefficient(t *testing.T) {
+	modifier := &ImageModifier{JPEGCoefficientMode: true}
+	for name, data := range testCoefficientSources(t) {
+		original, err := modifier.decodeJPEG(data)
+		if err != nil {
+			t.Fatalf("%s: 系数解码失败: %v", name, err)
+		}
+
+		for i := 0; i < 10; i++ {
+			result := &PixelModifyResult{}
+			modified, err := modifier.modifyJPEGPixel(data, result)
+			if err != nil {
+				t.Fatalf("%s: 系数微调失败: %v", name, err)
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(modified)); err != nil {
+				t.Fatalf("%s: 标准库无法解码: %v", name, err)
+			}
+			if count, total := diffCoefficients(t, data, modified); count != 1 || total != 1 {
+				t.Fatalf("%s: 应只有一个系数变化1，实际 %d 个、共变化 %d", name, count, total)
+			}
+
+			// 扫描之外的字节和扫描头全部保持不变
+			img, _ := modifier.decodeJPEG(modified)
+			if len(img.scans) != len(original.scans) {
+				t.Fatalf("%s: 扫描数量变化", name)
+			}
+			if !bytes.Equal(data[:original.scans[0].dataStart], modified[:img.scans[0].dataStart]) {
+				t.Fatalf("%s: 第一个扫描之前的数据发生变化", name)
+			}
+			for s, scan := range img.scans {
+				orig := original.scans[s]
+				if !bytes.Equal(data[orig.headerStart:orig.dataStart], modified[scan.headerStart:scan.dataStart]) {
+					t.Fatalf("%s: 第 %d 个扫描头发生变化", name, s)
+				}
+			}
+			last, lastOrig := img.scans[len(img.scans)-1], original.scans[len(original.scans)-1]
+			if !bytes.Equal(data[lastOrig.dataEnd:], modified[last.dataEnd:]) {
+				t.Fatalf("%s: 最后一个扫描之后的数据发生变化", name)
+			}
+		}
+	}
+}
+
+func TestModifyJPEGCoefficientRepeatedly(t *testing.T) {
+	modifier := &ImageModifier{JPEGCoefficientMode: true}
+	data := testCoefficientSources(t)["progressive"]
+	current := data
+	for i := 0; i < 8; i++ {
+		modified, err := modifier.modifyJPEGPixel(current, &PixelModifyResult{})
+		if err != nil {
+			t.Fatalf("系数微调失败: %v", err)
+		}
+		current = modified
+	}
+
+	// 每次只改动一个系数，多次修改的变化不会超过修改次数
+	if _, total := diffCoefficients(t, data, current); total > 8 {
+		t.Errorf("多次修改后系数共变化 %d，超过修改次数", total)
+	}
+}
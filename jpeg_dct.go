@@ -0,0 +1,125 @@
+package imagemodify
+
+import (
+	"math"
+)
+
+// jpegDCTBasis DCT基函数：jpegDCTBasis[u][x] = C(u)/2 * cos((2x+1)uπ/16)，C(0)=1/√2，其余为1
+// 以此为基的二维DCT是正交变换，正反变换使用同一张表
+var jpegDCTBasis = func() (basis [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		scale := 0.5
+		if u == 0 {
+			scale = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			basis[u][x] = scale * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return basis
+}()
+
+// jpegIDCT 二维逆DCT，输入输出均为自然顺序（行优先）
+func jpegIDCT(coeffs *[64]float64) [64]float64 {
+	var tmp, out [64]float64
+	// 先对每行做一维变换，再对每列
+	for v := 0; v < 8; v++ {
+		for x := 0; x < 8; x++ {
+			sum := 0.0
+			for u := 0; u < 8; u++ {
+				sum += jpegDCTBasis[u][x] * coeffs[v*8+u]
+			}
+			tmp[v*8+x] = sum
+		}
+	}
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			sum := 0.0
+			for v := 0; v < 8; v++ {
+				sum += jpegDCTBasis[v][y] * tmp[v*8+x]
+			}
+			out[y*8+x] = sum
+		}
+	}
+	return out
+}
+
+// jpegFDCT 二维正DCT，输入输出均为自然顺序（行优先）
+func jpegFDCT(samples *[64]float64) [64]float64 {
+	var tmp, out [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < 8; x++ {
+				sum += jpegDCTBasis[u][x] * samples[y*8+x]
+			}
+			tmp[y*8+u] = sum
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			sum := 0.0
+			for y := 0; y < 8; y++ {
+				sum += jpegDCTBasis[v][y] * tmp[y*8+u]
+			}
+			out[v*8+u] = sum
+		}
+	}
+	return out
+}
+
+// blockSamples 将一个块反量化并做逆DCT，得到 0~255 的采样值（自然顺序）
+func (img *jpegImage) blockSamples(c *jpegComponent, block *jpegBlock) [64]float64 {
+	var coeffs [64]float64
+	quant := &img.quant[c.tq]
+	for k := 0; k < 64; k++ {
+		coeffs[jpegUnzig[k]] = float64(block[k]) * float64(quant[k])
+	}
+	samples := jpegIDCT(&coeffs)
+	for i, s := range samples {
+		samples[i] = math.Max(0, math.Min(255, math.Round(s+128)))
+	}
+	return samples
+}
+
+// quantizeBlock 对采样值做正DCT并量化，得到块的系数（之字形顺序）
+func (img *jpegImage) quantizeBlock(c *jpegComponent, samples *[64]float64) jpegBlock {
+	var shifted [64]float64
+	for i, s := range samples {
+		shifted[i] = s - 128
+	}
+	coeffs := jpegFDCT(&shifted)
+
+	var block jpegBlock
+	quant := &img.quant[c.tq]
+	for k := 0; k < 64; k++ {
+		block[k] = clampJPEGCoefficient(math.Round(coeffs[jpegUnzig[k]] / float64(quant[k])))
+	}
+	return block
+}
+
+// requantize 将全部系数换算到新的量化表：系数 × 旧量化值 ÷ 新量化值（四舍五入）
+// 新旧量化表相同的分量保持不变
+func (img *jpegImage) requantize(quant [4][64]uint16) {
+	for _, c := range img.components {
+		oldQuant, newQuant := img.quant[c.tq], quant[c.tq]
+		if oldQuant == newQuant {
+			continue
+		}
+		for i := range c.blocks {
+			block := &c.blocks[i]
+			for k, value := range block {
+				if value != 0 {
+					block[k] = clampJPEGCoefficient(math.Round(float64(value) * float64(oldQuant[k]) / float64(newQuant[k])))
+				}
+			}
+		}
+	}
+	img.quant = quant
+}
+
+// clampJPEGCoefficient 将系数限制在8位精度可编码的范围内
+// AC系数最多10位；DC限制在同样范围内，保证相邻块的差值不超过11位
+func clampJPEGCoefficient(value float64) int16 {
+	return int16(math.Max(-1023, math.Min(1023, value)))
+}
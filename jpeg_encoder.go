@@ -0,0 +1,533 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// jpegEncodeOptions 系数级重新编码的参数
+type jpegEncodeOptions struct {
+	segments        []jpegSegment // 写在帧头之前的标记段（APPn、COM等），按顺序写入
+	progressive     bool          // 编码为渐进式（SOF2），否则为顺序模式
+	optimize        bool          // 按符号统计生成最优霍夫曼表，否则使用标准表；渐进式总是使用最优表
+	restartInterval int           // 重启间隔（MCU数），0表示不使用
+	trailing        []byte        // 写在EOI之后的数据
+}
+
+// jpegBitWriter 熵编码数据的位写入器，自动插入0xFF之后的填充字节
+type jpegBitWriter struct {
+	buf *bytes.Buffer
+	acc uint32
+	n   int
+}
+
+// writeBits 写入 bits 的低 n 位（n 不超过16）
+func (w *jpegBitWriter) writeBits(bits uint32, n int) {
+	w.acc = w.acc<<uint(n) | bits&(1<<uint(n)-1)
+	w.n += n
+	for w.n >= 8 {
+		b := byte(w.acc >> uint(w.n-8))
+		w.buf.WriteByte(b)
+		if b == 0xFF {
+			w.buf.WriteByte(0x00)
+		}
+		w.n -= 8
+	}
+	w.acc &= 1<<uint(w.n) - 1
+}
+
+// writeSymbol 写入一个霍夫曼符号
+func (w *jpegBitWriter) writeSymbol(e *jpegHuffmanEncoder, symbol byte) error {
+	if e.freq != nil {
+		e.freq[symbol]++
+	} else if !e.has(symbol) {
+		return fmt.Errorf("霍夫曼表中没有符号 0x%02X", symbol)
+	}
+	w.writeBits(uint32(e.codes[symbol]), int(e.sizes[symbol]))
+	return nil
+}
+
+// writeValue 写入系数值的附加位（负数取反码）
+func (w *jpegBitWriter) writeValue(value, size int) {
+	if value < 0 {
+		value--
+	}
+	w.writeBits(uint32(value), size)
+}
+
+// flush 用1填充到字节边界
+func (w *jpegBitWriter) flush() {
+	if w.n > 0 {
+		w.writeBits(1<<uint(8-w.n)-1, 8-w.n)
+	}
+}
+
+// jpegBitLength 系数绝对值的位数（即霍夫曼符号中的SSSS）
+func jpegBitLength(value int) int {
+	if value < 0 {
+		value = -value
+	}
+	n := 0
+	for value > 0 {
+		n++
+		value >>= 1
+	}
+	return n
+}
+
+// encodeJPEGSequentialBlock 按顺序模式编码一个块
+func encodeJPEGSequentialBlock(w *jpegBitWriter, block *jpegBlock, dc, ac *jpegHuffmanEncoder, pred *int) error {
+	diff := int(block[0]) - *pred
+	*pred = int(block[0])
+	size := jpegBitLength(diff)
+	if err := w.writeSymbol(dc, byte(size)); err != nil {
+		return err
+	}
+	w.writeValue(diff, size)
+
+	run := 0
+	for k := 1; k < 64; k++ {
+		if block[k] == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			if err := w.writeSymbol(ac, 0xF0); err != nil { // ZRL
+				return err
+			}
+			run -= 16
+		}
+		size := jpegBitLength(int(block[k]))
+		if err := w.writeSymbol(ac, byte(run<<4|size)); err != nil {
+			return err
+		}
+		w.writeValue(int(block[k]), size)
+		run = 0
+	}
+	if run > 0 {
+		return w.writeSymbol(ac, 0x00) // EOB
+	}
+	return nil
+}
+
+// writeJPEGSegment 写入一个带长度字段的标记段
+func writeJPEGSegment(buf *bytes.Buffer, marker byte, data []byte) {
+	buf.Write([]byte{0xFF, marker})
+	binary.Write(buf, binary.BigEndian, uint16(len(data)+2))
+	buf.Write(data)
+}
+
+// encode 将系数重新编码为JPEG
+// 保留分量标识、采样因子和量化表编号；量化表取自 img.quant，
+// 第一个分量使用0号霍夫曼表，其余分量使用1号表
+func (img *jpegImage) encode(options *jpegEncodeOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, jpegMarkerSOI})
+	for _, segment := range options.segments {
+		writeJPEGSegment(&buf, segment.marker, segment.data)
+	}
+
+	// 量化表：只写入分量用到的表，顺序模式下任一表超过8位时使用扩展顺序模式（SOF1）
+	sofMarker := byte(jpegMarkerSOF0)
+	if options.progressive {
+		sofMarker = jpegMarkerSOF2
+	}
+	var dqt []byte
+	written := [4]bool{}
+	for _, c := range img.components {
+		if written[c.tq] {
+			continue
+		}
+		written[c.tq] = true
+		table := img.quant[c.tq]
+		wide := false
+		for _, q := range table {
+			wide = wide || q > 255
+		}
+		if wide {
+			if !options.progressive {
+				sofMarker = jpegMarkerSOF1
+			}
+			dqt = append(dqt, 0x10|byte(c.tq))
+			for _, q := range table {
+				dqt = binary.BigEndian.AppendUint16(dqt, q)
+			}
+		} else {
+			dqt = append(dqt, byte(c.tq))
+			for _, q := range table {
+				dqt = append(dqt, byte(q))
+			}
+		}
+	}
+	writeJPEGSegment(&buf, jpegMarkerDQT, dqt)
+
+	// 帧头
+	sof := []byte{8, byte(img.height >> 8), byte(img.height), byte(img.width >> 8), byte(img.width), byte(len(img.components))}
+	for _, c := range img.components {
+		sof = append(sof, c.id, byte(c.h<<4|c.v), byte(c.tq))
+	}
+	writeJPEGSegment(&buf, sofMarker, sof)
+
+	// 霍夫曼表
+	scans := img.sequentialScans()
+	if options.progressive {
+		scans = img.progressiveScans()
+	}
+	for _, scan := range scans {
+		scan.restartInterval = options.restartInterval
+	}
+	var dcSpecs, acSpecs [4]*jpegHuffmanSpec
+	if options.progressive || options.optimize {
+		var err error
+		if dcSpecs, acSpecs, err = img.optimalHuffmanSpecs(scans, options.progressive); err != nil {
+			return nil, err
+		}
+	} else {
+		dcSpecs[0], acSpecs[0] = jpegStdDCLuminance, jpegStdACLuminance
+		if len(img.components) > 1 {
+			dcSpecs[1], acSpecs[1] = jpegStdDCChrominance, jpegStdACChrominance
+		}
+	}
+	var dht []byte
+	for i := range dcSpecs {
+		if dcSpecs[i] != nil {
+			dht = append(append(dht, byte(i)), dcSpecs[i].encode()...)
+		}
+		if acSpecs[i] != nil {
+			dht = append(append(dht, 0x10|byte(i)), acSpecs[i].encode()...)
+		}
+	}
+	writeJPEGSegment(&buf, jpegMarkerDHT, dht)
+
+	if options.restartInterval > 0 {
+		writeJPEGSegment(&buf, jpegMarkerDRI, binary.BigEndian.AppendUint16(nil, uint16(options.restartInterval)))
+	}
+
+	for _, scan := range scans {
+		sos := []byte{byte(len(scan.components))}
+		for _, sc := range scan.components {
+			sos = append(sos, img.components[sc.index].id, byte(sc.td<<4|sc.ta))
+		}
+		sos = append(sos, byte(scan.ss), byte(scan.se), byte(scan.ah<<4|scan.al))
+		writeJPEGSegment(&buf, jpegMarkerSOS, sos)
+
+		scan.dcTables, scan.acTables = dcSpecs, acSpecs
+		if err := img.encodeScan(&buf, scan, options.progressive); err != nil {
+			return nil, err
+		}
+	}
+
+	buf.Write([]byte{0xFF, jpegMarkerEOI})
+	buf.Write(options.trailing)
+	return buf.Bytes(), nil
+}
+
+// optimalHuffmanSpecs 统计各扫描中每张表用到的符号频率，生成最优霍夫曼表
+func (img *jpegImage) optimalHuffmanSpecs(scans []*jpegScan, progressive bool) (dcSpecs, acSpecs [4]*jpegHuffmanSpec, err error) {
+	var dc, ac [4]*jpegHuffmanEncoder
+	for i := range dc {
+		dc[i] = &jpegHuffmanEncoder{freq: make([]int, 256)}
+		ac[i] = &jpegHuffmanEncoder{freq: make([]int, 256)}
+	}
+
+	var discard bytes.Buffer
+	for _, scan := range scans {
+		discard.Reset()
+		if err := img.encodeScanWith(&discard, scan, progressive, &dc, &ac); err != nil {
+			return dcSpecs, acSpecs, err
+		}
+	}
+
+	for i := range dc {
+		dcSpecs[i] = buildJPEGHuffmanSpec(dc[i].freq)
+		acSpecs[i] = buildJPEGHuffmanSpec(ac[i].freq)
+	}
+	return dcSpecs, acSpecs, nil
+}
+
+// jpegHuffmanTable 分量使用的霍夫曼表编号：第一个分量为0号，其余为1号
+func jpegHuffmanTable(index int) int {
+	if index == 0 {
+		return 0
+	}
+	return 1
+}
+
+// interleavedScans 对全部分量编码同一频谱范围的扫描：
+// MCU不超过10个块时全部分量交错为一次扫描，否则每个分量单独扫描
+func (img *jpegImage) interleavedScans(ss, se, ah, al int) []*jpegScan {
+	blocksPerMCU := 0
+	for _, c := range img.components {
+		blocksPerMCU += c.h * c.v
+	}
+
+	var scans []*jpegScan
+	if len(img.components) == 1 || blocksPerMCU <= 10 {
+		scan := &jpegScan{ss: ss, se: se, ah: ah, al: al}
+		for i := range img.components {
+			table := jpegHuffmanTable(i)
+			scan.components = append(scan.components, jpegScanComponent{index: i, td: table, ta: table})
+		}
+		return append(scans, scan)
+	}
+	for i := range img.components {
+		scans = append(scans, img.componentScan(i, ss, se, ah, al))
+	}
+	return scans
+}
+
+// componentScan 单个分量的扫描
+func (img *jpegImage) componentScan(index, ss, se, ah, al int) *jpegScan {
+	table := jpegHuffmanTable(index)
+	return &jpegScan{ss: ss, se: se, ah: ah, al: al, components: []jpegScanComponent{{index: index, td: table, ta: table}}}
+}
+
+// sequentialScans 顺序模式的扫描划分
+func (img *jpegImage) sequentialScans() []*jpegScan {
+	return img.interleavedScans(0, 63, 0, 0)
+}
+
+// progressiveScans 渐进式的扫描划分，与libjpeg的默认脚本相同：
+// DC先编码除最低位外的部分，AC先以低频（1~5）和高频（6~63）分两次编码高位，再逐位细化
+func (img *jpegImage) progressiveScans() []*jpegScan {
+	scans := img.interleavedScans(0, 0, 0, 1)
+	for i := range img.components {
+		scans = append(scans, img.componentScan(i, 1, 5, 0, 2), img.componentScan(i, 6, 63, 0, 2))
+	}
+	for i := range img.components {
+		scans = append(scans, img.componentScan(i, 1, 63, 2, 1))
+	}
+	scans = append(scans, img.interleavedScans(0, 0, 1, 0)...)
+	for i := range img.components {
+		scans = append(scans, img.componentScan(i, 1, 63, 1, 0))
+	}
+	return scans
+}
+
+// jpegScanEncoder 一次扫描的熵编码状态
+type jpegScanEncoder struct {
+	w        *jpegBitWriter
+	scan     *jpegScan
+	dc, ac   []*jpegHuffmanEncoder
+	preds    []int
+	eobrun   int    // 尚未写出的连续EOB块数（渐进式AC扫描）
+	corrBits []byte // 属于EOB游程中各块的细化位，随EOBRUN一起写出
+}
+
+// jpegMaxCorrBits 缓冲的细化位达到该数量时提前写出EOBRUN（与libjpeg一致）
+const jpegMaxCorrBits = 1000 - 64 + 1
+
+// encodeScan 按扫描参数熵编码全部块并写入 buf（不含SOS段本身）
+// 霍夫曼表取自 scan.dcTables/acTables；progressive 为 false 时按顺序模式编码。
+// 设置了重启间隔时在每个间隔后写入RST标记，编码方式与libjpeg相同
+func (img *jpegImage) encodeScan(buf *bytes.Buffer, scan *jpegScan, progressive bool) error {
+	var dc, ac [4]*jpegHuffmanEncoder
+	for _, sc := range scan.components {
+		if scan.ss == 0 && scan.ah == 0 && dc[sc.td] == nil {
+			if scan.dcTables[sc.td] == nil {
+				return fmt.Errorf("扫描引用了未定义的霍夫曼表")
+			}
+			dc[sc.td] = newJPEGHuffmanEncoder(scan.dcTables[sc.td])
+		}
+		if scan.se > 0 && ac[sc.ta] == nil {
+			if scan.acTables[sc.ta] == nil {
+				return fmt.Errorf("扫描引用了未定义的霍夫曼表")
+			}
+			ac[sc.ta] = newJPEGHuffmanEncoder(scan.acTables[sc.ta])
+		}
+	}
+	return img.encodeScanWith(buf, scan, progressive, &dc, &ac)
+}
+
+// encodeScanWith 使用给定的编码器（按表编号）熵编码一次扫描
+func (img *jpegImage) encodeScanWith(buf *bytes.Buffer, scan *jpegScan, progressive bool, dc, ac *[4]*jpegHuffmanEncoder) error {
+	e := &jpegScanEncoder{
+		w:     &jpegBitWriter{buf: buf},
+		scan:  scan,
+		dc:    make([]*jpegHuffmanEncoder, len(scan.components)),
+		ac:    make([]*jpegHuffmanEncoder, len(scan.components)),
+		preds: make([]int, len(scan.components)),
+	}
+	for i, sc := range scan.components {
+		e.dc[i], e.ac[i] = dc[sc.td], ac[sc.ta]
+	}
+
+	for n, mcu := range img.scanMCUs(scan) {
+		if scan.restartInterval > 0 && n > 0 && n%scan.restartInterval == 0 {
+			if err := e.emitEOBRun(); err != nil {
+				return err
+			}
+			e.w.flush()
+			buf.Write([]byte{0xFF, jpegMarkerRST0 + byte((n/scan.restartInterval-1)%8)})
+			for i := range e.preds {
+				e.preds[i] = 0
+			}
+		}
+
+		for _, ref := range mcu {
+			var err error
+			switch {
+			case !progressive:
+				err = encodeJPEGSequentialBlock(e.w, ref.block, e.dc[ref.scanIndex], e.ac[ref.scanIndex], &e.preds[ref.scanIndex])
+			case scan.ss == 0 && scan.ah == 0:
+				err = e.encodeDCFirst(ref.block, ref.scanIndex)
+			case scan.ss == 0:
+				e.w.writeBits(uint32(int(ref.block[0])>>uint(scan.al))&1, 1)
+			case scan.ah == 0:
+				err = e.encodeACFirst(ref.block)
+			default:
+				err = e.encodeACRefine(ref.block)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := e.emitEOBRun(); err != nil {
+		return err
+	}
+	e.w.flush()
+	return nil
+}
+
+// emitEOBRun 写出累计的EOBRUN及其细化位
+func (e *jpegScanEncoder) emitEOBRun() error {
+	if e.eobrun > 0 {
+		nbits := jpegBitLength(e.eobrun) - 1
+		if err := e.w.writeSymbol(e.ac[0], byte(nbits<<4)); err != nil {
+			return err
+		}
+		e.w.writeBits(uint32(e.eobrun), nbits)
+		e.eobrun = 0
+	}
+	e.writeCorrBits(e.corrBits)
+	e.corrBits = e.corrBits[:0]
+	return nil
+}
+
+// encodeDCFirst 渐进式DC首次扫描：编码右移 Al 位后的DC差值
+func (e *jpegScanEncoder) encodeDCFirst(block *jpegBlock, index int) error {
+	value := int(block[0]) >> uint(e.scan.al)
+	diff := value - e.preds[index]
+	e.preds[index] = value
+	size := jpegBitLength(diff)
+	if err := e.w.writeSymbol(e.dc[index], byte(size)); err != nil {
+		return err
+	}
+	e.w.writeValue(diff, size)
+	return nil
+}
+
+// encodeACFirst 渐进式AC首次扫描：系数绝对值右移 Al 位后编码，块尾的零计入EOBRUN
+func (e *jpegScanEncoder) encodeACFirst(block *jpegBlock) error {
+	run := 0
+	for k := e.scan.ss; k <= e.scan.se; k++ {
+		value := int(block[k])
+		if value < 0 {
+			value = -(-value >> uint(e.scan.al))
+		} else {
+			value >>= uint(e.scan.al)
+		}
+		if value == 0 {
+			run++
+			continue
+		}
+		if err := e.emitEOBRun(); err != nil {
+			return err
+		}
+		for run > 15 {
+			if err := e.w.writeSymbol(e.ac[0], 0xF0); err != nil {
+				return err
+			}
+			run -= 16
+		}
+		size := jpegBitLength(value)
+		if err := e.w.writeSymbol(e.ac[0], byte(run<<4|size)); err != nil {
+			return err
+		}
+		e.w.writeValue(value, size)
+		run = 0
+	}
+
+	if run > 0 {
+		e.eobrun++
+		if e.eobrun == 0x7FFF {
+			return e.emitEOBRun()
+		}
+	}
+	return nil
+}
+
+// encodeACRefine 渐进式AC细化扫描：新出现的 ±1 系数编码为符号，已有非零系数追加一位细化位
+func (e *jpegScanEncoder) encodeACRefine(block *jpegBlock) error {
+	var absValues [64]int
+	last := -1 // 最后一个新出现的非零系数
+	for k := e.scan.ss; k <= e.scan.se; k++ {
+		value := int(block[k])
+		if value < 0 {
+			value = -value
+		}
+		absValues[k] = value >> uint(e.scan.al)
+		if absValues[k] == 1 {
+			last = k
+		}
+	}
+
+	run := 0
+	var pending []byte // 本块中尚未写出的细化位
+	for k := e.scan.ss; k <= e.scan.se; k++ {
+		value := absValues[k]
+		if value == 0 {
+			run++
+			continue
+		}
+		for run > 15 && k <= last {
+			if err := e.emitEOBRun(); err != nil {
+				return err
+			}
+			if err := e.w.writeSymbol(e.ac[0], 0xF0); err != nil {
+				return err
+			}
+			run -= 16
+			e.writeCorrBits(pending)
+			pending = pending[:0]
+		}
+		if value > 1 {
+			pending = append(pending, byte(value&1))
+			continue
+		}
+
+		if err := e.emitEOBRun(); err != nil {
+			return err
+		}
+		if err := e.w.writeSymbol(e.ac[0], byte(run<<4|1)); err != nil {
+			return err
+		}
+		sign := uint32(0)
+		if block[k] > 0 {
+			sign = 1
+		}
+		e.w.writeBits(sign, 1)
+		e.writeCorrBits(pending)
+		pending = pending[:0]
+		run = 0
+	}
+
+	if run > 0 || len(pending) > 0 {
+		e.eobrun++
+		e.corrBits = append(e.corrBits, pending...)
+		if e.eobrun == 0x7FFF || len(e.corrBits) > jpegMaxCorrBits {
+			return e.emitEOBRun()
+		}
+	}
+	return nil
+}
+
+// writeCorrBits 写出细化位
+func (e *jpegScanEncoder) writeCorrBits(bits []byte) {
+	for _, bit := range bits {
+		e.w.writeBits(uint32(bit), 1)
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"image/jpeg"
+	"io"
 )
 
 // modifyJPEGSHA1 修改JPEG图片的SHA1值
 // 通过在JPEG的Comment段中添加随机数据来改变SHA1，不影响图片显示
 func (m *ImageModifier) modifyJPEGSHA1(data []byte) ([]byte, error) {
 	// 解码JPEG图片
+	if err := m.checkDecodeConfig(data); err != nil {
+		return nil, err
+	}
 	img, err := jpeg.Decode(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("解码JPEG图片失败: %v", err)
@@ -34,36 +38,75 @@ func (m *ImageModifier) modifyJPEGSHA1(data []byte) ([]byte, error) {
 
 	// 在JPEG数据中插入随机注释段
 	// JPEG格式允许插入注释段而不影响图片显示
-	modifiedData := m.insertJPEGComment(buf.Bytes(), randomComment)
-
-	return modifiedData, nil
+	return m.insertJPEGComment(buf.Bytes(), randomComment)
 }
 
-// insertJPEGComment 在JPEG数据中插入注释段
-func (m *ImageModifier) insertJPEGComment(data []byte, comment []byte) []byte {
+// JPEGCommentPlacement 插入JPEG注释段（COM）的位置
+type JPEGCommentPlacement int
+
+const (
+	// JPEGCommentAfterAPPn 默认位置：SOI之后连续的APPn段之后。
+	// JFIF和EXIF要求APP0/APP1紧跟在SOI之后，注释段放在它们前面会干扰部分读取器的识别
+	JPEGCommentAfterAPPn JPEGCommentPlacement = iota
+
+	// JPEGCommentBeforeSOS 第一个SOS段之前，即全部量化表、霍夫曼表和帧头之后
+	JPEGCommentBeforeSOS
+
+	// JPEGCommentAfterEOI 紧跟在EOI之后，作为附加数据；解码器不会读取，标记段结构完全不变
+	JPEGCommentAfterEOI
+)
+
+// insertJPEGComment 按 JPEGCommentPlacement 在JPEG数据中插入注释段
+func (m *ImageModifier) insertJPEGComment(data []byte, comment []byte) ([]byte, error) {
 	// JPEG文件格式：
 	// FF D8 (SOI) ... 各种段 ... FF D9 (EOI)
-	// 注释段格式：FF FE [长度高字节] [长度低字节] [注释数据]
-
-	if len(data) < 4 {
-		return data
+	// 注释段格式：FF FE [长度高字节] [长度低字节] [注释数据]，长度包括长度字段本身
+	if len(comment) > 0xFFFF-2 {
+		return nil, fmt.Errorf("注释过长: %d 字节", len(comment))
 	}
 
-	// 寻找插入位置（在SOI之后，第一个段之前）
-	insertPos := 2 // 跳过SOI标记 (FF D8)
-
-	// 构造注释段
-	commentLength := len(comment) + 2 // 注释数据长度 + 长度字段本身
-	commentSegment := make([]byte, 0, commentLength+2)
-	commentSegment = append(commentSegment, 0xFF, 0xFE)                                       // 注释段标记
-	commentSegment = append(commentSegment, byte(commentLength>>8), byte(commentLength&0xFF)) // 长度
-	commentSegment = append(commentSegment, comment...)                                       // 注释数据
+	insertPos, err := m.findJPEGCommentInsertPos(data)
+	if err != nil {
+		return nil, err
+	}
 
-	// 构造新的JPEG数据
-	result := make([]byte, 0, len(data)+len(commentSegment))
-	result = append(result, data[:insertPos]...) // SOI
-	result = append(result, commentSegment...)   // 注释段
-	result = append(result, data[insertPos:]...) // 剩余数据
+	var buf bytes.Buffer
+	buf.Grow(len(data) + len(comment) + 4)
+	buf.Write(data[:insertPos])
+	writeJPEGSegment(&buf, jpegMarkerCOM, comment)
+	buf.Write(data[insertPos:])
+	return buf.Bytes(), nil
+}
 
-	return result
+// findJPEGCommentInsertPos 按 JPEGCommentPlacement 查找插入注释段的位置
+func (m *ImageModifier) findJPEGCommentInsertPos(data []byte) (int, error) {
+	r := m.newJPEGSegmentReader(data)
+	for {
+		segment, err := r.next()
+		if err == io.EOF {
+			// 没有EOI的文件：EOI之后即文件末尾
+			return len(data), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		marker := segment.marker
+		switch m.JPEGCommentPlacement {
+		case JPEGCommentBeforeSOS:
+			if marker == jpegMarkerSOS {
+				return segment.start, nil
+			}
+			if marker == jpegMarkerEOI {
+				return 0, fmt.Errorf("JPEG文件缺少SOS段")
+			}
+		case JPEGCommentAfterEOI:
+			if marker == jpegMarkerEOI {
+				return segment.end, nil
+			}
+		default:
+			if marker != jpegMarkerSOI && (marker < jpegMarkerAPP0 || marker > jpegMarkerAPP15) {
+				return segment.start, nil
+			}
+		}
+	}
 }
@@ -0,0 +1,121 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTestJFIFEXIFJPEG 带JFIF（APP0）和EXIF（APP1）段的测试JPEG
+func buildTestJFIFEXIFJPEG(t *testing.T) []byte {
+	exif := append([]byte("Exif\x00\x00"), NewImageModifier().buildEXIF(&ImageMetadata{Artist: "作者"})...)
+	return buildTestJPEG(t, 32, 16, [][2]int{{2, 1}, {1, 1}, {1, 1}}, []jpegSegment{
+		{marker: jpegMarkerAPP0, data: []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")},
+		{marker: jpegMarkerAPP0 + 1, data: exif},
+	})
+}
+
+// checkJFIFEXIF 检查JFIF段紧跟在SOI之后、EXIF段紧跟在JFIF之后，且EXIF可以解析
+func checkJFIFEXIF(t *testing.T, data []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte{0xFF, jpegMarkerSOI, 0xFF, jpegMarkerAPP0}) || string(data[6:11]) != "JFIF\x00" {
+		t.Fatal("JFIF段不再紧跟在SOI之后")
+	}
+	segments, _, err := readJPEGSegments(t, data)
+	if err != nil {
+		t.Fatalf("读取标记失败: %v", err)
+	}
+	exif := segments[2]
+	if exif.marker != jpegMarkerAPP0+1 || !bytes.HasPrefix(exif.data, []byte("Exif\x00\x00")) {
+		t.Fatalf("EXIF段不再紧跟在JFIF段之后，第三个标记为 0x%02X", exif.marker)
+	}
+	var metadata ImageMetadata
+	NewImageModifier().parseEXIF(exif.data[6:], &metadata)
+	if metadata.Artist != "作者" {
+		t.Errorf("EXIF中的作者为 %q", metadata.Artist)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("image/jpeg 解码失败: %v", err)
+	}
+}
+
+func TestInsertJPEGCommentPlacement(t *testing.T) {
+	source := buildTestJFIFEXIFJPEG(t)
+	for _, tt := range []struct {
+		name      string
+		placement JPEGCommentPlacement
+		before    byte // 注释段之后的标记，0表示位于EOI之后
+	}{
+		{"after APPn", JPEGCommentAfterAPPn, jpegMarkerDQT},
+		{"before SOS", JPEGCommentBeforeSOS, jpegMarkerSOS},
+		{"after EOI", JPEGCommentAfterEOI, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			modifier := &ImageModifier{JPEGCommentPlacement: tt.placement}
+			data, err := modifier.insertJPEGComment(append(append([]byte(nil), source...), "trailing"...), []byte("comment"))
+			if err != nil {
+				t.Fatalf("插入注释段失败: %v", err)
+			}
+			checkJFIFEXIF(t, data)
+
+			r := modifier.newJPEGSegmentReader(data)
+			var previous byte
+			for {
+				segment, err := r.next()
+				if err != nil {
+					break
+				}
+				if previous == jpegMarkerCOM && segment.marker != tt.before {
+					t.Errorf("注释段之后为 0x%02X，应为 0x%02X", segment.marker, tt.before)
+				}
+				previous = segment.marker
+			}
+			trailing, _ := r.trailingComments()
+			if (tt.before == 0) != (len(trailing) == 1) || string(r.trailing()) != "trailing" {
+				t.Errorf("EOI之后有 %d 个注释段，其余数据为 %q", len(trailing), r.trailing())
+			}
+			if comments, _ := modifier.extractJPEGComments(data); len(comments) != 1 || string(comments[0]) != "comment" {
+				t.Errorf("提取的注释段为 %q", comments)
+			}
+		})
+	}
+
+	// 没有SOS的文件无法插入到SOS之前
+	if _, err := (&ImageModifier{JPEGCommentPlacement: JPEGCommentBeforeSOS}).insertJPEGComment([]byte{0xFF, 0xD8, 0xFF, 0xD9}, []byte("comment")); err == nil {
+		t.Error("缺少SOS时应返回错误")
+	}
+}
+
+func TestJPEGMetadataPlacement(t *testing.T) {
+	for _, placement := range []JPEGCommentPlacement{JPEGCommentAfterAPPn, JPEGCommentBeforeSOS, JPEGCommentAfterEOI} {
+		testFile := filepath.Join(t.TempDir(), "test.jpg")
+		if err := os.WriteFile(testFile, buildTestJFIFEXIFJPEG(t), 0644); err != nil {
+			t.Fatalf("写入测试文件失败: %v", err)
+		}
+
+		// 反复修改不会累积注释段，像素微调和无损转码保留注释段（包括EOI之后的）
+		modifier := &ImageModifier{JPEGCommentPlacement: placement}
+		for _, title := range []string{"旧标题", "标题"} {
+			if _, err := modifier.ModifyImageMetadata(testFile, &ImageMetadata{Title: title}); err != nil {
+				t.Fatalf("位置 %d: 修改元数据失败: %v", placement, err)
+			}
+		}
+		if _, err := modifier.ModifyImageSHA1ByPixel(testFile); err != nil {
+			t.Fatalf("位置 %d: 像素微调失败: %v", placement, err)
+		}
+		if _, err := modifier.ModifyImageSHA1ByTranscode(testFile); err != nil {
+			t.Fatalf("位置 %d: 无损转码失败: %v", placement, err)
+		}
+
+		data, _ := os.ReadFile(testFile)
+		checkJFIFEXIF(t, data)
+		if comments, _ := modifier.extractJPEGComments(data); len(comments) != 1 {
+			t.Errorf("位置 %d: 应有1个注释段，实际为 %d", placement, len(comments))
+		}
+		if metadata, err := modifier.GetImageMetadata(testFile); err != nil || metadata.Title != "标题" {
+			t.Errorf("位置 %d: 读取元数据失败: %+v（%v）", placement, metadata, err)
+		}
+	}
+}
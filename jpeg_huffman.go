@@ -0,0 +1,278 @@
+package imagemodify
+
+import (
+	"fmt"
+)
+
+// jpegHuffmanSpec 霍夫曼表定义（与DHT段中的格式相同）
+type jpegHuffmanSpec struct {
+	counts [16]byte // 长度为 1~16 位的码字个数
+	values []byte   // 按码字顺序排列的符号
+}
+
+// JPEG标准（附录K）中的霍夫曼表
+var (
+	jpegStdDCLuminance = &jpegHuffmanSpec{
+		counts: [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	jpegStdDCChrominance = &jpegHuffmanSpec{
+		counts: [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	jpegStdACLuminance = &jpegHuffmanSpec{
+		counts: [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		values: []byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	}
+	jpegStdACChrominance = &jpegHuffmanSpec{
+		counts: [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		values: []byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	}
+)
+
+// parseDHT 解析霍夫曼表段，写入对应的DC/AC表
+func parseDHT(segment []byte, dcTables, acTables *[4]*jpegHuffmanSpec) error {
+	for len(segment) > 0 {
+		if len(segment) < 17 {
+			return fmt.Errorf("DHT段数据不完整")
+		}
+		tc, th := segment[0]>>4, segment[0]&0x0F
+		if tc > 1 || th > 3 {
+			return fmt.Errorf("DHT段参数无效")
+		}
+
+		spec := &jpegHuffmanSpec{}
+		total := 0
+		for i := 0; i < 16; i++ {
+			spec.counts[i] = segment[1+i]
+			total += int(spec.counts[i])
+		}
+		if total == 0 || total > 256 || len(segment) < 17+total {
+			return fmt.Errorf("DHT段码字数量无效")
+		}
+		spec.values = append([]byte(nil), segment[17:17+total]...)
+
+		if tc == 0 {
+			dcTables[th] = spec
+		} else {
+			acTables[th] = spec
+		}
+		segment = segment[17+total:]
+	}
+	return nil
+}
+
+// encode 编码为DHT段中的一个表（不含类别和编号字节）
+func (s *jpegHuffmanSpec) encode() []byte {
+	return append(append([]byte(nil), s.counts[:]...), s.values...)
+}
+
+// jpegHuffmanDecoder 规范霍夫曼码的解码器
+type jpegHuffmanDecoder struct {
+	maxCode [17]int // 各长度的最大码字（-1表示该长度没有码字）
+	valPtr  [17]int // 各长度第一个码字在values中的位置
+	minCode [17]int
+	values  []byte
+}
+
+// newJPEGHuffmanDecoder 根据霍夫曼表构造解码器
+func newJPEGHuffmanDecoder(spec *jpegHuffmanSpec) (*jpegHuffmanDecoder, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("扫描引用了未定义的霍夫曼表")
+	}
+	d := &jpegHuffmanDecoder{values: spec.values}
+	code, k := 0, 0
+	for length := 1; length <= 16; length++ {
+		count := int(spec.counts[length-1])
+		d.valPtr[length] = k
+		d.minCode[length] = code
+		code += count
+		k += count
+		if count > 0 {
+			d.maxCode[length] = code - 1
+		} else {
+			d.maxCode[length] = -1
+		}
+		if code > 1<<uint(length) {
+			return nil, fmt.Errorf("霍夫曼表码字数量无效")
+		}
+		code <<= 1
+	}
+	return d, nil
+}
+
+// decode 读取一个霍夫曼符号
+func (d *jpegHuffmanDecoder) decode(r *jpegBitReader) (byte, error) {
+	code := 0
+	for length := 1; length <= 16; length++ {
+		code = code<<1 | r.readBit()
+		if code <= d.maxCode[length] {
+			return d.values[d.valPtr[length]+code-d.minCode[length]], nil
+		}
+	}
+	return 0, fmt.Errorf("无效的霍夫曼码")
+}
+
+// jpegHuffmanEncoder 霍夫曼编码表：符号 -> 码字和长度
+// freq 不为nil时只统计符号频率（生成最优表的第一遍），不输出有效码字
+type jpegHuffmanEncoder struct {
+	codes [256]uint16
+	sizes [256]byte
+	freq  []int
+}
+
+// newJPEGHuffmanEncoder 根据霍夫曼表构造编码器
+func newJPEGHuffmanEncoder(spec *jpegHuffmanSpec) *jpegHuffmanEncoder {
+	e := &jpegHuffmanEncoder{}
+	code, k := 0, 0
+	for length := 1; length <= 16; length++ {
+		for i := 0; i < int(spec.counts[length-1]); i++ {
+			e.codes[spec.values[k]] = uint16(code)
+			e.sizes[spec.values[k]] = byte(length)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return e
+}
+
+// has 判断符号是否可以编码
+func (e *jpegHuffmanEncoder) has(symbol byte) bool {
+	return e.sizes[symbol] > 0
+}
+
+// buildJPEGHuffmanSpec 根据符号频率生成最优霍夫曼表（附录K.2的算法，与libjpeg相同）
+// 码长限制在16位以内，并保留一个码字使得不会出现全1的码字；没有任何符号时返回nil
+func buildJPEGHuffmanSpec(freq []int) *jpegHuffmanSpec {
+	var counts [257]int
+	var codeSize [257]int
+	var others [257]int
+	used := false
+	for i := range others {
+		others[i] = -1
+		if i < 256 && freq[i] > 0 {
+			counts[i] = freq[i]
+			used = true
+		}
+	}
+	if !used {
+		return nil
+	}
+	counts[256] = 1 // 保留码字
+
+	for {
+		// 找出频率最小的两个符号（频率相同时取序号较大的）
+		c1, c2 := -1, -1
+		for i, v := range counts {
+			if v > 0 && (c1 < 0 || v <= counts[c1]) {
+				c1 = i
+			}
+		}
+		for i, v := range counts {
+			if v > 0 && i != c1 && (c2 < 0 || v <= counts[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+
+		counts[c1] += counts[c2]
+		counts[c2] = 0
+		codeSize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codeSize[c1]++
+		}
+		others[c1] = c2
+		codeSize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codeSize[c2]++
+		}
+	}
+
+	bits := make([]int, 258)
+	for _, size := range codeSize {
+		if size > 0 {
+			bits[size]++
+		}
+	}
+	// 超过16位的码字：每次把最长的一对移到较短的长度
+	for i := len(bits) - 1; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// 去掉保留码字（最长的一个）
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	spec := &jpegHuffmanSpec{}
+	for length := 1; length <= 16; length++ {
+		spec.counts[length-1] = byte(bits[length])
+	}
+	for size := 1; size < len(bits); size++ {
+		for symbol := 0; symbol < 256; symbol++ {
+			if codeSize[symbol] == size {
+				spec.values = append(spec.values, byte(symbol))
+			}
+		}
+	}
+	return spec
+}
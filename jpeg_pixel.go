@@ -0,0 +1,366 @@
+package imagemodify
+
+import (
+	"fmt"
+	"math"
+)
+
+// JPEG像素微调在系数级完成：解码得到各分量的量化DCT系数，只对选中像素所在的块做
+// 逆DCT -> 修改采样值 -> 正DCT的往返，其余块直接换算到输出量化表。
+// 分量数、分量标识、色度采样因子保持不变，不经过RGB转换，
+// 灰度图仍是灰度图，CMYK/YCCK也不会被转换成YCbCr
+
+// modifyJPEGPixel 通过微调像素修改JPEG图片
+// 默认原样沿用原图的量化表，除被修改的块外其余块的系数完全不变；
+// 设置 JPEGStandardTables 时改用按等效质量缩放的标准量化表。渐进式和重启间隔与原图一致，所用的设置记录在 result 中。
+// 设置 JPEGCoefficientMode 时改为只修改一个系数，见 modifyCoefficient
+func (m *ImageModifier) modifyJPEGPixel(data []byte, result *PixelModifyResult) ([]byte, error) {
+	img, err := m.decodeJPEG(data)
+	if err != nil {
+		return nil, fmt.Errorf("解码JPEG图片失败: %w", err)
+	}
+
+	result.JPEGQuality = img.estimateQuality()
+	if m.JPEGCoefficientMode {
+		result.JPEGSourceTables = true
+		return img.modifyCoefficient(data, m.generateRandomBytes(4))
+	}
+
+	result.JPEGSourceTables = !m.JPEGStandardTables
+	if m.JPEGStandardTables {
+		img.requantize(img.standardQuant(result.JPEGQuality))
+	}
+
+	score, err := m.adjustJPEGPixels(img)
+	if err != nil {
+		return nil, err
+	}
+	result.TextureScore = score
+
+	// 保持原图的编码方式：渐进式仍输出渐进式，重启间隔不变
+	result.JPEGProgressive = img.progressive()
+	encoded, err := img.encode(&jpegEncodeOptions{
+		segments:        img.ancillarySegments(),
+		progressive:     result.JPEGProgressive,
+		restartInterval: img.restartInterval,
+		trailing:        img.trailing,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("重新编码JPEG失败: %v", err)
+	}
+	return encoded, nil
+}
+
+// ancillarySegments 返回需要原样保留的APPn（EXIF、ICC、XMP、IPTC、JFIF、Adobe等）和COM段，保持原有顺序
+// ModifyImageMetadata 写入的元数据因此可以在像素微调后保留
+func (img *jpegImage) ancillarySegments() []jpegSegment {
+	var segments []jpegSegment
+	for _, segment := range img.segments {
+		if (segment.marker >= jpegMarkerAPP0 && segment.marker <= jpegMarkerAPP15) || segment.marker == jpegMarkerCOM {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// estimateQuality 估算原图量化表对应的质量：选择缩放后与原表差值之和最小的质量
+// 原表由标准表按libjpeg方式缩放得到时（绝大多数编码器如此），结果与编码时的质量一致
+func (img *jpegImage) estimateQuality() int {
+	best, bestDist := 100, -1
+	for quality := 100; quality >= 1; quality-- {
+		candidate := img.standardQuant(quality)
+		dist := 0
+		counted := [4]bool{}
+		for _, c := range img.components {
+			if counted[c.tq] {
+				continue
+			}
+			counted[c.tq] = true
+			for k := range candidate[c.tq] {
+				dist += abs(int(candidate[c.tq][k]) - int(img.quant[c.tq][k]))
+			}
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = quality, dist
+		}
+	}
+	return best
+}
+
+// standardQuant 按质量缩放的标准量化表（libjpeg的缩放方式）
+// 第一个分量使用的表取亮度表，其余取色度表
+func (img *jpegImage) standardQuant(quality int) [4][64]uint16 {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+
+	var quant [4][64]uint16
+	for i, c := range img.components {
+		base := &jpegStdLuminanceQuant
+		if i > 0 && c.tq != img.components[0].tq {
+			base = &jpegStdChrominanceQuant
+		}
+		for k, q := range base {
+			value := (int(q)*scale + 50) / 100
+			if value < 1 {
+				value = 1
+			} else if value > 255 {
+				value = 255
+			}
+			quant[c.tq][k] = uint16(value)
+		}
+	}
+	return quant
+}
+
+// adjustJPEGPixels 微调第一个分量（亮度、灰度或CMYK的C）中 PixelCount 个采样（默认一个边缘采样），
+// 返回被修改采样中最小的局部纹理强度。
+// 高质量量化下很小的调整可能被量化抵消，此时逐步加大幅度，但不超过 PixelMaxDelta（默认2）。
+// 重新量化会改变整个块，因此设置了 PixelMask 时只选择所在块完全位于允许范围内的采样；
+// 并对修改后的块做逆DCT：块内任一采样的变化超过 PixelMaxDelta，
+// 或者设置了 MaxDeltaE 时任一像素与原颜色的ΔE2000超过上限，都改试其他采样。
+// 所有采样都没有变化时依次尝试把候选所在块的DC系数调整1，同样要满足上述限制，都不满足时返回错误
+func (m *ImageModifier) adjustJPEGPixels(img *jpegImage) (float64, error) {
+	count, maxDelta := m.pixelBudget()
+	if m.MaxDeltaE > 0 && len(img.components) != 1 && len(img.components) != 3 {
+		return 0, fmt.Errorf("MaxDeltaE 不支持 %d 个分量的JPEG图片", len(img.components))
+	}
+
+	c := img.components[0]
+	rng := m.pixelRand()
+	// 没有交流分量的块视为平坦区域
+	texture := m.jpegTexture(img, c)
+	candidates, err := m.pixelCandidates(rng, img.width, img.height, count, pixelProbe{
+		texture: texture,
+		uniform: func(x, y int) bool { return img.blockVariance(c, c.blockAt(img, x, y)) == 0 },
+	})
+	if err != nil {
+		return 0, err
+	}
+	if m.PixelMask != nil {
+		// 修改会波及整个块，只保留所在块完全位于允许范围内的候选
+		allowed := candidates[:0]
+		for _, pixel := range candidates {
+			sx, sy := pixel.X*c.h/img.hmax, pixel.Y*c.v/img.vmax
+			if img.blockAllowed(c, sx/8*8, sy/8*8, m.PixelMask) {
+				allowed = append(allowed, pixel)
+			}
+		}
+		if len(allowed) == 0 {
+			return 0, fmt.Errorf("%w: 候选像素所在的块中都有 PixelMask 不允许修改的像素", ErrNoEligiblePixel)
+		}
+		candidates = allowed
+	}
+
+	// 块修改前的采样，同一个块被多次修改时按原图计算
+	original := make(map[*jpegBlock][64]float64)
+	// check 检查修改后的块是否满足 PixelMaxDelta 和 MaxDeltaE，不满足时返回原因
+	check := func(pixel PixelCoord, block, result *jpegBlock) error {
+		before, ok := original[block]
+		if !ok {
+			before = img.blockSamples(c, block)
+			original[block] = before
+		}
+		after := img.blockSamples(c, result)
+		for i := range before {
+			if diff := abs(int(after[i] - before[i])); diff > maxDelta {
+				return fmt.Errorf("量化步长过大，修改后的块中有采样变化了 %d 级，超过 PixelMaxDelta %d", diff, maxDelta)
+			}
+		}
+		if m.MaxDeltaE > 0 {
+			sx, sy := pixel.X*c.h/img.hmax, pixel.Y*c.v/img.vmax
+			if dist := img.blockDeltaE(c, sx/8*8, sy/8*8, &before, &after); dist > m.MaxDeltaE {
+				return fmt.Errorf("修改后的色差 %.2f 超过 MaxDeltaE %.2f", dist, m.MaxDeltaE)
+			}
+		}
+		return nil
+	}
+
+	adjusted, score := 0, 0.0
+	var deltaErr error // 最近一次因超出 PixelMaxDelta 或 MaxDeltaE 而无法修改的原因
+	for _, pixel := range candidates {
+		if adjusted == count {
+			break
+		}
+		block := c.blockAt(img, pixel.X, pixel.Y)
+		pixelScore := texture(pixel.X, pixel.Y)
+		sx, sy := pixel.X*c.h/img.hmax, pixel.Y*c.v/img.vmax
+		offset := (sy%8)*8 + sx%8
+
+		samples := img.blockSamples(c, block)
+		for delta := pixelStep(rng, maxDelta); abs(delta) <= maxDelta; delta *= 2 {
+			modified := samples
+			modified[offset] = float64(bounceSample(int(samples[offset]), delta, 255))
+			result := img.quantizeBlock(c, &modified)
+			if result == *block {
+				continue
+			}
+			if err := check(pixel, block, &result); err != nil {
+				// 更大的幅度只会使变化更大
+				deltaErr = err
+				break
+			}
+			*block = result
+			if adjusted == 0 || pixelScore < score {
+				score = pixelScore
+			}
+			adjusted++
+			break
+		}
+	}
+	if adjusted > 0 {
+		return score, nil
+	}
+
+	for _, pixel := range candidates {
+		block := c.blockAt(img, pixel.X, pixel.Y)
+		result := *block
+		if result[0] >= 1023 {
+			result[0]--
+		} else {
+			result[0]++
+		}
+		if err := check(pixel, block, &result); err != nil {
+			deltaErr = err
+			continue
+		}
+		*block = result
+		return texture(pixel.X, pixel.Y), nil
+	}
+	return 0, deltaErr
+}
+
+// blockDeltaE 第一个分量中左上角采样位于 (bx, by) 的块由采样 before 改为 after 后，受影响像素与原颜色的最大ΔE2000
+// 灰度直接按亮度计算；三个分量时取其余分量在同一位置的采样，按YCbCr（Adobe标记的RGB则直接）换算为sRGB。
+// CMYK/YCCK没有可靠的sRGB换算，调用方需要先排除
+func (img *jpegImage) blockDeltaE(c *jpegComponent, bx, by int, before, after *[64]float64) float64 {
+
+	// 其余分量的采样按块缓存
+	cache := make(map[*jpegBlock][64]float64)
+	sampleAt := func(k *jpegComponent, x, y int) float64 {
+		kx, ky := x*k.h/img.hmax, y*k.v/img.vmax
+		b := &k.blocks[(ky/8)*k.blocksPerLine+kx/8]
+		samples, ok := cache[b]
+		if !ok {
+			samples = img.blockSamples(k, b)
+			cache[b] = samples
+		}
+		return samples[(ky%8)*8+kx%8]
+	}
+	rgb := img.rgbComponents()
+	toLab := func(first float64, x, y int) labColor {
+		if len(img.components) == 1 {
+			return sampleToLab([]int{int(first)}, 255)
+		}
+		y1, y2 := sampleAt(img.components[1], x, y), sampleAt(img.components[2], x, y)
+		if rgb {
+			return sampleToLab([]int{int(first), int(y1), int(y2)}, 255)
+		}
+		cb, cr := y1-128, y2-128
+		return sampleToLab([]int{
+			clampInt(int(math.Round(first+1.402*cr)), 0, 255),
+			clampInt(int(math.Round(first-0.344136*cb-0.714136*cr)), 0, 255),
+			clampInt(int(math.Round(first+1.772*cb)), 0, 255),
+		}, 255)
+	}
+
+	worst := 0.0
+	for i := range before {
+		if before[i] == after[i] {
+			continue
+		}
+		x, y := (bx+i%8)*img.hmax/c.h, (by+i/8)*img.vmax/c.v
+		if x >= img.width || y >= img.height {
+			continue // 补齐的采样不会显示
+		}
+		if dist := deltaE2000(toLab(before[i], x, y), toLab(after[i], x, y)); dist > worst {
+			worst = dist
+		}
+	}
+	return worst
+}
+
+// blockAllowed 第一个分量中左上角采样位于 (bx, by) 的块覆盖的图像像素是否都在 mask 允许的范围内
+// 系数变化后解码器（整数逆DCT）可能改变块内任一像素，因此按整个块判断；只修改第一个分量，色度块不变
+func (img *jpegImage) blockAllowed(c *jpegComponent, bx, by int, mask *PixelMask) bool {
+	for y := by * img.vmax / c.v; y < (by+8)*img.vmax/c.v && y < img.height; y++ {
+		for x := bx * img.hmax / c.h; x < (bx+8)*img.hmax/c.h && x < img.width; x++ {
+			if !mask.allows(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// rgbComponents 三个分量是否直接存储RGB：Adobe APP14的transform为0，或者没有该段时分量标识为'R'、'G'、'B'
+func (img *jpegImage) rgbComponents() bool {
+	if len(img.components) != 3 {
+		return false
+	}
+	for _, segment := range img.segments {
+		if segment.marker == jpegMarkerAPP14 && len(segment.data) >= 12 && string(segment.data[:5]) == "Adobe" {
+			return segment.data[11] == 0
+		}
+	}
+	ids := img.components
+	return ids[0].id == 'R' && ids[1].id == 'G' && ids[2].id == 'B'
+}
+
+// blockAt 返回包含图像坐标 (x, y) 的块
+func (c *jpegComponent) blockAt(img *jpegImage, x, y int) *jpegBlock {
+	sx, sy := x*c.h/img.hmax, y*c.v/img.vmax
+	return &c.blocks[(sy/8)*c.blocksPerLine+sx/8]
+}
+
+// blockVariance 块内采样的方差。JPEG的8x8 DCT是正交变换，方差等于反量化后交流系数的平方和除以64，不需要逆DCT
+func (img *jpegImage) blockVariance(c *jpegComponent, block *jpegBlock) float64 {
+	sum := 0.0
+	for k := 1; k < 64; k++ {
+		v := float64(block[k]) * float64(img.quant[c.tq][k])
+		sum += v * v
+	}
+	return sum / 64
+}
+
+// jpegTexture 按 TextureMetric 返回分量 c 在图像坐标 (x, y) 处的纹理强度：
+// 方差取所在块的方差；梯度对所在块做逆DCT后计算，块边界按最近采样延伸
+func (m *ImageModifier) jpegTexture(img *jpegImage, c *jpegComponent) func(x, y int) float64 {
+	if m.TextureMetric == TextureVariance {
+		return func(x, y int) float64 {
+			return img.blockVariance(c, c.blockAt(img, x, y))
+		}
+	}
+	return func(x, y int) float64 {
+		samples := img.blockSamples(c, c.blockAt(img, x, y))
+		sx, sy := x*c.h/img.hmax, y*c.v/img.vmax
+		return sobelMagnitude(func(bx, by int) float64 {
+			return samples[clampInt(by, 0, 7)*8+clampInt(bx, 0, 7)]
+		}, sx%8, sy%8)
+	}
+}
+
+// 标准量化表（附录K，之字形顺序）
+var (
+	jpegStdLuminanceQuant = [64]byte{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	}
+	jpegStdChrominanceQuant = [64]byte{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	}
+)
@@ -0,0 +1,480 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTestJPEG 直接在系数级构造JPEG：sampling 为各分量的 h、v，采样值为渐变
+func buildTestJPEG(t testing.TB, width, height int, sampling [][2]int, segments []jpegSegment) []byte {
+	img := &jpegImage{sofMarker: jpegMarkerSOF0, width: width, height: height, hmax: 1, vmax: 1}
+	for i, s := range sampling {
+		tq := 0
+		if i > 0 {
+			tq = 1
+		}
+		img.components = append(img.components, &jpegComponent{id: byte(i + 1), h: s[0], v: s[1], tq: tq})
+		if s[0] > img.hmax {
+			img.hmax = s[0]
+		}
+		if s[1] > img.vmax {
+			img.vmax = s[1]
+		}
+	}
+	img.mcusX = (width + 8*img.hmax - 1) / (8 * img.hmax)
+	img.mcusY = (height + 8*img.vmax - 1) / (8 * img.vmax)
+	img.quant = img.standardQuant(90)
+
+	for ci, c := range img.components {
+		c.blocksPerLine, c.blocksPerColumn = img.mcusX*c.h, img.mcusY*c.v
+		c.blocks = make([]jpegBlock, c.blocksPerLine*c.blocksPerColumn)
+		for by := 0; by < c.blocksPerColumn; by++ {
+			for bx := 0; bx < c.blocksPerLine; bx++ {
+				var samples [64]float64
+				for i := range samples {
+					x, y := bx*8+i%8, by*8+i/8
+					samples[i] = float64((x*3 + y*5 + ci*60) % 256)
+				}
+				c.blocks[by*c.blocksPerLine+bx] = img.quantizeBlock(c, &samples)
+			}
+		}
+	}
+
+	data, err := img.encode(&jpegEncodeOptions{segments: segments})
+	if err != nil {
+		t.Fatalf("构造测试JPEG失败: %v", err)
+	}
+	return data
+}
+
+func TestJPEGCoefficientRoundTrip(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 37, 29), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = uint8(i * 7)
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = uint8(i*3), uint8(255-i)
+	}
+	gray := image.NewGray(image.Rect(0, 0, 20, 13))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 11)
+	}
+
+	modifier := NewImageModifier()
+	for _, img := range []image.Image{src, gray} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+			t.Fatalf("编码JPEG失败: %v", err)
+		}
+
+		coeffs, err := modifier.decodeJPEG(buf.Bytes())
+		if err != nil {
+			t.Fatalf("系数解码失败: %v", err)
+		}
+		encoded, err := coeffs.encode(&jpegEncodeOptions{})
+		if err != nil {
+			t.Fatalf("系数编码失败: %v", err)
+		}
+
+		// 系数完全相同时，解码后的像素也应完全相同
+		assertJPEGPixelsEqual(t, buf.Bytes(), encoded)
+	}
+}
+
+// assertJPEGPixelsEqual 断言两个JPEG解码后的像素完全相同
+func assertJPEGPixelsEqual(t *testing.T, a, b []byte) {
+	t.Helper()
+	imgA, err := jpeg.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatalf("解码JPEG失败: %v", err)
+	}
+	imgB, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("解码JPEG失败: %v", err)
+	}
+	if imgA.Bounds() != imgB.Bounds() {
+		t.Fatalf("图像尺寸不同: %v %v", imgA.Bounds(), imgB.Bounds())
+	}
+	bounds := imgA.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if imgA.At(x, y) != imgB.At(x, y) {
+				t.Fatalf("像素 (%d,%d) 不同: %v %v", x, y, imgA.At(x, y), imgB.At(x, y))
+			}
+		}
+	}
+}
+
+func TestModifyJPEGPixelPreservesColorSpace(t *testing.T) {
+	adobeYCCK := jpegSegment{marker: jpegMarkerAPP14, data: []byte{'A', 'd', 'o', 'b', 'e', 0, 100, 0, 0, 0, 0, 2}}
+	adobeRGB := jpegSegment{marker: jpegMarkerAPP14, data: []byte{'A', 'd', 'o', 'b', 'e', 0, 100, 0, 0, 0, 0, 0}}
+
+	tests := []struct {
+		name     string
+		sampling [][2]int
+		segments []jpegSegment
+		check    func(t *testing.T, img image.Image)
+	}{
+		{"gray", [][2]int{{1, 1}}, nil, func(t *testing.T, img image.Image) {
+			if _, ok := img.(*image.Gray); !ok {
+				t.Errorf("灰度图被转换为 %T", img)
+			}
+		}},
+		{"ycbcr444", [][2]int{{1, 1}, {1, 1}, {1, 1}}, nil, func(t *testing.T, img image.Image) {
+			if ycc, ok := img.(*image.YCbCr); !ok || ycc.SubsampleRatio != image.YCbCrSubsampleRatio444 {
+				t.Errorf("4:4:4采样未保留: %T", img)
+			}
+		}},
+		{"ycbcr422", [][2]int{{2, 1}, {1, 1}, {1, 1}}, nil, func(t *testing.T, img image.Image) {
+			if ycc, ok := img.(*image.YCbCr); !ok || ycc.SubsampleRatio != image.YCbCrSubsampleRatio422 {
+				t.Errorf("4:2:2采样未保留: %T", img)
+			}
+		}},
+		{"rgb", [][2]int{{1, 1}, {1, 1}, {1, 1}}, []jpegSegment{adobeRGB}, func(t *testing.T, img image.Image) {
+			if _, ok := img.(*image.RGBA); !ok {
+				t.Errorf("RGB图像被转换为 %T", img)
+			}
+		}},
+		{"ycck", [][2]int{{2, 2}, {1, 1}, {1, 1}, {2, 2}}, []jpegSegment{adobeYCCK}, func(t *testing.T, img image.Image) {
+			if _, ok := img.(*image.CMYK); !ok {
+				t.Errorf("YCCK图像被转换为 %T", img)
+			}
+		}},
+	}
+
+	modifier := NewImageModifier()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildTestJPEG(t, 40, 24, tt.sampling, tt.segments)
+			modified, err := modifier.modifyJPEGPixel(data, &PixelModifyResult{})
+			if err != nil {
+				t.Fatalf("像素微调失败: %v", err)
+			}
+
+			img, err := jpeg.Decode(bytes.NewReader(modified))
+			if err != nil {
+				t.Fatalf("解码修改后的JPEG失败: %v", err)
+			}
+			tt.check(t, img)
+
+			coeffs, err := modifier.decodeJPEG(modified)
+			if err != nil {
+				t.Fatalf("系数解码失败: %v", err)
+			}
+			if len(coeffs.components) != len(tt.sampling) {
+				t.Fatalf("分量数变化: %d -> %d", len(tt.sampling), len(coeffs.components))
+			}
+			for i, c := range coeffs.components {
+				if len(tt.sampling) > 1 && (c.h != tt.sampling[i][0] || c.v != tt.sampling[i][1]) {
+					t.Errorf("分量 %d 的采样因子变化", i)
+				}
+			}
+		})
+	}
+}
+
+func TestModifyJPEGPixelAlwaysChanges(t *testing.T) {
+	// 纯色图像在高质量量化下，微小调整最容易被量化抵消
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("编码JPEG失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+	original, err := modifier.decodeJPEG(buf.Bytes())
+	if err != nil {
+		t.Fatalf("系数解码失败: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		modified, err := modifier.modifyJPEGPixel(buf.Bytes(), &PixelModifyResult{})
+		if err != nil {
+			t.Fatalf("像素微调失败: %v", err)
+		}
+		result, err := modifier.decodeJPEG(modified)
+		if err != nil {
+			t.Fatalf("系数解码失败: %v", err)
+		}
+		changed := 0
+		for b := range result.components[0].blocks {
+			if result.components[0].blocks[b] != original.components[0].blocks[b] {
+				changed++
+			}
+		}
+		if changed != 1 {
+			t.Fatalf("应恰好修改1个块，实际修改了 %d 个", changed)
+		}
+	}
+}
+
+func TestModifyJPEGPixelRespectsMaxDelta(t *testing.T) {
+	data := buildTestJPEG(t, 48, 40, [][2]int{{2, 2}, {1, 1}, {1, 1}}, nil)
+	original, err := NewImageModifier().decodeJPEG(data)
+	if err != nil {
+		t.Fatalf("系数解码失败: %v", err)
+	}
+	for _, maxDelta := range []int{1, 2} {
+		modifier := &ImageModifier{PixelCount: 3, PixelMaxDelta: maxDelta}
+		for i := 0; i < 10; i++ {
+			modified, err := modifier.modifyJPEGPixel(data, &PixelModifyResult{})
+			if err != nil {
+				t.Fatalf("PixelMaxDelta %d: 像素微调失败: %v", maxDelta, err)
+			}
+			result, err := modifier.decodeJPEG(modified)
+			if err != nil {
+				t.Fatalf("系数解码失败: %v", err)
+			}
+			c, rc := original.components[0], result.components[0]
+			for b := range c.blocks {
+				before, after := original.blockSamples(c, &c.blocks[b]), result.blockSamples(rc, &rc.blocks[b])
+				for k := range before {
+					if diff := abs(int(after[k] - before[k])); diff > maxDelta {
+						t.Fatalf("PixelMaxDelta %d: 块 %d 的采样 %d 变化了 %d 级", maxDelta, b, k, diff)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestModifyJPEGPixelMaskedBlocks(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 40, 40), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = uint8(i*11 + i/40*5)
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = uint8(100+i%20), uint8(150-i%20)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("编码JPEG失败: %v", err)
+	}
+	before, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("解码JPEG失败: %v", err)
+	}
+
+	// 排除区域不与8x8块对齐，选中的像素附近的整个块都可能发生变化
+	excluded := image.Rect(5, 3, 27, 21)
+	modifier := &ImageModifier{
+		PixelCount:        8,
+		PixelDistribution: PixelDistributionUniform,
+		PixelMask:         &PixelMask{Exclude: []image.Rectangle{excluded}},
+	}
+	for i := 0; i < 10; i++ {
+		modified, err := modifier.modifyJPEGPixel(buf.Bytes(), &PixelModifyResult{})
+		if err != nil {
+			t.Fatalf("像素微调失败: %v", err)
+		}
+		after, err := jpeg.Decode(bytes.NewReader(modified))
+		if err != nil {
+			t.Fatalf("解码JPEG失败: %v", err)
+		}
+		for y := excluded.Min.Y; y < excluded.Max.Y; y++ {
+			for x := excluded.Min.X; x < excluded.Max.X; x++ {
+				if before.At(x, y) != after.At(x, y) {
+					t.Fatalf("被排除的像素 (%d, %d) 发生了变化", x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestModifyJPEGPixelMaxDeltaE(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 32, 32), image.YCbCrSubsampleRatio444)
+	for i := range src.Y {
+		src.Y[i], src.Cb[i], src.Cr[i] = uint8(i*7+i/32*3), uint8(90+i%32), uint8(160-i%32)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("编码JPEG失败: %v", err)
+	}
+	before, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("解码JPEG失败: %v", err)
+	}
+
+	// 任何可见的修改都超过该上限
+	if _, err := (&ImageModifier{MaxDeltaE: 0.01}).modifyJPEGPixel(buf.Bytes(), &PixelModifyResult{}); err == nil {
+		t.Error("色差上限过小时应返回错误")
+	}
+
+	const maxDeltaE = 3.0
+	for i := 0; i < 10; i++ {
+		modified, err := (&ImageModifier{MaxDeltaE: maxDeltaE}).modifyJPEGPixel(buf.Bytes(), &PixelModifyResult{})
+		if err != nil {
+			t.Fatalf("像素微调失败: %v", err)
+		}
+		after, err := jpeg.Decode(bytes.NewReader(modified))
+		if err != nil {
+			t.Fatalf("解码JPEG失败: %v", err)
+		}
+		// image/jpeg的整数逆DCT与系数级计算有±1的误差，留出余量
+		worst := 0.0
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				r1, g1, b1, _ := before.At(x, y).RGBA()
+				r2, g2, b2, _ := after.At(x, y).RGBA()
+				dist := deltaE2000(sampleToLab([]int{int(r1 >> 8), int(g1 >> 8), int(b1 >> 8)}, 255),
+					sampleToLab([]int{int(r2 >> 8), int(g2 >> 8), int(b2 >> 8)}, 255))
+				if dist > worst {
+					worst = dist
+				}
+			}
+		}
+		if worst > maxDeltaE+1 {
+			t.Fatalf("最大色差 %.2f 超过上限 %.2f", worst, maxDeltaE)
+		}
+	}
+}
+
+func TestModifyJPEGPixelReusesQuantTables(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = uint8(i*13 + i/64*7)
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = uint8(i*5), uint8(i*3)
+	}
+
+	for _, quality := range []int{30, 75, 95} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatalf("编码JPEG失败: %v", err)
+		}
+		testFile := filepath.Join(t.TempDir(), "test.jpg")
+		if err := os.WriteFile(testFile, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("写入测试文件失败: %v", err)
+		}
+
+		// 低质量下量化步长较大，调整一个系数至少改变3级
+		modifier := NewImageModifier()
+		if quality < 50 {
+			modifier.PixelMaxDelta = 4
+		}
+		result, err := modifier.ModifyImageSHA1ByPixelResult(testFile)
+		if err != nil {
+			t.Fatalf("质量 %d: 像素微调失败: %v", quality, err)
+		}
+		if result.JPEGQuality != quality || !result.JPEGSourceTables {
+			t.Errorf("质量 %d: 结果为 %+v", quality, result)
+		}
+
+		modified, _ := os.ReadFile(testFile)
+		before, _ := modifier.decodeJPEG(buf.Bytes())
+		after, err := modifier.decodeJPEG(modified)
+		if err != nil {
+			t.Fatalf("系数解码失败: %v", err)
+		}
+		if before.quant != after.quant {
+			t.Errorf("质量 %d: 量化表发生了变化", quality)
+		}
+		changed := 0
+		for i, c := range before.components {
+			for b := range c.blocks {
+				if c.blocks[b] != after.components[i].blocks[b] {
+					changed++
+				}
+			}
+		}
+		if changed != 1 {
+			t.Errorf("质量 %d: 应恰好修改1个块，实际修改了 %d 个", quality, changed)
+		}
+		if len(modified) > len(buf.Bytes())*11/10 {
+			t.Errorf("质量 %d: 文件大小 %d -> %d", quality, len(buf.Bytes()), len(modified))
+		}
+	}
+}
+
+func TestModifyJPEGPixelStandardTables(t *testing.T) {
+	data := buildTestJPEG(t, 32, 32, [][2]int{{2, 2}, {1, 1}, {1, 1}}, nil)
+	modifier := &ImageModifier{JPEGStandardTables: true}
+	result := &PixelModifyResult{}
+	if _, err := modifier.modifyJPEGPixel(data, result); err != nil {
+		t.Fatalf("像素微调失败: %v", err)
+	}
+	if result.JPEGQuality != 90 || result.JPEGSourceTables {
+		t.Errorf("结果为 %+v", result)
+	}
+}
+
+func TestModifyJPEGPixelKeepsProgressive(t *testing.T) {
+	sources := testCoefficientSources(t)
+	for _, name := range []string{"baseline", "progressive", "progressive-restart"} {
+		before, _ := NewImageModifier().decodeJPEG(sources[name])
+		result := &PixelModifyResult{}
+		modified, err := NewImageModifier().modifyJPEGPixel(sources[name], result)
+		if err != nil {
+			t.Fatalf("%s: 像素微调失败: %v", name, err)
+		}
+		after, err := NewImageModifier().decodeJPEG(modified)
+		if err != nil {
+			t.Fatalf("%s: 系数解码失败: %v", name, err)
+		}
+		if after.progressive() != before.progressive() || result.JPEGProgressive != before.progressive() {
+			t.Errorf("%s: 渐进式由 %v 变为 %v（结果 %v）", name, before.progressive(), after.progressive(), result.JPEGProgressive)
+		}
+		if after.restartInterval != before.restartInterval {
+			t.Errorf("%s: 重启间隔由 %d 变为 %d", name, before.restartInterval, after.restartInterval)
+		}
+	}
+}
+
+func TestModifyJPEGPixelKeepsSegments(t *testing.T) {
+	segments := []jpegSegment{
+		{marker: jpegMarkerAPP0, data: []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")},
+		{marker: 0xE1, data: append([]byte("Exif\x00\x00"), NewImageModifier().buildEXIF(&ImageMetadata{CameraMake: "Test"})...)},
+		{marker: 0xE2, data: []byte("ICC_PROFILE\x00\x01\x01fake-profile")},
+		{marker: 0xED, data: []byte("Photoshop 3.0\x00")},
+		{marker: jpegMarkerCOM, data: []byte("comment")},
+	}
+	data := buildTestJPEG(t, 24, 24, [][2]int{{1, 1}, {1, 1}, {1, 1}}, segments)
+
+	modifier := NewImageModifier()
+	modified, err := modifier.modifyJPEGPixel(data, &PixelModifyResult{})
+	if err != nil {
+		t.Fatalf("像素微调失败: %v", err)
+	}
+	result, err := modifier.decodeJPEG(modified)
+	if err != nil {
+		t.Fatalf("系数解码失败: %v", err)
+	}
+	if len(result.segments) != len(segments) {
+		t.Fatalf("标记段数量变化: %d -> %d", len(segments), len(result.segments))
+	}
+	for i, segment := range segments {
+		if result.segments[i].marker != segment.marker || !bytes.Equal(result.segments[i].data, segment.data) {
+			t.Errorf("第 %d 个标记段（0x%02X）未按原样保留", i, segment.marker)
+		}
+	}
+}
+
+func TestJPEGMetadataSurvivesPixelMode(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.jpg")
+	if err := os.WriteFile(testFile, buildTestJPEG(t, 32, 16, [][2]int{{2, 1}, {1, 1}, {1, 1}}, nil), 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+	if _, err := modifier.ModifyImageMetadata(testFile, &ImageMetadata{Artist: "作者", Copyright: "版权"}); err != nil {
+		t.Fatalf("修改元数据失败: %v", err)
+	}
+	if _, err := modifier.ModifyImageSHA1ByPixel(testFile); err != nil {
+		t.Fatalf("像素微调失败: %v", err)
+	}
+
+	metadata, err := modifier.GetImageMetadata(testFile)
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	if metadata.Artist != "作者" || metadata.Copyright != "版权" {
+		t.Errorf("像素微调后元数据丢失: %+v", metadata)
+	}
+}
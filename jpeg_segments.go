@@ -0,0 +1,154 @@
+package imagemodify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// jpegMarkerSegment 由 jpegSegmentReader 读出的一个标记。相邻标记首尾相接，
+// 依次拼接全部标记和 trailing() 即得到原文件
+type jpegMarkerSegment struct {
+	marker     byte
+	start      int    // 起始位置（包括标记之前的0xFF填充字节）
+	segmentEnd int    // 标记段（标记、长度字段和段数据）之后的位置
+	end        int    // 结束位置，SOS段包括其后的熵编码数据
+	data       []byte // 段数据（不含标记和长度字段），无长度字段的标记为nil
+}
+
+// jpegSegmentReader 逐个读取JPEG文件中的标记，所有需要遍历标记段的函数共用
+// 带长度字段的标记段受 MaxChunkSize、MaxChunks 限制。
+// 很多编码器和截断的文件在熵编码数据之后没有EOI，元数据和注释的读写把这种情况视为正常结束；
+// 只有解码像素时（requireEOI）才要求EOI
+type jpegSegmentReader struct {
+	m          *ImageModifier
+	data       []byte
+	pos        int
+	count      int64 // 已读取的带长度字段的标记段数量
+	done       bool  // 已读到EOI
+	requireEOI bool  // 缺少EOI时返回错误
+	afterScan  bool  // 上一个标记是SOS，其熵编码数据已读完
+	truncated  bool  // 文件在熵编码数据之后结束，没有EOI
+}
+
+// newJPEGSegmentReader 创建标记读取器，第一个标记必须是SOI
+func (m *ImageModifier) newJPEGSegmentReader(data []byte) *jpegSegmentReader {
+	return &jpegSegmentReader{m: m, data: data}
+}
+
+// jpegStandalone 是否为没有长度字段的标记（SOI、EOI、RSTn、TEM）
+func jpegStandalone(marker byte) bool {
+	return marker == jpegMarkerSOI || marker == jpegMarkerEOI || marker == 0x01 ||
+		(marker >= jpegMarkerRST0 && marker <= jpegMarkerRST7)
+}
+
+// next 读取下一个标记，读完EOI（或者没有EOI的文件在熵编码数据之后结束）后返回 io.EOF
+func (r *jpegSegmentReader) next() (jpegMarkerSegment, error) {
+	if r.done || r.truncated {
+		return jpegMarkerSegment{}, io.EOF
+	}
+	data, start := r.data, r.pos
+	if start == 0 && (len(data) < 2 || data[0] != 0xFF || data[1] != jpegMarkerSOI) {
+		return jpegMarkerSegment{}, fmt.Errorf("不是有效的JPEG文件")
+	}
+
+	// 标记之前可以有任意数量的0xFF填充字节
+	pos := start
+	for pos+1 < len(data) && data[pos] == 0xFF && data[pos+1] == 0xFF {
+		pos++
+	}
+	if start == len(data) && r.afterScan && !r.requireEOI {
+		r.truncated = true
+		return jpegMarkerSegment{}, io.EOF
+	}
+	if pos+2 > len(data) {
+		return jpegMarkerSegment{}, fmt.Errorf("JPEG文件缺少EOI标记")
+	}
+	marker := data[pos+1]
+	if data[pos] != 0xFF || marker == 0x00 {
+		return jpegMarkerSegment{}, fmt.Errorf("JPEG标记格式错误（位置 %d）", pos)
+	}
+	pos += 2
+
+	segment := jpegMarkerSegment{marker: marker, start: start}
+	if jpegStandalone(marker) {
+		r.done = marker == jpegMarkerEOI
+	} else {
+		if pos+2 > len(data) {
+			return jpegMarkerSegment{}, fmt.Errorf("JPEG标记段不完整（位置 %d）", pos-2)
+		}
+		length := int(binary.BigEndian.Uint16(data[pos : pos+2]))
+		if length < 2 || length > len(data)-pos {
+			return jpegMarkerSegment{}, fmt.Errorf("JPEG标记段长度无效（位置 %d）", pos-2)
+		}
+		r.count++
+		if err := r.m.checkChunk(int64(length-2), r.count); err != nil {
+			return jpegMarkerSegment{}, err
+		}
+		segment.data = data[pos+2 : pos+length]
+		pos += length
+	}
+	segment.segmentEnd = pos
+	if marker == jpegMarkerSOS {
+		pos = findJPEGScanEnd(data, pos)
+	}
+	segment.end = pos
+	r.pos = pos
+	r.afterScan = marker == jpegMarkerSOS
+	return segment, nil
+}
+
+// trailing EOI之后的数据
+func (r *jpegSegmentReader) trailing() []byte {
+	return r.data[r.pos:]
+}
+
+// trailingComments 读完EOI之后，读取紧跟其后的完整COM段（JPEGCommentAfterEOI 写入的位置），
+// 其后的其余数据仍由 trailing() 返回
+func (r *jpegSegmentReader) trailingComments() ([]jpegMarkerSegment, error) {
+	if !r.done {
+		return nil, nil
+	}
+	var comments []jpegMarkerSegment
+	data := r.data
+	for {
+		pos := r.pos
+		if len(data)-pos < 4 || data[pos] != 0xFF || data[pos+1] != jpegMarkerCOM {
+			return comments, nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || length > len(data)-pos-2 {
+			return comments, nil
+		}
+		r.count++
+		if err := r.m.checkChunk(int64(length-2), r.count); err != nil {
+			return nil, err
+		}
+		end := pos + 2 + length
+		comments = append(comments, jpegMarkerSegment{marker: jpegMarkerCOM, start: pos, segmentEnd: end, end: end, data: data[pos+4 : end]})
+		r.pos = end
+	}
+}
+
+// findJPEGScanEnd 查找熵编码数据的结束位置：0xFF00和RST标记（包括其前的填充字节）属于熵编码数据，
+// 其他标记之前的填充字节不属于
+func findJPEGScanEnd(data []byte, pos int) int {
+	for pos < len(data) {
+		if data[pos] != 0xFF {
+			pos++
+			continue
+		}
+		next := pos + 1
+		for next < len(data) && data[next] == 0xFF {
+			next++
+		}
+		if next == len(data) {
+			break
+		}
+		if data[next] != 0x00 && (data[next] < jpegMarkerRST0 || data[next] > jpegMarkerRST7) {
+			return pos
+		}
+		pos = next + 1
+	}
+	return len(data)
+}
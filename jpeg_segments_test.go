@@ -0,0 +1,294 @@
+package imagemodify
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readJPEGSegments 读取全部标记，检查相邻标记首尾相接
+func readJPEGSegments(t testing.TB, data []byte) ([]jpegMarkerSegment, []byte, error) {
+	r := NewImageModifier().newJPEGSegmentReader(data)
+	var segments []jpegMarkerSegment
+	for {
+		segment, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(segments) > 0 && segment.start != segments[len(segments)-1].end {
+			t.Fatalf("标记 0x%02X 的起始位置 %d 与上一个标记的结束位置不相接", segment.marker, segment.start)
+		}
+		segments = append(segments, segment)
+	}
+	return segments, r.trailing(), nil
+}
+
+// withJPEGComments 在每个DQT、DHT、SOF和SOS之后插入注释段，SOS之后的注释段前加填充字节
+func withJPEGComments(t *testing.T, data []byte) []byte {
+	segments, trailing, err := readJPEGSegments(t, data)
+	if err != nil {
+		t.Fatalf("读取标记失败: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, segment := range segments {
+		buf.Write(data[segment.start:segment.end])
+		switch segment.marker {
+		case jpegMarkerDQT, jpegMarkerDHT, jpegMarkerSOF0, jpegMarkerSOF2:
+			writeJPEGSegment(&buf, jpegMarkerCOM, []byte("comment"))
+		case jpegMarkerSOS:
+			buf.Write([]byte{0xFF, 0xFF, 0xFF})
+			writeJPEGSegment(&buf, jpegMarkerCOM, []byte("after scan"))
+		}
+	}
+	buf.Write(trailing)
+	return buf.Bytes()
+}
+
+func TestJPEGSegmentReader(t *testing.T) {
+	for name, source := range testCoefficientSources(t) {
+		data := append(withJPEGComments(t, source), "trailing"...)
+		segments, trailing, err := readJPEGSegments(t, data)
+		if err != nil {
+			t.Fatalf("%s: 读取标记失败: %v", name, err)
+		}
+		if string(trailing) != "trailing" {
+			t.Errorf("%s: EOI之后的数据为 %q", name, trailing)
+		}
+		if first, last := segments[0], segments[len(segments)-1]; first.marker != jpegMarkerSOI || last.marker != jpegMarkerEOI || last.end != len(data)-len(trailing) {
+			t.Errorf("%s: 首尾标记为 0x%02X、0x%02X", name, first.marker, last.marker)
+		}
+		for _, segment := range segments {
+			if segment.marker == jpegMarkerSOS && bytes.HasSuffix(data[segment.segmentEnd:segment.end], []byte{0xFF}) {
+				t.Errorf("%s: 填充字节被计入熵编码数据", name)
+			}
+		}
+
+		// 插入注释段和填充字节后系数不变
+		original, err := NewImageModifier().decodeJPEG(source)
+		if err != nil {
+			t.Fatalf("%s: 系数解码失败: %v", name, err)
+		}
+		img, err := NewImageModifier().decodeJPEG(data)
+		if err != nil {
+			t.Fatalf("%s: 带注释段的JPEG解码失败: %v", name, err)
+		}
+		for ci, c := range img.components {
+			for b := range c.blocks {
+				if c.blocks[b] != original.components[ci].blocks[b] {
+					t.Fatalf("%s: 分量 %d 第 %d 块的系数不同", name, ci, b)
+				}
+			}
+		}
+	}
+}
+
+func TestJPEGScanWithFillBeforeRestart(t *testing.T) {
+	source := testCoefficientSources(t)["baseline-restart"]
+	original, err := NewImageModifier().decodeJPEG(source)
+	if err != nil {
+		t.Fatalf("系数解码失败: %v", err)
+	}
+	// 每个RST标记之前插入填充字节
+	scan := original.scans[0]
+	var buf bytes.Buffer
+	buf.Write(source[:scan.dataStart])
+	for i := scan.dataStart; i < scan.dataEnd; i++ {
+		if source[i] == 0xFF && source[i+1] >= jpegMarkerRST0 && source[i+1] <= jpegMarkerRST7 {
+			buf.WriteByte(0xFF)
+		}
+		buf.WriteByte(source[i])
+	}
+	buf.Write(source[scan.dataEnd:])
+
+	img, err := NewImageModifier().decodeJPEG(buf.Bytes())
+	if err != nil {
+		t.Fatalf("RST之前带填充字节的JPEG解码失败: %v", err)
+	}
+	for b, block := range img.components[0].blocks {
+		if block != original.components[0].blocks[b] {
+			t.Fatalf("第 %d 块的系数不同", b)
+		}
+	}
+}
+
+func TestJPEGSegmentReaderRejectsMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":       {},
+		"no SOI":      {0xFF, 0xD9},
+		"no EOI":      {0xFF, 0xD8, 0xFF, 0xFE, 0x00, 0x03, 'a'},
+		"short":       {0xFF, 0xD8, 0xFF, 0xFE, 0x00},
+		"length < 2":  {0xFF, 0xD8, 0xFF, 0xFE, 0x00, 0x01, 0xFF, 0xD9},
+		"overrun":     {0xFF, 0xD8, 0xFF, 0xFE, 0xFF, 0xFF, 0xFF, 0xD9},
+		"not marker":  {0xFF, 0xD8, 0x12, 0xFF, 0xD9},
+		"FF00":        {0xFF, 0xD8, 0xFF, 0x00, 0xFF, 0xD9},
+		"fill at end": {0xFF, 0xD8, 0xFF, 0xFF, 0xFF},
+	} {
+		if _, _, err := readJPEGSegments(t, data); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+		modifier := NewImageModifier()
+		if _, err := modifier.removeJPEGComments(data); err == nil {
+			t.Errorf("%s: removeJPEGComments 应返回错误", name)
+		}
+		if _, err := modifier.extractJPEGComments(data); err == nil {
+			t.Errorf("%s: extractJPEGComments 应返回错误", name)
+		}
+	}
+}
+
+func TestJPEGSegmentReaderMissingEOI(t *testing.T) {
+	source := testCoefficientSources(t)["progressive"]
+	if !bytes.HasSuffix(source, []byte{0xFF, 0xD9}) {
+		t.Fatal("测试图片应以EOI结尾")
+	}
+	data := source[:len(source)-2]
+	modifier := NewImageModifier()
+
+	// 熵编码数据之后直接结束：读写元数据和注释时视为正常结束
+	segments, trailing, err := readJPEGSegments(t, data)
+	if err != nil {
+		t.Fatalf("读取缺少EOI的文件失败: %v", err)
+	}
+	if len(trailing) != 0 || segments[len(segments)-1].marker != jpegMarkerSOS {
+		t.Error("最后一个标记应为SOS，且没有尾随数据")
+	}
+	commented, err := modifier.insertJPEGComment(data, []byte("comment"))
+	if err != nil {
+		t.Fatalf("插入注释段失败: %v", err)
+	}
+	if comments, err := modifier.extractJPEGComments(commented); err != nil || len(comments) != 1 {
+		t.Fatalf("应提取到1个注释段: %v", err)
+	}
+	if clean, err := modifier.removeJPEGComments(commented); err != nil || !bytes.Equal(clean, data) {
+		t.Errorf("移除注释段后应得到原文件: %v", err)
+	}
+	modified, err := modifier.modifyJPEGMetadata(data, &ImageMetadata{Title: "标题"})
+	if err != nil {
+		t.Fatalf("修改元数据失败: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "noeoi.jpg")
+	if err := os.WriteFile(path, modified, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if metadata, err := modifier.getJPEGMetadata(path); err != nil || metadata.Title != "标题" {
+		t.Errorf("读取元数据失败: %v", err)
+	}
+
+	modifier.JPEGCommentPlacement = JPEGCommentAfterEOI
+	appended, err := modifier.insertJPEGComment(data, []byte("comment"))
+	if err != nil {
+		t.Fatalf("在文件末尾插入注释段失败: %v", err)
+	}
+	if !bytes.HasPrefix(appended, data) {
+		t.Error("注释段应追加在文件末尾")
+	}
+
+	// 解码像素时仍要求EOI
+	if _, err := modifier.decodeJPEG(data); err == nil {
+		t.Error("decodeJPEG 应返回错误")
+	}
+	if _, err := NewImageModifier().modifyJPEGPixel(data, &PixelModifyResult{}); err == nil {
+		t.Error("modifyJPEGPixel 应返回错误")
+	}
+}
+
+func TestRemoveJPEGComments(t *testing.T) {
+	source := testCoefficientSources(t)["progressive"]
+	data := append(withJPEGComments(t, source), "trailing"...)
+	modifier := NewImageModifier()
+
+	comments, err := modifier.extractJPEGComments(data)
+	if err != nil {
+		t.Fatalf("提取注释段失败: %v", err)
+	}
+	original, _ := NewImageModifier().decodeJPEG(source)
+	if want := 3 + len(original.scans); len(comments) < want {
+		t.Errorf("应找到至少 %d 个注释段，实际为 %d", want, len(comments))
+	}
+
+	clean, err := modifier.removeJPEGComments(data)
+	if err != nil {
+		t.Fatalf("移除注释段失败: %v", err)
+	}
+	if !bytes.Equal(clean, append(append([]byte(nil), source...), "trailing"...)) {
+		t.Error("移除注释段后其余字节发生了变化")
+	}
+
+	// 修改元数据后只保留一个注释段；JSON注释段之前的其他注释段不影响读取
+	modified, err := modifier.modifyJPEGMetadata(data, &ImageMetadata{Title: "标题"})
+	if err != nil {
+		t.Fatalf("修改元数据失败: %v", err)
+	}
+	if comments, _ := modifier.extractJPEGComments(modified); len(comments) != 1 {
+		t.Errorf("修改元数据后应只有1个注释段，实际为 %d", len(comments))
+	}
+	modified, _ = modifier.insertJPEGComment(modified, []byte("not json"))
+	testFile := filepath.Join(t.TempDir(), "test.jpg")
+	if err := os.WriteFile(testFile, modified, 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+	if metadata, err := modifier.GetImageMetadata(testFile); err != nil || metadata.Title != "标题" {
+		t.Errorf("读取元数据失败: %+v（%v）", metadata, err)
+	}
+
+	if _, err := modifier.insertJPEGComment(source, make([]byte, 0xFFFE)); err == nil {
+		t.Error("超过段长度上限的注释应返回错误")
+	}
+}
+
+// fuzzJPEGSeeds 模糊测试的初始语料
+func fuzzJPEGSeeds(f *testing.F) {
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xD9})
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xFF, 0xFE, 0x00, 0x04, 'h', 'i', 0xFF, 0xD0, 0xFF, 0xD9, 'x'})
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02, 0x12, 0xFF, 0x00, 0xFF, 0xD3, 0x34, 0xFF, 0xFF, 0xD9})
+	f.Add(buildTestJPEG(f, 16, 8, [][2]int{{1, 1}}, []jpegSegment{{marker: jpegMarkerCOM, data: []byte("comment")}}))
+	f.Add(rebuildTestJPEG(f, buildTestJPEG(f, 24, 16, [][2]int{{2, 1}, {1, 1}, {1, 1}}, nil), true, 2))
+}
+
+func FuzzJPEGSegmentReader(f *testing.F) {
+	fuzzJPEGSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		segments, trailing, err := readJPEGSegments(t, data)
+		if err != nil {
+			return
+		}
+		// 全部标记和EOI之后的数据拼接后与原文件相同
+		var buf bytes.Buffer
+		for _, segment := range segments {
+			buf.Write(data[segment.start:segment.end])
+		}
+		buf.Write(trailing)
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatal("拼接全部标记后与原文件不同")
+		}
+
+		modifier := NewImageModifier()
+		clean, err := modifier.removeJPEGComments(data)
+		if err != nil {
+			t.Fatalf("可读取的文件移除注释段失败: %v", err)
+		}
+		if comments, err := modifier.extractJPEGComments(clean); err != nil || len(comments) != 0 {
+			t.Fatalf("移除后仍有 %d 个注释段（%v）", len(comments), err)
+		}
+		inserted, err := modifier.insertJPEGComment(clean, []byte("comment"))
+		if err != nil {
+			t.Fatalf("插入注释段失败: %v", err)
+		}
+		if comments, err := modifier.extractJPEGComments(inserted); err != nil || len(comments) != 1 {
+			t.Fatalf("插入后应有1个注释段，实际为 %d（%v）", len(comments), err)
+		}
+	})
+}
+
+func FuzzDecodeJPEG(f *testing.F) {
+	fuzzJPEGSeeds(f)
+	modifier := &ImageModifier{Limits: ResourceLimits{MaxPixels: 1 << 16}}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		modifier.decodeJPEG(data)
+	})
+}
@@ -0,0 +1,122 @@
+package imagemodify
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+// JPEG无损转码：系数完全不变，只改变熵编码方式——最优霍夫曼表、基线与渐进式互换、重启间隔。
+// 输出与原图解码后的像素逐位相同，作为验证，转码结果会用 image/jpeg 解码并与原图比较。
+
+// jpegTranscodeVariant 一种转码方式
+type jpegTranscodeVariant struct {
+	progressive     bool
+	restartInterval int
+}
+
+// transcodeJPEG 无损转码JPEG，保留全部APPn和COM段
+// 从随机选择的方式开始依次尝试，返回第一个与原文件不同且像素验证通过的结果
+func (m *ImageModifier) transcodeJPEG(data []byte) ([]byte, error) {
+	img, err := m.decodeJPEG(data)
+	if err != nil {
+		return nil, fmt.Errorf("解码JPEG图片失败: %w", err)
+	}
+	original, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解码JPEG图片失败: %v", err)
+	}
+
+	// 基线/渐进式 × 不使用/每行MCU重启，与原文件相同的组合排在最后
+	var variants, same []jpegTranscodeVariant
+	for _, progressive := range []bool{!img.progressive(), img.progressive()} {
+		for _, restartInterval := range []int{0, img.mcusX} {
+			variant := jpegTranscodeVariant{progressive: progressive, restartInterval: restartInterval}
+			if progressive == img.progressive() && (restartInterval > 0) == (img.restartInterval > 0) {
+				same = append(same, variant)
+			} else {
+				variants = append(variants, variant)
+			}
+		}
+	}
+	start := int(m.generateRandomBytes(1)[0]) % len(variants)
+	variants = append(append(variants[start:], variants[:start]...), same...)
+
+	segments := img.ancillarySegments()
+	for _, variant := range variants {
+		encoded, err := img.encode(&jpegEncodeOptions{
+			segments:        segments,
+			progressive:     variant.progressive,
+			optimize:        true,
+			restartInterval: variant.restartInterval,
+			trailing:        img.trailing,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("重新编码JPEG失败: %v", err)
+		}
+		if bytes.Equal(encoded, data) {
+			continue
+		}
+		// 个别解码器对部分组合的处理与规范不一致（例如 image/jpeg 对色度抽样的渐进式重启间隔），验证不通过时换下一种
+		if decoded, err := jpeg.Decode(bytes.NewReader(encoded)); err == nil && samePixels(original, decoded) {
+			return encoded, nil
+		}
+	}
+	return nil, fmt.Errorf("找不到像素不变的转码方式")
+}
+
+// samePixels 判断两张图片的像素是否逐位相同
+func samePixels(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	switch a := a.(type) {
+	case *image.YCbCr:
+		if b, ok := b.(*image.YCbCr); ok {
+			return a.SubsampleRatio == b.SubsampleRatio && a.YStride == b.YStride && a.CStride == b.CStride &&
+				bytes.Equal(a.Y, b.Y) && bytes.Equal(a.Cb, b.Cb) && bytes.Equal(a.Cr, b.Cr)
+		}
+	case *image.Gray:
+		if b, ok := b.(*image.Gray); ok {
+			return bytes.Equal(a.Pix, b.Pix)
+		}
+	case *image.CMYK:
+		if b, ok := b.(*image.CMYK); ok {
+			return bytes.Equal(a.Pix, b.Pix)
+		}
+	case *image.RGBA:
+		if b, ok := b.(*image.RGBA); ok {
+			return bytes.Equal(a.Pix, b.Pix)
+		}
+	case *image.RGBA64:
+		if b, ok := b.(*image.RGBA64); ok {
+			return bytes.Equal(a.Pix, b.Pix)
+		}
+	case *image.Gray16:
+		if b, ok := b.(*image.Gray16); ok {
+			return bytes.Equal(a.Pix, b.Pix)
+		}
+	case *image.NRGBA:
+		// 完全透明的像素RGB不同也视为相同，字节不同时还要逐像素比较
+		if b, ok := b.(*image.NRGBA); ok && bytes.Equal(a.Pix, b.Pix) {
+			return true
+		}
+	case *image.NRGBA64:
+		if b, ok := b.(*image.NRGBA64); ok && bytes.Equal(a.Pix, b.Pix) {
+			return true
+		}
+	}
+
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, a1 := a.At(x, y).RGBA()
+			r2, g2, b2, a2 := b.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildJPEGHuffmanSpec(t *testing.T) {
+	// 斐波那契频率会产生很长的码字，必须被限制在16位以内
+	freq := make([]int, 256)
+	a, b := 1, 1
+	for i := 0; i < 40; i++ {
+		freq[i] = a
+		a, b = b, a+b
+	}
+	freq[200] = 7
+
+	spec := buildJPEGHuffmanSpec(freq)
+	if _, err := newJPEGHuffmanDecoder(spec); err != nil {
+		t.Fatalf("生成的霍夫曼表无效: %v", err)
+	}
+	encoder := newJPEGHuffmanEncoder(spec)
+	for symbol, f := range freq {
+		if f > 0 && !encoder.has(byte(symbol)) {
+			t.Errorf("符号 0x%02X 没有码字", symbol)
+		}
+	}
+
+	// 码字空间不能被占满，否则会出现全1的码字
+	space := 0
+	for length, count := range spec.counts {
+		space += int(count) << uint(15-length)
+	}
+	if space >= 1<<16 {
+		t.Errorf("码字空间被占满: %d", space)
+	}
+
+	if buildJPEGHuffmanSpec(make([]int, 256)) != nil {
+		t.Error("没有符号时应返回nil")
+	}
+}
+
+func TestTranscodeJPEGKeepsPixels(t *testing.T) {
+	sources := testCoefficientSources(t)
+	sources["segments"] = buildTestJPEG(t, 40, 24, [][2]int{{2, 2}, {1, 1}, {1, 1}}, []jpegSegment{
+		{marker: jpegMarkerAPP0, data: []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")},
+		{marker: 0xE2, data: []byte("ICC_PROFILE\x00\x01\x01fake-profile")},
+		{marker: jpegMarkerCOM, data: []byte("comment")},
+	})
+
+	modifier := NewImageModifier()
+	for name, data := range sources {
+		original, err := modifier.decodeJPEG(data)
+		if err != nil {
+			t.Fatalf("%s: 系数解码失败: %v", name, err)
+		}
+		for i := 0; i < 6; i++ {
+			transcoded, err := modifier.transcodeJPEG(data)
+			if err != nil {
+				t.Fatalf("%s: 转码失败: %v", name, err)
+			}
+			if bytes.Equal(transcoded, data) {
+				t.Fatalf("%s: 转码后文件没有变化", name)
+			}
+			assertJPEGPixelsEqual(t, data, transcoded)
+			if count, _ := diffCoefficients(t, data, transcoded); count != 0 {
+				t.Fatalf("%s: 转码改变了 %d 个系数", name, count)
+			}
+
+			img, _ := modifier.decodeJPEG(transcoded)
+			if len(img.segments) != len(original.segments) {
+				t.Fatalf("%s: 标记段数量变化", name)
+			}
+			for s := range img.segments {
+				if img.segments[s].marker != original.segments[s].marker || !bytes.Equal(img.segments[s].data, original.segments[s].data) {
+					t.Fatalf("%s: 第 %d 个标记段未按原样保留", name, s)
+				}
+			}
+		}
+	}
+}
+
+func TestModifyImageSHA1ByTranscode(t *testing.T) {
+	data := testCoefficientSources(t)["baseline"]
+	testFile := filepath.Join(t.TempDir(), "test.jpg")
+	if err := os.WriteFile(testFile, data, 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+	originalSHA1, _ := modifier.GetImageSHA1(testFile)
+	newSHA1, err := modifier.ModifyImageSHA1ByTranscode(testFile)
+	if err != nil {
+		t.Fatalf("无损重新编码失败: %v", err)
+	}
+	if newSHA1 == originalSHA1 {
+		t.Error("SHA1没有变化")
+	}
+
+	modified, _ := os.ReadFile(testFile)
+	assertJPEGPixelsEqual(t, data, modified)
+
+	if _, err := modifier.ModifyImageSHA1ByTranscode(filepath.Join(t.TempDir(), "test.gif")); err == nil {
+		t.Error("不存在的文件应返回错误")
+	}
+}
+
+func BenchmarkSamePixels(b *testing.B) {
+	for _, tt := range []struct {
+		name string
+		a, b image.Image
+	}{
+		{"ycbcr", image.NewYCbCr(image.Rect(0, 0, 2048, 1536), image.YCbCrSubsampleRatio420), image.NewYCbCr(image.Rect(0, 0, 2048, 1536), image.YCbCrSubsampleRatio420)},
+		{"nrgba", image.NewNRGBA(image.Rect(0, 0, 2048, 1536)), image.NewNRGBA(image.Rect(0, 0, 2048, 1536))},
+		{"generic", image.NewNRGBA(image.Rect(0, 0, 2048, 1536)), image.NewRGBA(image.Rect(0, 0, 2048, 1536))},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !samePixels(tt.a, tt.b) {
+					b.Fatal("图像应相同")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package imagemodify
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// JPEG XL 有两种形式：
+// 1. 裸码流：以 FF 0A 开头，没有可以容纳附加数据的位置
+// 2. ISOBMFF容器：以 "JXL " 签名box开头，之后是 ftyp、jxlc/jxlp（码流）以及 Exif、xml 等box
+// 解码器会忽略未知类型的box，因此可以通过添加box修改文件而无需解码像素
+
+// jxlContainerSignature JPEG XL容器的签名box
+var jxlContainerSignature = []byte{0x00, 0x00, 0x00, 0x0C, 'J', 'X', 'L', ' ', 0x0D, 0x0A, 0x87, 0x0A}
+
+// jxlCodestreamSignature JPEG XL裸码流的签名
+var jxlCodestreamSignature = []byte{0xFF, 0x0A}
+
+// isJXLContainer 判断数据是否为JPEG XL容器
+func (m *ImageModifier) isJXLContainer(data []byte) bool {
+	return bytes.HasPrefix(data, jxlContainerSignature)
+}
+
+// isJXLCodestream 判断数据是否为JPEG XL裸码流
+func (m *ImageModifier) isJXLCodestream(data []byte) bool {
+	return bytes.HasPrefix(data, jxlCodestreamSignature)
+}
+
+// parseJXLContainer 解析JPEG XL容器中的顶层box
+func (m *ImageModifier) parseJXLContainer(data []byte) ([]isoBox, error) {
+	boxes, err := m.parseISOBoxes(data, 0, len(data))
+	if err != nil {
+		return nil, fmt.Errorf("解析JPEG XL容器失败: %v", err)
+	}
+	if len(boxes) < 2 || boxes[1].boxType != "ftyp" {
+		return nil, fmt.Errorf("JPEG XL容器缺少ftyp box")
+	}
+	return boxes, nil
+}
+
+// jxlToContainer 返回容器形式的JPEG XL数据
+// 裸码流只有在设置 JXLWrapCodestream 后才会被包装为容器（签名box + ftyp + jxlc）
+func (m *ImageModifier) jxlToContainer(data []byte) ([]byte, error) {
+	if m.isJXLContainer(data) {
+		return data, nil
+	}
+	if !m.isJXLCodestream(data) {
+		return nil, fmt.Errorf("不是有效的JPEG XL文件")
+	}
+	if !m.JXLWrapCodestream {
+		return nil, fmt.Errorf("JPEG XL裸码流无法添加box，请设置 JXLWrapCodestream 以转换为容器格式")
+	}
+
+	// ftyp: 主品牌 "jxl "，次版本 0，兼容品牌 "jxl "
+	ftyp := []byte{'j', 'x', 'l', ' ', 0, 0, 0, 0, 'j', 'x', 'l', ' '}
+
+	result := make([]byte, 0, len(data)+64)
+	result = append(result, jxlContainerSignature...)
+	result = append(result, buildISOBox("ftyp", ftyp)...)
+	result = append(result, buildISOBox("jxlc", data)...)
+	return result, nil
+}
+
+// appendJXLBox 在JPEG XL容器末尾追加一个box
+func (m *ImageModifier) appendJXLBox(data []byte, box []byte) ([]byte, error) {
+	container, err := m.jxlToContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	boxes, err := m.parseJXLContainer(container)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendISOBox(container, boxes, box), nil
+}
+
+// modifyJXLSHA1 通过添加包含随机数据的free box修改JPEG XL文件
+func (m *ImageModifier) modifyJXLSHA1(data []byte) ([]byte, error) {
+	return m.appendJXLBox(data, buildISOBox("free", m.generateRandomBytes(16)))
+}
@@ -0,0 +1,99 @@
+package imagemodify
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// modifyJXLMetadata 修改JPEG XL图片的Exif和xml（XMP）box
+// 移除已有的元数据box后，在码流box之前插入新的Exif和xml box
+func (m *ImageModifier) modifyJXLMetadata(data []byte, metadata *ImageMetadata) ([]byte, error) {
+	container, err := m.jxlToContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	boxes, err := m.parseJXLContainer(container)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exif box 的数据以4字节的TIFF头偏移开始
+	exifPayload := make([]byte, 4, 4+256)
+	exifPayload = append(exifPayload, m.buildEXIF(metadata)...)
+	metadataBoxes := append(buildISOBox("Exif", exifPayload), buildISOBox("xml ", m.buildXMPPacket(metadata))...)
+
+	result := make([]byte, 0, len(container)+len(metadataBoxes))
+	inserted := false
+
+	for _, box := range boxes {
+		if m.isJXLMetadataBox(container, box) {
+			continue
+		}
+
+		// 元数据放在第一个码流box之前，便于流式读取
+		if !inserted && (box.boxType == "jxlc" || box.boxType == "jxlp") {
+			result = append(result, metadataBoxes...)
+			inserted = true
+		}
+		result = append(result, container[box.start:box.end]...)
+	}
+	if !inserted {
+		result = append(result, metadataBoxes...)
+	}
+
+	return result, nil
+}
+
+// isJXLMetadataBox 判断box是否为Exif/XMP元数据（包括brotli压缩的brob形式）
+func (m *ImageModifier) isJXLMetadataBox(data []byte, box isoBox) bool {
+	switch box.boxType {
+	case "Exif", "xml ":
+		return true
+	case "brob":
+		payload := box.payload(data)
+		return len(payload) >= 4 && (string(payload[:4]) == "Exif" || string(payload[:4]) == "xml ")
+	}
+	return false
+}
+
+// getJXLMetadata 获取JPEG XL图片的元数据
+// XMP中的字段优先，缺失的字段从Exif中补充；brotli压缩的brob box无法读取，将被忽略
+func (m *ImageModifier) getJXLMetadata(imagePath string) (*ImageMetadata, error) {
+	data, err := m.readImageFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+
+	metadata := &ImageMetadata{}
+	if m.isJXLCodestream(data) {
+		// 裸码流中没有元数据box
+		return metadata, nil
+	}
+
+	boxes, err := m.parseJXLContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var exifData []byte
+	for _, box := range boxes {
+		switch box.boxType {
+		case "xml ":
+			m.parseRDFMetadata(box.payload(data), metadata)
+		case "Exif":
+			payload := box.payload(data)
+			if len(payload) >= 4 {
+				offset := uint64(binary.BigEndian.Uint32(payload[:4]))
+				if offset+4 <= uint64(len(payload)) {
+					exifData = payload[4+offset:]
+				}
+			}
+		}
+	}
+	if exifData != nil {
+		m.parseEXIF(exifData, metadata)
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,156 @@
+package imagemodify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testJXLCodestream 测试用的JPEG XL裸码流（只需签名正确，不需要解码像素）
+var testJXLCodestream = append([]byte{0xFF, 0x0A}, bytes.Repeat([]byte{0x5A, 0xA5}, 64)...)
+
+// jxlCodestreamOf 取出JPEG XL容器中的码流
+func jxlCodestreamOf(t *testing.T, modifier *ImageModifier, data []byte) []byte {
+	boxes, err := modifier.parseJXLContainer(data)
+	if err != nil {
+		t.Fatalf("解析JPEG XL容器失败: %v", err)
+	}
+	for _, box := range boxes {
+		if box.boxType == "jxlc" {
+			return box.payload(data)
+		}
+	}
+	t.Fatal("没有找到jxlc box")
+	return nil
+}
+
+// TestModifyJXLSHA1 测试JPEG XL随机数据修改
+func TestModifyJXLSHA1(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.jxl")
+	if err := os.WriteFile(testFile, testJXLCodestream, 0644); err != nil {
+		t.Fatalf("创建测试文件失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+
+	// 裸码流默认不允许转换
+	if _, err := modifier.ModifyImageSHA1(testFile); err == nil {
+		t.Fatal("裸码流未设置 JXLWrapCodestream 时应返回错误")
+	}
+
+	modifier.JXLWrapCodestream = true
+	for i := 0; i < 2; i++ {
+		if _, err := modifier.ModifyImageSHA1(testFile); err != nil {
+			t.Fatalf("修改JPEG XL失败: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("读取修改后的文件失败: %v", err)
+	}
+	if !modifier.isJXLContainer(data) {
+		t.Fatal("修改后应为容器格式")
+	}
+	if !bytes.Equal(jxlCodestreamOf(t, modifier, data), testJXLCodestream) {
+		t.Error("码流内容发生了变化")
+	}
+}
+
+// TestModifyJXLSHA1SizeToEnd 测试最后一个box大小为0时追加box
+func TestModifyJXLSHA1SizeToEnd(t *testing.T) {
+	modifier := NewImageModifier()
+
+	data := append([]byte{}, jxlContainerSignature...)
+	data = append(data, buildISOBox("ftyp", []byte("jxl \x00\x00\x00\x00jxl "))...)
+	data = append(data, 0, 0, 0, 0, 'j', 'x', 'l', 'c') // 大小为0的jxlc
+	data = append(data, testJXLCodestream...)
+
+	modified, err := modifier.modifyJXLSHA1(data)
+	if err != nil {
+		t.Fatalf("修改JPEG XL失败: %v", err)
+	}
+	if !bytes.Equal(jxlCodestreamOf(t, modifier, modified), testJXLCodestream) {
+		t.Error("码流内容发生了变化")
+	}
+}
+
+// TestModifyJXLMetadata 测试JPEG XL元数据的写入与读取
+func TestModifyJXLMetadata(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.jxl")
+	if err := os.WriteFile(testFile, testJXLCodestream, 0644); err != nil {
+		t.Fatalf("创建测试文件失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+	modifier.JXLWrapCodestream = true
+
+	dateTime := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	metadata := &ImageMetadata{
+		Title:       "标题",
+		Artist:      "张三",
+		Copyright:   "© 2024 张三",
+		Description: "测试图片",
+		DateTime:    &dateTime,
+		Location:    "北京",
+		CameraMake:  "Canon",
+		CameraModel: "EOS R5",
+		Software:    "imagemodify",
+		ImageWidth:  1920,
+		ImageHeight: 1080,
+	}
+
+	// 连续写入两次，旧的元数据box应被替换
+	for i := 0; i < 2; i++ {
+		if _, err := modifier.ModifyImageMetadata(testFile, metadata); err != nil {
+			t.Fatalf("修改JPEG XL元数据失败: %v", err)
+		}
+		metadata.Software = "imagemodify v2"
+	}
+	metadata.Software = "imagemodify"
+	if _, err := modifier.ModifyImageMetadata(testFile, metadata); err != nil {
+		t.Fatalf("修改JPEG XL元数据失败: %v", err)
+	}
+
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("读取修改后的文件失败: %v", err)
+	}
+	boxes, _ := modifier.parseJXLContainer(data)
+	exifCount := 0
+	for _, box := range boxes {
+		if box.boxType == "Exif" {
+			exifCount++
+		}
+	}
+	if exifCount != 1 {
+		t.Errorf("Exif box数量应为1，实际为%d", exifCount)
+	}
+	if !bytes.Equal(jxlCodestreamOf(t, modifier, data), testJXLCodestream) {
+		t.Error("码流内容发生了变化")
+	}
+
+	result, err := modifier.GetImageMetadata(testFile)
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	if result.Title != metadata.Title || result.Artist != metadata.Artist || result.Copyright != metadata.Copyright ||
+		result.Description != metadata.Description || result.Location != metadata.Location ||
+		result.CameraMake != metadata.CameraMake || result.CameraModel != metadata.CameraModel ||
+		result.Software != metadata.Software || result.ImageWidth != 1920 || result.ImageHeight != 1080 {
+		t.Errorf("元数据不一致: %+v", result)
+	}
+	if result.DateTime == nil || !result.DateTime.Equal(dateTime) {
+		t.Errorf("拍摄时间不一致: %v", result.DateTime)
+	}
+
+	// 仅通过Exif也能读取基本字段
+	exifOnly := &ImageMetadata{}
+	modifier.parseEXIF(modifier.buildEXIF(metadata), exifOnly)
+	if exifOnly.Artist != metadata.Artist || exifOnly.CameraModel != metadata.CameraModel ||
+		exifOnly.DateTime == nil || !exifOnly.DateTime.Equal(dateTime) || exifOnly.ImageHeight != 1080 {
+		t.Errorf("Exif元数据不一致: %+v", exifOnly)
+	}
+}
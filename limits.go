@@ -0,0 +1,171 @@
+package imagemodify
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrLimitExceeded 输入超出 ResourceLimits 设定的上限，可用 errors.Is 判断；
+// 具体超出的限制可用 errors.As 取得 *LimitError
+var ErrLimitExceeded = errors.New("超出资源限制")
+
+// ResourceLimits 处理不可信输入时的资源上限，防止超大文件或解压炸弹耗尽内存
+// 字段为0时使用默认值，为负数时不限制
+type ResourceLimits struct {
+	// MaxFileSize 输入文件的字节数，默认1GiB；视频容器（MP4/MOV）默认不限制，只受显式设置的值约束
+	MaxFileSize int64
+
+	// MaxPixels 解码前由图片头得到的像素数量（宽×高），默认 1<<28（约2.7亿像素）
+	MaxPixels int64
+
+	// MaxChunkSize 单个PNG块或JPEG标记段的数据长度，默认256MiB
+	MaxChunkSize int64
+
+	// MaxChunks PNG块、JPEG标记段或GIF块（扩展块和图像块）的数量，默认 1<<20
+	MaxChunks int64
+
+	// MaxTextSize PNG压缩文本块（zTXt、iTXt）解压后的字节数，默认16MiB
+	MaxTextSize int64
+}
+
+// 默认的资源上限
+const (
+	defaultMaxFileSize  = 1 << 30
+	defaultMaxPixels    = 1 << 28
+	defaultMaxChunkSize = 1 << 28
+	defaultMaxChunks    = 1 << 20
+	defaultMaxTextSize  = 16 << 20
+)
+
+// LimitError 超出的具体资源限制
+type LimitError struct {
+	Limit string // 超出的限制，为 ResourceLimits 的字段名，例如 "MaxPixels"
+	Value int64  // 实际值
+	Max   int64  // 上限
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %s 为 %d，上限为 %d", ErrLimitExceeded, e.Limit, e.Value, e.Max)
+}
+
+// Is 使 errors.Is(err, ErrLimitExceeded) 成立
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// resolveLimit 0表示默认值，负数表示不限制（返回0）
+func resolveLimit(value, defaultValue int64) int64 {
+	switch {
+	case value == 0:
+		return defaultValue
+	case value < 0:
+		return 0
+	}
+	return value
+}
+
+// checkLimit 检查 value 是否超过上限 max（0表示不限制）
+func checkLimit(limit string, value, max int64) error {
+	if max > 0 && value > max {
+		return &LimitError{Limit: limit, Value: value, Max: max}
+	}
+	return nil
+}
+
+// checkFileSize 检查输入文件大小
+func (m *ImageModifier) checkFileSize(size int64) error {
+	return checkLimit("MaxFileSize", size, resolveLimit(m.Limits.MaxFileSize, defaultMaxFileSize))
+}
+
+// fileSizeLimit 输入文件的大小上限（0表示不限制）
+// 视频容器通常远大于图片，没有显式设置 MaxFileSize 时不限制
+func (m *ImageModifier) fileSizeLimit(imagePath string) int64 {
+	switch strings.ToLower(filepath.Ext(imagePath)) {
+	case ".mp4", ".m4v", ".mov":
+		return resolveLimit(m.Limits.MaxFileSize, 0)
+	}
+	return resolveLimit(m.Limits.MaxFileSize, defaultMaxFileSize)
+}
+
+// checkPixels 解码前检查图片头中的尺寸
+func (m *ImageModifier) checkPixels(width, height int) error {
+	return checkLimit("MaxPixels", int64(width)*int64(height), resolveLimit(m.Limits.MaxPixels, defaultMaxPixels))
+}
+
+// checkChunk 检查第 count 个PNG块或JPEG标记段（从1开始计数）的数据长度和块数量
+func (m *ImageModifier) checkChunk(length, count int64) error {
+	if err := checkLimit("MaxChunkSize", length, resolveLimit(m.Limits.MaxChunkSize, defaultMaxChunkSize)); err != nil {
+		return err
+	}
+	return checkLimit("MaxChunks", count, resolveLimit(m.Limits.MaxChunks, defaultMaxChunks))
+}
+
+// checkDecodeConfig 用 image.DecodeConfig 读取图片头，在完整解码之前检查像素数量
+func (m *ImageModifier) checkDecodeConfig(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("读取图片尺寸失败: %v", err)
+	}
+	return m.checkPixels(config.Width, config.Height)
+}
+
+// readImageFile 读取输入文件，超过 MaxFileSize（见 fileSizeLimit）时不读取内容
+func (m *ImageModifier) readImageFile(imagePath string) ([]byte, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	max := m.fileSizeLimit(imagePath)
+	if err := checkLimit("MaxFileSize", info.Size(), max); err != nil {
+		return nil, err
+	}
+
+	// 读取期间文件可能变大
+	var r io.Reader = f
+	if max > 0 {
+		r = io.LimitReader(f, max+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLimit("MaxFileSize", int64(len(data)), max); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// inflateText 解压zTXt/iTXt中的文本，解压后的大小不超过 MaxTextSize
+func (m *ImageModifier) inflateText(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var limited io.Reader = r
+	max := resolveLimit(m.Limits.MaxTextSize, defaultMaxTextSize)
+	if max > 0 {
+		limited = io.LimitReader(r, max+1)
+	}
+	text, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLimit("MaxTextSize", int64(len(text)), max); err != nil {
+		return nil, err
+	}
+	return text, nil
+}
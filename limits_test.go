@@ -0,0 +1,175 @@
+package imagemodify
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// expectLimit 检查错误是否为指定的资源限制
+func expectLimit(t *testing.T, err error, limit string) {
+	t.Helper()
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("应返回 ErrLimitExceeded，实际为 %v", err)
+	}
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != limit {
+		t.Fatalf("应超出 %s，实际为 %v", limit, err)
+	}
+}
+
+func TestResolveLimit(t *testing.T) {
+	if resolveLimit(0, 10) != 10 || resolveLimit(5, 10) != 5 || resolveLimit(-1, 10) != 0 {
+		t.Error("resolveLimit 结果不正确")
+	}
+	if checkLimit("MaxPixels", 100, 0) != nil {
+		t.Error("上限为0时不应限制")
+	}
+}
+
+func TestLimitsFileSizeAndPixels(t *testing.T) {
+	dir := t.TempDir()
+	pngFile := filepath.Join(dir, "test.png")
+	jpegFile := filepath.Join(dir, "test.jpg")
+	gifFile := filepath.Join(dir, "test.gif")
+	if err := createTestPNG(pngFile); err != nil {
+		t.Fatalf("创建测试图片失败: %v", err)
+	}
+	if err := createTestJPEG(jpegFile); err != nil {
+		t.Fatalf("创建测试图片失败: %v", err)
+	}
+	if err := os.WriteFile(gifFile, createTestGIF(t), 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+
+	small := &ImageModifier{Limits: ResourceLimits{MaxFileSize: 100}}
+	for _, path := range []string{pngFile, jpegFile} {
+		_, err := small.ModifyImageSHA1ByPixel(path)
+		expectLimit(t, err, "MaxFileSize")
+		_, err = small.GetImageMetadata(path)
+		expectLimit(t, err, "MaxFileSize")
+	}
+	_, err := (&ImageModifier{PNGStreaming: true, Limits: ResourceLimits{MaxFileSize: 100}}).ModifyImageSHA1ByPixel(pngFile)
+	expectLimit(t, err, "MaxFileSize")
+
+	// 100x100 的图片超过5000像素的上限，在解码前被拒绝
+	few := &ImageModifier{Limits: ResourceLimits{MaxPixels: 5000}}
+	for _, path := range []string{pngFile, jpegFile} {
+		_, err := few.ModifyImageSHA1ByPixel(path)
+		expectLimit(t, err, "MaxPixels")
+		_, err = few.ModifyImageSHA1ByTranscode(path)
+		expectLimit(t, err, "MaxPixels")
+	}
+	tiny := &ImageModifier{Limits: ResourceLimits{MaxPixels: 100}}
+	_, err = tiny.ModifyImageSHA1ByPalette(gifFile)
+	expectLimit(t, err, "MaxPixels")
+	_, err = tiny.modifyQOIPixel(createTestQOI(4), &PixelModifyResult{})
+	expectLimit(t, err, "MaxPixels")
+
+	// 负数表示不限制
+	unlimited := &ImageModifier{Limits: ResourceLimits{MaxFileSize: -1, MaxPixels: -1}}
+	if _, err := unlimited.ModifyImageSHA1ByPixel(pngFile); err != nil {
+		t.Errorf("不限制时像素微调失败: %v", err)
+	}
+}
+
+func TestLimitsPNGChunks(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.png")
+	if err := createTestPNG(testFile); err != nil {
+		t.Fatalf("创建测试图片失败: %v", err)
+	}
+
+	modifier := &ImageModifier{Limits: ResourceLimits{MaxChunks: 2}}
+	_, err := modifier.ModifyImageMetadata(testFile, &ImageMetadata{Title: "test"})
+	expectLimit(t, err, "MaxChunks")
+	_, err = modifier.GetImageMetadata(testFile)
+	expectLimit(t, err, "MaxChunks")
+
+	modifier = &ImageModifier{Limits: ResourceLimits{MaxChunkSize: 16}}
+	_, err = modifier.ModifyImageSHA1ByPixel(testFile)
+	expectLimit(t, err, "MaxChunkSize")
+	modifier.PNGStreaming = true
+	_, err = modifier.ModifyImageSHA1ByPixel(testFile)
+	expectLimit(t, err, "MaxChunkSize")
+}
+
+func TestLimitsJPEGSegments(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.jpg")
+	if err := createTestJPEG(testFile); err != nil {
+		t.Fatalf("创建测试图片失败: %v", err)
+	}
+	_, err := (&ImageModifier{Limits: ResourceLimits{MaxChunks: 3}}).ModifyImageSHA1ByPixel(testFile)
+	expectLimit(t, err, "MaxChunks")
+}
+
+func TestLimitsGIFBlocks(t *testing.T) {
+	// 第二帧的尺寸远大于逻辑屏幕，在解压之前被拒绝
+	data := createTestGIF(t)
+	file, err := NewImageModifier().parseGIF(data)
+	if err != nil {
+		t.Fatalf("解析GIF失败: %v", err)
+	}
+	huge := append([]byte(nil), data...)
+	pos := file.images[1].descriptor
+	huge[pos+5], huge[pos+6], huge[pos+7], huge[pos+8] = 0x60, 0xEA, 0x60, 0xEA
+	_, err = NewImageModifier().reorderGIFPalette(huge)
+	expectLimit(t, err, "MaxPixels")
+	_, err = NewImageModifier().modifyGIFPixel(huge)
+	expectLimit(t, err, "MaxPixels")
+
+	_, err = (&ImageModifier{Limits: ResourceLimits{MaxChunks: 2}}).reorderGIFPalette(data)
+	expectLimit(t, err, "MaxChunks")
+}
+
+func TestLimitsContainerFileSize(t *testing.T) {
+	modifier := NewImageModifier()
+	if limit := modifier.fileSizeLimit("movie.MP4"); limit != 0 {
+		t.Errorf("视频容器默认不应限制文件大小，实际为 %d", limit)
+	}
+	if limit := modifier.fileSizeLimit("photo.png"); limit != defaultMaxFileSize {
+		t.Errorf("图片的默认上限为 %d", limit)
+	}
+	explicit := &ImageModifier{Limits: ResourceLimits{MaxFileSize: 100}}
+	if limit := explicit.fileSizeLimit("movie.mov"); limit != 100 {
+		t.Errorf("显式设置的上限应对视频容器生效，实际为 %d", limit)
+	}
+}
+
+func TestLimitsPNGCompressedText(t *testing.T) {
+	// zTXt中的标题，以及解压后1MiB的iTXt
+	var title, bomb bytes.Buffer
+	w := zlib.NewWriter(&title)
+	w.Write([]byte("compressed title"))
+	w.Close()
+	w = zlib.NewWriter(&bomb)
+	w.Write(make([]byte, 1<<20))
+	w.Close()
+
+	data := createTestBorderedPNG(t)
+	modifier := NewImageModifier()
+	chunks, _ := modifier.parsePNGChunks(data)
+	last := chunks[len(chunks)-1]
+	var file bytes.Buffer
+	file.Write(data[:last.start])
+	file.Write(buildPNGChunk("zTXt", append([]byte("Title\x00\x00"), title.Bytes()...)))
+	file.Write(buildPNGChunk("iTXt", append([]byte("Comment\x00\x01\x00\x00\x00"), bomb.Bytes()...)))
+	file.Write(data[last.start:])
+	testFile := filepath.Join(t.TempDir(), "test.png")
+	if err := os.WriteFile(testFile, file.Bytes(), 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+
+	metadata, err := modifier.GetImageMetadata(testFile)
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	if metadata.Title != "compressed title" {
+		t.Errorf("zTXt中的标题为 %q", metadata.Title)
+	}
+
+	_, err = (&ImageModifier{Limits: ResourceLimits{MaxTextSize: 1 << 16}}).GetImageMetadata(testFile)
+	expectLimit(t, err, "MaxTextSize")
+}
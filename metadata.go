@@ -12,6 +12,7 @@ import (
 // ImageMetadata 图片元数据结构
 type ImageMetadata struct {
 	// 基本信息
+	Title       string // 标题
 	Artist      string // 作者/创作者
 	Copyright   string // 版权信息
 	Description string // 图片描述
@@ -46,9 +47,9 @@ func (m *ImageModifier) ModifyImageMetadata(imagePath string, metadata *ImageMet
 	}
 
 	// 读取原始文件
-	originalData, err := os.ReadFile(imagePath)
+	originalData, err := m.readImageFile(imagePath)
 	if err != nil {
-		return "", fmt.Errorf("读取图片文件失败: %v", err)
+		return "", fmt.Errorf("读取图片文件失败: %w", err)
 	}
 
 	// 计算原始SHA1
@@ -63,12 +64,18 @@ func (m *ImageModifier) ModifyImageMetadata(imagePath string, metadata *ImageMet
 		modifiedData, err = m.modifyJPEGMetadata(originalData, metadata)
 	case ".png":
 		modifiedData, err = m.modifyPNGMetadata(originalData, metadata)
+	case ".svg":
+		modifiedData, err = m.modifySVGMetadata(originalData, metadata)
+	case ".jxl":
+		modifiedData, err = m.modifyJXLMetadata(originalData, metadata)
+	case ".mp4", ".m4v", ".mov":
+		modifiedData, err = m.modifyMP4Metadata(originalData, metadata)
 	default:
 		return "", fmt.Errorf("不支持的图片格式: %s", ext)
 	}
 
 	if err != nil {
-		return "", fmt.Errorf("修改图片元数据失败: %v", err)
+		return "", fmt.Errorf("修改图片元数据失败: %w", err)
 	}
 
 	// 验证修改后的数据与原始数据不同
@@ -101,6 +108,12 @@ func (m *ImageModifier) GetImageMetadata(imagePath string) (*ImageMetadata, erro
 		return m.getJPEGMetadata(imagePath)
 	case ".png":
 		return m.getPNGMetadata(imagePath)
+	case ".svg":
+		return m.getSVGMetadata(imagePath)
+	case ".jxl":
+		return m.getJXLMetadata(imagePath)
+	case ".mp4", ".m4v", ".mov":
+		return m.getMP4Metadata(imagePath)
 	default:
 		return nil, fmt.Errorf("不支持的图片格式: %s", ext)
 	}
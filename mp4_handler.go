@@ -0,0 +1,170 @@
+package imagemodify
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MP4/MOV/M4V 与图片容器一样由box组成，修改方式与JPEG XL类似：
+// 随机数据模式在文件末尾追加free box，不移动任何已有数据，因此无需修正偏移量；
+// 元数据模式修改 moov/udta/meta/ilst，moov大小变化时需要修正指向其后数据的块偏移
+
+// parseMP4 解析MP4/MOV文件的顶层box
+func (m *ImageModifier) parseMP4(data []byte) ([]isoBox, error) {
+	boxes, err := m.parseISOBoxes(data, 0, len(data))
+	if err != nil {
+		return nil, fmt.Errorf("解析MP4/MOV失败: %v", err)
+	}
+
+	for _, box := range boxes {
+		if box.boxType == "moov" {
+			return boxes, nil
+		}
+	}
+	return nil, fmt.Errorf("不是有效的MP4/MOV文件：缺少moov box")
+}
+
+// modifyMP4SHA1 通过在文件末尾追加包含随机数据的free box修改MP4/MOV文件
+func (m *ImageModifier) modifyMP4SHA1(data []byte) ([]byte, error) {
+	boxes, err := m.parseMP4(data)
+	if err != nil {
+		return nil, err
+	}
+	return appendISOBox(data, boxes, buildISOBox("free", m.generateRandomBytes(16))), nil
+}
+
+// mp4ContainerBoxes 需要递归查找块偏移表的容器box
+var mp4ContainerBoxes = map[string]bool{
+	"moov": true,
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+	"moof": true,
+	"traf": true,
+	"mfra": true,
+}
+
+// patchMP4Offsets 修正 data[start:end] 中所有指向 threshold 及之后位置的绝对偏移
+// 包括 stco/co64 块偏移表、tfhd 的 base_data_offset 以及 tfra 的 moof_offset
+func (m *ImageModifier) patchMP4Offsets(data []byte, start, end int, threshold uint64, delta int64) error {
+	boxes, err := m.parseISOBoxes(data, start, end)
+	if err != nil {
+		return err
+	}
+
+	shift := func(offset uint64) (uint64, error) {
+		if offset < threshold {
+			return offset, nil
+		}
+		shifted := int64(offset) + delta
+		if shifted < 0 {
+			return 0, fmt.Errorf("偏移量修正后为负数")
+		}
+		return uint64(shifted), nil
+	}
+
+	for _, box := range boxes {
+		payload := box.payload(data)
+
+		if mp4ContainerBoxes[box.boxType] {
+			if err := m.patchMP4Offsets(data, box.start+box.headerSize, box.end, threshold, delta); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch box.boxType {
+		case "stco", "co64":
+			if len(payload) < 8 {
+				return fmt.Errorf("%s box数据不完整", box.boxType)
+			}
+			entrySize := 4
+			if box.boxType == "co64" {
+				entrySize = 8
+			}
+			count := int(binary.BigEndian.Uint32(payload[4:8]))
+			if count > (len(payload)-8)/entrySize {
+				return fmt.Errorf("%s box数据不完整", box.boxType)
+			}
+
+			for i := 0; i < count; i++ {
+				entry := payload[8+i*entrySize:]
+				if entrySize == 4 {
+					offset, err := shift(uint64(binary.BigEndian.Uint32(entry)))
+					if err != nil {
+						return err
+					}
+					if offset > 0xFFFFFFFF {
+						return fmt.Errorf("修正后的块偏移超出stco的32位范围")
+					}
+					binary.BigEndian.PutUint32(entry, uint32(offset))
+				} else {
+					offset, err := shift(binary.BigEndian.Uint64(entry))
+					if err != nil {
+						return err
+					}
+					binary.BigEndian.PutUint64(entry, offset)
+				}
+			}
+		case "tfhd":
+			// [版本/标志 4][track_ID 4][base_data_offset 8，标志0x000001时存在]
+			if len(payload) >= 16 && binary.BigEndian.Uint32(payload[0:4])&0x000001 != 0 {
+				offset, err := shift(binary.BigEndian.Uint64(payload[8:16]))
+				if err != nil {
+					return err
+				}
+				binary.BigEndian.PutUint64(payload[8:16], offset)
+			}
+		case "tfra":
+			if err := m.patchMP4TFRA(payload, shift); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// patchMP4TFRA 修正 tfra（片段随机访问表）中的 moof_offset
+func (m *ImageModifier) patchMP4TFRA(payload []byte, shift func(uint64) (uint64, error)) error {
+	// [版本/标志 4][track_ID 4][保留26位 + 三个2位长度字段][条目数 4][条目...]
+	if len(payload) < 16 {
+		return fmt.Errorf("tfra box数据不完整")
+	}
+	version := payload[0]
+	lengths := binary.BigEndian.Uint32(payload[8:12])
+	count := int(binary.BigEndian.Uint32(payload[12:16]))
+
+	fieldSize := 4
+	if version == 1 {
+		fieldSize = 8
+	}
+	// time + moof_offset + traf_number + trun_number + sample_number
+	entrySize := 2*fieldSize + int((lengths>>4)&3+1) + int((lengths>>2)&3+1) + int(lengths&3+1)
+	if count > (len(payload)-16)/entrySize {
+		return fmt.Errorf("tfra box数据不完整")
+	}
+
+	for i := 0; i < count; i++ {
+		field := payload[16+i*entrySize+fieldSize:]
+		if fieldSize == 8 {
+			offset, err := shift(binary.BigEndian.Uint64(field))
+			if err != nil {
+				return err
+			}
+			binary.BigEndian.PutUint64(field, offset)
+		} else {
+			offset, err := shift(uint64(binary.BigEndian.Uint32(field)))
+			if err != nil {
+				return err
+			}
+			if offset > 0xFFFFFFFF {
+				return fmt.Errorf("修正后的moof偏移超出32位范围")
+			}
+			binary.BigEndian.PutUint32(field, uint32(offset))
+		}
+	}
+
+	return nil
+}
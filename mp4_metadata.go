@@ -0,0 +1,350 @@
+package imagemodify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// mp4TextAtom 元数据字段与 ilst 标签的对应关系
+type mp4TextAtom struct {
+	atom  string                       // 标签类型（\xa9 即 ©）
+	field func(*ImageMetadata) *string // 对应的元数据字段
+}
+
+// mp4TextAtoms 写入 ilst 的文本标签，按此顺序写入
+var mp4TextAtoms = []mp4TextAtom{
+	{"\xa9nam", func(md *ImageMetadata) *string { return &md.Title }},
+	{"\xa9ART", func(md *ImageMetadata) *string { return &md.Artist }},
+	{"\xa9cpy", func(md *ImageMetadata) *string { return &md.Copyright }},
+	{"desc", func(md *ImageMetadata) *string { return &md.Description }},
+	{"\xa9xyz", func(md *ImageMetadata) *string { return &md.Location }},
+	{"\xa9mak", func(md *ImageMetadata) *string { return &md.CameraMake }},
+	{"\xa9mod", func(md *ImageMetadata) *string { return &md.CameraModel }},
+	{"\xa9too", func(md *ImageMetadata) *string { return &md.Software }},
+}
+
+// mp4DateAtom 拍摄时间标签
+const mp4DateAtom = "\xa9day"
+
+// mp4ManagedAtoms 由本库管理的标签（写入时替换），包括读取时兼容的别名
+var mp4ManagedAtoms = map[string]bool{
+	"\xa9nam": true, "\xa9ART": true, "\xa9cpy": true, "desc": true, "\xa9des": true, "\xa9cmt": true,
+	"\xa9xyz": true, "\xa9mak": true, "\xa9mod": true, "\xa9too": true, "\xa9day": true,
+}
+
+// mp4MetaHandler meta box中的hdlr（处理器类型 mdir，iTunes风格元数据）
+var mp4MetaHandler = []byte{
+	0, 0, 0, 0, // 版本/标志
+	0, 0, 0, 0, // pre_defined
+	'm', 'd', 'i', 'r', // 处理器类型
+	'a', 'p', 'p', 'l', 0, 0, 0, 0, 0, 0, 0, 0, // 保留
+	0, // 名称（空字符串）
+}
+
+// modifyMP4Metadata 修改MP4/MOV的 moov/udta/meta/ilst 元数据
+// moov大小变化后，所有指向moov之后数据的块偏移（stco/co64等）都会被修正
+func (m *ImageModifier) modifyMP4Metadata(data []byte, metadata *ImageMetadata) ([]byte, error) {
+	boxes, err := m.parseMP4(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var moov isoBox
+	for _, box := range boxes {
+		if box.boxType == "moov" {
+			moov = box
+			break
+		}
+	}
+
+	newMoov, err := m.rebuildMP4Moov(data, moov, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, len(data)-(moov.end-moov.start)+len(newMoov))
+	result = append(result, data[:moov.start]...)
+	result = append(result, newMoov...)
+	result = append(result, data[moov.end:]...)
+
+	// moov之后的数据整体移动了 delta 字节（moov位于mdat之前时需要修正块偏移）
+	delta := int64(len(newMoov)) - int64(moov.end-moov.start)
+	if delta != 0 {
+		if err := m.patchMP4Offsets(result, 0, len(result), uint64(moov.end), delta); err != nil {
+			return nil, fmt.Errorf("修正块偏移失败: %v", err)
+		}
+	}
+
+	return result, nil
+}
+
+// rebuildMP4Moov 重建moov box，替换其中udta的元数据
+func (m *ImageModifier) rebuildMP4Moov(data []byte, moov isoBox, metadata *ImageMetadata) ([]byte, error) {
+	children, err := m.parseISOBoxes(data, moov.start+moov.headerSize, moov.end)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload []byte
+	udtaFound := false
+	for _, child := range children {
+		if child.boxType == "udta" && !udtaFound {
+			udta, err := m.rebuildMP4Udta(data, &child, metadata)
+			if err != nil {
+				return nil, err
+			}
+			payload = append(payload, udta...)
+			udtaFound = true
+			continue
+		}
+		payload = append(payload, data[child.start:child.end]...)
+	}
+	if !udtaFound {
+		udta, err := m.rebuildMP4Udta(data, nil, metadata)
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, udta...)
+	}
+
+	return buildISOBox("moov", payload), nil
+}
+
+// rebuildMP4Udta 重建udta box：保留无关的子box，替换meta中由本库管理的标签
+func (m *ImageModifier) rebuildMP4Udta(data []byte, udta *isoBox, metadata *ImageMetadata) ([]byte, error) {
+	var payload []byte
+	var keptItems []byte
+	var keptMetaChildren []byte
+	var keptHandler []byte
+
+	if udta != nil {
+		children, err := m.parseISOBoxes(data, udta.start+udta.headerSize, udta.end)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, child := range children {
+			switch {
+			case child.boxType == "meta":
+				items, others, hdlr, err := m.splitMP4Meta(data, child)
+				if err != nil {
+					return nil, err
+				}
+				if handler := mp4HandlerType(hdlr); handler != "" && handler != "mdir" {
+					// 其他处理器（如QuickTime的mdta、ID3的ID32）的meta原样保留
+					payload = append(payload, data[child.start:child.end]...)
+					continue
+				}
+				if keptHandler == nil {
+					keptHandler = hdlr
+				}
+				keptItems = append(keptItems, items...)
+				keptMetaChildren = append(keptMetaChildren, others...)
+			case mp4ManagedAtoms[child.boxType]:
+				// 旧式QuickTime用户数据标签，由新的ilst标签取代
+			default:
+				payload = append(payload, data[child.start:child.end]...)
+			}
+		}
+	}
+
+	// 新的ilst：保留的其他标签 + 本次写入的标签
+	ilst := keptItems
+	for _, textAtom := range mp4TextAtoms {
+		if value := *textAtom.field(metadata); value != "" {
+			ilst = append(ilst, m.buildMP4TextItem(textAtom.atom, value)...)
+		}
+	}
+	if metadata.DateTime != nil {
+		ilst = append(ilst, m.buildMP4TextItem(mp4DateAtom, metadata.DateTime.UTC().Format("2006-01-02T15:04:05Z"))...)
+	}
+
+	// meta 为完整box：[版本/标志][hdlr][ilst][其他子box]，已有的hdlr原样保留
+	meta := make([]byte, 4)
+	if keptHandler != nil {
+		meta = append(meta, keptHandler...)
+	} else {
+		meta = append(meta, buildISOBox("hdlr", mp4MetaHandler)...)
+	}
+	meta = append(meta, buildISOBox("ilst", ilst)...)
+	meta = append(meta, keptMetaChildren...)
+
+	payload = append(payload, buildISOBox("meta", meta)...)
+	return buildISOBox("udta", payload), nil
+}
+
+// mp4MetaChildrenStart 返回meta box中子box的起始位置
+// ISO规范中meta是带版本/标志的完整box，而QuickTime中的meta直接包含子box
+func (m *ImageModifier) mp4MetaChildrenStart(data []byte, meta isoBox) int {
+	start := meta.start + meta.headerSize
+	if start+8 <= meta.end && string(data[start+4:start+8]) == "hdlr" {
+		return start
+	}
+	return start + 4
+}
+
+// splitMP4Meta 拆分meta box：返回需要保留的ilst标签、除hdlr/ilst外的其他子box，以及原有的hdlr box（没有时为nil）
+func (m *ImageModifier) splitMP4Meta(data []byte, meta isoBox) ([]byte, []byte, []byte, error) {
+	start := m.mp4MetaChildrenStart(data, meta)
+	if start > meta.end {
+		return nil, nil, nil, fmt.Errorf("meta box数据不完整")
+	}
+	children, err := m.parseISOBoxes(data, start, meta.end)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var items, others, hdlr []byte
+	for _, child := range children {
+		switch child.boxType {
+		case "hdlr":
+			if hdlr == nil {
+				hdlr = data[child.start:child.end]
+			}
+		case "ilst":
+			ilstItems, err := m.parseISOBoxes(data, child.start+child.headerSize, child.end)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			for _, item := range ilstItems {
+				if !mp4ManagedAtoms[item.boxType] {
+					items = append(items, data[item.start:item.end]...)
+				}
+			}
+		default:
+			others = append(others, data[child.start:child.end]...)
+		}
+	}
+
+	return items, others, hdlr, nil
+}
+
+// mp4HandlerType 返回hdlr box中的处理器类型，数据不完整时返回空字符串
+func mp4HandlerType(hdlr []byte) string {
+	// [box头 8][版本/标志 4][pre_defined 4][处理器类型 4]
+	if len(hdlr) < 20 {
+		return ""
+	}
+	return string(hdlr[16:20])
+}
+
+// buildMP4TextItem 构造ilst中的文本标签：[标签box [data box [类型 1=UTF-8][区域 0][文本]]]
+func (m *ImageModifier) buildMP4TextItem(atom, value string) []byte {
+	dataPayload := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(dataPayload[0:4], 1)
+	dataPayload = append(dataPayload, value...)
+	return buildISOBox(atom, buildISOBox("data", dataPayload))
+}
+
+// getMP4Metadata 获取MP4/MOV的元数据
+// 同时支持 udta/meta/ilst 中的iTunes风格标签和udta中的旧式QuickTime标签
+func (m *ImageModifier) getMP4Metadata(imagePath string) (*ImageMetadata, error) {
+	data, err := m.readImageFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+
+	boxes, err := m.parseMP4(data)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &ImageMetadata{}
+	values := make(map[string]string)
+
+	for _, box := range boxes {
+		if box.boxType != "moov" {
+			continue
+		}
+		children, err := m.parseISOBoxes(data, box.start+box.headerSize, box.end)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if child.boxType == "udta" {
+				m.readMP4Udta(data, child, values)
+			}
+		}
+	}
+
+	for _, textAtom := range mp4TextAtoms {
+		*textAtom.field(metadata) = values[textAtom.atom]
+	}
+	for _, alias := range []string{"\xa9des", "\xa9cmt"} {
+		if metadata.Description == "" {
+			metadata.Description = values[alias]
+		}
+	}
+	if day := values[mp4DateAtom]; day != "" {
+		for _, layout := range []string{"2006-01-02T15:04:05Z", time.RFC3339, "2006-01-02", "2006"} {
+			if t, err := time.Parse(layout, day); err == nil {
+				metadata.DateTime = &t
+				break
+			}
+		}
+	}
+
+	return metadata, nil
+}
+
+// readMP4Udta 读取udta中的文本标签，ilst中的值优先于旧式QuickTime标签
+func (m *ImageModifier) readMP4Udta(data []byte, udta isoBox, values map[string]string) {
+	children, err := m.parseISOBoxes(data, udta.start+udta.headerSize, udta.end)
+	if err != nil {
+		return
+	}
+
+	for _, child := range children {
+		switch {
+		case child.boxType == "meta":
+			start := m.mp4MetaChildrenStart(data, child)
+			if start > child.end {
+				continue
+			}
+			metaChildren, err := m.parseISOBoxes(data, start, child.end)
+			if err != nil {
+				continue
+			}
+			for _, metaChild := range metaChildren {
+				if metaChild.boxType == "ilst" {
+					m.readMP4Ilst(data, metaChild, values)
+				}
+			}
+		case strings.HasPrefix(child.boxType, "\xa9"):
+			// 旧式QuickTime标签：[文本长度 2][语言 2][文本]
+			payload := child.payload(data)
+			if len(payload) >= 4 {
+				length := int(binary.BigEndian.Uint16(payload[0:2]))
+				if 4+length <= len(payload) {
+					if _, exists := values[child.boxType]; !exists {
+						values[child.boxType] = string(payload[4 : 4+length])
+					}
+				}
+			}
+		}
+	}
+}
+
+// readMP4Ilst 读取ilst中的UTF-8文本标签
+func (m *ImageModifier) readMP4Ilst(data []byte, ilst isoBox, values map[string]string) {
+	items, err := m.parseISOBoxes(data, ilst.start+ilst.headerSize, ilst.end)
+	if err != nil {
+		return
+	}
+
+	for _, item := range items {
+		dataBoxes, err := m.parseISOBoxes(data, item.start+item.headerSize, item.end)
+		if err != nil {
+			continue
+		}
+		for _, dataBox := range dataBoxes {
+			payload := dataBox.payload(data)
+			if dataBox.boxType == "data" && len(payload) >= 8 && binary.BigEndian.Uint32(payload[0:4]) == 1 {
+				values[item.boxType] = string(payload[8:])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testMP4Chunks 测试视频中的媒体数据块
+var testMP4Chunks = [][]byte{
+	bytes.Repeat([]byte{0x11}, 32),
+	bytes.Repeat([]byte{0x22}, 48),
+	bytes.Repeat([]byte{0x33}, 16),
+}
+
+// createTestMP4 创建最小的MP4结构（ftyp + moov + mdat），moovFirst 控制moov是否位于mdat之前
+// 块偏移表中的一个轨道使用stco，另一个使用co64
+func createTestMP4(moovFirst bool, udta []byte) []byte {
+	ftyp := buildISOBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	mdatPayload := bytes.Join(testMP4Chunks, nil)
+	mdat := buildISOBox("mdat", mdatPayload)
+
+	buildMoov := func(mdatStart int) []byte {
+		chunkOffsets := []uint64{}
+		offset := uint64(mdatStart + 8)
+		for _, chunk := range testMP4Chunks {
+			chunkOffsets = append(chunkOffsets, offset)
+			offset += uint64(len(chunk))
+		}
+
+		stco := make([]byte, 8)
+		binary.BigEndian.PutUint32(stco[4:8], 2)
+		for _, o := range chunkOffsets[:2] {
+			stco = binary.BigEndian.AppendUint32(stco, uint32(o))
+		}
+		co64 := make([]byte, 8)
+		binary.BigEndian.PutUint32(co64[4:8], 1)
+		co64 = binary.BigEndian.AppendUint64(co64, chunkOffsets[2])
+
+		trak := func(table []byte, tableType string) []byte {
+			stbl := buildISOBox("stbl", buildISOBox(tableType, table))
+			return buildISOBox("trak", buildISOBox("mdia", buildISOBox("minf", stbl)))
+		}
+
+		payload := buildISOBox("mvhd", make([]byte, 100))
+		payload = append(payload, trak(stco, "stco")...)
+		payload = append(payload, trak(co64, "co64")...)
+		payload = append(payload, udta...)
+		return buildISOBox("moov", payload)
+	}
+
+	if moovFirst {
+		// moov大小与偏移量无关，先用占位值计算大小
+		moovSize := len(buildMoov(0))
+		moov := buildMoov(len(ftyp) + moovSize)
+		return bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+	}
+	moov := buildMoov(len(ftyp))
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+// verifyMP4Chunks 验证块偏移表仍指向正确的媒体数据
+func verifyMP4Chunks(t *testing.T, modifier *ImageModifier, data []byte) {
+	var offsets []uint64
+	var walk func(start, end int)
+	walk = func(start, end int) {
+		boxes, err := modifier.parseISOBoxes(data, start, end)
+		if err != nil {
+			t.Fatalf("解析box失败: %v", err)
+		}
+		for _, box := range boxes {
+			payload := box.payload(data)
+			switch box.boxType {
+			case "moov", "trak", "mdia", "minf", "stbl":
+				walk(box.start+box.headerSize, box.end)
+			case "stco":
+				for i := 0; i < int(binary.BigEndian.Uint32(payload[4:8])); i++ {
+					offsets = append(offsets, uint64(binary.BigEndian.Uint32(payload[8+i*4:])))
+				}
+			case "co64":
+				for i := 0; i < int(binary.BigEndian.Uint32(payload[4:8])); i++ {
+					offsets = append(offsets, binary.BigEndian.Uint64(payload[8+i*8:]))
+				}
+			}
+		}
+	}
+	walk(0, len(data))
+
+	if len(offsets) != len(testMP4Chunks) {
+		t.Fatalf("块偏移数量不一致: %d", len(offsets))
+	}
+	for i, offset := range offsets {
+		chunk := testMP4Chunks[i]
+		if offset+uint64(len(chunk)) > uint64(len(data)) || !bytes.Equal(data[offset:offset+uint64(len(chunk))], chunk) {
+			t.Errorf("第%d个块偏移 %d 没有指向正确的数据", i+1, offset)
+		}
+	}
+}
+
+// TestModifyMP4SHA1 测试MP4随机数据修改
+func TestModifyMP4SHA1(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.mp4")
+	if err := os.WriteFile(testFile, createTestMP4(true, nil), 0644); err != nil {
+		t.Fatalf("创建测试MP4失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+	if _, err := modifier.ModifyImageSHA1(testFile); err != nil {
+		t.Fatalf("修改MP4失败: %v", err)
+	}
+
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("读取修改后的文件失败: %v", err)
+	}
+	verifyMP4Chunks(t, modifier, data)
+}
+
+// TestModifyMP4Metadata 测试MP4元数据修改及块偏移修正
+func TestModifyMP4Metadata(t *testing.T) {
+	// 旧式QuickTime标签和需要保留的其他ilst标签
+	quickTimeArtist := buildISOBox("\xa9ART", append([]byte{0, 3, 0x55, 0xC4}, "old"...))
+	oldMeta := append(make([]byte, 4), buildISOBox("hdlr", mp4MetaHandler)...)
+	oldMeta = append(oldMeta, buildISOBox("ilst", NewImageModifier().buildMP4TextItem("\xa9alb", "专辑"))...)
+	udta := buildISOBox("udta", append(quickTimeArtist, buildISOBox("meta", oldMeta)...))
+
+	for _, moovFirst := range []bool{true, false} {
+		testFile := filepath.Join(t.TempDir(), "test.mov")
+		if err := os.WriteFile(testFile, createTestMP4(moovFirst, udta), 0644); err != nil {
+			t.Fatalf("创建测试MOV失败: %v", err)
+		}
+
+		modifier := NewImageModifier()
+		dateTime := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+		metadata := &ImageMetadata{
+			Title:       "标题",
+			Artist:      "张三",
+			Copyright:   "© 2024 张三",
+			Description: "测试视频",
+			DateTime:    &dateTime,
+			Location:    "+39.9042+116.4074/",
+			CameraMake:  "Apple",
+			CameraModel: "iPhone",
+			Software:    "imagemodify",
+		}
+
+		if _, err := modifier.ModifyImageMetadata(testFile, metadata); err != nil {
+			t.Fatalf("修改MOV元数据失败 (moovFirst=%v): %v", moovFirst, err)
+		}
+
+		data, err := os.ReadFile(testFile)
+		if err != nil {
+			t.Fatalf("读取修改后的文件失败: %v", err)
+		}
+		verifyMP4Chunks(t, modifier, data)
+
+		if !bytes.Contains(data, []byte("专辑")) {
+			t.Error("无关的ilst标签应被保留")
+		}
+
+		result, err := modifier.GetImageMetadata(testFile)
+		if err != nil {
+			t.Fatalf("读取元数据失败: %v", err)
+		}
+		if result.Title != metadata.Title || result.Artist != metadata.Artist || result.Copyright != metadata.Copyright ||
+			result.Description != metadata.Description || result.Location != metadata.Location ||
+			result.CameraMake != metadata.CameraMake || result.CameraModel != metadata.CameraModel ||
+			result.Software != metadata.Software {
+			t.Errorf("元数据不一致: %+v", result)
+		}
+		if result.DateTime == nil || !result.DateTime.Equal(dateTime) {
+			t.Errorf("拍摄时间不一致: %v", result.DateTime)
+		}
+	}
+}
+
+// TestModifyMP4MetadataKeepsHandlers 测试已有meta的hdlr保持不变，其他处理器的meta原样保留
+func TestModifyMP4MetadataKeepsHandlers(t *testing.T) {
+	handler := func(handlerType, name string) []byte {
+		payload := append(make([]byte, 8), handlerType...)
+		payload = append(payload, make([]byte, 12)...)
+		return buildISOBox("hdlr", append(payload, name+"\x00"...))
+	}
+	mdirHandler := handler("mdir", "Apple Metadata Handler")
+	id3Meta := buildISOBox("meta", append(append(make([]byte, 4), handler("ID32", "")...), buildISOBox("ID32", []byte("id3 data"))...))
+	mdirMeta := buildISOBox("meta", append(append(make([]byte, 4), mdirHandler...), buildISOBox("ilst", nil)...))
+	udta := buildISOBox("udta", append(append([]byte(nil), id3Meta...), mdirMeta...))
+
+	modifier := NewImageModifier()
+	modified, err := modifier.modifyMP4Metadata(createTestMP4(true, udta), &ImageMetadata{Title: "标题"})
+	if err != nil {
+		t.Fatalf("修改元数据失败: %v", err)
+	}
+	verifyMP4Chunks(t, modifier, modified)
+
+	if !bytes.Contains(modified, id3Meta) {
+		t.Error("其他处理器的meta应原样保留")
+	}
+	if !bytes.Contains(modified, mdirHandler) || bytes.Contains(modified, buildISOBox("hdlr", mp4MetaHandler)) {
+		t.Error("已有的hdlr应保持不变")
+	}
+	testFile := filepath.Join(t.TempDir(), "test.mp4")
+	if err := os.WriteFile(testFile, modified, 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+	if result, err := modifier.GetImageMetadata(testFile); err != nil || result.Title != "标题" {
+		t.Errorf("读取元数据失败: %+v（%v）", result, err)
+	}
+}
@@ -0,0 +1,345 @@
+package imagemodify
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Netpbm 格式：
+// P1/P4 PBM 位图（ASCII/二进制），P2/P5 PGM 灰度图，P3/P6 PPM 彩色图，P7 PAM 任意通道
+// 文件头由魔数、宽、高、最大值等以空白分隔的字段组成，头部中可以出现以 # 开头的注释
+
+// netpbmImage 解码后的Netpbm图像
+// 样本按原始数值保存（不做缩放），重新编码时可以精确还原
+type netpbmImage struct {
+	magic     string   // P1 ~ P7
+	width     int      // 宽度
+	height    int      // 高度
+	depth     int      // 每个像素的通道数
+	maxval    int      // 样本最大值（PBM为1）
+	tupleType string   // PAM的TUPLTYPE
+	samples   []uint16 // 按行、按像素、按通道排列的样本
+	header    []byte   // 原始文件头（包含注释），重新编码时原样保留
+	trailer   []byte   // 栅格数据之后的附加数据
+}
+
+// isNetpbm 判断数据是否以Netpbm魔数开头
+func (m *ImageModifier) isNetpbm(data []byte) bool {
+	return len(data) >= 3 && data[0] == 'P' && data[1] >= '1' && data[1] <= '7' && isNetpbmSpace(data[2])
+}
+
+// isNetpbmSpace 判断是否为Netpbm头部中的空白字符
+func isNetpbmSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// netpbmScanner 逐个读取Netpbm头部字段（跳过空白和注释）
+type netpbmScanner struct {
+	data []byte
+	pos  int
+}
+
+// skipSpace 跳过空白和注释
+func (s *netpbmScanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch {
+		case isNetpbmSpace(s.data[s.pos]):
+			s.pos++
+		case s.data[s.pos] == '#':
+			for s.pos < len(s.data) && s.data[s.pos] != '\n' && s.data[s.pos] != '\r' {
+				s.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// readInt 读取一个十进制整数
+func (s *netpbmScanner) readInt() (int, error) {
+	s.skipSpace()
+	start := s.pos
+	for s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+		s.pos++
+	}
+	if start == s.pos || s.pos-start > 9 {
+		return 0, fmt.Errorf("Netpbm文件头格式错误")
+	}
+	return strconv.Atoi(string(s.data[start:s.pos]))
+}
+
+// decodeNetpbm 解码Netpbm图像
+func (m *ImageModifier) decodeNetpbm(data []byte) (*netpbmImage, error) {
+	if !m.isNetpbm(data) {
+		return nil, fmt.Errorf("不是有效的Netpbm文件")
+	}
+
+	img := &netpbmImage{magic: string(data[:2])}
+	scanner := &netpbmScanner{data: data, pos: 2}
+
+	var err error
+	if img.magic == "P7" {
+		err = m.decodePAMHeader(img, scanner)
+	} else {
+		err = m.decodePNMHeader(img, scanner)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if img.width <= 0 || img.height <= 0 || img.depth <= 0 || img.maxval <= 0 || img.maxval > 65535 {
+		return nil, fmt.Errorf("Netpbm文件头参数无效")
+	}
+	if img.width > (1<<31)/img.height/img.depth {
+		return nil, fmt.Errorf("Netpbm图像尺寸过大")
+	}
+	if err := m.checkPixels(img.width, img.height); err != nil {
+		return nil, err
+	}
+
+	img.header = data[:scanner.pos]
+	img.samples = make([]uint16, img.width*img.height*img.depth)
+
+	switch img.magic {
+	case "P1":
+		err = m.decodeNetpbmASCIIBits(img, scanner)
+	case "P2", "P3":
+		err = m.decodeNetpbmASCII(img, scanner)
+	case "P4":
+		err = m.decodeNetpbmBinaryBits(img, scanner)
+	default:
+		err = m.decodeNetpbmBinary(img, scanner)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	img.trailer = data[scanner.pos:]
+	return img, nil
+}
+
+// decodePNMHeader 解析P1~P6的文件头
+func (m *ImageModifier) decodePNMHeader(img *netpbmImage, scanner *netpbmScanner) error {
+	var err error
+	if img.width, err = scanner.readInt(); err != nil {
+		return err
+	}
+	if img.height, err = scanner.readInt(); err != nil {
+		return err
+	}
+
+	img.depth = 1
+	img.maxval = 1
+	switch img.magic {
+	case "P3", "P6":
+		img.depth = 3
+	}
+	if img.magic != "P1" && img.magic != "P4" {
+		if img.maxval, err = scanner.readInt(); err != nil {
+			return err
+		}
+	}
+
+	// 文件头以单个空白字符结束，之后即为栅格数据
+	if scanner.pos >= len(scanner.data) || !isNetpbmSpace(scanner.data[scanner.pos]) {
+		return fmt.Errorf("Netpbm文件头格式错误")
+	}
+	scanner.pos++
+	return nil
+}
+
+// decodePAMHeader 解析P7（PAM）的文件头
+func (m *ImageModifier) decodePAMHeader(img *netpbmImage, scanner *netpbmScanner) error {
+	data := scanner.data
+	for {
+		// 按行读取
+		end := bytes.IndexByte(data[scanner.pos:], '\n')
+		if end < 0 {
+			return fmt.Errorf("PAM文件头缺少ENDHDR")
+		}
+		line := strings.TrimSpace(string(data[scanner.pos : scanner.pos+end]))
+		scanner.pos += end + 1
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if fields[0] == "ENDHDR" {
+			return nil
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("PAM文件头格式错误: %s", line)
+		}
+
+		var err error
+		switch fields[0] {
+		case "WIDTH":
+			img.width, err = strconv.Atoi(fields[1])
+		case "HEIGHT":
+			img.height, err = strconv.Atoi(fields[1])
+		case "DEPTH":
+			img.depth, err = strconv.Atoi(fields[1])
+		case "MAXVAL":
+			img.maxval, err = strconv.Atoi(fields[1])
+		case "TUPLTYPE":
+			img.tupleType = strings.Join(fields[1:], " ")
+		}
+		if err != nil {
+			return fmt.Errorf("PAM文件头格式错误: %s", line)
+		}
+	}
+}
+
+// decodeNetpbmASCIIBits 解码P1的ASCII位图（0/1之间可以没有空白）
+func (m *ImageModifier) decodeNetpbmASCIIBits(img *netpbmImage, scanner *netpbmScanner) error {
+	for i := range img.samples {
+		scanner.skipSpace()
+		if scanner.pos >= len(scanner.data) || (scanner.data[scanner.pos] != '0' && scanner.data[scanner.pos] != '1') {
+			return fmt.Errorf("PBM像素数据不完整")
+		}
+		img.samples[i] = uint16(scanner.data[scanner.pos] - '0')
+		scanner.pos++
+	}
+	return nil
+}
+
+// decodeNetpbmASCII 解码P2/P3的ASCII样本
+func (m *ImageModifier) decodeNetpbmASCII(img *netpbmImage, scanner *netpbmScanner) error {
+	for i := range img.samples {
+		value, err := scanner.readInt()
+		if err != nil || value > img.maxval {
+			return fmt.Errorf("Netpbm像素数据不完整或越界")
+		}
+		img.samples[i] = uint16(value)
+	}
+	return nil
+}
+
+// decodeNetpbmBinaryBits 解码P4的二进制位图（每行按字节对齐）
+func (m *ImageModifier) decodeNetpbmBinaryBits(img *netpbmImage, scanner *netpbmScanner) error {
+	rowSize := (img.width + 7) / 8
+	if scanner.pos+rowSize*img.height > len(scanner.data) {
+		return fmt.Errorf("PBM像素数据不完整")
+	}
+	for y := 0; y < img.height; y++ {
+		row := scanner.data[scanner.pos+y*rowSize:]
+		for x := 0; x < img.width; x++ {
+			img.samples[y*img.width+x] = uint16(row[x/8]>>(7-uint(x%8))) & 1
+		}
+	}
+	scanner.pos += rowSize * img.height
+	return nil
+}
+
+// decodeNetpbmBinary 解码P5/P6/P7的二进制样本（maxval大于255时每个样本2字节，大端序）
+func (m *ImageModifier) decodeNetpbmBinary(img *netpbmImage, scanner *netpbmScanner) error {
+	sampleSize := img.sampleSize()
+	if scanner.pos+len(img.samples)*sampleSize > len(scanner.data) {
+		return fmt.Errorf("Netpbm像素数据不完整")
+	}
+	raster := scanner.data[scanner.pos:]
+	for i := range img.samples {
+		if sampleSize == 2 {
+			img.samples[i] = uint16(raster[2*i])<<8 | uint16(raster[2*i+1])
+		} else {
+			img.samples[i] = uint16(raster[i])
+		}
+		if int(img.samples[i]) > img.maxval {
+			return fmt.Errorf("Netpbm像素值越界")
+		}
+	}
+	scanner.pos += len(img.samples) * sampleSize
+	return nil
+}
+
+// sampleSize 二进制格式中每个样本占用的字节数
+func (img *netpbmImage) sampleSize() int {
+	if img.maxval > 255 {
+		return 2
+	}
+	return 1
+}
+
+// encode 将图像重新编码为原有的Netpbm变体，保留原始文件头和附加数据
+func (img *netpbmImage) encode() []byte {
+	var buf bytes.Buffer
+	if len(img.header) > 0 {
+		buf.Write(img.header)
+	} else {
+		img.writeHeader(&buf)
+	}
+
+	switch img.magic {
+	case "P1", "P2", "P3":
+		// ASCII格式每行不超过70个字符
+		lineLen := 0
+		for _, sample := range img.samples {
+			text := strconv.Itoa(int(sample))
+			if lineLen > 0 && lineLen+len(text)+1 > 70 {
+				buf.WriteByte('\n')
+				lineLen = 0
+			} else if lineLen > 0 {
+				buf.WriteByte(' ')
+				lineLen++
+			}
+			buf.WriteString(text)
+			lineLen += len(text)
+		}
+		// 原始数据末尾的换行属于附加数据，避免重复写入
+		if len(img.trailer) == 0 || !isNetpbmSpace(img.trailer[0]) {
+			buf.WriteByte('\n')
+		}
+	case "P4":
+		rowSize := (img.width + 7) / 8
+		row := make([]byte, rowSize)
+		for y := 0; y < img.height; y++ {
+			for i := range row {
+				row[i] = 0
+			}
+			for x := 0; x < img.width; x++ {
+				if img.samples[y*img.width+x] != 0 {
+					row[x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+			buf.Write(row)
+		}
+	default:
+		for _, sample := range img.samples {
+			if img.sampleSize() == 2 {
+				buf.WriteByte(byte(sample >> 8))
+			}
+			buf.WriteByte(byte(sample))
+		}
+	}
+
+	buf.Write(img.trailer)
+	return buf.Bytes()
+}
+
+// writeHeader 生成标准的Netpbm文件头
+func (img *netpbmImage) writeHeader(buf *bytes.Buffer) {
+	if img.magic == "P7" {
+		fmt.Fprintf(buf, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\n", img.width, img.height, img.depth, img.maxval)
+		if img.tupleType != "" {
+			fmt.Fprintf(buf, "TUPLTYPE %s\n", img.tupleType)
+		}
+		buf.WriteString("ENDHDR\n")
+		return
+	}
+
+	fmt.Fprintf(buf, "%s\n%d %d\n", img.magic, img.width, img.height)
+	if img.magic != "P1" && img.magic != "P4" {
+		fmt.Fprintf(buf, "%d\n", img.maxval)
+	}
+}
+
+// colorChannels 参与颜色的通道数（不含alpha）
+func (img *netpbmImage) colorChannels() int {
+	if strings.HasSuffix(img.tupleType, "_ALPHA") || (img.tupleType == "" && (img.depth == 2 || img.depth == 4)) {
+		return img.depth - 1
+	}
+	return img.depth
+}
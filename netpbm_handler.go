@@ -0,0 +1,86 @@
+package imagemodify
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// insertNetpbmComment 在Netpbm文件头中插入注释
+// 注释紧跟在魔数后的第一个空白字符之后，不影响文件头字段和像素数据
+func (m *ImageModifier) insertNetpbmComment(data []byte, randomData []byte) ([]byte, error) {
+	if !m.isNetpbm(data) {
+		return nil, fmt.Errorf("不是有效的Netpbm文件")
+	}
+
+	comment := []byte("# imagemodify:" + hex.EncodeToString(randomData) + "\n")
+
+	insertPos := 3 // 魔数（2字节）+ 一个空白字符
+	result := make([]byte, 0, len(data)+len(comment))
+	result = append(result, data[:insertPos]...)
+	if data[2] != '\n' {
+		// 注释必须独占到行尾，魔数后若不是换行则先补一个换行
+		result = append(result, '\n')
+	}
+	result = append(result, comment...)
+	result = append(result, data[insertPos:]...)
+
+	return result, nil
+}
+
+// modifyNetpbmPixel 通过无损微调边缘像素修改Netpbm图像
+// 颜色通道的样本值按原始精度 ±1（处于边界时反向调整），PBM位图翻转一个像素；
+// 设置了 MaxDeltaE 时改为在ΔE2000约束下调整
+func (m *ImageModifier) modifyNetpbmPixel(data []byte) ([]byte, error) {
+	img, err := m.decodeNetpbm(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按 PixelDistribution 和 PixelMask 随机选择一个像素
+	candidates, err := m.pixelCandidates(m.pixelRand(), img.width, img.height, 1, pixelProbe{
+		uniform: uniformFunc(img.width, img.height, func(x1, y1, x2, y2 int) bool {
+			a, b := (y1*img.width+x1)*img.depth, (y2*img.width+x2)*img.depth
+			for c := 0; c < img.depth; c++ {
+				if img.samples[a+c] != img.samples[b+c] {
+					return false
+				}
+			}
+			return true
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	selectedPixel := candidates[0]
+
+	adjustment := 1
+	if m.generateRandomBytes(1)[0]&0x80 != 0 {
+		adjustment = -1
+	}
+
+	offset := (selectedPixel.Y*img.width + selectedPixel.X) * img.depth
+	if m.MaxDeltaE > 0 {
+		samples := make([]int, img.colorChannels())
+		for c := range samples {
+			samples[c] = int(img.samples[offset+c])
+		}
+		adjusted, err := m.perceptualAdjust(samples, img.maxval, nil)
+		if err != nil {
+			return nil, err
+		}
+		for c, value := range adjusted {
+			img.samples[offset+c] = uint16(value)
+		}
+		return img.encode(), nil
+	}
+
+	for c := 0; c < img.colorChannels(); c++ {
+		value := int(img.samples[offset+c]) + adjustment
+		if value < 0 || value > img.maxval {
+			value = int(img.samples[offset+c]) - adjustment
+		}
+		img.samples[offset+c] = uint16(value)
+	}
+
+	return img.encode(), nil
+}
@@ -0,0 +1,102 @@
+package imagemodify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testNetpbmFiles 各种Netpbm变体的测试数据
+var testNetpbmFiles = map[string]string{
+	"p1.pbm": "P1\n# 注释\n4 3\n0101\n1010\n0110\n",
+	"p2.pgm": "P2\n3 3\n15\n0 5 10\n15 7 3\n1 2 3\n",
+	"p3.ppm": "P3 2 2 255\n255 0 0  0 255 0\n0 0 255  128 128 128\n",
+	"p4.pbm": "P4\n10 2\n\xAB\xC0\x55\x40",
+	"p5.pgm": "P5\n2 2\n65535\n\x00\x01\xFF\xFF\x12\x34\x80\x00",
+	"p6.ppm": "P6\n2 2\n255\n\x01\x02\x03\x04\x05\x06\x07\x08\x09\xFF\xFE\xFD",
+	"p7.pam": "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n\x10\x20\x30\x00\x40\x50\x60\xFF",
+}
+
+// TestNetpbmRoundTrip 测试Netpbm解码后重新编码与原始数据一致
+func TestNetpbmRoundTrip(t *testing.T) {
+	modifier := NewImageModifier()
+	for name, content := range testNetpbmFiles {
+		img, err := modifier.decodeNetpbm([]byte(content))
+		if err != nil {
+			t.Fatalf("%s 解码失败: %v", name, err)
+		}
+
+		// 二进制格式应逐字节一致，ASCII格式允许空白不同，但重新解码后的样本必须一致
+		encoded := img.encode()
+		if img.magic >= "P4" && !bytes.Equal(encoded, []byte(content)) {
+			t.Errorf("%s 重新编码后数据不一致", name)
+		}
+		decoded, err := modifier.decodeNetpbm(encoded)
+		if err != nil {
+			t.Fatalf("%s 重新解码失败: %v", name, err)
+		}
+		for i := range img.samples {
+			if img.samples[i] != decoded.samples[i] {
+				t.Fatalf("%s 第%d个样本不一致", name, i)
+			}
+		}
+	}
+}
+
+// TestModifyNetpbm 测试Netpbm格式的随机数据和像素微调修改
+func TestModifyNetpbm(t *testing.T) {
+	for name, content := range testNetpbmFiles {
+		for _, pixel := range []bool{false, true} {
+			testFile := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+				t.Fatalf("创建测试文件失败: %v", err)
+			}
+
+			modifier := NewImageModifier()
+			var err error
+			if pixel {
+				_, err = modifier.ModifyImageSHA1ByPixel(testFile)
+			} else {
+				_, err = modifier.ModifyImageSHA1(testFile)
+			}
+			if err != nil {
+				t.Fatalf("%s 修改失败 (pixel=%v): %v", name, pixel, err)
+			}
+
+			data, err := os.ReadFile(testFile)
+			if err != nil {
+				t.Fatalf("读取修改后的文件失败: %v", err)
+			}
+			original, _ := modifier.decodeNetpbm([]byte(content))
+			modified, err := modifier.decodeNetpbm(data)
+			if err != nil {
+				t.Fatalf("%s 修改后无法解码: %v", name, err)
+			}
+
+			// 随机模式像素不变；像素模式恰好一个像素发生变化，且每个样本最多变化1
+			changedPixels := 0
+			for i := 0; i < original.width*original.height; i++ {
+				changed := false
+				for c := 0; c < original.depth; c++ {
+					diff := int(original.samples[i*original.depth+c]) - int(modified.samples[i*original.depth+c])
+					if diff < -1 || diff > 1 {
+						t.Errorf("%s 样本变化超过1", name)
+					}
+					if diff != 0 {
+						changed = true
+					}
+				}
+				if changed {
+					changedPixels++
+				}
+			}
+			if pixel && changedPixels != 1 {
+				t.Errorf("%s 像素模式应修改1个像素，实际修改了%d个", name, changedPixels)
+			}
+			if !pixel && changedPixels != 0 {
+				t.Errorf("%s 随机模式不应修改像素", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package imagemodify
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image/png"
+	"math/rand"
+)
+
+// 调色板重排：打乱调色板项的顺序并同步改写像素索引，文件字节改变而每个像素渲染出的颜色不变。
+// PNG同时调整tRNS、hIST和bKGD；GIF同时调整背景色索引和图形控制扩展中的透明色索引。
+
+// paletteRand 调色板重排使用的随机数源：设置了 PaletteSeed 时使用固定种子
+func (m *ImageModifier) paletteRand() *rand.Rand {
+	seed := m.PaletteSeed
+	if seed == 0 {
+		seed = int64(binary.BigEndian.Uint64(m.generateRandomBytes(8)))
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// palettePermutation 生成 n 个调色板项的排列，新索引为 perm[旧索引]，保证不是恒等排列
+func palettePermutation(rng *rand.Rand, n int) ([]int, error) {
+	if n < 2 {
+		return nil, fmt.Errorf("调色板只有 %d 种颜色，无法重排", n)
+	}
+	for {
+		perm := rng.Perm(n)
+		for i, p := range perm {
+			if i != p {
+				return perm, nil
+			}
+		}
+	}
+}
+
+// reorderPNGPalette 重排调色板PNG的PLTE并改写图像数据
+func (m *ImageModifier) reorderPNGPalette(data []byte) ([]byte, error) {
+	chunks, err := m.parsePNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	header, err := m.parsePNGHeader(chunks)
+	if err != nil {
+		return nil, err
+	}
+	if header.colorType != pngColorPalette {
+		return nil, fmt.Errorf("PNG图片不是调色板图像")
+	}
+	if m.isAPNG(chunks) {
+		// 动画帧（fdAT）中的索引同样需要改写，目前不支持
+		return nil, fmt.Errorf("%w: 调色板重排不支持APNG", ErrUnsafeAPNGEdit)
+	}
+
+	var plte []byte
+	var zdata []byte
+	for _, chunk := range chunks {
+		switch chunk.chunkType {
+		case "PLTE":
+			plte = chunk.data
+		case "IDAT":
+			zdata = append(zdata, chunk.data...)
+		}
+	}
+	if len(plte) == 0 || len(plte)%3 != 0 {
+		return nil, fmt.Errorf("PNG调色板无效")
+	}
+	if err := m.checkDecodeConfig(data); err != nil {
+		return nil, err
+	}
+	perm, err := palettePermutation(m.paletteRand(), len(plte)/3)
+	if err != nil {
+		return nil, err
+	}
+
+	// 改写像素索引
+	passes, err := header.unfilterPNGImageData(zdata)
+	if err != nil {
+		return nil, err
+	}
+	for _, pass := range passes {
+		for _, row := range pass.rows {
+			remapPackedIndices(row, header.bitDepth, perm)
+		}
+	}
+	compressed, err := compressPNGPasses(passes, &pngRecompressOptions{filter: 0, level: zlib.DefaultCompression})
+	if err != nil {
+		return nil, err
+	}
+
+	// 按原有顺序写回全部块，替换与调色板相关的块和IDAT
+	var buf bytes.Buffer
+	buf.Write(data[:chunks[0].start])
+	idatWritten := false
+	for _, chunk := range chunks {
+		switch chunk.chunkType {
+		case "PLTE":
+			buf.Write(buildPNGChunk("PLTE", permuteEntries(chunk.data, 3, perm, nil)))
+		case "tRNS":
+			buf.Write(buildPNGChunk("tRNS", permuteTransparency(chunk.data, perm)))
+		case "hIST":
+			buf.Write(buildPNGChunk("hIST", permuteEntries(chunk.data, 2, perm, nil)))
+		case "bKGD":
+			background := append([]byte(nil), chunk.data...)
+			if len(background) == 1 && int(background[0]) < len(perm) {
+				background[0] = byte(perm[background[0]])
+			}
+			buf.Write(buildPNGChunk("bKGD", background))
+		case "IDAT":
+			if !idatWritten {
+				buf.Write(m.buildIDATChunks(compressed, pngIDATChunkSize))
+				idatWritten = true
+			}
+		default:
+			buf.Write(data[chunk.start:chunk.end])
+		}
+	}
+	buf.Write(data[chunks[len(chunks)-1].end:])
+	result := buf.Bytes()
+
+	// 验证解码后的颜色完全相同
+	before, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解码PNG图片失败: %v", err)
+	}
+	after, err := png.Decode(bytes.NewReader(result))
+	if err != nil || !samePixels(before, after) {
+		return nil, fmt.Errorf("调色板重排后像素发生变化")
+	}
+	return result, nil
+}
+
+// permuteEntries 按排列移动定长的表项，结果共 len(perm) 项；原表不足的项取 fill（为nil时取0）
+func permuteEntries(data []byte, size int, perm []int, fill []byte) []byte {
+	if fill == nil {
+		fill = make([]byte, size)
+	}
+	result := make([]byte, len(perm)*size)
+	for i, target := range perm {
+		entry := fill
+		if (i+1)*size <= len(data) {
+			entry = data[i*size : (i+1)*size]
+		}
+		copy(result[target*size:], entry)
+	}
+	return result
+}
+
+// permuteTransparency 重排tRNS中各调色板项的alpha，省略结尾不透明的项（至少保留一项）
+func permuteTransparency(trns []byte, perm []int) []byte {
+	alpha := permuteEntries(trns, 1, perm, []byte{255})
+	end := len(alpha)
+	for end > 1 && alpha[end-1] == 255 {
+		end--
+	}
+	return alpha[:end]
+}
+
+// remapPackedIndices 改写一行中按位深度紧密排列的索引
+func remapPackedIndices(row []byte, bitDepth int, perm []int) {
+	mask := 1<<uint(bitDepth) - 1
+	for i, b := range row {
+		var out byte
+		for shift := 8 - bitDepth; shift >= 0; shift -= bitDepth {
+			index := int(b>>uint(shift)) & mask
+			if index < len(perm) {
+				index = perm[index]
+			}
+			out |= byte(index << uint(shift))
+		}
+		row[i] = out
+	}
+}
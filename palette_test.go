@@ -0,0 +1,184 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestPalettedPNG 生成调色板PNG，colors 决定位深度；附带bKGD和hIST
+func createTestPalettedPNG(t *testing.T, colors int) []byte {
+	var palette color.Palette
+	for i := 0; i < colors; i++ {
+		palette = append(palette, color.NRGBA{uint8(i * 40), uint8(255 - i*30), uint8(i * 7), uint8(255 - i%3*100)})
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 15, 9), palette)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % colors)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+	chunks, _ := modifier.parsePNGChunks(buf.Bytes())
+	var data []byte
+	data = append(data, pngSignature...)
+	for _, chunk := range chunks {
+		if chunk.chunkType == "IDAT" {
+			data = append(data, buildPNGChunk("bKGD", []byte{1})...)
+			data = append(data, buildPNGChunk("hIST", make([]byte, 2*colors))...)
+		}
+		data = append(data, buf.Bytes()[chunk.start:chunk.end]...)
+	}
+	return data
+}
+
+func TestReorderPNGPalette(t *testing.T) {
+	modifier := NewImageModifier()
+	for _, colors := range []int{2, 4, 11, 200} {
+		data := createTestPalettedPNG(t, colors)
+		reordered, err := modifier.reorderPNGPalette(data)
+		if err != nil {
+			t.Fatalf("%d 色: 调色板重排失败: %v", colors, err)
+		}
+		if bytes.Equal(pngChunkData(t, data, "PLTE"), pngChunkData(t, reordered, "PLTE")) {
+			t.Errorf("%d 色: 调色板没有变化", colors)
+		}
+		if changed := countChangedPixels(t, data, reordered); changed != 0 {
+			t.Errorf("%d 色: %d 个像素颜色发生变化", colors, changed)
+		}
+
+		// bKGD 指向的颜色不变
+		plte, newPLTE := pngChunkData(t, data, "PLTE"), pngChunkData(t, reordered, "PLTE")
+		index := int(pngChunkData(t, reordered, "bKGD")[0])
+		if !bytes.Equal(plte[3:6], newPLTE[3*index:3*index+3]) {
+			t.Errorf("%d 色: bKGD 未同步调整", colors)
+		}
+	}
+
+	if _, err := modifier.reorderPNGPalette(createTestPalettedPNG(t, 1)); err == nil {
+		t.Error("只有一种颜色时应返回错误")
+	}
+}
+
+func TestPaletteSeedIsReproducible(t *testing.T) {
+	data := createTestPalettedPNG(t, 16)
+	a, err := (&ImageModifier{PaletteSeed: 42}).reorderPNGPalette(data)
+	if err != nil {
+		t.Fatalf("调色板重排失败: %v", err)
+	}
+	b, _ := (&ImageModifier{PaletteSeed: 42}).reorderPNGPalette(data)
+	c, _ := (&ImageModifier{PaletteSeed: 43}).reorderPNGPalette(data)
+	if !bytes.Equal(a, b) {
+		t.Error("相同种子应得到相同结果")
+	}
+	if bytes.Equal(a, c) {
+		t.Error("不同种子应得到不同结果")
+	}
+}
+
+// createTestGIF 生成两帧GIF：第一帧使用全局颜色表并带透明色，第二帧使用局部颜色表
+func createTestGIF(t *testing.T) []byte {
+	global := color.Palette{color.RGBA{0, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}}
+	local := color.Palette{color.RGBA{10, 10, 10, 255}, color.RGBA{20, 30, 40, 255}, color.RGBA{90, 80, 70, 255},
+		color.RGBA{1, 2, 3, 255}, color.RGBA{200, 200, 0, 255}, color.RGBA{0, 200, 200, 255}}
+
+	first := image.NewPaletted(image.Rect(0, 0, 20, 12), global)
+	second := image.NewPaletted(image.Rect(2, 3, 14, 10), local)
+	for i := range first.Pix {
+		first.Pix[i] = uint8(i % 4)
+	}
+	for i := range second.Pix {
+		second.Pix[i] = uint8(i * 7 % 6)
+	}
+
+	anim := &gif.GIF{
+		Image:           []*image.Paletted{first, second},
+		Delay:           []int{10, 20},
+		Disposal:        []byte{gif.DisposalNone, gif.DisposalBackground},
+		Config:          image.Config{ColorModel: global, Width: 20, Height: 12},
+		BackgroundIndex: 2,
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("编码GIF失败: %v", err)
+	}
+
+	// 给第一帧的图形控制扩展设置透明色（索引3）
+	data := buf.Bytes()
+	file, err := NewImageModifier().parseGIF(data)
+	if err != nil {
+		t.Fatalf("解析GIF失败: %v", err)
+	}
+	control := file.images[0].control
+	data[control+3] |= 0x01
+	data[control+6] = 3
+	return data
+}
+
+func TestReorderGIFPalette(t *testing.T) {
+	data := createTestGIF(t)
+	modifier := NewImageModifier()
+	for i := 0; i < 5; i++ {
+		reordered, err := modifier.reorderGIFPalette(data)
+		if err != nil {
+			t.Fatalf("调色板重排失败: %v", err)
+		}
+		if bytes.Equal(reordered, data) {
+			t.Fatal("GIF没有变化")
+		}
+
+		before, _ := gif.DecodeAll(bytes.NewReader(data))
+		after, err := gif.DecodeAll(bytes.NewReader(reordered))
+		if err != nil {
+			t.Fatalf("重排后的GIF无法解码: %v", err)
+		}
+		for f := range before.Image {
+			if !samePixels(before.Image[f], after.Image[f]) {
+				t.Fatalf("第 %d 帧颜色发生变化", f)
+			}
+			if after.Delay[f] != before.Delay[f] || after.Disposal[f] != before.Disposal[f] {
+				t.Fatalf("第 %d 帧的延迟或处置方式发生变化", f)
+			}
+		}
+		// 透明色仍然是原来的颜色项
+		if _, _, _, a := after.Image[0].At(3, 0).RGBA(); a != 0 {
+			t.Error("透明色索引未同步调整")
+		}
+	}
+}
+
+func TestModifyImageSHA1ByPalette(t *testing.T) {
+	dir := t.TempDir()
+	modifier := NewImageModifier()
+	for name, data := range map[string][]byte{"test.gif": createTestGIF(t), "test.png": createTestPalettedPNG(t, 8)} {
+		testFile := filepath.Join(dir, name)
+		if err := os.WriteFile(testFile, data, 0644); err != nil {
+			t.Fatalf("写入测试文件失败: %v", err)
+		}
+		originalSHA1, _ := modifier.GetImageSHA1(testFile)
+		newSHA1, err := modifier.ModifyImageSHA1ByPalette(testFile)
+		if err != nil {
+			t.Fatalf("%s: 调色板重排失败: %v", name, err)
+		}
+		if newSHA1 == originalSHA1 {
+			t.Errorf("%s: SHA1没有变化", name)
+		}
+	}
+
+	// 非调色板PNG
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	testFile := filepath.Join(dir, "rgba.png")
+	os.WriteFile(testFile, buf.Bytes(), 0644)
+	if _, err := modifier.ModifyImageSHA1ByPalette(testFile); err == nil {
+		t.Error("非调色板PNG应返回错误")
+	}
+}
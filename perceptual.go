@@ -0,0 +1,173 @@
+package imagemodify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// 感知约束的像素微调：在CIELAB空间用ΔE2000衡量颜色变化。
+// 以原颜色为中心枚举各通道的小幅调整，只保留确实改变了样本值、
+// 且与原颜色的ΔE2000不超过 MaxDeltaE 的候选，再从中随机选择一个。
+// 调整不经过截断，边界上的颜色不会出现"调整后没有变化"的情况
+
+// labColor CIELAB颜色（D65白点）
+type labColor struct {
+	l, a, b float64
+}
+
+// srgbToLinear sRGB伽马解码，v 取值 0~1
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// labF CIELAB转换中的分段函数
+func labF(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29.0
+}
+
+// sampleToLab 把灰度（1个通道）或RGB（3个通道）样本值转换为CIELAB，样本按sRGB解释
+func sampleToLab(samples []int, maxValue int) labColor {
+	var r, g, b float64
+	if len(samples) == 1 {
+		r = srgbToLinear(float64(samples[0]) / float64(maxValue))
+		g, b = r, r
+	} else {
+		r = srgbToLinear(float64(samples[0]) / float64(maxValue))
+		g = srgbToLinear(float64(samples[1]) / float64(maxValue))
+		b = srgbToLinear(float64(samples[2]) / float64(maxValue))
+	}
+
+	// 线性sRGB -> XYZ，按D65白点归一化
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return labColor{l: 116*fy - 16, a: 500 * (fx - fy), b: 200 * (fy - fz)}
+}
+
+// deltaE2000 计算两个CIELAB颜色之间的CIEDE2000色差
+func deltaE2000(c1, c2 labColor) float64 {
+	const deg = math.Pi / 180
+
+	cab := (math.Hypot(c1.a, c1.b) + math.Hypot(c2.a, c2.b)) / 2
+	cab7 := math.Pow(cab, 7)
+	g := 0.5 * (1 - math.Sqrt(cab7/(cab7+math.Pow(25, 7))))
+
+	a1, a2 := (1+g)*c1.a, (1+g)*c2.a
+	ch1, ch2 := math.Hypot(a1, c1.b), math.Hypot(a2, c2.b)
+	h1, h2 := hueAngle(c1.b, a1), hueAngle(c2.b, a2)
+
+	dl := c2.l - c1.l
+	dc := ch2 - ch1
+	var dh float64
+	if ch1*ch2 != 0 {
+		dh = h2 - h1
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+	}
+	dH := 2 * math.Sqrt(ch1*ch2) * math.Sin(dh/2*deg)
+
+	lMean := (c1.l + c2.l) / 2
+	cMean := (ch1 + ch2) / 2
+	hMean := h1 + h2
+	if ch1*ch2 != 0 {
+		if math.Abs(h1-h2) <= 180 {
+			hMean /= 2
+		} else if h1+h2 < 360 {
+			hMean = (h1 + h2 + 360) / 2
+		} else {
+			hMean = (h1 + h2 - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos((hMean-30)*deg) + 0.24*math.Cos(2*hMean*deg) +
+		0.32*math.Cos((3*hMean+6)*deg) - 0.20*math.Cos((4*hMean-63)*deg)
+	dTheta := 30 * math.Exp(-((hMean-275)/25)*((hMean-275)/25))
+	cMean7 := math.Pow(cMean, 7)
+	rc := 2 * math.Sqrt(cMean7/(cMean7+math.Pow(25, 7)))
+	sl := 1 + 0.015*(lMean-50)*(lMean-50)/math.Sqrt(20+(lMean-50)*(lMean-50))
+	sc := 1 + 0.045*cMean
+	sh := 1 + 0.015*cMean*t
+	rt := -math.Sin(2*dTheta*deg) * rc
+
+	l, c, h := dl/sl, dc/sc, dH/sh
+	return math.Sqrt(l*l + c*c + h*h + rt*c*h)
+}
+
+// hueAngle 色相角（度，0~360）
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// perceptualSteps 各通道候选的调整量：8位精度的 ±1、±2 个单位，
+// 高精度（如16位）时额外加入原始精度的 ±1，以满足很小的ΔE上限
+func perceptualSteps(maxValue int) []int {
+	unit := 1
+	if maxValue > 255 {
+		unit = maxValue / 255
+	}
+	steps := []int{0, unit, -unit, 2 * unit, -2 * unit}
+	if unit > 1 {
+		steps = append(steps, 1, -1)
+	}
+	return steps
+}
+
+// perceptualAdjust 在 MaxDeltaE 的约束下调整一个像素的颜色样本（1个灰度通道或3个RGB通道）
+// reject 不为nil时排除不允许的结果（如恰好等于透明色键）。没有满足条件的候选时返回错误
+func (m *ImageModifier) perceptualAdjust(samples []int, maxValue int, reject func([]int) bool) ([]int, error) {
+	if len(samples) != 1 && len(samples) != 3 {
+		return nil, fmt.Errorf("ΔE约束只支持灰度和RGB图像（%d 个颜色通道）", len(samples))
+	}
+
+	original := sampleToLab(samples, maxValue)
+	steps := perceptualSteps(maxValue)
+	var candidates [][]int
+	var visit func(channel int, current []int)
+	visit = func(channel int, current []int) {
+		if channel == len(samples) {
+			if equalSamples(current, samples) || (reject != nil && reject(current)) {
+				return
+			}
+			if deltaE2000(original, sampleToLab(current, maxValue)) <= m.MaxDeltaE {
+				candidates = append(candidates, append([]int(nil), current...))
+			}
+			return
+		}
+		for _, step := range steps {
+			value := samples[channel] + step
+			if value < 0 || value > maxValue {
+				continue
+			}
+			current[channel] = value
+			visit(channel+1, current)
+		}
+		current[channel] = samples[channel]
+	}
+	visit(0, append([]int(nil), samples...))
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("无法在ΔE不超过 %.2f 的前提下改变该像素", m.MaxDeltaE)
+	}
+	pick := binary.BigEndian.Uint32(m.generateRandomBytes(4))
+	return candidates[pick%uint32(len(candidates))], nil
+}
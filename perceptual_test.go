@@ -0,0 +1,119 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func TestDeltaE2000(t *testing.T) {
+	// Sharma、Wu、Dalal 给出的CIEDE2000测试数据
+	tests := []struct {
+		c1, c2 labColor
+		want   float64
+	}{
+		{labColor{50, 2.6772, -79.7751}, labColor{50, 0, -82.7485}, 2.0425},
+		{labColor{50, 2.8361, -74.0200}, labColor{50, 0, -82.7485}, 3.4412},
+		{labColor{50, 0, 0}, labColor{50, -1, 2}, 2.3669},
+		{labColor{50, 2.5, 0}, labColor{73, 25, -18}, 27.1492},
+		{labColor{60.2574, -34.0099, 36.2677}, labColor{60.4626, -34.1751, 39.4387}, 1.2644},
+		{labColor{22.7233, 20.0904, -46.6940}, labColor{23.0331, 14.9730, -42.5619}, 2.0373},
+	}
+	for _, tt := range tests {
+		if got := deltaE2000(tt.c1, tt.c2); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("deltaE2000(%v, %v) = %.4f，期望 %.4f", tt.c1, tt.c2, got, tt.want)
+		}
+		if got := deltaE2000(tt.c2, tt.c1); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("deltaE2000 不对称: %.4f", got)
+		}
+	}
+
+	white := sampleToLab([]int{255, 255, 255}, 255)
+	if math.Abs(white.l-100) > 1e-3 || math.Abs(white.a) > 1e-3 || math.Abs(white.b) > 1e-3 {
+		t.Errorf("白色的Lab值错误: %v", white)
+	}
+}
+
+func TestPerceptualAdjust(t *testing.T) {
+	modifier := &ImageModifier{MaxDeltaE: 1}
+	for _, tt := range []struct {
+		samples  []int
+		maxValue int
+	}{
+		{[]int{0, 0, 0}, 255},
+		{[]int{255, 255, 255}, 255},
+		{[]int{255, 0, 0}, 255},
+		{[]int{0, 0, 255}, 255},
+		{[]int{128}, 255},
+		{[]int{65535, 0, 30000}, 65535},
+	} {
+		for i := 0; i < 20; i++ {
+			adjusted, err := modifier.perceptualAdjust(tt.samples, tt.maxValue, nil)
+			if err != nil {
+				t.Fatalf("%v: 调整失败: %v", tt.samples, err)
+			}
+			if equalSamples(adjusted, tt.samples) {
+				t.Fatalf("%v: 样本没有变化", tt.samples)
+			}
+			if dist := deltaE2000(sampleToLab(tt.samples, tt.maxValue), sampleToLab(adjusted, tt.maxValue)); dist > 1 {
+				t.Fatalf("%v -> %v: ΔE %.3f 超过上限", tt.samples, adjusted, dist)
+			}
+		}
+	}
+
+	if _, err := (&ImageModifier{MaxDeltaE: 0.01}).perceptualAdjust([]int{128, 128, 128}, 255, nil); err == nil {
+		t.Error("ΔE上限过小时应返回错误")
+	}
+	if _, err := modifier.perceptualAdjust([]int{0}, 1, nil); err == nil {
+		t.Error("1位灰度无法满足ΔE上限，应返回错误")
+	}
+}
+
+func TestModifyPNGPixelMaxDeltaE(t *testing.T) {
+	rgb := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	rgb64 := image.NewRGBA64(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			// 边缘像素处于0或255，固定幅度微调时容易被截断
+			v := uint8(255 * ((x + y) % 2))
+			rgb.SetNRGBA(x, y, color.NRGBA{v, 255 - v, v, 255})
+			rgb64.SetRGBA64(x, y, color.RGBA64{uint16(v) * 257, 0, 0xFFFF, 0xFFFF})
+		}
+	}
+
+	modifier := &ImageModifier{MaxDeltaE: 0.8}
+	for name, img := range map[string]image.Image{"rgb": rgb, "rgb64": rgb64} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("编码PNG失败: %v", err)
+		}
+		data := buf.Bytes()
+		for i := 0; i < 10; i++ {
+			modified, err := modifier.modifyPNGPixel(data, &PixelModifyResult{})
+			if err != nil {
+				t.Fatalf("%s: 像素微调失败: %v", name, err)
+			}
+			if changed := countChangedPixels(t, data, modified); changed != 1 {
+				t.Fatalf("%s: 应改变1个像素，实际为 %d", name, changed)
+			}
+
+			before, _ := png.Decode(bytes.NewReader(data))
+			after, _ := png.Decode(bytes.NewReader(modified))
+			bounds := before.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					r1, g1, b1, _ := before.At(x, y).RGBA()
+					r2, g2, b2, _ := after.At(x, y).RGBA()
+					c1 := sampleToLab([]int{int(r1), int(g1), int(b1)}, 0xFFFF)
+					c2 := sampleToLab([]int{int(r2), int(g2), int(b2)}, 0xFFFF)
+					if dist := deltaE2000(c1, c2); dist > 0.8 {
+						t.Fatalf("%s: 像素 (%d,%d) 的ΔE %.3f 超过上限", name, x, y, dist)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package imagemodify
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// ErrNoEligiblePixel 在区域掩码和像素分布的限制下没有可以修改的像素
+var ErrNoEligiblePixel = errors.New("没有允许修改的像素")
+
+// PixelMask 限制像素微调可以修改的区域，坐标均为图片坐标（左上角为原点）
+type PixelMask struct {
+	// Include 只允许修改这些矩形内的像素，为空时不限制
+	Include []image.Rectangle
+
+	// Exclude 不允许修改这些矩形内的像素，优先于 Include
+	Exclude []image.Rectangle
+
+	// Image 掩码图像：对应位置亮度不低于50%（白色）的像素才允许修改，超出掩码图像范围的像素不允许修改
+	Image image.Image
+
+	// AvoidUniform 为true时跳过平坦区域中的像素（周围3x3邻域内颜色完全相同），
+	// 例如纯色边框和背景
+	AvoidUniform bool
+}
+
+// pixelProbe 选择像素时需要的图像信息，由各格式的像素微调提供，不需要的项可以为nil
+type pixelProbe struct {
+	texture func(x, y int) float64 // 纹理强度，越大越不易察觉
+	uniform func(x, y int) bool    // 是否处于平坦区域
+}
+
+// allows 判断掩码的矩形和掩码图像是否允许修改 (x, y)，nil 表示不限制
+func (mask *PixelMask) allows(x, y int) bool {
+	if mask == nil {
+		return true
+	}
+	p := image.Point{X: x, Y: y}
+	if len(mask.Include) > 0 {
+		included := false
+		for _, r := range mask.Include {
+			if p.In(r) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, r := range mask.Exclude {
+		if p.In(r) {
+			return false
+		}
+	}
+	if mask.Image != nil {
+		q := p.Add(mask.Image.Bounds().Min)
+		if !q.In(mask.Image.Bounds()) {
+			return false
+		}
+		if color.Gray16Model.Convert(mask.Image.At(q.X, q.Y)).(color.Gray16).Y < 0x8000 {
+			return false
+		}
+	}
+	return true
+}
+
+// regions 可能允许修改的矩形：Include 与图片范围的交集，没有 Include 时为整幅图片
+func (mask *PixelMask) regions(width, height int) []image.Rectangle {
+	bounds := image.Rect(0, 0, width, height)
+	if mask == nil || len(mask.Include) == 0 {
+		return []image.Rectangle{bounds}
+	}
+	var regions []image.Rectangle
+	for _, r := range mask.Include {
+		if r = r.Intersect(bounds); !r.Empty() {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// eligible 返回判断像素是否允许修改的函数：掩码限制之外，设置了 AvoidUniform 时还要排除平坦区域
+func (mask *PixelMask) eligible(probe pixelProbe) func(p PixelCoord) bool {
+	return func(p PixelCoord) bool {
+		if !mask.allows(p.X, p.Y) {
+			return false
+		}
+		return mask == nil || !mask.AvoidUniform || probe.uniform == nil || !probe.uniform(p.X, p.Y)
+	}
+}
+
+// uniformFunc 根据两个像素是否相同生成平坦区域判断：3x3邻域内的像素都与中心相同（图片边界外的不计）
+func uniformFunc(width, height int, same func(x1, y1, x2, y2 int) bool) func(x, y int) bool {
+	return func(x, y int) bool {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || ny < 0 || nx >= width || ny >= height || (dx == 0 && dy == 0) {
+					continue
+				}
+				if !same(x, y, nx, ny) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+}
+
+// imageUniform 基于 image.Image 的平坦区域判断
+func imageUniform(img image.Image) func(x, y int) bool {
+	bounds := img.Bounds()
+	return uniformFunc(bounds.Dx(), bounds.Dy(), func(x1, y1, x2, y2 int) bool {
+		r1, g1, b1, a1 := img.At(bounds.Min.X+x1, bounds.Min.Y+y1).RGBA()
+		r2, g2, b2, a2 := img.At(bounds.Min.X+x2, bounds.Min.Y+y2).RGBA()
+		return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+	})
+}
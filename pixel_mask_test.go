@@ -0,0 +1,147 @@
+package imagemodify
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPixelMaskAllows(t *testing.T) {
+	maskImage := image.NewGray(image.Rect(10, 10, 20, 20))
+	maskImage.SetGray(13, 12, color.Gray{Y: 255})
+
+	mask := &PixelMask{
+		Include: []image.Rectangle{image.Rect(0, 0, 8, 8)},
+		Exclude: []image.Rectangle{image.Rect(2, 2, 4, 4)},
+	}
+	for _, tt := range []struct {
+		mask *PixelMask
+		x, y int
+		want bool
+	}{
+		{nil, 5, 5, true},
+		{mask, 1, 1, true},
+		{mask, 3, 3, false},
+		{mask, 9, 1, false},
+		{&PixelMask{Image: maskImage}, 3, 2, true},
+		{&PixelMask{Image: maskImage}, 2, 2, false},
+		{&PixelMask{Image: maskImage}, 30, 30, false},
+	} {
+		if got := tt.mask.allows(tt.x, tt.y); got != tt.want {
+			t.Errorf("allows(%d, %d) = %v，期望 %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+// createTestBorderedPNG 生成带4像素纯白边框的PNG，内部为渐变
+func createTestBorderedPNG(t testing.TB) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 48, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			c := color.NRGBA{255, 255, 255, 255}
+			if x >= 4 && y >= 4 && x < 44 && y < 28 {
+				c = color.NRGBA{uint8(x * 5), uint8(y * 7), uint8(x * y), 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// changedPixels 返回两个PNG中颜色不同的像素
+func changedPixels(t *testing.T, a, b []byte) []image.Point {
+	imgA, _ := png.Decode(bytes.NewReader(a))
+	imgB, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("解码PNG失败: %v", err)
+	}
+	var points []image.Point
+	bounds := imgA.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if imgA.At(x, y) != imgB.At(x, y) {
+				points = append(points, image.Pt(x, y))
+			}
+		}
+	}
+	return points
+}
+
+func TestModifyPNGPixelMask(t *testing.T) {
+	data := createTestBorderedPNG(t)
+	logo := image.Rect(20, 10, 30, 20)
+
+	for name, modifier := range map[string]*ImageModifier{
+		"avoid-uniform": {PixelCount: 8, PixelDistribution: PixelDistributionUniform, PixelMask: &PixelMask{AvoidUniform: true}},
+		"include":       {PixelCount: 8, PixelDistribution: PixelDistributionUniform, PixelMask: &PixelMask{Include: []image.Rectangle{image.Rect(4, 4, 44, 28)}, Exclude: []image.Rectangle{logo}}},
+		"texture":       {PixelCount: 8, PixelDistribution: PixelDistributionTexture, PixelMask: &PixelMask{AvoidUniform: true, Exclude: []image.Rectangle{logo}}},
+	} {
+		for i := 0; i < 5; i++ {
+			modified, err := modifier.modifyPNGPixel(data, &PixelModifyResult{})
+			if err != nil {
+				t.Fatalf("%s: 像素微调失败: %v", name, err)
+			}
+			points := changedPixels(t, data, modified)
+			if len(points) != 8 {
+				t.Fatalf("%s: 应改变8个像素，实际为 %d", name, len(points))
+			}
+			// 避开平坦区域时，紧贴内容的一圈边框像素不算平坦
+			margin := 4
+			if len(modifier.PixelMask.Include) == 0 {
+				margin = 3
+			}
+			for _, p := range points {
+				if p.X < margin || p.Y < margin || p.X >= 48-margin || p.Y >= 32-margin {
+					t.Fatalf("%s: 修改了白色边框中的像素 %v", name, p)
+				}
+				if name != "avoid-uniform" && p.In(logo) {
+					t.Fatalf("%s: 修改了排除区域中的像素 %v", name, p)
+				}
+			}
+		}
+	}
+
+	// 稀疏的掩码图像：只允许一个像素
+	maskImage := image.NewGray(image.Rect(0, 0, 48, 32))
+	maskImage.SetGray(30, 25, color.Gray{Y: 255})
+	modifier := &ImageModifier{PixelDistribution: PixelDistributionUniform, PixelMask: &PixelMask{Image: maskImage}}
+	modified, err := modifier.modifyPNGPixel(data, &PixelModifyResult{})
+	if err != nil {
+		t.Fatalf("像素微调失败: %v", err)
+	}
+	if points := changedPixels(t, data, modified); len(points) != 1 || points[0] != image.Pt(30, 25) {
+		t.Errorf("应只修改掩码允许的像素，实际为 %v", points)
+	}
+}
+
+func TestPixelMaskNoEligiblePixel(t *testing.T) {
+	dir := t.TempDir()
+	pngFile := filepath.Join(dir, "test.png")
+	if err := os.WriteFile(pngFile, createTestBorderedPNG(t), 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+	jpegFile := filepath.Join(dir, "test.jpg")
+	if err := createTestJPEG(jpegFile); err != nil {
+		t.Fatalf("创建测试图片失败: %v", err)
+	}
+
+	// 默认的边缘分布下边框全部是平坦区域
+	modifier := &ImageModifier{PixelMask: &PixelMask{AvoidUniform: true}}
+	if _, err := modifier.ModifyImageSHA1ByPixel(pngFile); !errors.Is(err, ErrNoEligiblePixel) {
+		t.Errorf("应返回 ErrNoEligiblePixel，实际为 %v", err)
+	}
+
+	modifier = &ImageModifier{PixelMask: &PixelMask{Exclude: []image.Rectangle{image.Rect(0, 0, 1000, 1000)}}}
+	if _, err := modifier.ModifyImageSHA1ByPixel(jpegFile); !errors.Is(err, ErrNoEligiblePixel) {
+		t.Errorf("JPEG应返回 ErrNoEligiblePixel，实际为 %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package imagemodify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math/rand"
+	"sort"
+)
+
+// PixelDistribution 像素微调时被修改像素的空间分布
+type PixelDistribution int
+
+const (
+	// PixelDistributionEdge 默认：图像四周的边缘像素
+	PixelDistributionEdge PixelDistribution = iota
+
+	// PixelDistributionCorner 四个角附近的小块区域
+	PixelDistributionCorner
+
+	// PixelDistributionUniform 整幅图像内均匀随机
+	PixelDistributionUniform
+
+	// PixelDistributionTexture 优先选择纹理丰富（局部梯度大）的区域，修改最不易察觉
+	PixelDistributionTexture
+)
+
+const (
+	defaultPixelMaxDelta = 2  // 默认每个像素的最大调整幅度（8位精度）
+	minCornerSize        = 4  // 角落区域的最小边长
+	maxCornerSize        = 32 // 角落区域的最大边长
+	minSampledCandidates = 256
+)
+
+// pixelRand 像素选择使用的随机数源
+func (m *ImageModifier) pixelRand() *rand.Rand {
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(m.generateRandomBytes(8)))))
+}
+
+// pixelBudget 返回要修改的像素数量和每个像素的最大调整幅度（8位精度）
+func (m *ImageModifier) pixelBudget() (count, maxDelta int) {
+	count, maxDelta = m.PixelCount, m.PixelMaxDelta
+	if count < 1 {
+		count = 1
+	}
+	if maxDelta < 1 {
+		maxDelta = defaultPixelMaxDelta
+	}
+	return count, maxDelta
+}
+
+// pixelStep 随机的调整量：幅度为 1~maxDelta，方向随机
+func pixelStep(rng *rand.Rand, maxDelta int) int {
+	step := rng.Intn(maxDelta) + 1
+	if rng.Intn(2) == 1 {
+		step = -step
+	}
+	return step
+}
+
+// pixelCandidates 按 PixelDistribution 生成随机顺序的候选像素，调用方依次尝试，直到修改了足够数量的像素
+// 候选像素都满足 PixelMask 的限制，没有满足限制的像素时返回 ErrNoEligiblePixel。
+// 边缘、均匀和纹理分布只抽样有限数量的候选，大图也不会生成全部坐标；
+// probe.texture 为nil时纹理分布退化为均匀分布
+func (m *ImageModifier) pixelCandidates(rng *rand.Rand, width, height, count int, probe pixelProbe) ([]PixelCoord, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("图片尺寸无效: %dx%d", width, height)
+	}
+	eligible := m.PixelMask.eligible(probe)
+
+	var candidates []PixelCoord
+	switch m.PixelDistribution {
+	case PixelDistributionCorner:
+		shorter := width
+		if height < shorter {
+			shorter = height
+		}
+		size := clampInt(shorter/16, minCornerSize, maxCornerSize)
+		if size > shorter {
+			size = shorter
+		}
+		seen := make(map[PixelCoord]bool)
+		for dy := 0; dy < size; dy++ {
+			for dx := 0; dx < size; dx++ {
+				for _, p := range []PixelCoord{{dx, dy}, {width - 1 - dx, dy}, {dx, height - 1 - dy}, {width - 1 - dx, height - 1 - dy}} {
+					if !seen[p] && eligible(p) {
+						candidates = append(candidates, p)
+					}
+					seen[p] = true
+				}
+			}
+		}
+	case PixelDistributionUniform, PixelDistributionTexture:
+		samples := 4 * count
+		if m.PixelDistribution == PixelDistributionTexture {
+			samples = 16 * count
+		}
+		if samples < minSampledCandidates {
+			samples = minSampledCandidates
+		}
+		candidates = samplePixels(rng, m.PixelMask.regions(width, height), samples, eligible)
+	default:
+		samples := 4 * count
+		if samples < minSampledCandidates {
+			samples = minSampledCandidates
+		}
+		candidates = sampleIndexed(rng, edgePixelCount(width, height), samples, func(i int) PixelCoord {
+			return edgePixelAt(width, height, i)
+		}, eligible, false)
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoEligiblePixel
+	}
+
+	rng.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	if m.PixelDistribution == PixelDistributionTexture && probe.texture != nil {
+		scores := make([]float64, len(candidates))
+		for i, p := range candidates {
+			scores[i] = probe.texture(p.X, p.Y)
+		}
+		order := make([]int, len(candidates))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+		sorted := make([]PixelCoord, len(candidates))
+		for i, index := range order {
+			sorted[i] = candidates[index]
+		}
+		candidates = sorted
+	}
+	return candidates, nil
+}
+
+// samplePixels 在若干矩形内随机抽取最多 n 个不重复且满足 eligible 的像素，按面积加权
+func samplePixels(rng *rand.Rand, regions []image.Rectangle, n int, eligible func(p PixelCoord) bool) []PixelCoord {
+	total := 0
+	for _, r := range regions {
+		total += r.Dx() * r.Dy()
+	}
+	return sampleIndexed(rng, total, n, func(i int) PixelCoord {
+		for _, r := range regions {
+			if area := r.Dx() * r.Dy(); i >= area {
+				i -= area
+				continue
+			}
+			return PixelCoord{X: r.Min.X + i%r.Dx(), Y: r.Min.Y + i/r.Dx()}
+		}
+		return PixelCoord{}
+	}, eligible, len(regions) > 1)
+}
+
+// sampleIndexed 从编号为 0~total-1 的像素（编号由 at 转换为坐标）中随机抽取最多 n 个不重复且满足 eligible 的像素，
+// 内存只与 n 有关。先随机抽样；抽不到任何像素时（允许的像素很稀疏）逐个扫描，用蓄水池抽样保留 n 个。
+// overlapping 表示不同编号可能对应同一坐标，扫描时需要去重
+func sampleIndexed(rng *rand.Rand, total, n int, at func(i int) PixelCoord, eligible func(p PixelCoord) bool, overlapping bool) []PixelCoord {
+	if total <= 0 {
+		return nil
+	}
+
+	var pixels []PixelCoord
+	if total > 4*n {
+		seen := make(map[PixelCoord]bool, n)
+		for attempt := 0; attempt < 32*n && len(pixels) < n; attempt++ {
+			p := at(rng.Intn(total))
+			if !seen[p] {
+				seen[p] = true
+				if eligible(p) {
+					pixels = append(pixels, p)
+				}
+			}
+		}
+		if len(pixels) > 0 {
+			return pixels
+		}
+	}
+
+	// 逐个扫描全部像素
+	var seen map[PixelCoord]bool
+	if overlapping {
+		seen = make(map[PixelCoord]bool)
+	}
+	found := 0
+	for i := 0; i < total; i++ {
+		p := at(i)
+		if seen[p] || !eligible(p) {
+			continue
+		}
+		if seen != nil {
+			seen[p] = true
+		}
+		found++
+		if len(pixels) < n {
+			pixels = append(pixels, p)
+		} else if j := rng.Intn(found); j < n {
+			pixels[j] = p
+		}
+	}
+	return pixels
+}
+
+// edgePixelCount 图像四周边缘像素的数量（每个像素只计一次）
+func edgePixelCount(width, height int) int {
+	rows, sides := 2, 2
+	if height == 1 {
+		rows = 1
+	}
+	if width == 1 {
+		sides = 1
+	}
+	count := rows * width
+	if height > 2 {
+		count += sides * (height - 2)
+	}
+	return count
+}
+
+// edgePixelAt 第 i 个边缘像素的坐标：先是上下两行，再是左右两列（不含角落）
+func edgePixelAt(width, height, i int) PixelCoord {
+	rows, sides := 2, 2
+	if height == 1 {
+		rows = 1
+	}
+	if width == 1 {
+		sides = 1
+	}
+	if i < rows*width {
+		return PixelCoord{X: i % width, Y: i / width * (height - 1)}
+	}
+	i -= rows * width
+	x := 0
+	if i%sides == 1 {
+		x = width - 1
+	}
+	return PixelCoord{X: x, Y: 1 + i/sides}
+}
+
+// clampInt 将数值限制在 [low, high] 范围内
+func clampInt(v, low, high int) int {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
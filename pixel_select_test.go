@@ -0,0 +1,166 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPixelCandidates(t *testing.T) {
+	// 左半部分平坦，右半部分为杂乱的纹理
+	img := image.NewGray(image.Rect(0, 0, 64, 40))
+	for y := 0; y < 40; y++ {
+		for x := 32; x < 64; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x*7 + y*13) % 5 * 60)})
+		}
+	}
+
+	for _, tt := range []struct {
+		distribution PixelDistribution
+		inside       func(p PixelCoord) bool
+	}{
+		{PixelDistributionEdge, func(p PixelCoord) bool { return p.X == 0 || p.Y == 0 || p.X == 63 || p.Y == 39 }},
+		{PixelDistributionCorner, func(p PixelCoord) bool { return (p.X < 4 || p.X > 59) && (p.Y < 4 || p.Y > 35) }},
+		{PixelDistributionUniform, func(p PixelCoord) bool { return p.X >= 0 && p.Y >= 0 && p.X < 64 && p.Y < 40 }},
+	} {
+		modifier := &ImageModifier{PixelDistribution: tt.distribution}
+		candidates, err := modifier.pixelCandidates(modifier.pixelRand(), 64, 40, 10, pixelProbe{})
+		if err != nil {
+			t.Fatalf("分布 %d: 生成候选像素失败: %v", tt.distribution, err)
+		}
+		if len(candidates) < 10 {
+			t.Fatalf("分布 %d: 候选像素太少: %d", tt.distribution, len(candidates))
+		}
+		seen := make(map[PixelCoord]bool)
+		for _, p := range candidates {
+			if !tt.inside(p) {
+				t.Fatalf("分布 %d: 候选像素 %v 不在预期区域", tt.distribution, p)
+			}
+			if seen[p] {
+				t.Fatalf("分布 %d: 候选像素 %v 重复", tt.distribution, p)
+			}
+			seen[p] = true
+		}
+	}
+
+	modifier := &ImageModifier{PixelDistribution: PixelDistributionTexture}
+	candidates, _ := modifier.pixelCandidates(modifier.pixelRand(), 64, 40, 10, pixelProbe{texture: modifier.imageTexture(img)})
+	for _, p := range candidates[:10] {
+		if p.X < 31 {
+			t.Errorf("纹理分布选中了平坦区域的像素 %v", p)
+		}
+	}
+}
+
+func TestModifyPNGPixelBudget(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(100 + i%50)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+	data := buf.Bytes()
+
+	for _, distribution := range []PixelDistribution{PixelDistributionEdge, PixelDistributionCorner, PixelDistributionUniform, PixelDistributionTexture} {
+		modifier := &ImageModifier{PixelCount: 12, PixelMaxDelta: 3, PixelDistribution: distribution}
+		modified, err := modifier.modifyPNGPixel(data, &PixelModifyResult{})
+		if err != nil {
+			t.Fatalf("分布 %d: 像素微调失败: %v", distribution, err)
+		}
+		if changed := countChangedPixels(t, data, modified); changed != 12 {
+			t.Errorf("分布 %d: 应改变12个像素，实际为 %d", distribution, changed)
+		}
+
+		after, _ := png.Decode(bytes.NewReader(modified))
+		for i, v := range after.(*image.NRGBA).Pix {
+			if d := abs(int(v) - int(img.Pix[i])); d > 3 {
+				t.Fatalf("分布 %d: 调整幅度 %d 超过上限", distribution, d)
+			}
+		}
+	}
+}
+
+func TestModifyJPEGPixelBudget(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.jpg")
+	if err := createTestJPEG(testFile); err != nil {
+		t.Fatalf("创建测试图片失败: %v", err)
+	}
+	data, _ := os.ReadFile(testFile)
+
+	modifier := &ImageModifier{PixelCount: 8, PixelDistribution: PixelDistributionCorner}
+	modified, err := modifier.modifyJPEGPixel(data, &PixelModifyResult{})
+	if err != nil {
+		t.Fatalf("像素微调失败: %v", err)
+	}
+
+	before, _ := modifier.decodeJPEG(data)
+	after, err := modifier.decodeJPEG(modified)
+	if err != nil {
+		t.Fatalf("解码修改后的JPEG失败: %v", err)
+	}
+	c := before.components[0]
+	changed := 0
+	for i := range c.blocks {
+		if c.blocks[i] == after.components[0].blocks[i] {
+			continue
+		}
+		changed++
+		// 100x100 的图像角落区域为 6x6，只可能落在第0、11、12行列的块中
+		bx, by := i%c.blocksPerLine, i/c.blocksPerLine
+		if (bx != 0 && bx != 11 && bx != 12) || (by != 0 && by != 11 && by != 12) {
+			t.Errorf("角落分布修改了块 (%d,%d)", bx, by)
+		}
+	}
+	if changed == 0 {
+		t.Error("没有块发生变化")
+	}
+	for k := 1; k < len(before.components); k++ {
+		for i := range before.components[k].blocks {
+			if before.components[k].blocks[i] != after.components[k].blocks[i] {
+				t.Fatal("色度分量发生变化")
+			}
+		}
+	}
+}
+
+func TestEdgePixelAt(t *testing.T) {
+	for _, size := range [][2]int{{1, 1}, {5, 1}, {1, 4}, {2, 2}, {7, 5}} {
+		width, height := size[0], size[1]
+		seen := make(map[PixelCoord]bool)
+		for i := 0; i < edgePixelCount(width, height); i++ {
+			p := edgePixelAt(width, height, i)
+			if p.X != 0 && p.Y != 0 && p.X != width-1 && p.Y != height-1 {
+				t.Fatalf("%dx%d: 第 %d 个像素 %v 不在边缘", width, height, i, p)
+			}
+			if seen[p] {
+				t.Fatalf("%dx%d: 像素 %v 重复", width, height, p)
+			}
+			seen[p] = true
+		}
+		// 内部像素数量 + 边缘像素数量 = 全部像素
+		inner := 0
+		if width > 2 && height > 2 {
+			inner = (width - 2) * (height - 2)
+		}
+		if len(seen)+inner != width*height {
+			t.Errorf("%dx%d: 边缘像素数量为 %d", width, height, len(seen))
+		}
+	}
+}
+
+func BenchmarkPixelCandidatesEdge(b *testing.B) {
+	modifier := &ImageModifier{PixelCount: 16}
+	rng := modifier.pixelRand()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := modifier.pixelCandidates(rng, 8000, 6000, 16, pixelProbe{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
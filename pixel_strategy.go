@@ -0,0 +1,146 @@
+package imagemodify
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// PixelStrategy 像素微调时选择和修改像素的方式
+type PixelStrategy int
+
+const (
+	// PixelStrategyEdge 默认方式：把一个边缘像素的亮度微调±1~2
+	// GIF不受 PixelStrategy 影响，总是修改透明色对应的颜色表项，见 modifyGIFPixel
+	PixelStrategyEdge PixelStrategy = iota
+
+	// PixelStrategyTransparent 只修改完全透明（alpha为0）像素的颜色通道，渲染结果没有任何变化；
+	// 找不到这样的像素时返回 ErrNoTransparentPixel
+	PixelStrategyTransparent
+
+	// PixelStrategyTransparentOrEdge 优先修改完全透明的像素，找不到时退回边缘微调
+	PixelStrategyTransparentOrEdge
+)
+
+// ErrNoTransparentPixel 图片中没有可以修改的完全透明像素
+var ErrNoTransparentPixel = errors.New("图片中没有完全透明的像素")
+
+// prefersTransparent 当前策略是否优先修改透明像素
+func (m *ImageModifier) prefersTransparent() bool {
+	return m.PixelStrategy == PixelStrategyTransparent || m.PixelStrategy == PixelStrategyTransparentOrEdge
+}
+
+// adjustPNGTransparentPixel 随机选择一个完全透明的像素，只修改它的颜色通道
+// 带alpha通道的图像改写颜色样本；调色板图像在tRNS中有两个以上完全透明的项时改用另一个透明索引，
+// 只有一个时改写该项在PLTE中的RGB，返回新的PLTE数据（其余情况返回nil）。
+// 透明色键（灰度或真彩色图像的tRNS）无法在不改变颜色的情况下修改，视为没有透明像素；
+// 只在 PixelMask 允许的范围内选择
+func (m *ImageModifier) adjustPNGTransparentPixel(header *pngHeader, chunks []pngChunk, img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	randomBytes := m.generateRandomBytes(8)
+	pick := binary.BigEndian.Uint32(randomBytes[0:4])
+
+	if paletted, ok := img.(*image.Paletted); ok {
+		var transparent []uint8
+		isTransparent := make([]int, len(paletted.Palette)) // 透明项在 transparent 中的位置+1
+		for i, c := range paletted.Palette {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				transparent = append(transparent, uint8(i))
+				isTransparent[i] = len(transparent)
+			}
+		}
+		if len(transparent) == 1 {
+			return m.adjustTransparentPaletteEntry(chunks, paletted, transparent[0], randomBytes[4:6])
+		}
+		if len(transparent) == 0 {
+			return nil, ErrNoTransparentPixel
+		}
+		var pixels []int
+		for i, index := range paletted.Pix {
+			if int(index) < len(isTransparent) && isTransparent[index] > 0 && m.PixelMask.allows(i%paletted.Stride, i/paletted.Stride) {
+				pixels = append(pixels, i)
+			}
+		}
+		if len(pixels) == 0 {
+			return nil, ErrNoTransparentPixel
+		}
+		i := pixels[pick%uint32(len(pixels))]
+		j := isTransparent[paletted.Pix[i]] - 1
+		paletted.Pix[i] = transparent[(j+1+int(randomBytes[4])%(len(transparent)-1))%len(transparent)]
+		return nil, nil
+	}
+
+	if header.colorType != pngColorGrayAlpha && header.colorType != pngColorRGBA {
+		return nil, ErrNoTransparentPixel
+	}
+
+	var pixels []image.Point
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			samples := header.samples(img, x, y)
+			if samples[len(samples)-1] == 0 && m.PixelMask.allows(x-bounds.Min.X, y-bounds.Min.Y) {
+				pixels = append(pixels, image.Point{X: x, Y: y})
+			}
+		}
+	}
+	if len(pixels) == 0 {
+		return nil, ErrNoTransparentPixel
+	}
+
+	// 随机选择一个颜色通道，改成另一个随机值
+	pixel := pixels[pick%uint32(len(pixels))]
+	samples := header.samples(img, pixel.X, pixel.Y)
+	channel := int(randomBytes[4]) % (len(samples) - 1)
+	maxValue := header.maxSample()
+	offset := 1 + int(binary.BigEndian.Uint16(randomBytes[6:8]))%maxValue
+	samples[channel] = (samples[channel] + offset) % (maxValue + 1)
+	header.setSamples(img, pixel.X, pixel.Y, samples)
+	return nil, nil
+}
+
+// adjustTransparentPaletteEntry 改写唯一完全透明的调色板项在PLTE中的一个颜色分量，返回新的PLTE数据
+// 使用该索引的像素渲染结果不变。bKGD指向该项，或者没有像素使用它，或者有像素位于 PixelMask 之外时，
+// 视为没有可以修改的透明像素
+func (m *ImageModifier) adjustTransparentPaletteEntry(chunks []pngChunk, paletted *image.Paletted, index uint8, randomBytes []byte) ([]byte, error) {
+	var plte []byte
+	for _, chunk := range chunks {
+		switch chunk.chunkType {
+		case "PLTE":
+			plte = chunk.data
+		case "bKGD":
+			if len(chunk.data) > 0 && chunk.data[0] == index {
+				return nil, ErrNoTransparentPixel
+			}
+		}
+	}
+	if 3*int(index)+3 > len(plte) {
+		return nil, ErrNoTransparentPixel
+	}
+
+	used := false
+	for i, pixel := range paletted.Pix {
+		if pixel != index {
+			continue
+		}
+		if !m.PixelMask.allows(i%paletted.Stride, i/paletted.Stride) {
+			return nil, ErrNoTransparentPixel
+		}
+		used = true
+	}
+	if !used {
+		return nil, ErrNoTransparentPixel
+	}
+
+	plte = append([]byte(nil), plte...)
+	plte[3*int(index)+int(randomBytes[0])%3] += 1 + randomBytes[1]%255
+	return plte, nil
+}
+
+// checkTransparentSupport 严格的透明像素策略下，不支持该方式的格式直接返回错误
+func (m *ImageModifier) checkTransparentSupport(format string) error {
+	if m.PixelStrategy == PixelStrategyTransparent {
+		return fmt.Errorf("%w: %s 不支持透明像素策略", ErrNoTransparentPixel, format)
+	}
+	return nil
+}
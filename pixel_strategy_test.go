@@ -0,0 +1,215 @@
+package imagemodify
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestTransparentPNG 生成带alpha通道的PNG，transparent 个像素完全透明
+func createTestTransparentPNG(t *testing.T, img interface {
+	image.Image
+	Set(x, y int, c color.Color)
+}, transparent int) []byte {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			alpha := uint8(255)
+			if y*bounds.Dx()+x < transparent {
+				alpha = 0
+			}
+			img.Set(x, y, color.NRGBA{uint8(x * 20), uint8(y * 20), uint8(x + y), alpha})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestModifyPNGTransparentPixel(t *testing.T) {
+	modifier := &ImageModifier{PixelStrategy: PixelStrategyTransparent}
+	for name, data := range map[string][]byte{
+		"rgba":     createTestTransparentPNG(t, image.NewNRGBA(image.Rect(0, 0, 10, 8)), 5),
+		"rgba64":   createTestTransparentPNG(t, image.NewNRGBA64(image.Rect(0, 0, 10, 8)), 1),
+		"paletted": createTestPalettedPNG(t, 3),
+	} {
+		if name == "paletted" {
+			// 让索引0和2都完全透明
+			data = append([]byte(nil), data...)
+			chunks, _ := modifier.parsePNGChunks(data)
+			var rebuilt []byte
+			rebuilt = append(rebuilt, pngSignature...)
+			for _, chunk := range chunks {
+				if chunk.chunkType == "tRNS" {
+					rebuilt = append(rebuilt, buildPNGChunk("tRNS", []byte{0, 255, 0})...)
+					continue
+				}
+				rebuilt = append(rebuilt, data[chunk.start:chunk.end]...)
+			}
+			data = rebuilt
+		}
+
+		for i := 0; i < 10; i++ {
+			modified, err := modifier.modifyPNGPixel(data, &PixelModifyResult{})
+			if err != nil {
+				t.Fatalf("%s: 像素微调失败: %v", name, err)
+			}
+			if bytes.Equal(pngChunkData(t, data, "IDAT"), pngChunkData(t, modified, "IDAT")) {
+				t.Fatalf("%s: 图像数据没有变化", name)
+			}
+			// 渲染结果完全相同
+			if changed := countChangedPixels(t, data, modified); changed != 0 {
+				t.Fatalf("%s: %d 个可见像素发生变化", name, changed)
+			}
+		}
+	}
+}
+
+// withPNGTRNS 把PNG的tRNS块替换为指定数据
+func withPNGTRNS(t *testing.T, data, trns []byte) []byte {
+	chunks, err := NewImageModifier().parsePNGChunks(data)
+	if err != nil {
+		t.Fatalf("解析PNG失败: %v", err)
+	}
+	rebuilt := append([]byte(nil), pngSignature...)
+	for _, chunk := range chunks {
+		if chunk.chunkType == "tRNS" {
+			rebuilt = append(rebuilt, buildPNGChunk("tRNS", trns)...)
+			continue
+		}
+		rebuilt = append(rebuilt, data[chunk.start:chunk.end]...)
+	}
+	return rebuilt
+}
+
+func TestModifyPNGTransparentPaletteEntry(t *testing.T) {
+	// 只有索引2完全透明：改写它在PLTE中的RGB
+	data := withPNGTRNS(t, createTestPalettedPNG(t, 3), []byte{255, 255, 0})
+	modifier := &ImageModifier{PixelStrategy: PixelStrategyTransparent}
+	for i := 0; i < 10; i++ {
+		modified, err := modifier.modifyPNGPixel(data, &PixelModifyResult{})
+		if err != nil {
+			t.Fatalf("像素微调失败: %v", err)
+		}
+		plte, newPLTE := pngChunkData(t, data, "PLTE"), pngChunkData(t, modified, "PLTE")
+		if bytes.Equal(plte, newPLTE) || !bytes.Equal(plte[:6], newPLTE[:6]) {
+			t.Fatalf("应只改写透明项的颜色: %v -> %v", plte, newPLTE)
+		}
+		if changed := countChangedPixels(t, data, modified); changed != 0 {
+			t.Fatalf("%d 个可见像素发生变化", changed)
+		}
+	}
+
+	// 透明项被 PixelMask 排除的像素使用，或者是bKGD指向的背景色时不能修改
+	masked := &ImageModifier{PixelStrategy: PixelStrategyTransparent, PixelMask: &PixelMask{Exclude: []image.Rectangle{image.Rect(2, 0, 3, 1)}}}
+	if _, err := masked.modifyPNGPixel(data, &PixelModifyResult{}); !errors.Is(err, ErrNoTransparentPixel) {
+		t.Errorf("透明项被排除的像素使用时应返回 ErrNoTransparentPixel，实际为 %v", err)
+	}
+	background := withPNGTRNS(t, createTestPalettedPNG(t, 3), []byte{255, 0, 255})
+	if _, err := modifier.modifyPNGPixel(background, &PixelModifyResult{}); !errors.Is(err, ErrNoTransparentPixel) {
+		t.Errorf("透明项为背景色时应返回 ErrNoTransparentPixel，实际为 %v", err)
+	}
+}
+
+func TestPixelStrategyWithoutTransparentPixel(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 6, 6)))
+	opaque := createTestTransparentPNG(t, image.NewNRGBA(image.Rect(0, 0, 6, 6)), 0)
+
+	strict := &ImageModifier{PixelStrategy: PixelStrategyTransparent}
+	if _, err := strict.modifyPNGPixel(opaque, &PixelModifyResult{}); !errors.Is(err, ErrNoTransparentPixel) {
+		t.Errorf("没有透明像素时应返回 ErrNoTransparentPixel，实际为 %v", err)
+	}
+
+	fallback := &ImageModifier{PixelStrategy: PixelStrategyTransparentOrEdge}
+	modified, err := fallback.modifyPNGPixel(opaque, &PixelModifyResult{})
+	if err != nil {
+		t.Fatalf("退回边缘微调失败: %v", err)
+	}
+	if changed := countChangedPixels(t, opaque, modified); changed != 1 {
+		t.Errorf("应改变1个边缘像素，实际为 %d", changed)
+	}
+
+	// 完全透明的图片不需要退回
+	modified, err = fallback.modifyPNGPixel(buf.Bytes(), &PixelModifyResult{})
+	if err != nil {
+		t.Fatalf("像素微调失败: %v", err)
+	}
+	if changed := countChangedPixels(t, buf.Bytes(), modified); changed != 0 {
+		t.Errorf("%d 个可见像素发生变化", changed)
+	}
+}
+
+func TestModifyGIFPixel(t *testing.T) {
+	data := createTestGIF(t)
+	modifier := NewImageModifier()
+	for i := 0; i < 10; i++ {
+		modified, err := modifier.modifyGIFPixel(data)
+		if err != nil {
+			t.Fatalf("GIF像素微调失败: %v", err)
+		}
+		if bytes.Equal(modified, data) {
+			t.Fatal("GIF没有变化")
+		}
+		// 只有全局颜色表中的透明色（索引3）改变
+		file, _ := NewImageModifier().parseGIF(data)
+		diff := -1
+		for j := range data {
+			if data[j] != modified[j] {
+				diff = j
+			}
+		}
+		if diff < file.globalTable+9 || diff >= file.globalTable+12 {
+			t.Fatalf("修改位置 %d 不是透明色对应的颜色表项", diff)
+		}
+	}
+
+	// 没有透明色的GIF
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, frame, nil); err != nil {
+		t.Fatalf("编码GIF失败: %v", err)
+	}
+	if _, err := modifier.modifyGIFPixel(buf.Bytes()); !errors.Is(err, ErrNoTransparentPixel) {
+		t.Errorf("没有透明色时应返回 ErrNoTransparentPixel，实际为 %v", err)
+	}
+}
+
+func TestModifyImageSHA1ByPixelStrategy(t *testing.T) {
+	dir := t.TempDir()
+	modifier := &ImageModifier{PixelStrategy: PixelStrategyTransparent}
+	for name, data := range map[string][]byte{
+		"test.gif": createTestGIF(t),
+		"test.png": createTestTransparentPNG(t, image.NewNRGBA(image.Rect(0, 0, 9, 9)), 3),
+	} {
+		testFile := filepath.Join(dir, name)
+		if err := os.WriteFile(testFile, data, 0644); err != nil {
+			t.Fatalf("写入测试文件失败: %v", err)
+		}
+		originalSHA1, _ := modifier.GetImageSHA1(testFile)
+		newSHA1, err := modifier.ModifyImageSHA1ByPixel(testFile)
+		if err != nil {
+			t.Fatalf("%s: 像素微调失败: %v", name, err)
+		}
+		if newSHA1 == originalSHA1 {
+			t.Errorf("%s: SHA1没有变化", name)
+		}
+	}
+
+	// JPEG没有透明像素
+	testFile := filepath.Join(dir, "test.jpg")
+	if err := createTestJPEG(testFile); err != nil {
+		t.Fatalf("创建测试JPEG失败: %v", err)
+	}
+	if _, err := modifier.ModifyImageSHA1ByPixel(testFile); !errors.Is(err, ErrNoTransparentPixel) {
+		t.Errorf("JPEG应返回 ErrNoTransparentPixel，实际为 %v", err)
+	}
+}
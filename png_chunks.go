@@ -0,0 +1,281 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// pngChunk PNG文件中的一个块
+type pngChunk struct {
+	chunkType string // 块类型
+	data      []byte // 块数据
+	start     int    // 块在文件中的起始位置（长度字段处）
+	end       int    // 块在文件中的结束位置（CRC之后）
+}
+
+// pngHeader IHDR块中的图像参数
+type pngHeader struct {
+	width     int
+	height    int
+	bitDepth  int
+	colorType int
+	interlace int
+}
+
+// PNG颜色类型
+const (
+	pngColorGray      = 0
+	pngColorRGB       = 2
+	pngColorPalette   = 3
+	pngColorGrayAlpha = 4
+	pngColorRGBA      = 6
+)
+
+// ErrCorruptPNG PNG文件的块结构损坏（块不完整、长度或类型无效、CRC校验失败），可用 errors.Is 判断；
+// 出错的块可用 errors.As 取得 *PNGChunkError
+var ErrCorruptPNG = errors.New("PNG文件已损坏")
+
+// PNGChunkError 损坏的PNG块
+type PNGChunkError struct {
+	Offset int64  // 块在文件中的位置（长度字段处）
+	Type   string // 块类型，未能读取时为空
+	Reason string // 损坏的原因
+}
+
+func (e *PNGChunkError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("%v: 位置 %d 的块%s", ErrCorruptPNG, e.Offset, e.Reason)
+	}
+	return fmt.Sprintf("%v: 位置 %d 的块 %s %s", ErrCorruptPNG, e.Offset, e.Type, e.Reason)
+}
+
+// Is 使 errors.Is(err, ErrCorruptPNG) 成立
+func (e *PNGChunkError) Is(target error) bool {
+	return target == ErrCorruptPNG
+}
+
+// pngMaxChunkLength PNG规范规定的块数据长度上限（2^31-1）
+const pngMaxChunkLength = 1<<31 - 1
+
+// checkPNGChunkHeader 检查第 count 个块（从1开始计数）的长度和类型，offset 为块在文件中的位置
+// 内存中的块读取器和流式处理共用
+func (m *ImageModifier) checkPNGChunkHeader(offset int64, length uint32, chunkType string, count int64) error {
+	if length > pngMaxChunkLength {
+		return &PNGChunkError{Offset: offset, Type: chunkType, Reason: "长度超过2^31-1"}
+	}
+	for i := 0; i < len(chunkType); i++ {
+		if c := chunkType[i] | 0x20; c < 'a' || c > 'z' {
+			return &PNGChunkError{Offset: offset, Reason: "类型无效"}
+		}
+	}
+	return m.checkChunk(int64(length), count)
+}
+
+// pngChunkReader 逐个读取内存中PNG文件的块，校验长度、类型、资源限制和CRC，
+// 所有需要遍历PNG块的函数共用。读到IEND或文件恰好在块边界结束时停止
+type pngChunkReader struct {
+	m     *ImageModifier
+	data  []byte
+	pos   int
+	count int64 // 已读取的块数量
+	done  bool  // 已读到IEND
+}
+
+// newPNGChunkReader 检查PNG签名并创建块读取器
+func (m *ImageModifier) newPNGChunkReader(data []byte) (*pngChunkReader, error) {
+	if len(data) < 8 || !bytes.Equal(data[:8], pngSignature) {
+		return nil, fmt.Errorf("不是有效的PNG文件")
+	}
+	return &pngChunkReader{m: m, data: data, pos: 8}, nil
+}
+
+// next 读取下一个块，读完IEND或文件结束后返回 io.EOF
+func (r *pngChunkReader) next() (pngChunk, error) {
+	if r.done || r.pos == len(r.data) {
+		return pngChunk{}, io.EOF
+	}
+	start := r.pos
+	if len(r.data)-start < 12 {
+		return pngChunk{}, &PNGChunkError{Offset: int64(start), Reason: "不完整"}
+	}
+	length := binary.BigEndian.Uint32(r.data[start : start+4])
+	chunkType := string(r.data[start+4 : start+8])
+	r.count++
+	if err := r.m.checkPNGChunkHeader(int64(start), length, chunkType, r.count); err != nil {
+		return pngChunk{}, err
+	}
+	// 先以uint64比较，长度不超过剩余数据时才转换为int
+	if uint64(length) > uint64(len(r.data)-start-12) {
+		return pngChunk{}, &PNGChunkError{Offset: int64(start), Type: chunkType, Reason: "长度超出文件范围"}
+	}
+
+	dataEnd := start + 8 + int(length)
+	if crc32.ChecksumIEEE(r.data[start+4:dataEnd]) != binary.BigEndian.Uint32(r.data[dataEnd:dataEnd+4]) {
+		return pngChunk{}, &PNGChunkError{Offset: int64(start), Type: chunkType, Reason: "CRC校验失败"}
+	}
+	chunk := pngChunk{
+		chunkType: chunkType,
+		data:      r.data[start+8 : dataEnd],
+		start:     start,
+		end:       dataEnd + 4,
+	}
+	r.pos = chunk.end
+	r.done = chunkType == "IEND"
+	return chunk, nil
+}
+
+// trailing IEND之后的数据
+func (r *pngChunkReader) trailing() []byte {
+	return r.data[r.pos:]
+}
+
+// parsePNGChunks 解析PNG文件中的全部块（遇到IEND后停止，之后的数据不属于任何块）
+func (m *ImageModifier) parsePNGChunks(data []byte) ([]pngChunk, error) {
+	r, err := m.newPNGChunkReader(data)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []pngChunk
+	for {
+		chunk, err := r.next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+// parsePNGHeader 从块列表中解析IHDR
+func (m *ImageModifier) parsePNGHeader(chunks []pngChunk) (*pngHeader, error) {
+	if len(chunks) == 0 || chunks[0].chunkType != "IHDR" || len(chunks[0].data) != 13 {
+		return nil, fmt.Errorf("PNG文件缺少IHDR块")
+	}
+
+	data := chunks[0].data
+	header := &pngHeader{
+		width:     int(binary.BigEndian.Uint32(data[0:4])),
+		height:    int(binary.BigEndian.Uint32(data[4:8])),
+		bitDepth:  int(data[8]),
+		colorType: int(data[9]),
+		interlace: int(data[12]),
+	}
+
+	if header.width <= 0 || header.height <= 0 || header.interlace > 1 {
+		return nil, fmt.Errorf("PNG文件头参数无效")
+	}
+	if header.channels() == 0 {
+		return nil, fmt.Errorf("不支持的PNG颜色类型: %d", header.colorType)
+	}
+	if !header.validBitDepth() {
+		return nil, fmt.Errorf("PNG颜色类型 %d 不支持 %d 位深度", header.colorType, header.bitDepth)
+	}
+
+	return header, nil
+}
+
+// validBitDepth 位深度是否为该颜色类型允许的取值
+func (h *pngHeader) validBitDepth() bool {
+	switch h.colorType {
+	case pngColorGray:
+		return h.bitDepth == 1 || h.bitDepth == 2 || h.bitDepth == 4 || h.bitDepth == 8 || h.bitDepth == 16
+	case pngColorPalette:
+		return h.bitDepth == 1 || h.bitDepth == 2 || h.bitDepth == 4 || h.bitDepth == 8
+	}
+	return h.bitDepth == 8 || h.bitDepth == 16
+}
+
+// channels 每个像素的通道数
+func (h *pngHeader) channels() int {
+	switch h.colorType {
+	case pngColorGray, pngColorPalette:
+		return 1
+	case pngColorGrayAlpha:
+		return 2
+	case pngColorRGB:
+		return 3
+	case pngColorRGBA:
+		return 4
+	}
+	return 0
+}
+
+// bitsPerPixel 每个像素占用的位数
+func (h *pngHeader) bitsPerPixel() int {
+	return h.channels() * h.bitDepth
+}
+
+// buildPNGChunk 构造完整的PNG块（长度 + 类型 + 数据 + CRC）
+func buildPNGChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(data)))
+	copy(chunk[4:8], chunkType)
+	chunk = append(chunk, data...)
+
+	crc := crc32.NewIEEE()
+	crc.Write(chunk[4:])
+	return binary.BigEndian.AppendUint32(chunk, crc.Sum32())
+}
+
+// replacePNGChunkData 用长度相同的新数据替换第一个指定类型的块并重新计算CRC，其余块的位置不变
+func replacePNGChunkData(data []byte, chunks []pngChunk, chunkType string, chunkData []byte) ([]byte, error) {
+	for _, chunk := range chunks {
+		if chunk.chunkType == chunkType {
+			if len(chunk.data) != len(chunkData) {
+				return nil, fmt.Errorf("%s块的长度不能改变", chunkType)
+			}
+			result := append([]byte(nil), data...)
+			copy(result[chunk.start:chunk.end], buildPNGChunk(chunkType, chunkData))
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("PNG文件缺少%s块", chunkType)
+}
+
+// replacePNGImageData 用新的压缩图像数据替换文件中连续的IDAT块，每块最多 chunkSize 字节
+// 其他所有块（包括APNG的acTL/fcTL/fdAT）保持逐字节不变
+func (m *ImageModifier) replacePNGImageData(data []byte, chunks []pngChunk, zdata []byte, chunkSize int) ([]byte, error) {
+	first, last := -1, -1
+	for i, chunk := range chunks {
+		if chunk.chunkType == "IDAT" {
+			if first < 0 {
+				first = i
+			} else if last != i-1 {
+				return nil, fmt.Errorf("PNG文件中的IDAT块不连续")
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return nil, fmt.Errorf("PNG文件缺少IDAT块")
+	}
+
+	result := make([]byte, 0, len(data)+len(zdata))
+	result = append(result, data[:chunks[first].start]...)
+	result = append(result, m.buildIDATChunks(zdata, chunkSize)...)
+	result = append(result, data[chunks[last].end:]...)
+	return result, nil
+}
+
+// pngIDATChunkSize 默认每个IDAT块的最大数据长度
+const pngIDATChunkSize = 1 << 15
+
+// buildIDATChunks 将压缩后的图像数据拆分为每块最多 chunkSize 字节的IDAT块
+func (m *ImageModifier) buildIDATChunks(zdata []byte, chunkSize int) []byte {
+	var buf bytes.Buffer
+	for len(zdata) > 0 {
+		n := len(zdata)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		buf.Write(buildPNGChunk("IDAT", zdata[:n]))
+		zdata = zdata[n:]
+	}
+	return buf.Bytes()
+}
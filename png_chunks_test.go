@@ -0,0 +1,217 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// readPNGChunks 读取全部块，检查相邻块首尾相接
+func readPNGChunks(t testing.TB, data []byte) ([]pngChunk, []byte, error) {
+	r, err := NewImageModifier().newPNGChunkReader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var chunks []pngChunk
+	pos := 8
+	for {
+		chunk, err := r.next()
+		if err == io.EOF {
+			return chunks, r.trailing(), nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if chunk.start != pos {
+			t.Fatalf("块 %s 的起始位置 %d 与上一个块的结束位置 %d 不相接", chunk.chunkType, chunk.start, pos)
+		}
+		pos = chunk.end
+		chunks = append(chunks, chunk)
+	}
+}
+
+// expectCorruptPNG 检查错误是否为指定位置的损坏块
+func expectCorruptPNG(t *testing.T, err error, offset int64) {
+	t.Helper()
+	if !errors.Is(err, ErrCorruptPNG) {
+		t.Fatalf("应返回 ErrCorruptPNG，实际为 %v", err)
+	}
+	var chunkErr *PNGChunkError
+	if !errors.As(err, &chunkErr) || chunkErr.Offset != offset {
+		t.Fatalf("损坏块的位置应为 %d，实际为 %v", offset, err)
+	}
+}
+
+func TestPNGChunkReader(t *testing.T) {
+	data := append(createTestAPNG(t), "trailing"...)
+	chunks, trailing, err := readPNGChunks(t, data)
+	if err != nil {
+		t.Fatalf("读取块失败: %v", err)
+	}
+	if string(trailing) != "trailing" {
+		t.Errorf("IEND之后的数据为 %q", trailing)
+	}
+	if last := chunks[len(chunks)-1]; last.chunkType != "IEND" || last.end != len(data)-len(trailing) {
+		t.Errorf("最后一个块为 %s", last.chunkType)
+	}
+
+	// 没有IEND、恰好在块边界结束的文件可以读取
+	if chunks, _, err := readPNGChunks(t, data[:chunks[len(chunks)-1].start]); err != nil || chunks[len(chunks)-1].chunkType == "IEND" {
+		t.Errorf("没有IEND的文件读取结果不正确: %v", err)
+	}
+}
+
+func TestPNGChunkReaderRejectsCorrupt(t *testing.T) {
+	data := createTestBorderedPNG(t)
+	chunks, _, err := readPNGChunks(t, data)
+	if err != nil {
+		t.Fatalf("读取块失败: %v", err)
+	}
+	idat := chunks[1]
+	if idat.chunkType != "IDAT" {
+		t.Fatalf("第二个块为 %s", idat.chunkType)
+	}
+
+	corrupt := func(edit func(b []byte) []byte) []byte {
+		return edit(append([]byte(nil), data...))
+	}
+	for name, tt := range map[string]struct {
+		data   []byte
+		offset int64
+	}{
+		"crc": {corrupt(func(b []byte) []byte {
+			b[idat.end-1] ^= 0xFF
+			return b
+		}), int64(idat.start)},
+		"huge length": {corrupt(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[idat.start:], 0xFFFFFFFF)
+			return b
+		}), int64(idat.start)},
+		"overrun": {corrupt(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[idat.start:], uint32(len(b)))
+			return b
+		}), int64(idat.start)},
+		"type": {corrupt(func(b []byte) []byte {
+			b[idat.start+4] = 0
+			return b
+		}), int64(idat.start)},
+		"truncated": {data[:idat.end-1], int64(idat.start)},
+		"short":     {data[:idat.start+5], int64(idat.start)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			modifier := NewImageModifier()
+			_, err := modifier.parsePNGChunks(tt.data)
+			expectCorruptPNG(t, err, tt.offset)
+			_, err = modifier.parsePNGMetadata(tt.data)
+			expectCorruptPNG(t, err, tt.offset)
+			_, err = modifier.modifyPNGMetadata(tt.data, &ImageMetadata{Title: "test"})
+			expectCorruptPNG(t, err, tt.offset)
+			_, err = modifier.modifyPNGSHA1(tt.data)
+			expectCorruptPNG(t, err, tt.offset)
+			err = modifier.streamPNGPixel(bytes.NewReader(tt.data), io.Discard)
+			expectCorruptPNG(t, err, tt.offset)
+		})
+	}
+}
+
+func TestParsePNGHeaderBitDepth(t *testing.T) {
+	modifier := NewImageModifier()
+	for _, tt := range []struct {
+		colorType, bitDepth byte
+		valid               bool
+	}{
+		{pngColorGray, 1, true}, {pngColorGray, 16, true}, {pngColorGray, 0, false}, {pngColorGray, 3, false},
+		{pngColorPalette, 8, true}, {pngColorPalette, 16, false},
+		{pngColorRGB, 8, true}, {pngColorRGB, 4, false},
+		{pngColorGrayAlpha, 16, true}, {pngColorRGBA, 1, false},
+	} {
+		ihdr := []byte{0, 0, 0, 8, 0, 0, 0, 8, tt.bitDepth, tt.colorType, 0, 0, 0}
+		_, err := modifier.parsePNGHeader([]pngChunk{{chunkType: "IHDR", data: ihdr}})
+		if (err == nil) != tt.valid {
+			t.Errorf("颜色类型 %d、%d 位深度: %v", tt.colorType, tt.bitDepth, err)
+		}
+	}
+}
+
+func TestPNGMetadataKeepsTrailingData(t *testing.T) {
+	modifier := NewImageModifier()
+	data := append(createTestBorderedPNG(t), "trailing"...)
+	data, err := modifier.modifyPNGSHA1(data)
+	if err != nil {
+		t.Fatalf("插入文本块失败: %v", err)
+	}
+	modified, err := modifier.modifyPNGMetadata(data, &ImageMetadata{Title: "标题"})
+	if err != nil {
+		t.Fatalf("修改元数据失败: %v", err)
+	}
+	chunks, trailing, err := readPNGChunks(t, modified)
+	if err != nil {
+		t.Fatalf("读取块失败: %v", err)
+	}
+	if string(trailing) != "trailing" {
+		t.Errorf("IEND之后的数据为 %q", trailing)
+	}
+	texts := 0
+	for _, chunk := range chunks {
+		if chunk.chunkType == "tEXt" {
+			texts++
+		}
+	}
+	if texts != 1 || chunks[len(chunks)-2].chunkType != "tEXt" {
+		t.Errorf("应只在IEND之前保留1个文本块，实际为 %d 个", texts)
+	}
+	if metadata, err := modifier.parsePNGMetadata(modified); err != nil || metadata.Title != "标题" {
+		t.Errorf("读取元数据失败: %+v（%v）", metadata, err)
+	}
+}
+
+// fuzzPNGSeeds 模糊测试的初始语料
+func fuzzPNGSeeds(f *testing.F) {
+	f.Add(append(append([]byte(nil), pngSignature...), buildPNGChunk("IEND", nil)...))
+	f.Add(append(append([]byte(nil), pngSignature...), buildPNGChunk("tEXt", []byte("Title\x00test"))...))
+	f.Add(append(createTestBorderedPNG(f), "trailing"...))
+	f.Add(createTestAPNG(f))
+}
+
+func FuzzPNGChunkReader(f *testing.F) {
+	fuzzPNGSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		chunks, trailing, err := readPNGChunks(t, data)
+		if err != nil {
+			return
+		}
+		// 签名、全部块和IEND之后的数据拼接后与原文件相同
+		end := 8
+		if len(chunks) > 0 {
+			end = chunks[len(chunks)-1].end
+		}
+		if end+len(trailing) != len(data) {
+			t.Fatal("全部块和IEND之后的数据没有覆盖整个文件")
+		}
+
+		modifier := NewImageModifier()
+		clean, err := modifier.removeExistingTextChunks(data)
+		if err != nil {
+			t.Fatalf("可读取的文件移除文本块失败: %v", err)
+		}
+		inserted, err := modifier.insertPNGTextChunk(clean, "Title", "fuzz")
+		if err != nil {
+			t.Fatalf("插入文本块失败: %v", err)
+		}
+		metadata, err := modifier.parsePNGMetadata(inserted)
+		if err != nil || metadata.Title != "fuzz" {
+			t.Fatalf("插入文本块后读取元数据失败: %+v（%v）", metadata, err)
+		}
+	})
+}
+
+func FuzzPNGMetadata(f *testing.F) {
+	fuzzPNGSeeds(f)
+	modifier := &ImageModifier{Limits: ResourceLimits{MaxPixels: 1 << 16, MaxTextSize: 1 << 16}}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		modifier.parsePNGMetadata(data)
+		modifier.streamPNGPixel(bytes.NewReader(data), io.Discard)
+	})
+}
@@ -0,0 +1,373 @@
+package imagemodify
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+)
+
+// 与 image/png 不同，这里的编码器按原始IHDR（位深度、颜色类型、隔行方式）重新生成图像数据，
+// 只替换IDAT块，PLTE/tRNS以及APNG的动画块都可以原样保留。
+// image/png 解码得到的具体图像类型与IHDR的对应关系：
+//   灰度 1/2/4/8 位 -> *image.Gray（有tRNS时为 *image.NRGBA），16 位 -> *image.Gray16（*image.NRGBA64）
+//   真彩色 8 位 -> *image.RGBA（*image.NRGBA），16 位 -> *image.RGBA64（*image.NRGBA64）
+//   调色板 -> *image.Paletted
+//   灰度+alpha、真彩色+alpha 8 位 -> *image.NRGBA，16 位 -> *image.NRGBA64
+// 样本按具体类型直接读写，不经过颜色模型转换，避免预乘alpha带来的误差
+
+// adam7Passes Adam7隔行扫描的7个子图像（起点与步长）
+var adam7Passes = []struct{ xStart, yStart, xStep, yStep int }{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// checkImageType 检查解码得到的图像类型是否与IHDR一致
+func (h *pngHeader) checkImageType(img image.Image) error {
+	ok := false
+	switch img.(type) {
+	case *image.Paletted:
+		ok = h.colorType == pngColorPalette
+	case *image.Gray:
+		ok = h.colorType == pngColorGray && h.bitDepth <= 8
+	case *image.Gray16:
+		ok = h.colorType == pngColorGray && h.bitDepth == 16
+	case *image.RGBA:
+		ok = h.colorType == pngColorRGB && h.bitDepth == 8
+	case *image.RGBA64:
+		ok = h.colorType == pngColorRGB && h.bitDepth == 16
+	case *image.NRGBA:
+		ok = h.colorType != pngColorPalette && h.bitDepth <= 8
+	case *image.NRGBA64:
+		ok = h.colorType != pngColorPalette && h.bitDepth == 16
+	}
+	if !ok {
+		return fmt.Errorf("解码得到的图像类型 %T 与PNG文件头不一致", img)
+	}
+	return nil
+}
+
+// maxSample 单个样本的最大值
+func (h *pngHeader) maxSample() int {
+	return 1<<uint(h.bitDepth) - 1
+}
+
+// grayScale 低位深度灰度值在解码结果中的放大倍数（1位为0xff，2位为0x55，4位为0x11）
+func (h *pngHeader) grayScale() int {
+	if h.bitDepth >= 8 {
+		return 1
+	}
+	return 0xff / h.maxSample()
+}
+
+// samples 读取像素的原始样本值（与IHDR中的位深度一致，调色板图像返回索引）
+func (h *pngHeader) samples(img image.Image, x, y int) []int {
+	switch img := img.(type) {
+	case *image.Paletted:
+		return []int{int(img.ColorIndexAt(x, y))}
+	case *image.Gray:
+		return []int{int(img.GrayAt(x, y).Y) / h.grayScale()}
+	case *image.Gray16:
+		return []int{int(img.Gray16At(x, y).Y)}
+	case *image.RGBA:
+		c := img.RGBAAt(x, y)
+		return []int{int(c.R), int(c.G), int(c.B)}
+	case *image.RGBA64:
+		c := img.RGBA64At(x, y)
+		return []int{int(c.R), int(c.G), int(c.B)}
+	case *image.NRGBA:
+		c := img.NRGBAAt(x, y)
+		return h.selectChannels(int(c.R)/h.grayScale(), int(c.G), int(c.B), int(c.A))
+	case *image.NRGBA64:
+		c := img.NRGBA64At(x, y)
+		return h.selectChannels(int(c.R), int(c.G), int(c.B), int(c.A))
+	}
+	return nil
+}
+
+// selectChannels 按颜色类型从RGBA中取出需要的通道
+func (h *pngHeader) selectChannels(r, g, b, a int) []int {
+	switch h.colorType {
+	case pngColorGray:
+		return []int{r}
+	case pngColorGrayAlpha:
+		return []int{r, a}
+	case pngColorRGB:
+		return []int{r, g, b}
+	default:
+		return []int{r, g, b, a}
+	}
+}
+
+// setSamples 写回像素的原始样本值，是 samples 的逆操作
+// 没有alpha通道的 NRGBA/NRGBA64（存在tRNS）保持原有alpha不变
+func (h *pngHeader) setSamples(img image.Image, x, y int, s []int) {
+	// expand 将样本展开为 R、G、B、A（A 为 -1 表示保持不变）
+	expand := func() (int, int, int, int) {
+		switch h.colorType {
+		case pngColorGray:
+			return s[0], s[0], s[0], -1
+		case pngColorGrayAlpha:
+			return s[0], s[0], s[0], s[1]
+		case pngColorRGB:
+			return s[0], s[1], s[2], -1
+		default:
+			return s[0], s[1], s[2], s[3]
+		}
+	}
+
+	switch img := img.(type) {
+	case *image.Paletted:
+		img.SetColorIndex(x, y, uint8(s[0]))
+	case *image.Gray:
+		img.Pix[img.PixOffset(x, y)] = uint8(s[0] * h.grayScale())
+	case *image.Gray16:
+		i := img.PixOffset(x, y)
+		img.Pix[i], img.Pix[i+1] = uint8(s[0]>>8), uint8(s[0])
+	case *image.RGBA:
+		i := img.PixOffset(x, y)
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = uint8(s[0]), uint8(s[1]), uint8(s[2])
+	case *image.RGBA64:
+		i := img.PixOffset(x, y)
+		for c := 0; c < 3; c++ {
+			img.Pix[i+2*c], img.Pix[i+2*c+1] = uint8(s[c]>>8), uint8(s[c])
+		}
+	case *image.NRGBA:
+		r, g, b, a := expand()
+		scale := 1
+		if h.colorType == pngColorGray || h.colorType == pngColorGrayAlpha {
+			scale = h.grayScale()
+		}
+		i := img.PixOffset(x, y)
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = uint8(r*scale), uint8(g*scale), uint8(b*scale)
+		if a >= 0 {
+			img.Pix[i+3] = uint8(a)
+		}
+	case *image.NRGBA64:
+		r, g, b, a := expand()
+		i := img.PixOffset(x, y)
+		for c, v := range []int{r, g, b, a} {
+			if v >= 0 {
+				img.Pix[i+2*c], img.Pix[i+2*c+1] = uint8(v>>8), uint8(v)
+			}
+		}
+	}
+}
+
+// encodePNGImageData 按IHDR参数将图像编码为压缩后的图像数据（即全部IDAT块的内容）
+func (m *ImageModifier) encodePNGImageData(h *pngHeader, img image.Image) ([]byte, error) {
+	if err := h.checkImageType(img); err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() != h.width || bounds.Dy() != h.height {
+		return nil, fmt.Errorf("图像尺寸与PNG文件头不一致")
+	}
+
+	var raw bytes.Buffer
+	if h.interlace == 0 {
+		m.writePNGScanlines(&raw, h, img, 0, 0, 1, 1)
+	} else {
+		for _, pass := range adam7Passes {
+			m.writePNGScanlines(&raw, h, img, pass.xStart, pass.yStart, pass.xStep, pass.yStep)
+		}
+	}
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writePNGScanlines 写入一个（子）图像的全部扫描行，每行以过滤类型字节开头
+func (m *ImageModifier) writePNGScanlines(raw *bytes.Buffer, h *pngHeader, img image.Image, xStart, yStart, xStep, yStep int) {
+	bounds := img.Bounds()
+	width := (h.width - xStart + xStep - 1) / xStep
+	height := (h.height - yStart + yStep - 1) / yStep
+	if width <= 0 || height <= 0 {
+		return // 小图像的某些Adam7子图像为空
+	}
+
+	rowSize := (width*h.bitsPerPixel() + 7) / 8
+	bpp := (h.bitsPerPixel() + 7) / 8 // 过滤时对应像素的字节距离
+	prev := make([]byte, rowSize)
+	cur := make([]byte, rowSize)
+	filtered := make([]byte, rowSize)
+	best := make([]byte, rowSize)
+
+	for py := 0; py < height; py++ {
+		y := bounds.Min.Y + yStart + py*yStep
+		if !h.packRowFast(cur, img, bounds.Min.X+xStart, y, xStep, width) {
+			h.packRow(cur, img, bounds.Min.X+xStart, y, xStep, width)
+		}
+
+		// 调色板和低位深度图像不过滤，其余按最小绝对值和选择过滤方式
+		filterType := byte(0)
+		if h.colorType != pngColorPalette && h.bitDepth >= 8 {
+			bestSum := pngRowCost(cur)
+			for ft := byte(1); ft <= 4; ft++ {
+				pngFilterRow(ft, filtered, cur, prev, bpp)
+				if sum := pngRowCost(filtered); sum < bestSum {
+					bestSum, filterType = sum, ft
+					copy(best, filtered)
+				}
+			}
+		}
+
+		if filterType == 0 {
+			copy(best, cur)
+		}
+		raw.WriteByte(filterType)
+		raw.Write(best)
+		prev, cur = cur, prev
+	}
+}
+
+// packRow 把一行中 width 个像素（从 x0 开始，间隔 xStep）的样本按位深度紧密写入 dst，适用于全部图像类型
+func (h *pngHeader) packRow(dst []byte, img image.Image, x0, y, xStep, width int) {
+	for i := range dst {
+		dst[i] = 0
+	}
+	bit := 0
+	for px := 0; px < width; px++ {
+		for _, v := range h.samples(img, x0+px*xStep, y) {
+			switch h.bitDepth {
+			case 16:
+				dst[bit/8], dst[bit/8+1] = uint8(v>>8), uint8(v)
+			case 8:
+				dst[bit/8] = uint8(v)
+			default:
+				dst[bit/8] |= uint8(v) << uint(8-h.bitDepth-bit%8)
+			}
+			bit += h.bitDepth
+		}
+	}
+}
+
+// packRowFast 8/16位深度时直接从图像的Pix复制字节，不经过逐像素的接口调用和切片分配
+// image/png 解码得到的具体类型中，样本在Pix中的字节顺序（16位为大端）与PNG扫描行相同，
+// 只需按颜色类型挑出需要的字节。没有对应的快速路径时返回false
+func (h *pngHeader) packRowFast(dst []byte, img image.Image, x0, y, xStep, width int) bool {
+	if h.bitDepth < 8 {
+		return false
+	}
+	var pix []byte
+	var offset, size int
+	switch img := img.(type) {
+	case *image.Paletted:
+		pix, offset, size = img.Pix, img.PixOffset(x0, y), 1
+	case *image.Gray:
+		pix, offset, size = img.Pix, img.PixOffset(x0, y), 1
+	case *image.Gray16:
+		pix, offset, size = img.Pix, img.PixOffset(x0, y), 2
+	case *image.RGBA:
+		pix, offset, size = img.Pix, img.PixOffset(x0, y), 4
+	case *image.RGBA64:
+		pix, offset, size = img.Pix, img.PixOffset(x0, y), 8
+	case *image.NRGBA:
+		pix, offset, size = img.Pix, img.PixOffset(x0, y), 4
+	case *image.NRGBA64:
+		pix, offset, size = img.Pix, img.PixOffset(x0, y), 8
+	default:
+		return false
+	}
+
+	// 每个像素要复制的字节：源像素中的起始位置和长度
+	sampleBytes := h.bitDepth / 8
+	var parts [][2]int
+	switch {
+	case size == 1 || size == 2 || h.colorType == pngColorRGBA:
+		parts = [][2]int{{0, size}}
+	case h.colorType == pngColorRGB:
+		parts = [][2]int{{0, 3 * sampleBytes}}
+	case h.colorType == pngColorGrayAlpha:
+		parts = [][2]int{{0, sampleBytes}, {3 * sampleBytes, sampleBytes}}
+	default:
+		return false
+	}
+
+	out := 0
+	for _, p := range parts {
+		out += p[1]
+	}
+	if out*width != len(dst) {
+		return false // 与IHDR不一致，交给通用路径
+	}
+	if xStep == 1 && len(parts) == 1 && parts[0][1] == size {
+		copy(dst, pix[offset:offset+size*width])
+		return true
+	}
+	i := 0
+	for px := 0; px < width; px++ {
+		src := pix[offset+px*xStep*size:]
+		for _, p := range parts {
+			i += copy(dst[i:], src[p[0]:p[0]+p[1]])
+		}
+	}
+	return true
+}
+
+// pngRowCost 过滤结果的代价：按有符号字节计算的绝对值之和
+func pngRowCost(row []byte) int {
+	sum := 0
+	for _, b := range row {
+		sum += abs(int(int8(b)))
+	}
+	return sum
+}
+
+// pngFilterRow 按指定过滤类型（1=Sub 2=Up 3=Average 4=Paeth）过滤一行
+func pngFilterRow(filterType byte, dst, cur, prev []byte, bpp int) {
+	for i := range cur {
+		var a, b, c int
+		if i >= bpp {
+			a = int(cur[i-bpp])
+			c = int(prev[i-bpp])
+		}
+		b = int(prev[i])
+
+		var predictor int
+		switch filterType {
+		case 1:
+			predictor = a
+		case 2:
+			predictor = b
+		case 3:
+			predictor = (a + b) / 2
+		case 4:
+			predictor = paethPredictor(a, b, c)
+		}
+		dst[i] = cur[i] - uint8(predictor)
+	}
+}
+
+// paethPredictor Paeth预测函数
+func paethPredictor(a, b, c int) int {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// abs 整数绝对值
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,127 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// fillTestPix 用确定的伪随机字节填充像素，alpha不为0，保证快速路径与通用路径可比
+func fillTestPix(pix []byte) {
+	for i := range pix {
+		pix[i] = uint8(i*131 + i/7)
+	}
+}
+
+func TestPackPNGRowFast(t *testing.T) {
+	rect := image.Rect(0, 0, 37, 3)
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.Gray{Y: uint8(i)}
+	}
+	tests := []struct {
+		name   string
+		header pngHeader
+		img    image.Image
+	}{
+		{"palette8", pngHeader{bitDepth: 8, colorType: pngColorPalette}, image.NewPaletted(rect, palette)},
+		{"gray8", pngHeader{bitDepth: 8, colorType: pngColorGray}, image.NewGray(rect)},
+		{"gray16", pngHeader{bitDepth: 16, colorType: pngColorGray}, image.NewGray16(rect)},
+		{"rgb8", pngHeader{bitDepth: 8, colorType: pngColorRGB}, image.NewRGBA(rect)},
+		{"rgb16", pngHeader{bitDepth: 16, colorType: pngColorRGB}, image.NewRGBA64(rect)},
+		{"rgb8 trns", pngHeader{bitDepth: 8, colorType: pngColorRGB}, image.NewNRGBA(rect)},
+		{"rgba8", pngHeader{bitDepth: 8, colorType: pngColorRGBA}, image.NewNRGBA(rect)},
+		{"gray alpha8", pngHeader{bitDepth: 8, colorType: pngColorGrayAlpha}, image.NewNRGBA(rect)},
+		{"rgba16", pngHeader{bitDepth: 16, colorType: pngColorRGBA}, image.NewNRGBA64(rect)},
+		{"gray alpha16", pngHeader{bitDepth: 16, colorType: pngColorGrayAlpha}, image.NewNRGBA64(rect)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &tt.header
+			h.width, h.height = rect.Dx(), rect.Dy()
+			switch img := tt.img.(type) {
+			case *image.Paletted:
+				fillTestPix(img.Pix)
+			case *image.Gray:
+				fillTestPix(img.Pix)
+			case *image.Gray16:
+				fillTestPix(img.Pix)
+			case *image.RGBA:
+				fillTestPix(img.Pix)
+			case *image.RGBA64:
+				fillTestPix(img.Pix)
+			case *image.NRGBA:
+				fillTestPix(img.Pix)
+			case *image.NRGBA64:
+				fillTestPix(img.Pix)
+			}
+
+			// 连续像素与Adam7式的间隔采样
+			for _, step := range []struct{ x0, xStep int }{{0, 1}, {1, 2}, {4, 8}} {
+				width := (rect.Dx() - step.x0 + step.xStep - 1) / step.xStep
+				rowSize := (width*h.bitsPerPixel() + 7) / 8
+				fast, slow := make([]byte, rowSize), make([]byte, rowSize)
+				if !h.packRowFast(fast, tt.img, step.x0, 1, step.xStep, width) {
+					t.Fatalf("步长 %d: 没有使用快速路径", step.xStep)
+				}
+				h.packRow(slow, tt.img, step.x0, 1, step.xStep, width)
+				if !bytes.Equal(fast, slow) {
+					t.Errorf("步长 %d: 快速路径结果与通用路径不一致", step.xStep)
+				}
+			}
+		})
+	}
+
+	// 低位深度和有tRNS的灰度图像使用通用路径
+	if (&pngHeader{bitDepth: 4, colorType: pngColorGray}).packRowFast(make([]byte, 19), image.NewGray(rect), 0, 0, 1, 37) {
+		t.Error("4位灰度不应使用快速路径")
+	}
+	if (&pngHeader{bitDepth: 8, colorType: pngColorGray}).packRowFast(make([]byte, 37), image.NewNRGBA(rect), 0, 0, 1, 37) {
+		t.Error("带tRNS的灰度图像不应使用快速路径")
+	}
+}
+
+// benchmarkPNGImage 生成 width x height 的真彩色图像和对应的IHDR
+func benchmarkPNGImage(width, height int) (*pngHeader, *image.RGBA) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillTestPix(img.Pix)
+	return &pngHeader{width: width, height: height, bitDepth: 8, colorType: pngColorRGB}, img
+}
+
+func BenchmarkPackPNGRow(b *testing.B) {
+	h, img := benchmarkPNGImage(4096, 1)
+	row := make([]byte, 4096*3)
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(row)))
+		for i := 0; i < b.N; i++ {
+			h.packRowFast(row, img, 0, 0, 1, 4096)
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(row)))
+		for i := 0; i < b.N; i++ {
+			h.packRow(row, img, 0, 0, 1, 4096)
+		}
+	})
+}
+
+func BenchmarkModifyPNGPixel(b *testing.B) {
+	_, img := benchmarkPNGImage(1024, 768)
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, img); err != nil {
+		b.Fatal(err)
+	}
+	modifier := NewImageModifier()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := modifier.modifyPNGPixel(buf.Bytes(), &PixelModifyResult{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
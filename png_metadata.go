@@ -2,10 +2,9 @@ package imagemodify
 
 import (
 	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"hash/crc32"
-	"os"
+	"io"
 	"strconv"
 	"strings"
 	"time"
@@ -13,19 +12,17 @@ import (
 
 // modifyPNGMetadata 修改PNG图片的文本元数据
 func (m *ImageModifier) modifyPNGMetadata(data []byte, metadata *ImageMetadata) ([]byte, error) {
-	// PNG文件必须以PNG签名开头
-	if len(data) < 8 || !bytes.Equal(data[:8], []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}) {
-		return nil, fmt.Errorf("不是有效的PNG文件")
-	}
-
 	// 移除现有的文本块
-	cleanData := m.removeExistingTextChunks(data)
+	cleanData, err := m.removeExistingTextChunks(data)
+	if err != nil {
+		return nil, err
+	}
 
 	// 准备要添加的文本块
 	textChunks := m.createPNGTextChunks(metadata)
 
-	// 在IEND块之前插入新的文本块
-	return m.insertPNGTextChunks(cleanData, textChunks), nil
+	// 插入新的文本块（APNG插入到第一帧之前）
+	return m.insertPNGTextChunks(cleanData, textChunks)
 }
 
 // createPNGTextChunks 创建PNG文本块
@@ -33,6 +30,9 @@ func (m *ImageModifier) createPNGTextChunks(metadata *ImageMetadata) [][]byte {
 	var chunks [][]byte
 
 	// 添加各种元数据作为tEXt块
+	if metadata.Title != "" {
+		chunks = append(chunks, m.createPNGTextChunk("Title", metadata.Title))
+	}
 	if metadata.Artist != "" {
 		chunks = append(chunks, m.createPNGTextChunk("Author", metadata.Artist))
 	}
@@ -70,88 +70,44 @@ func (m *ImageModifier) createPNGTextChunks(metadata *ImageMetadata) [][]byte {
 
 // createPNGTextChunk 创建单个PNG文本块
 func (m *ImageModifier) createPNGTextChunk(keyword, text string) []byte {
-	// 构造tEXt块数据
+	// tEXt块格式：关键字\0文本
 	textData := []byte(keyword)
 	textData = append(textData, 0) // 分隔符
 	textData = append(textData, []byte(text)...)
-
-	// 构造完整的tEXt块
-	chunk := make([]byte, 0, 12+len(textData))
-
-	// 长度（4字节，大端序）
-	lengthBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(lengthBytes, uint32(len(textData)))
-	chunk = append(chunk, lengthBytes...)
-
-	// 类型（4字节）
-	chunkType := []byte("tEXt")
-	chunk = append(chunk, chunkType...)
-
-	// 数据
-	chunk = append(chunk, textData...)
-
-	// CRC（4字节）
-	crcData := append(chunkType, textData...)
-	crc := crc32.ChecksumIEEE(crcData)
-	crcBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(crcBytes, crc)
-	chunk = append(chunk, crcBytes...)
-
-	return chunk
+	return buildPNGChunk("tEXt", textData)
 }
 
-// removeExistingTextChunks 移除现有的文本块
-func (m *ImageModifier) removeExistingTextChunks(data []byte) []byte {
+// removeExistingTextChunks 移除现有的文本块（tEXt、zTXt、iTXt），其余块和IEND之后的数据原样保留
+func (m *ImageModifier) removeExistingTextChunks(data []byte) ([]byte, error) {
+	r, err := m.newPNGChunkReader(data)
+	if err != nil {
+		return nil, err
+	}
 	result := make([]byte, 0, len(data))
 	result = append(result, data[:8]...) // 保留PNG签名
-
-	pos := 8 // 跳过PNG签名
-
-	for pos < len(data)-8 {
-		// 读取块长度
-		if pos+4 > len(data) {
+	for {
+		chunk, err := r.next()
+		if err == io.EOF {
 			break
 		}
-		chunkLen := binary.BigEndian.Uint32(data[pos : pos+4])
-
-		// 读取块类型
-		if pos+8 > len(data) {
-			break
+		if err != nil {
+			return nil, err
 		}
-		chunkType := data[pos+4 : pos+8]
-
-		// 计算整个块的大小（长度+类型+数据+CRC）
-		totalChunkSize := int(chunkLen) + 12
-		chunkEnd := pos + totalChunkSize
-
-		if chunkEnd > len(data) {
-			// 块大小超出文件范围，复制剩余数据
-			result = append(result, data[pos:]...)
-			break
-		}
-
-		// 检查是否是文本块（tEXt, zTXt, iTXt）
-		chunkTypeStr := string(chunkType)
-		if chunkTypeStr == "tEXt" || chunkTypeStr == "zTXt" || chunkTypeStr == "iTXt" {
-			// 跳过文本块
-			pos = chunkEnd
-			continue
+		switch chunk.chunkType {
+		case "tEXt", "zTXt", "iTXt":
+		default:
+			result = append(result, data[chunk.start:chunk.end]...)
 		}
-
-		// 保留非文本块
-		result = append(result, data[pos:chunkEnd]...)
-		pos = chunkEnd
 	}
-
-	return result
+	return append(result, r.trailing()...), nil
 }
 
 // insertPNGTextChunks 在PNG文件中插入文本块
-func (m *ImageModifier) insertPNGTextChunks(data []byte, chunks [][]byte) []byte {
-	// 查找IEND块的位置
-	iendPos := m.findPNGIENDChunk(data)
-	if iendPos == -1 {
-		iendPos = len(data)
+func (m *ImageModifier) insertPNGTextChunks(data []byte, chunks [][]byte) ([]byte, error) {
+	// 查找插入位置（普通PNG为IEND块之前，APNG为第一帧之前）
+	insertPos, err := m.findPNGTextInsertPos(data)
+	if err != nil {
+		return nil, err
 	}
 
 	// 计算所有文本块的总大小
@@ -162,67 +118,51 @@ func (m *ImageModifier) insertPNGTextChunks(data []byte, chunks [][]byte) []byte
 
 	// 构造新的PNG数据
 	result := make([]byte, 0, len(data)+totalChunkSize)
-	result = append(result, data[:iendPos]...) // IEND之前的数据
+	result = append(result, data[:insertPos]...) // 插入位置之前的数据
 
 	// 添加所有文本块
 	for _, chunk := range chunks {
 		result = append(result, chunk...)
 	}
 
-	result = append(result, data[iendPos:]...) // IEND块
+	result = append(result, data[insertPos:]...) // 插入位置之后的数据
 
-	return result
+	return result, nil
 }
 
 // getPNGMetadata 获取PNG图片的文本元数据
 func (m *ImageModifier) getPNGMetadata(imagePath string) (*ImageMetadata, error) {
-	data, err := os.ReadFile(imagePath)
+	data, err := m.readImageFile(imagePath)
 	if err != nil {
-		return nil, fmt.Errorf("读取文件失败: %v", err)
+		return nil, fmt.Errorf("读取文件失败: %w", err)
 	}
+	return m.parsePNGMetadata(data)
+}
 
-	// PNG文件必须以PNG签名开头
-	if len(data) < 8 || !bytes.Equal(data[:8], []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}) {
-		return nil, fmt.Errorf("不是有效的PNG文件")
+// parsePNGMetadata 从tEXt、zTXt和iTXt块中读取文本元数据
+func (m *ImageModifier) parsePNGMetadata(data []byte) (*ImageMetadata, error) {
+	r, err := m.newPNGChunkReader(data)
+	if err != nil {
+		return nil, err
 	}
-
 	metadata := &ImageMetadata{}
-	pos := 8 // 跳过PNG签名
-
-	for pos < len(data)-8 {
-		// 读取块长度
-		if pos+4 > len(data) {
-			break
+	for {
+		chunk, err := r.next()
+		if err == io.EOF {
+			return metadata, nil
 		}
-		chunkLen := binary.BigEndian.Uint32(data[pos : pos+4])
-		pos += 4
-
-		// 读取块类型
-		if pos+4 > len(data) {
-			break
+		if err != nil {
+			return nil, err
 		}
-		chunkType := data[pos : pos+4]
-		pos += 4
-
-		// 检查是否是文本块
-		if bytes.Equal(chunkType, []byte("tEXt")) {
-			// 解析tEXt块
-			if pos+int(chunkLen) <= len(data) {
-				textData := data[pos : pos+int(chunkLen)]
-				m.parsePNGTextChunk(textData, metadata)
+		switch chunk.chunkType {
+		case "tEXt":
+			m.parsePNGTextChunk(chunk.data, metadata)
+		case "zTXt", "iTXt":
+			if err := m.parsePNGCompressedTextChunk(chunk.chunkType, chunk.data, metadata); err != nil {
+				return nil, err
 			}
 		}
-
-		// 跳过数据和CRC
-		pos += int(chunkLen) + 4
-
-		// 检查是否是IEND块
-		if bytes.Equal(chunkType, []byte("IEND")) {
-			break
-		}
 	}
-
-	return metadata, nil
 }
 
 // parsePNGTextChunk 解析PNG文本块
@@ -232,12 +172,54 @@ func (m *ImageModifier) parsePNGTextChunk(data []byte, metadata *ImageMetadata)
 	if len(parts) != 2 {
 		return
 	}
+	m.setPNGTextField(string(parts[0]), string(parts[1]), metadata)
+}
+
+// parsePNGCompressedTextChunk 解析zTXt块和iTXt块，压缩的文本解压后不能超过 MaxTextSize
+// 格式错误或无法解压的块被忽略，超出资源限制时返回错误
+func (m *ImageModifier) parsePNGCompressedTextChunk(chunkType string, data []byte, metadata *ImageMetadata) error {
+	// zTXt块格式：关键字\0 压缩方法 压缩的文本
+	// iTXt块格式：关键字\0 压缩标志 压缩方法 语言标签\0 翻译后的关键字\0 文本（压缩标志为1时压缩）
+	parts := bytes.SplitN(data, []byte{0}, 2)
+	if len(parts) != 2 || len(parts[1]) < 1 {
+		return nil
+	}
+	keyword, rest := string(parts[0]), parts[1]
+
+	compressed := true
+	if chunkType == "iTXt" {
+		if len(rest) < 2 {
+			return nil
+		}
+		compressed = rest[0] == 1
+		fields := bytes.SplitN(rest[2:], []byte{0}, 3)
+		if len(fields) != 3 {
+			return nil
+		}
+		rest = fields[2]
+	} else {
+		rest = rest[1:]
+	}
 
-	keyword := string(parts[0])
-	text := string(parts[1])
+	if compressed {
+		text, err := m.inflateText(rest)
+		if errors.Is(err, ErrLimitExceeded) {
+			return err
+		}
+		if err != nil {
+			return nil
+		}
+		rest = text
+	}
+	m.setPNGTextField(keyword, string(rest), metadata)
+	return nil
+}
 
-	// 根据关键字设置对应的元数据字段
+// setPNGTextField 根据文本块的关键字设置对应的元数据字段
+func (m *ImageModifier) setPNGTextField(keyword, text string, metadata *ImageMetadata) {
 	switch strings.ToLower(keyword) {
+	case "title":
+		metadata.Title = text
 	case "author", "artist":
 		metadata.Artist = text
 	case "copyright":
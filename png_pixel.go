@@ -0,0 +1,310 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// PNG像素微调直接操作 image/png 解码得到的具体图像类型（Gray、Gray16、Paletted、NRGBA64等），
+// 按原始IHDR重新编码后只替换IDAT块：位深度、颜色类型、隔行方式、PLTE和tRNS都保持不变
+
+// modifyPNGPixel 通过微调像素修改PNG图片
+// APNG只修改第一帧（默认图像属于动画时为默认图像，否则为第一组fdAT）并保留其余动画块，
+// 结构异常时返回 ErrUnsafeAPNGEdit；
+// 被修改像素的纹理强度记录在 result 中
+func (m *ImageModifier) modifyPNGPixel(data []byte, result *PixelModifyResult) ([]byte, error) {
+	chunks, err := m.parsePNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	header, err := m.parsePNGHeader(chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	animated := m.isAPNG(chunks)
+	if animated {
+		info, err := m.checkAPNG(chunks)
+		if err != nil {
+			return nil, err
+		}
+		if !info.defaultFrame {
+			// 默认图像不会显示，改为修改动画的第一帧
+			return m.modifyAPNGFirstFrame(data, chunks, result)
+		}
+	}
+
+	// 解码PNG图片（APNG只得到默认图像）
+	if err := m.checkDecodeConfig(data); err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解码PNG图片失败: %v", err)
+	}
+	if err := header.checkImageType(img); err != nil {
+		if animated {
+			return nil, fmt.Errorf("%w: %v", ErrUnsafeAPNGEdit, err)
+		}
+		return nil, err
+	}
+
+	// 按策略优先修改完全透明的像素
+	adjusted := false
+	if m.prefersTransparent() {
+		plte, err := m.adjustPNGTransparentPixel(header, chunks, img)
+		if err != nil && (!errors.Is(err, ErrNoTransparentPixel) || m.PixelStrategy == PixelStrategyTransparent) {
+			return nil, err
+		}
+		adjusted = err == nil
+		if plte != nil {
+			// 只改写了透明调色板项的RGB，块的位置不变
+			if data, err = replacePNGChunkData(data, chunks, "PLTE", plte); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !adjusted {
+		score, err := m.adjustPNGPixels(header, chunks, img)
+		if err != nil {
+			return nil, err
+		}
+		result.TextureScore = score
+	}
+
+	// 按原始IHDR重新编码并替换IDAT块
+	zdata, err := m.encodePNGImageData(header, img)
+	if err != nil {
+		return nil, fmt.Errorf("重新编码PNG失败: %v", err)
+	}
+	return m.replacePNGImageData(data, chunks, zdata, pngIDATChunkSize)
+}
+
+// adjustPNGPixels 在保持位深度和颜色类型的前提下微调 PixelCount 个像素（默认一个边缘像素）
+// 调色板图像改用颜色最接近的另一个索引；低位深度灰度移动到相邻灰阶；
+// 存在tRNS透明色键时跳过透明像素，并避免调整后的颜色恰好等于透明色。返回被修改像素中最小的局部纹理强度
+func (m *ImageModifier) adjustPNGPixels(header *pngHeader, chunks []pngChunk, img image.Image) (float64, error) {
+	bounds := img.Bounds()
+	count, maxDelta := m.pixelBudget()
+	rng := m.pixelRand()
+	texture := m.imageTexture(img)
+	candidates, err := m.pixelCandidates(rng, bounds.Dx(), bounds.Dy(), count, pixelProbe{
+		texture: texture,
+		uniform: imageUniform(img),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var transparentKey []int
+	for _, chunk := range chunks {
+		if chunk.chunkType == "tRNS" {
+			transparentKey = header.transparentKey(chunk.data)
+		}
+	}
+
+	adjusted, score := 0, 0.0
+	var deltaErr error // 最近一次因超出 PixelMaxDelta 或 MaxDeltaE 而无法修改的原因
+	for _, pixel := range candidates {
+		if adjusted == count {
+			break
+		}
+		// 调整幅度为 ±1~maxDelta（16位深度按257倍放大，低位深度固定为1个灰阶）
+		step := pixelStep(rng, maxDelta)
+		if header.bitDepth < 8 {
+			step = step / abs(step)
+		} else if header.bitDepth == 16 {
+			step *= 257
+		}
+		pixelScore := texture(pixel.X, pixel.Y)
+		ok, err := m.adjustPNGPixelAt(header, img, bounds.Min.X+pixel.X, bounds.Min.Y+pixel.Y, step, transparentKey)
+		if err != nil {
+			deltaErr = err
+		} else if ok {
+			if adjusted == 0 || pixelScore < score {
+				score = pixelScore
+			}
+			adjusted++
+		}
+	}
+
+	if adjusted > 0 {
+		return score, nil
+	}
+	if deltaErr != nil {
+		return 0, deltaErr
+	}
+	return 0, fmt.Errorf("找不到可以安全微调的边缘像素")
+}
+
+// adjustPNGPixelAt 微调一个像素，该像素不适合修改（透明色键）时返回false
+// 设置了 MaxDeltaE 时忽略 step，改为在ΔE2000约束下调整；无法满足约束（包括调色板中没有足够接近的颜色）时返回错误，
+// 调用方改为尝试其他像素
+func (m *ImageModifier) adjustPNGPixelAt(header *pngHeader, img image.Image, x, y, step int, transparentKey []int) (bool, error) {
+	if paletted, ok := img.(*image.Paletted); ok {
+		index, err := m.adjustPaletteIndex(paletted.Palette, paletted.ColorIndexAt(x, y))
+		if err != nil {
+			return false, err
+		}
+		paletted.SetColorIndex(x, y, index)
+		return true, nil
+	}
+
+	adjusted, err := m.adjustPNGSamples(header, header.samples(img, x, y), step, transparentKey)
+	if adjusted == nil || err != nil {
+		return false, err
+	}
+	header.setSamples(img, x, y, adjusted)
+	return true, nil
+}
+
+// adjustPaletteIndex 为调色板像素选择另一个颜色最接近的索引（设置了 MaxDeltaE 时按ΔE2000）
+// 新颜色的各通道与原颜色相差不能超过 PixelMaxDelta 级（设置了 MaxDeltaE 时ΔE不能超过 MaxDeltaE），
+// 稀疏的调色板中找不到这样的颜色时返回错误
+func (m *ImageModifier) adjustPaletteIndex(palette color.Palette, index uint8) (uint8, error) {
+	if m.MaxDeltaE > 0 {
+		other, dist, ok := nearestPaletteIndexDeltaE(palette, index)
+		if !ok || dist > m.MaxDeltaE {
+			return 0, fmt.Errorf("调色板中没有与原颜色ΔE不超过 %.2f 的其他颜色", m.MaxDeltaE)
+		}
+		return other, nil
+	}
+	_, maxDelta := m.pixelBudget()
+	other, ok := nearestOtherPaletteIndex(palette, index, maxDelta)
+	if !ok {
+		return 0, fmt.Errorf("调色板中没有与原颜色相差不超过 %d 级的其他颜色", maxDelta)
+	}
+	return other, nil
+}
+
+// adjustPNGSamples 微调一个非调色板像素的原始样本值，返回新的样本值；
+// 该像素不适合修改（透明色键）时返回nil
+func (m *ImageModifier) adjustPNGSamples(header *pngHeader, samples []int, step int, transparentKey []int) ([]int, error) {
+	if transparentKey != nil && equalSamples(samples, transparentKey) {
+		return nil, nil // 透明像素
+	}
+
+	// 颜色通道（不含alpha）
+	colors := header.channels()
+	if header.colorType == pngColorGrayAlpha || header.colorType == pngColorRGBA {
+		colors--
+	}
+
+	if m.MaxDeltaE > 0 {
+		adjusted, err := m.perceptualAdjust(samples[:colors], header.maxSample(), func(c []int) bool {
+			return transparentKey != nil && equalSamples(c, transparentKey)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return append(adjusted, samples[colors:]...), nil
+	}
+
+	for _, delta := range []int{step, -step} {
+		adjusted := append([]int(nil), samples...)
+		for c := 0; c < colors; c++ {
+			adjusted[c] = bounceSample(adjusted[c], delta, header.maxSample())
+		}
+		if transparentKey != nil && equalSamples(adjusted, transparentKey) {
+			continue
+		}
+		return adjusted, nil
+	}
+	return nil, nil
+}
+
+// transparentKey 解析tRNS中的透明色键（灰度或真彩色图像），其余颜色类型返回nil
+func (h *pngHeader) transparentKey(trns []byte) []int {
+	if (h.colorType != pngColorGray && h.colorType != pngColorRGB) || len(trns) < 2*h.channels() {
+		return nil
+	}
+	key := make([]int, h.channels())
+	for i := range key {
+		key[i] = int(binary.BigEndian.Uint16(trns[2*i:]))
+	}
+	return key
+}
+
+// bounceSample 调整样本值，超出范围时改为反方向调整
+func bounceSample(value, delta, maxValue int) int {
+	if value+delta < 0 || value+delta > maxValue {
+		return value - delta
+	}
+	return value + delta
+}
+
+// equalSamples 比较两组样本值
+func equalSamples(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nearestOtherPaletteIndex 返回与指定索引颜色最接近的另一个调色板索引
+// 只考虑R、G、B、A（非预乘的8位值）各自相差不超过 maxDelta 的颜色，没有时返回false
+func nearestOtherPaletteIndex(palette color.Palette, index uint8, maxDelta int) (uint8, bool) {
+	if int(index) >= len(palette) {
+		return 0, false
+	}
+
+	original := color.NRGBAModel.Convert(palette[index]).(color.NRGBA)
+	best, bestDist := -1, uint64(0)
+	for i, c := range palette {
+		if i == int(index) {
+			continue
+		}
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		within := true
+		dist := uint64(0)
+		for _, d := range [][2]uint8{{original.R, n.R}, {original.G, n.G}, {original.B, n.B}, {original.A, n.A}} {
+			diff := abs(int(d[0]) - int(d[1]))
+			within = within && diff <= maxDelta
+			dist += uint64(diff * diff)
+		}
+		if within && (best < 0 || dist < bestDist) {
+			best, bestDist = i, dist
+		}
+	}
+	return uint8(best), best >= 0
+}
+
+// nearestPaletteIndexDeltaE 返回alpha相同、与指定索引的ΔE2000最小的另一个调色板索引及其ΔE
+func nearestPaletteIndexDeltaE(palette color.Palette, index uint8) (uint8, float64, bool) {
+	if int(index) >= len(palette) {
+		return 0, 0, false
+	}
+	lab := func(c color.Color) (labColor, uint8) {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		return sampleToLab([]int{int(n.R), int(n.G), int(n.B)}, 255), n.A
+	}
+
+	original, alpha := lab(palette[index])
+	best, bestDist := -1, 0.0
+	for i, c := range palette {
+		if i == int(index) {
+			continue
+		}
+		candidate, a := lab(c)
+		if a != alpha {
+			continue
+		}
+		if dist := deltaE2000(original, candidate); best < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	if best < 0 {
+		return 0, 0, false
+	}
+	return uint8(best), bestDist, true
+}
@@ -0,0 +1,221 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pngChunkData 返回指定类型的第一个块的数据
+func pngChunkData(t *testing.T, data []byte, chunkType string) []byte {
+	chunks, err := NewImageModifier().parsePNGChunks(data)
+	if err != nil {
+		t.Fatalf("解析PNG失败: %v", err)
+	}
+	for _, chunk := range chunks {
+		if chunk.chunkType == chunkType {
+			return chunk.data
+		}
+	}
+	return nil
+}
+
+// countChangedPixels 统计两张PNG解码后不同的像素数量
+func countChangedPixels(t *testing.T, a, b []byte) int {
+	imgA, err := png.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatalf("解码PNG失败: %v", err)
+	}
+	imgB, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("解码PNG失败: %v", err)
+	}
+	changed := 0
+	bounds := imgA.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, a1 := imgA.At(x, y).RGBA()
+			r2, g2, b2, a2 := imgB.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				changed++
+			}
+		}
+	}
+	return changed
+}
+
+func TestModifyPNGPixelPreservesFormat(t *testing.T) {
+	gray16 := image.NewGray16(image.Rect(0, 0, 12, 12))
+	gray := image.NewGray(image.Rect(0, 0, 12, 12))
+	rgb := image.NewRGBA(image.Rect(0, 0, 12, 12))
+	paletted := image.NewPaletted(image.Rect(0, 0, 12, 12), color.Palette{
+		color.NRGBA{0, 0, 0, 255}, color.NRGBA{200, 10, 10, 255}, color.NRGBA{10, 200, 10, 128},
+		// 与前三种颜色相差不超过2级，像素微调可以改用这些索引
+		color.NRGBA{1, 1, 1, 255}, color.NRGBA{202, 9, 10, 255}, color.NRGBA{10, 201, 11, 128},
+	})
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 12; x++ {
+			gray16.SetGray16(x, y, color.Gray16{Y: uint16(x*5000 + y)})
+			gray.SetGray(x, y, color.Gray{Y: uint8(x * 20)})
+			rgb.SetRGBA(x, y, color.RGBA{uint8(x * 20), uint8(y * 20), 255, 255})
+			paletted.SetColorIndex(x, y, uint8((x+y)%3))
+		}
+	}
+
+	modifier := NewImageModifier()
+	for _, img := range []image.Image{gray16, gray, rgb, paletted} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("编码PNG失败: %v", err)
+		}
+		data := buf.Bytes()
+
+		modified, err := modifier.modifyPNGPixel(data, &PixelModifyResult{})
+		if err != nil {
+			t.Fatalf("%T 像素微调失败: %v", img, err)
+		}
+
+		for _, chunkType := range []string{"IHDR", "PLTE", "tRNS"} {
+			if !bytes.Equal(pngChunkData(t, data, chunkType), pngChunkData(t, modified, chunkType)) {
+				t.Errorf("%T 的 %s 块发生了变化", img, chunkType)
+			}
+		}
+		if changed := countChangedPixels(t, data, modified); changed != 1 {
+			t.Errorf("%T 应恰好修改1个像素，实际修改了 %d 个", img, changed)
+		}
+	}
+}
+
+func TestModifyPNGPixelSparsePalette(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{
+		color.NRGBA{0, 0, 0, 255}, color.NRGBA{200, 10, 10, 255},
+	})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+
+	// 调色板中没有相近的颜色时不能换成明显不同的颜色
+	if _, err := NewImageModifier().modifyPNGPixel(buf.Bytes(), &PixelModifyResult{}); err == nil {
+		t.Error("调色板中没有相近颜色时应返回错误")
+	}
+	if _, err := (&ImageModifier{MaxDeltaE: 2}).modifyPNGPixel(buf.Bytes(), &PixelModifyResult{}); err == nil {
+		t.Error("设置 MaxDeltaE 且调色板中没有相近颜色时应返回错误")
+	}
+	modified, err := (&ImageModifier{PixelMaxDelta: 200}).modifyPNGPixel(buf.Bytes(), &PixelModifyResult{})
+	if err != nil {
+		t.Fatalf("放宽 PixelMaxDelta 后像素微调失败: %v", err)
+	}
+	if changed := countChangedPixels(t, buf.Bytes(), modified); changed != 1 {
+		t.Errorf("应恰好修改1个像素，实际修改了 %d 个", changed)
+	}
+}
+
+func TestModifyPNGPixelKeepsTransparentKey(t *testing.T) {
+	modifier := NewImageModifier()
+	header := &pngHeader{width: 6, height: 6, bitDepth: 8, colorType: pngColorRGB}
+
+	// 除(2,0)外全部为透明色键(10,20,30)
+	img := image.NewNRGBA(image.Rect(0, 0, 6, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 6; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{10, 20, 30, 0})
+		}
+	}
+	img.SetNRGBA(2, 0, color.NRGBA{11, 21, 31, 255})
+
+	zdata, err := modifier.encodePNGImageData(header, img)
+	if err != nil {
+		t.Fatalf("编码失败: %v", err)
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], 6)
+	binary.BigEndian.PutUint32(ihdr[4:8], 6)
+	ihdr[8], ihdr[9] = 8, pngColorRGB
+
+	var file bytes.Buffer
+	file.Write(pngSignature)
+	file.Write(buildPNGChunk("IHDR", ihdr))
+	file.Write(buildPNGChunk("tRNS", []byte{0, 10, 0, 20, 0, 30}))
+	file.Write(modifier.buildIDATChunks(zdata, pngIDATChunkSize))
+	file.Write(buildPNGChunk("IEND", nil))
+
+	for i := 0; i < 10; i++ {
+		modified, err := modifier.modifyPNGPixel(file.Bytes(), &PixelModifyResult{})
+		if err != nil {
+			t.Fatalf("像素微调失败: %v", err)
+		}
+		result, _ := png.Decode(bytes.NewReader(modified))
+		opaque := 0
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 6; x++ {
+				if _, _, _, a := result.At(x, y).RGBA(); a != 0 {
+					opaque++
+				}
+			}
+		}
+		if opaque != 1 {
+			t.Fatalf("透明像素数量发生变化，不透明像素 %d 个", opaque)
+		}
+		if countChangedPixels(t, file.Bytes(), modified) != 1 {
+			t.Fatal("应只修改唯一的不透明边缘像素")
+		}
+	}
+}
+
+func TestModifyPNGPixelKeepsAncillaryChunks(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 3)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+	chunks, _ := modifier.parsePNGChunks(buf.Bytes())
+	ancillary := [][]byte{
+		buildPNGChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}),
+		buildPNGChunk("sRGB", []byte{0}),
+		buildPNGChunk("pHYs", []byte{0, 0, 0x0B, 0x13, 0, 0, 0x0B, 0x13, 1}),
+		buildPNGChunk("tIME", []byte{0x07, 0xE8, 1, 2, 3, 4, 5}),
+	}
+	var data []byte
+	data = append(data, pngSignature...)
+	for _, chunk := range chunks {
+		data = append(data, buf.Bytes()[chunk.start:chunk.end]...)
+		if chunk.chunkType == "IHDR" {
+			for _, a := range ancillary {
+				data = append(data, a...)
+			}
+		}
+	}
+
+	testFile := filepath.Join(t.TempDir(), "test.png")
+	if err := os.WriteFile(testFile, data, 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+	if _, err := modifier.ModifyImageMetadata(testFile, &ImageMetadata{Artist: "作者"}); err != nil {
+		t.Fatalf("修改元数据失败: %v", err)
+	}
+	if _, err := modifier.ModifyImageSHA1ByPixel(testFile); err != nil {
+		t.Fatalf("像素微调失败: %v", err)
+	}
+
+	modified, _ := os.ReadFile(testFile)
+	for _, a := range ancillary {
+		if !bytes.Contains(modified, a) {
+			t.Errorf("辅助块 %s 丢失", a[4:8])
+		}
+	}
+	metadata, err := modifier.GetImageMetadata(testFile)
+	if err != nil || metadata.Artist != "作者" {
+		t.Errorf("像素微调后元数据丢失: %+v, %v", metadata, err)
+	}
+}
@@ -0,0 +1,205 @@
+package imagemodify
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// PNG无损重新压缩：解压IDAT得到过滤前的扫描行，换一种过滤方式、压缩级别或IDAT分块重新写入。
+// 扫描行的原始字节不变，因此解码后的像素与原图完全相同；IDAT以外的块逐字节保持不变。
+
+// pngFilterAdaptive 每行按最小绝对值和选择过滤方式
+const pngFilterAdaptive = -1
+
+// pngRecompressOptions 一种重新压缩方式
+type pngRecompressOptions struct {
+	filter    int // 0~4 为固定的过滤类型，pngFilterAdaptive 为逐行自适应
+	level     int // zlib压缩级别
+	chunkSize int // 每个IDAT块的最大数据长度
+}
+
+// pngPass 一个（Adam7子）图像的过滤前扫描行
+type pngPass struct {
+	rows [][]byte
+	bpp  int // 过滤时对应像素的字节距离
+}
+
+// recompressPNG 无损重新压缩PNG的图像数据
+// 从随机选择的组合开始依次尝试，返回第一个与原文件不同的结果
+func (m *ImageModifier) recompressPNG(data []byte) ([]byte, error) {
+	chunks, err := m.parsePNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	header, err := m.parsePNGHeader(chunks)
+	if err != nil {
+		return nil, err
+	}
+	if m.isAPNG(chunks) {
+		if _, err := m.checkAPNG(chunks); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.checkPixels(header.width, header.height); err != nil {
+		return nil, err
+	}
+
+	var zdata []byte
+	for _, chunk := range chunks {
+		if chunk.chunkType == "IDAT" {
+			zdata = append(zdata, chunk.data...)
+		}
+	}
+	passes, err := header.unfilterPNGImageData(zdata)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []int{pngFilterAdaptive, 0, 1, 2, 3, 4}
+	levels := []int{zlib.BestSpeed, zlib.DefaultCompression, zlib.BestCompression}
+	chunkSizes := []int{1 << 13, pngIDATChunkSize, 1 << 16, 1 << 20}
+	total := len(filters) * len(levels) * len(chunkSizes)
+
+	randomBytes := m.generateRandomBytes(2)
+	start := int(binary.BigEndian.Uint16(randomBytes)) % total
+	for i := 0; i < total; i++ {
+		n := (start + i) % total
+		options := &pngRecompressOptions{
+			filter:    filters[n%len(filters)],
+			level:     levels[n/len(filters)%len(levels)],
+			chunkSize: chunkSizes[n/len(filters)/len(levels)],
+		}
+		compressed, err := compressPNGPasses(passes, options)
+		if err != nil {
+			return nil, err
+		}
+		result, err := m.replacePNGImageData(data, chunks, compressed, options.chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(result, data) {
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("找不到与原文件不同的压缩方式")
+}
+
+// unfilterPNGImageData 解压图像数据并还原各扫描行的过滤
+// 最多解压IHDR尺寸所需的数据量，多余的数据被忽略
+func (h *pngHeader) unfilterPNGImageData(zdata []byte) ([]pngPass, error) {
+	r, err := zlib.NewReader(bytes.NewReader(zdata))
+	if err != nil {
+		return nil, fmt.Errorf("解压PNG图像数据失败: %v", err)
+	}
+	size := int64(0)
+	for _, p := range h.scanlinePasses() {
+		size += int64(p.height) * int64(1+(p.width*h.bitsPerPixel()+7)/8)
+	}
+	raw, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return nil, fmt.Errorf("解压PNG图像数据失败: %v", err)
+	}
+
+	passes := []struct{ xStart, yStart, xStep, yStep int }{{0, 0, 1, 1}}
+	if h.interlace != 0 {
+		passes = adam7Passes
+	}
+	bpp := (h.bitsPerPixel() + 7) / 8
+
+	var result []pngPass
+	for _, p := range passes {
+		width := (h.width - p.xStart + p.xStep - 1) / p.xStep
+		height := (h.height - p.yStart + p.yStep - 1) / p.yStep
+		if width <= 0 || height <= 0 {
+			continue
+		}
+		rowSize := (width*h.bitsPerPixel() + 7) / 8
+
+		pass := pngPass{bpp: bpp}
+		prev := make([]byte, rowSize)
+		for y := 0; y < height; y++ {
+			if len(raw) < 1+rowSize {
+				return nil, fmt.Errorf("PNG图像数据不完整")
+			}
+			filterType, row := raw[0], append([]byte(nil), raw[1:1+rowSize]...)
+			raw = raw[1+rowSize:]
+			if filterType > 4 {
+				return nil, fmt.Errorf("无效的PNG过滤类型 %d", filterType)
+			}
+			pngUnfilterRow(filterType, row, prev, bpp)
+			pass.rows = append(pass.rows, row)
+			prev = row
+		}
+		result = append(result, pass)
+	}
+	return result, nil
+}
+
+// pngUnfilterRow 就地还原一行的过滤，是 pngFilterRow 的逆操作
+func pngUnfilterRow(filterType byte, row, prev []byte, bpp int) {
+	for i := range row {
+		var a, c int
+		if i >= bpp {
+			a = int(row[i-bpp])
+			c = int(prev[i-bpp])
+		}
+		b := int(prev[i])
+
+		switch filterType {
+		case 1:
+			row[i] += uint8(a)
+		case 2:
+			row[i] += uint8(b)
+		case 3:
+			row[i] += uint8((a + b) / 2)
+		case 4:
+			row[i] += uint8(paethPredictor(a, b, c))
+		}
+	}
+}
+
+// compressPNGPasses 按指定过滤方式和压缩级别重新生成压缩后的图像数据
+func compressPNGPasses(passes []pngPass, options *pngRecompressOptions) ([]byte, error) {
+	var raw bytes.Buffer
+	for _, pass := range passes {
+		prev := make([]byte, len(pass.rows[0]))
+		filtered := make([]byte, len(prev))
+		best := make([]byte, len(prev))
+		for _, row := range pass.rows {
+			filterType := byte(0)
+			copy(best, row)
+			if options.filter == pngFilterAdaptive {
+				bestSum := pngRowCost(row)
+				for ft := byte(1); ft <= 4; ft++ {
+					pngFilterRow(ft, filtered, row, prev, pass.bpp)
+					if sum := pngRowCost(filtered); sum < bestSum {
+						bestSum, filterType = sum, ft
+						copy(best, filtered)
+					}
+				}
+			} else if options.filter > 0 {
+				filterType = byte(options.filter)
+				pngFilterRow(filterType, best, row, prev, pass.bpp)
+			}
+			raw.WriteByte(filterType)
+			raw.Write(best)
+			prev = row
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := zlib.NewWriterLevel(&buf, options.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,148 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testRecompressSources 测试用的各种PNG：低位深度灰度、调色板、16位RGBA、隔行扫描和APNG
+func testRecompressSources(t *testing.T) map[string][]byte {
+	gray1 := image.NewGray(image.Rect(0, 0, 19, 7))
+	paletted := image.NewPaletted(image.Rect(0, 0, 13, 9), color.Palette{
+		color.NRGBA{0, 0, 0, 255}, color.NRGBA{200, 10, 10, 255}, color.NRGBA{10, 200, 10, 128},
+		// 与前三种颜色相差不超过2级，像素微调可以改用这些索引
+		color.NRGBA{1, 1, 1, 255}, color.NRGBA{202, 9, 10, 255}, color.NRGBA{10, 201, 11, 128},
+	})
+	rgba64 := image.NewRGBA64(image.Rect(0, 0, 11, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 11; x++ {
+			gray1.SetGray(x, y%7, color.Gray{Y: uint8((x + y) % 2 * 255)})
+			paletted.SetColorIndex(x, y%9, uint8((x*y)%3))
+			rgba64.SetRGBA64(x, y, color.RGBA64{uint16(x * 6000), uint16(y * 6000), uint16(x * y * 500), 0xFFFF})
+		}
+	}
+
+	sources := map[string][]byte{"apng": createTestAPNG(t)}
+	for name, img := range map[string]image.Image{"gray1": gray1, "paletted": paletted, "rgba64": rgba64} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("编码PNG失败: %v", err)
+		}
+		sources[name] = buf.Bytes()
+	}
+
+	// 隔行扫描的RGB图像
+	modifier := NewImageModifier()
+	header := &pngHeader{width: 17, height: 11, bitDepth: 8, colorType: pngColorRGB, interlace: 1}
+	rgb := image.NewRGBA(image.Rect(0, 0, 17, 11))
+	for i := range rgb.Pix {
+		rgb.Pix[i] = uint8(i * 7)
+		if i%4 == 3 {
+			rgb.Pix[i] = 255
+		}
+	}
+	zdata, err := modifier.encodePNGImageData(header, rgb)
+	if err != nil {
+		t.Fatalf("编码失败: %v", err)
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], 17)
+	binary.BigEndian.PutUint32(ihdr[4:8], 11)
+	ihdr[8], ihdr[9], ihdr[12] = 8, pngColorRGB, 1
+	var file bytes.Buffer
+	file.Write(pngSignature)
+	file.Write(buildPNGChunk("IHDR", ihdr))
+	file.Write(buildPNGChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}))
+	file.Write(modifier.buildIDATChunks(zdata, pngIDATChunkSize))
+	file.Write(buildPNGChunk("tEXt", []byte("Comment\x00after IDAT")))
+	file.Write(buildPNGChunk("IEND", nil))
+	sources["interlaced"] = file.Bytes()
+	return sources
+}
+
+func TestRecompressPNGKeepsPixels(t *testing.T) {
+	modifier := NewImageModifier()
+	for name, data := range testRecompressSources(t) {
+		chunks, _ := modifier.parsePNGChunks(data)
+		header, _ := modifier.parsePNGHeader(chunks)
+
+		for i := 0; i < 8; i++ {
+			recompressed, err := modifier.recompressPNG(data)
+			if err != nil {
+				t.Fatalf("%s: 重新压缩失败: %v", name, err)
+			}
+			if bytes.Equal(recompressed, data) {
+				t.Fatalf("%s: 重新压缩后文件没有变化", name)
+			}
+			if changed := countChangedPixels(t, data, recompressed); changed != 0 {
+				t.Fatalf("%s: 重新压缩改变了 %d 个像素", name, changed)
+			}
+
+			// IDAT以外的块逐字节不变
+			before, after := animationChunks(t, data), animationChunks(t, recompressed)
+			if len(before) != len(after) {
+				t.Fatalf("%s: 块数量变化", name)
+			}
+			for c := range before {
+				if !bytes.Equal(before[c], after[c]) {
+					t.Fatalf("%s: 第 %d 个非IDAT块发生变化", name, c)
+				}
+			}
+
+			// 过滤前的扫描行完全相同
+			var zdata []byte
+			newChunks, _ := modifier.parsePNGChunks(recompressed)
+			for _, chunk := range newChunks {
+				if chunk.chunkType == "IDAT" {
+					zdata = append(zdata, chunk.data...)
+				}
+			}
+			passes, err := header.unfilterPNGImageData(zdata)
+			if err != nil {
+				t.Fatalf("%s: 解压失败: %v", name, err)
+			}
+			var originalData []byte
+			for _, chunk := range chunks {
+				if chunk.chunkType == "IDAT" {
+					originalData = append(originalData, chunk.data...)
+				}
+			}
+			originalPasses, _ := header.unfilterPNGImageData(originalData)
+			for p := range passes {
+				for r := range passes[p].rows {
+					if !bytes.Equal(passes[p].rows[r], originalPasses[p].rows[r]) {
+						t.Fatalf("%s: 第 %d 个子图像第 %d 行不同", name, p, r)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestModifyImageSHA1ByTranscodePNG(t *testing.T) {
+	data := testRecompressSources(t)["interlaced"]
+	testFile := filepath.Join(t.TempDir(), "test.png")
+	if err := os.WriteFile(testFile, data, 0644); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+	originalSHA1, _ := modifier.GetImageSHA1(testFile)
+	newSHA1, err := modifier.ModifyImageSHA1ByTranscode(testFile)
+	if err != nil {
+		t.Fatalf("无损重新编码失败: %v", err)
+	}
+	if newSHA1 == originalSHA1 {
+		t.Error("SHA1没有变化")
+	}
+	modified, _ := os.ReadFile(testFile)
+	if changed := countChangedPixels(t, data, modified); changed != 0 {
+		t.Errorf("像素发生了变化: %d", changed)
+	}
+}
@@ -0,0 +1,636 @@
+package imagemodify
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 流式PNG像素微调：逐块读取PNG，IDAT以外的块原样输出；IDAT的数据边解压边按行处理，
+// 只还原到最后一个被修改行的下一行为止的过滤，被修改的行和它的下一行按原过滤方式重新过滤，
+// 其余行的过滤后数据原样保留，再边压缩边写出新的IDAT块。
+// 输入读取两遍：第一遍只解压到最后一个候选像素所在的行，检查每个候选能否修改，
+// 按与 modifyPNGPixel 相同的顺序选出要修改的像素；第二遍按选定的像素写出。
+// 内存占用只与几行扫描行的大小有关，与图像高度和文件大小无关
+
+// pngStreamEdit 一个待修改的像素在扫描行序列中的位置
+type pngStreamEdit struct {
+	column int // 像素在该行中的序号
+	step   int // 调整量（原始样本精度）
+}
+
+// pngStreamPlan 第一遍读取确定的修改
+type pngStreamPlan struct {
+	edits   map[int][]pngStreamEdit // 扫描行序号 -> 该行要修改的像素，nil表示尚未确定
+	lastRow int                     // 最后一个被修改行的序号
+	apng    bool                    // 输入为APNG，改为完整解码处理
+}
+
+// errPNGStreamPlanned 第一遍读取在确定修改后提前结束
+var errPNGStreamPlanned = errors.New("已确定要修改的像素")
+
+// pngScanlinePass 一个（子）图像的扫描行布局，非隔行图像只有一个
+type pngScanlinePass struct {
+	xStart, yStart, xStep, yStep int
+	width, height                int
+}
+
+// scanlinePasses 按存储顺序返回各个非空（子）图像
+func (h *pngHeader) scanlinePasses() []pngScanlinePass {
+	passes := []struct{ xStart, yStart, xStep, yStep int }{{0, 0, 1, 1}}
+	if h.interlace != 0 {
+		passes = adam7Passes
+	}
+	var result []pngScanlinePass
+	for _, p := range passes {
+		width := (h.width - p.xStart + p.xStep - 1) / p.xStep
+		height := (h.height - p.yStart + p.yStep - 1) / p.yStep
+		if width > 0 && height > 0 {
+			result = append(result, pngScanlinePass{p.xStart, p.yStart, p.xStep, p.yStep, width, height})
+		}
+	}
+	return result
+}
+
+// scanlineOf 像素 (x, y) 所在扫描行在全部扫描行中的序号，以及像素在该行中的序号
+func (h *pngHeader) scanlineOf(x, y int) (row, column int) {
+	for _, p := range h.scanlinePasses() {
+		if x >= p.xStart && y >= p.yStart && (x-p.xStart)%p.xStep == 0 && (y-p.yStart)%p.yStep == 0 {
+			return row + (y-p.yStart)/p.yStep, (x - p.xStart) / p.xStep
+		}
+		row += p.height
+	}
+	return -1, -1
+}
+
+// rowSamples 从还原过滤后的扫描行中读取第 x 个像素的原始样本值
+func (h *pngHeader) rowSamples(row []byte, x int) []int {
+	samples := make([]int, h.channels())
+	for c := range samples {
+		bit := (x*len(samples) + c) * h.bitDepth
+		switch h.bitDepth {
+		case 16:
+			samples[c] = int(binary.BigEndian.Uint16(row[bit/8:]))
+		case 8:
+			samples[c] = int(row[bit/8])
+		default:
+			samples[c] = int(row[bit/8]>>uint(8-h.bitDepth-bit%8)) & h.maxSample()
+		}
+	}
+	return samples
+}
+
+// setRowSamples 写回扫描行中第 x 个像素的原始样本值，是 rowSamples 的逆操作
+func (h *pngHeader) setRowSamples(row []byte, x int, samples []int) {
+	for c, v := range samples {
+		bit := (x*len(samples) + c) * h.bitDepth
+		switch h.bitDepth {
+		case 16:
+			binary.BigEndian.PutUint16(row[bit/8:], uint16(v))
+		case 8:
+			row[bit/8] = uint8(v)
+		default:
+			shift := uint(8 - h.bitDepth - bit%8)
+			row[bit/8] = row[bit/8]&^(uint8(h.maxSample())<<shift) | uint8(v)<<shift
+		}
+	}
+}
+
+// pngPalette 由PLTE和tRNS构造调色板
+func pngPalette(plte, trns []byte) (color.Palette, error) {
+	if len(plte) == 0 || len(plte)%3 != 0 {
+		return nil, fmt.Errorf("PNG调色板无效")
+	}
+	palette := make(color.Palette, len(plte)/3)
+	for i := range palette {
+		c := color.NRGBA{plte[3*i], plte[3*i+1], plte[3*i+2], 255}
+		if i < len(trns) {
+			c.A = trns[i]
+		}
+		palette[i] = c
+	}
+	return palette, nil
+}
+
+// pngStreamable 当前设置能否流式处理PNG：透明像素策略、纹理分布和 AvoidUniform 需要完整的图像
+func (m *ImageModifier) pngStreamable() bool {
+	return m.PNGStreaming && !m.prefersTransparent() && m.PixelDistribution != PixelDistributionTexture &&
+		(m.PixelMask == nil || !m.PixelMask.AvoidUniform)
+}
+
+// modifyPNGPixelFile 流式微调PNG文件的像素：写入同一目录下的临时文件，完成后替换原文件
+func (m *ImageModifier) modifyPNGPixelFile(imagePath string) (*PixelModifyResult, error) {
+	in, err := os.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取图片文件失败: %v", err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("读取图片文件失败: %v", err)
+	}
+	if err := m.checkFileSize(info.Size()); err != nil {
+		return nil, fmt.Errorf("读取图片文件失败: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(imagePath), ".imagemodify-*.png")
+	if err != nil {
+		return nil, fmt.Errorf("写入修改后的图片失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	originalHash, modifiedHash := sha1.New(), sha1.New()
+	out := bufio.NewWriter(io.MultiWriter(tmp, modifiedHash))
+	if err := m.streamPNGPixelHashed(in, out, originalHash); err != nil {
+		return nil, fmt.Errorf("像素微调失败: %w", err)
+	}
+	if err := out.Flush(); err != nil {
+		return nil, fmt.Errorf("写入修改后的图片失败: %v", err)
+	}
+
+	result := &PixelModifyResult{SHA1: fmt.Sprintf("%x", modifiedHash.Sum(nil))}
+	if result.SHA1 == fmt.Sprintf("%x", originalHash.Sum(nil)) {
+		return nil, fmt.Errorf("SHA1修改失败，值未发生变化")
+	}
+
+	// 写回文件
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return nil, fmt.Errorf("写入修改后的图片失败: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("写入修改后的图片失败: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), imagePath); err != nil {
+		return nil, fmt.Errorf("写入修改后的图片失败: %v", err)
+	}
+	return result, nil
+}
+
+// streamPNGPixel 从 r 读取PNG，微调 PixelCount 个像素后写入 w
+// 选择像素的规则与 modifyPNGPixel 相同（不支持纹理分布和 AvoidUniform），IEND之后的数据原样保留。
+// r 会被读取两遍；APNG改为完整读入后由 modifyPNGPixel 处理
+func (m *ImageModifier) streamPNGPixel(r io.ReadSeeker, w io.Writer) error {
+	return m.streamPNGPixelHashed(r, w, nil)
+}
+
+// streamPNGPixelHashed 与 streamPNGPixel 相同，original 不为nil时同时计算输入的哈希
+func (m *ImageModifier) streamPNGPixelHashed(r io.ReadSeeker, w io.Writer, original hash.Hash) error {
+	plan := &pngStreamPlan{}
+	if err := m.streamPNG(r, io.Discard, plan); err != nil && err != errPNGStreamPlanned {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var second io.Reader = r
+	if original != nil {
+		second = io.TeeReader(r, original)
+	}
+
+	if plan.apng {
+		// APNG需要检查帧结构并可能修改第一组fdAT，改为完整解码
+		data, err := io.ReadAll(second)
+		if err != nil {
+			return err
+		}
+		modified, err := m.modifyPNGPixel(data, &PixelModifyResult{})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(modified)
+		return err
+	}
+	return m.streamPNG(second, w, plan)
+}
+
+// streamPNG 逐块读取PNG并写入 w。plan.edits 为nil时为第一遍：确定要修改的像素后返回 errPNGStreamPlanned
+func (m *ImageModifier) streamPNG(r io.Reader, w io.Writer, plan *pngStreamPlan) error {
+	sr := &pngStreamReader{m: m, r: bufio.NewReader(r)}
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(sr, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return fmt.Errorf("不是有效的PNG文件")
+	}
+	if _, err := w.Write(signature); err != nil {
+		return err
+	}
+
+	var header *pngHeader
+	var plte, trns []byte
+	var next *pngChunkHeader // 已读取的下一个块的长度和类型
+	idatSeen := false
+	for {
+		if next == nil {
+			var err error
+			if next, err = sr.readChunkHeader(); err == io.EOF {
+				break // 没有IEND的文件
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if next.chunkType == "IDAT" {
+			if idatSeen {
+				return fmt.Errorf("PNG文件中的IDAT块不连续")
+			}
+			if header == nil {
+				return fmt.Errorf("PNG文件缺少IHDR块")
+			}
+			idatSeen = true
+			var err error
+			if next, err = m.streamPNGImageData(sr, w, header, plte, trns, next, plan); err != nil {
+				return err
+			}
+			continue
+		}
+		if next.chunkType == "acTL" && plan.edits == nil {
+			plan.apng = true
+			return errPNGStreamPlanned
+		}
+
+		// 其余块原样输出
+		chunk, err := sr.readChunk(next)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		chunkType := next.chunkType
+		next = nil
+
+		data := chunk[8 : len(chunk)-4]
+		switch chunkType {
+		case "IHDR":
+			var err error
+			if header, err = m.parsePNGHeader([]pngChunk{{chunkType: chunkType, data: data}}); err != nil {
+				return err
+			}
+		case "PLTE":
+			plte = data
+		case "tRNS":
+			trns = data
+		}
+		if chunkType == "IEND" {
+			if _, err := io.Copy(w, sr); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	if !idatSeen {
+		return fmt.Errorf("PNG文件缺少IDAT块")
+	}
+	return nil
+}
+
+// pngChunkHeader 流式读取时已读取的块长度和类型
+type pngChunkHeader struct {
+	start     int64 // 块在文件中的位置
+	length    uint32
+	chunkType string
+}
+
+// pngStreamReader 流式读取PNG，与 pngChunkReader 相同地检查块的长度、类型、资源限制和CRC，
+// 并记录已读取的字节数，用于报告出错块的位置
+type pngStreamReader struct {
+	m      *ImageModifier
+	r      io.Reader
+	offset int64 // 已读取的字节数
+	count  int64 // 已读取的块数量
+}
+
+func (sr *pngStreamReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.offset += int64(n)
+	return n, err
+}
+
+// readChunkHeader 读取并检查下一个块的长度和类型，文件恰好在块边界结束时返回 io.EOF
+func (sr *pngStreamReader) readChunkHeader() (*pngChunkHeader, error) {
+	start := sr.offset
+	var buf [8]byte
+	if n, err := io.ReadFull(sr, buf[:]); err != nil {
+		if n == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, &PNGChunkError{Offset: start, Reason: "不完整"}
+	}
+	next := &pngChunkHeader{start: start, length: binary.BigEndian.Uint32(buf[:4]), chunkType: string(buf[4:8])}
+	sr.count++
+	if err := sr.m.checkPNGChunkHeader(start, next.length, next.chunkType, sr.count); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// readChunk 读取头部已读取的块的其余部分并校验CRC，返回完整的块
+func (sr *pngStreamReader) readChunk(next *pngChunkHeader) ([]byte, error) {
+	chunk := make([]byte, 12+int64(next.length))
+	binary.BigEndian.PutUint32(chunk, next.length)
+	copy(chunk[4:8], next.chunkType)
+	if _, err := io.ReadFull(sr, chunk[8:]); err != nil {
+		return nil, &PNGChunkError{Offset: next.start, Type: next.chunkType, Reason: "不完整"}
+	}
+	dataEnd := len(chunk) - 4
+	if crc32.ChecksumIEEE(chunk[4:dataEnd]) != binary.BigEndian.Uint32(chunk[dataEnd:]) {
+		return nil, &PNGChunkError{Offset: next.start, Type: next.chunkType, Reason: "CRC校验失败"}
+	}
+	return chunk, nil
+}
+
+// streamPNGImageData 处理连续的IDAT块，first 为第一个IDAT块（长度和类型已读取）。
+// 第一遍（plan.edits 为nil）检查候选像素并记录选定的修改，返回 errPNGStreamPlanned；
+// 第二遍按 plan 修改并写出，返回IDAT之后下一个块的长度和类型，文件在IDAT之后结束时返回nil
+func (m *ImageModifier) streamPNGImageData(r *pngStreamReader, w io.Writer, header *pngHeader, plte, trns []byte, first *pngChunkHeader, plan *pngStreamPlan) (*pngChunkHeader, error) {
+	if err := m.checkPixels(header.width, header.height); err != nil {
+		return nil, err
+	}
+	var palette color.Palette
+	if header.colorType == pngColorPalette {
+		var err error
+		if palette, err = pngPalette(plte, trns); err != nil {
+			return nil, err
+		}
+	}
+	transparentKey := header.transparentKey(trns)
+
+	planning := plan.edits == nil
+	lastRow := plan.lastRow
+	var candidates []pngStreamEdit
+	var candidateRows []int         // 各候选所在的扫描行
+	var rowCandidates map[int][]int // 扫描行序号 -> 该行候选在 candidates 中的序号
+	var usable []bool               // 第一遍检查的结果：候选能否修改
+	var deltaErr error              // 最近一次因超出 PixelMaxDelta 或 MaxDeltaE 而无法修改的原因
+	if planning {
+		count, maxDelta := m.pixelBudget()
+		rng := m.pixelRand()
+		pixels, err := m.pixelCandidates(rng, header.width, header.height, count, pixelProbe{})
+		if err != nil {
+			return nil, err
+		}
+		rowCandidates = make(map[int][]int)
+		lastRow = -1
+		for i, pixel := range pixels {
+			step := pixelStep(rng, maxDelta)
+			if header.bitDepth < 8 {
+				step = step / abs(step)
+			} else if header.bitDepth == 16 {
+				step *= 257
+			}
+			row, column := header.scanlineOf(pixel.X, pixel.Y)
+			candidates = append(candidates, pngStreamEdit{column: column, step: step})
+			candidateRows = append(candidateRows, row)
+			rowCandidates[row] = append(rowCandidates[row], i)
+			if row > lastRow {
+				lastRow = row
+			}
+		}
+		usable = make([]bool, len(candidates))
+	}
+	// finishPlan 按候选顺序选出前 PixelCount 个可以修改的像素
+	finishPlan := func() error {
+		count, _ := m.pixelBudget()
+		plan.edits = make(map[int][]pngStreamEdit)
+		plan.lastRow = -1
+		for i, ok := range usable {
+			if !ok || count == 0 {
+				continue
+			}
+			row := candidateRows[i]
+			plan.edits[row] = append(plan.edits[row], candidates[i])
+			if row > plan.lastRow {
+				plan.lastRow = row
+			}
+			count--
+		}
+		if plan.lastRow < 0 {
+			if deltaErr != nil {
+				return deltaErr
+			}
+			return fmt.Errorf("找不到可以安全微调的像素")
+		}
+		return errPNGStreamPlanned
+	}
+
+	in := &idatReader{r: r, current: first, remaining: first.length, crc: crc32.NewIEEE()}
+	in.crc.Write([]byte("IDAT"))
+	zr, err := zlib.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("解压PNG图像数据失败: %v", err)
+	}
+	out := &idatWriter{w: w}
+	zw := zlib.NewWriter(out)
+
+	bpp := (header.bitsPerPixel() + 7) / 8
+	unfiltering := true
+	row := 0
+	for _, pass := range header.scanlinePasses() {
+		rowSize := (pass.width*header.bitsPerPixel() + 7) / 8
+		line := make([]byte, 1+rowSize)     // 过滤类型 + 原始的过滤后数据
+		prev := make([]byte, rowSize)       // 上一行还原过滤后的原始数据
+		cur := make([]byte, rowSize)        // 本行还原过滤后的原始数据
+		prevEdited := make([]byte, rowSize) // 上一行修改后的数据
+		curEdited := make([]byte, rowSize)
+		refiltered := make([]byte, 1+rowSize)
+		prevChanged := false
+
+		for y := 0; y < pass.height; y, row = y+1, row+1 {
+			if planning && row > lastRow {
+				// 所有候选都已检查，不需要读取其余的行
+				return nil, finishPlan()
+			}
+			if _, err := io.ReadFull(zr, line); err != nil {
+				return nil, fmt.Errorf("PNG图像数据不完整: %w", err)
+			}
+			if !unfiltering {
+				if _, err := zw.Write(line); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			filterType := line[0]
+			if filterType > 4 {
+				return nil, fmt.Errorf("无效的PNG过滤类型 %d", filterType)
+			}
+			copy(cur, line[1:])
+			pngUnfilterRow(filterType, cur, prev, bpp)
+			copy(curEdited, cur)
+
+			if planning {
+				// 候选互不重复，在同一行的副本上依次检查
+				for _, i := range rowCandidates[row] {
+					ok, err := m.adjustPNGRowPixel(header, curEdited, candidates[i], palette, transparentKey)
+					if err != nil {
+						deltaErr = err
+					}
+					usable[i] = ok && err == nil
+				}
+				prev, cur = cur, prev
+				continue
+			}
+
+			changed := false
+			for _, edit := range plan.edits[row] {
+				ok, err := m.adjustPNGRowPixel(header, curEdited, edit, palette, transparentKey)
+				if err != nil || !ok {
+					return nil, fmt.Errorf("PNG图像数据在两次读取之间发生了变化")
+				}
+				changed = true
+			}
+
+			output := line
+			if changed || prevChanged {
+				refiltered[0] = filterType
+				pngFilterRow(filterType, refiltered[1:], curEdited, prevEdited, bpp)
+				output = refiltered
+			}
+			if _, err := zw.Write(output); err != nil {
+				return nil, err
+			}
+
+			prev, cur = cur, prev
+			prevEdited, curEdited = curEdited, prevEdited
+			prevChanged = changed
+			// 后面没有要修改的行时，除了紧接着被修改行的一行，其余行不必还原过滤
+			unfiltering = changed || row < lastRow
+		}
+	}
+	if planning {
+		return nil, finishPlan()
+	}
+
+	// 读完压缩流（校验Adler-32）和剩余的IDAT数据
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return nil, fmt.Errorf("解压PNG图像数据失败: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, in); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := out.flush(); err != nil {
+		return nil, err
+	}
+	return in.next, nil
+}
+
+// adjustPNGRowPixel 在还原过滤后的扫描行中微调一个像素，该像素不适合修改时返回false
+func (m *ImageModifier) adjustPNGRowPixel(header *pngHeader, row []byte, edit pngStreamEdit, palette color.Palette, transparentKey []int) (bool, error) {
+	samples := header.rowSamples(row, edit.column)
+	if palette != nil {
+		index, err := m.adjustPaletteIndex(palette, uint8(samples[0]))
+		if err != nil {
+			return false, err
+		}
+		header.setRowSamples(row, edit.column, []int{int(index)})
+		return true, nil
+	}
+
+	adjusted, err := m.adjustPNGSamples(header, samples, edit.step, transparentKey)
+	if adjusted == nil || err != nil {
+		return false, err
+	}
+	header.setRowSamples(row, edit.column, adjusted)
+	return true, nil
+}
+
+// idatReader 依次读出连续IDAT块中的数据并校验CRC，遇到其他块时结束
+type idatReader struct {
+	r         *pngStreamReader
+	current   *pngChunkHeader // 当前块
+	remaining uint32          // 当前块中未读的数据长度
+	crc       hash.Hash32     // 当前块的CRC
+	next      *pngChunkHeader // IDAT之后下一个块的长度和类型
+	done      bool
+}
+
+func (ir *idatReader) Read(p []byte) (int, error) {
+	for ir.remaining == 0 {
+		if ir.done {
+			return 0, io.EOF
+		}
+		// 校验当前块的CRC，再读取下一个块的长度和类型
+		var trailer [4]byte
+		if _, err := io.ReadFull(ir.r, trailer[:]); err != nil {
+			return 0, &PNGChunkError{Offset: ir.current.start, Type: "IDAT", Reason: "不完整"}
+		}
+		if binary.BigEndian.Uint32(trailer[:]) != ir.crc.Sum32() {
+			return 0, &PNGChunkError{Offset: ir.current.start, Type: "IDAT", Reason: "CRC校验失败"}
+		}
+		next, err := ir.r.readChunkHeader()
+		if err == io.EOF {
+			ir.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if next.chunkType != "IDAT" {
+			ir.next, ir.done = next, true
+			continue
+		}
+		ir.current, ir.remaining = next, next.length
+		ir.crc = crc32.NewIEEE()
+		ir.crc.Write([]byte("IDAT"))
+	}
+
+	if uint32(len(p)) > ir.remaining {
+		p = p[:ir.remaining]
+	}
+	n, err := ir.r.Read(p)
+	ir.remaining -= uint32(n)
+	ir.crc.Write(p[:n])
+	if err == io.EOF {
+		err = &PNGChunkError{Offset: ir.current.start, Type: "IDAT", Reason: "不完整"}
+	}
+	return n, err
+}
+
+// idatWriter 把写入的压缩数据拆分为每块 pngIDATChunkSize 字节的IDAT块写出
+type idatWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (iw *idatWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := pngIDATChunkSize - len(iw.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		iw.buf = append(iw.buf, p[:n]...)
+		p = p[n:]
+		if len(iw.buf) == pngIDATChunkSize {
+			if err := iw.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush 写出缓冲的数据
+func (iw *idatWriter) flush() error {
+	if len(iw.buf) == 0 {
+		return nil
+	}
+	_, err := iw.w.Write(buildPNGChunk("IDAT", iw.buf))
+	iw.buf = iw.buf[:0]
+	return err
+}
@@ -0,0 +1,276 @@
+package imagemodify
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPNGRowSamples(t *testing.T) {
+	for _, h := range []pngHeader{
+		{bitDepth: 1, colorType: pngColorGray},
+		{bitDepth: 2, colorType: pngColorPalette},
+		{bitDepth: 4, colorType: pngColorGray},
+		{bitDepth: 8, colorType: pngColorRGB},
+		{bitDepth: 16, colorType: pngColorGrayAlpha},
+	} {
+		row := make([]byte, (9*h.bitsPerPixel()+7)/8)
+		for x := 0; x < 9; x++ {
+			samples := make([]int, h.channels())
+			for c := range samples {
+				samples[c] = (x*5 + c*3) % (h.maxSample() + 1)
+			}
+			h.setRowSamples(row, x, samples)
+		}
+		for x := 0; x < 9; x++ {
+			for c, v := range h.rowSamples(row, x) {
+				if want := (x*5 + c*3) % (h.maxSample() + 1); v != want {
+					t.Errorf("%d位 颜色类型 %d: 像素 %d 通道 %d 为 %d，期望 %d", h.bitDepth, h.colorType, x, c, v, want)
+				}
+			}
+		}
+	}
+}
+
+func TestPNGScanlineOf(t *testing.T) {
+	h := &pngHeader{width: 17, height: 11, interlace: 1}
+	seen := make(map[[2]int]bool)
+	for y := 0; y < 11; y++ {
+		for x := 0; x < 17; x++ {
+			row, column := h.scanlineOf(x, y)
+			if row < 0 || seen[[2]int{row, column}] {
+				t.Fatalf("像素 (%d,%d) 的扫描行位置 (%d,%d) 无效或重复", x, y, row, column)
+			}
+			seen[[2]int{row, column}] = true
+		}
+	}
+}
+
+func TestStreamPNGPixel(t *testing.T) {
+	for name, data := range testRecompressSources(t) {
+		for _, distribution := range []PixelDistribution{PixelDistributionEdge, PixelDistributionUniform} {
+			modifier := &ImageModifier{PixelCount: 5, PixelDistribution: distribution}
+			var out bytes.Buffer
+			if err := modifier.streamPNGPixel(bytes.NewReader(data), &out); err != nil {
+				t.Fatalf("%s: 流式像素微调失败: %v", name, err)
+			}
+			modified := out.Bytes()
+			if changed := countChangedPixels(t, data, modified); changed != 5 {
+				t.Errorf("%s: 应改变5个像素，实际为 %d", name, changed)
+			}
+
+			// IDAT以外的块保持逐字节不变
+			before, _ := modifier.parsePNGChunks(data)
+			after, err := modifier.parsePNGChunks(modified)
+			if err != nil {
+				t.Fatalf("%s: 解析修改后的PNG失败: %v", name, err)
+			}
+			var kept, original []string
+			for _, chunk := range before {
+				if chunk.chunkType != "IDAT" {
+					original = append(original, string(data[chunk.start:chunk.end]))
+				}
+			}
+			for _, chunk := range after {
+				if chunk.chunkType != "IDAT" {
+					kept = append(kept, string(modified[chunk.start:chunk.end]))
+				}
+			}
+			if strings.Join(kept, "") != strings.Join(original, "") {
+				t.Errorf("%s: IDAT以外的块发生了变化", name)
+			}
+		}
+	}
+}
+
+func TestStreamPNGPixelKeepsTransparentKey(t *testing.T) {
+	// 左半部分是透明色键，右半部分不透明
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			c := color.RGBA{255, 255, 255, 255}
+			if x >= 10 {
+				c = color.RGBA{uint8(x * 9), uint8(y * 11), 30, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+	modifier := NewImageModifier()
+	chunks, _ := modifier.parsePNGChunks(buf.Bytes())
+	var file bytes.Buffer
+	file.Write(pngSignature)
+	for _, chunk := range chunks {
+		file.Write(buf.Bytes()[chunk.start:chunk.end])
+		if chunk.chunkType == "IHDR" {
+			file.Write(buildPNGChunk("tRNS", []byte{0, 255, 0, 255, 0, 255}))
+		}
+	}
+	data := file.Bytes()
+
+	for i := 0; i < 5; i++ {
+		var out bytes.Buffer
+		if err := (&ImageModifier{PixelCount: 5, PixelDistribution: PixelDistributionUniform}).streamPNGPixel(bytes.NewReader(data), &out); err != nil {
+			t.Fatalf("流式像素微调失败: %v", err)
+		}
+		points := changedPixels(t, data, out.Bytes())
+		if len(points) != 5 {
+			t.Fatalf("应改变5个像素，实际为 %d", len(points))
+		}
+		for _, p := range points {
+			if p.X < 10 {
+				t.Fatalf("修改了透明色键像素 %v", p)
+			}
+		}
+	}
+}
+
+func TestStreamPNGPixelFallsBackToEarlierRows(t *testing.T) {
+	// 只有第一行不是透明色键：排在前面的候选大多落在后面的行且无法修改，
+	// 只能使用已经读过的行中靠后的候选，结果应与完整解码时一样修改 PixelCount 个像素
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.RGBA{255, 255, 255, 255}
+			if y == 0 {
+				c = color.RGBA{uint8(x * 9), 40, 30, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+	chunks, _ := NewImageModifier().parsePNGChunks(buf.Bytes())
+	var file bytes.Buffer
+	file.Write(pngSignature)
+	for _, chunk := range chunks {
+		file.Write(buf.Bytes()[chunk.start:chunk.end])
+		if chunk.chunkType == "IHDR" {
+			file.Write(buildPNGChunk("tRNS", []byte{0, 255, 0, 255, 0, 255}))
+		}
+	}
+	data := file.Bytes()
+
+	for _, count := range []int{1, 3} {
+		modifier := &ImageModifier{PixelCount: count}
+		for i := 0; i < 10; i++ {
+			var out bytes.Buffer
+			if err := modifier.streamPNGPixel(bytes.NewReader(data), &out); err != nil {
+				t.Fatalf("流式像素微调失败: %v", err)
+			}
+			points := changedPixels(t, data, out.Bytes())
+			if len(points) != count {
+				t.Fatalf("应改变 %d 个像素，实际为 %d", count, len(points))
+			}
+			for _, p := range points {
+				if p.Y != 0 {
+					t.Fatalf("修改了透明色键像素 %v", p)
+				}
+			}
+		}
+	}
+}
+
+func TestStreamPNGPixelAPNG(t *testing.T) {
+	data := createTestAPNG(t)
+	var out bytes.Buffer
+	if err := NewImageModifier().streamPNGPixel(bytes.NewReader(data), &out); err != nil {
+		t.Fatalf("流式像素微调失败: %v", err)
+	}
+	chunks, err := NewImageModifier().parsePNGChunks(out.Bytes())
+	if err != nil {
+		t.Fatalf("解析修改后的APNG失败: %v", err)
+	}
+	if _, err := NewImageModifier().checkAPNG(chunks); err != nil {
+		t.Errorf("修改后的APNG结构无效: %v", err)
+	}
+	if changed := countChangedPixels(t, data, out.Bytes()); changed != 1 {
+		t.Errorf("应改变1个像素，实际为 %d", changed)
+	}
+}
+
+func TestStreamPNGPixelRejectsCorruptData(t *testing.T) {
+	data := testRecompressSources(t)["rgba64"]
+	modifier := NewImageModifier()
+	chunks, _ := modifier.parsePNGChunks(data)
+	for _, chunk := range chunks {
+		if chunk.chunkType != "IDAT" {
+			continue
+		}
+		corrupt := append([]byte(nil), data...)
+		corrupt[chunk.end-1] ^= 0xFF
+		if err := modifier.streamPNGPixel(bytes.NewReader(corrupt), &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "CRC") {
+			t.Errorf("IDAT的CRC错误应被检测到，实际为 %v", err)
+		}
+		break
+	}
+
+	if err := modifier.streamPNGPixel(bytes.NewReader(data[:len(data)/2]), &bytes.Buffer{}); err == nil {
+		t.Error("截断的PNG应返回错误")
+	}
+}
+
+func TestModifyImageSHA1ByPixelPNGStreaming(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.png")
+	data := append(createTestBorderedPNG(t), "trailing"...)
+	if err := os.WriteFile(testFile, data, 0600); err != nil {
+		t.Fatalf("写入测试文件失败: %v", err)
+	}
+
+	modifier := &ImageModifier{PNGStreaming: true, PixelCount: 3}
+	result, err := modifier.ModifyImageSHA1ByPixelResult(testFile)
+	if err != nil {
+		t.Fatalf("流式像素微调失败: %v", err)
+	}
+	modified, _ := os.ReadFile(testFile)
+	if sha1, _ := modifier.GetImageSHA1(testFile); sha1 != result.SHA1 {
+		t.Errorf("返回的SHA1 %s 与文件不一致 %s", result.SHA1, sha1)
+	}
+	if changed := countChangedPixels(t, data, modified); changed != 3 {
+		t.Errorf("应改变3个像素，实际为 %d", changed)
+	}
+	if !bytes.HasSuffix(modified, []byte("trailing")) {
+		t.Error("IEND之后的数据丢失")
+	}
+	if info, _ := os.Stat(testFile); info.Mode().Perm() != 0600 {
+		t.Errorf("文件权限变为 %v", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("临时文件没有清理: %d 个文件", len(entries))
+	}
+
+	// 区域掩码同样适用
+	modifier = &ImageModifier{PNGStreaming: true, PixelMask: &PixelMask{Exclude: []image.Rectangle{image.Rect(0, 0, 1000, 1000)}}}
+	if _, err := modifier.ModifyImageSHA1ByPixel(testFile); !errors.Is(err, ErrNoEligiblePixel) {
+		t.Errorf("应返回 ErrNoEligiblePixel，实际为 %v", err)
+	}
+}
+
+func BenchmarkStreamPNGPixel(b *testing.B) {
+	_, img := benchmarkPNGImage(1024, 768)
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, img); err != nil {
+		b.Fatal(err)
+	}
+	modifier := NewImageModifier()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := modifier.streamPNGPixel(bytes.NewReader(buf.Bytes()), io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// QOI 格式：
+// 14字节文件头 ["qoif"][宽 4字节][高 4字节][通道数 3/4][色彩空间 0/1]
+// 之后是编码块序列，以 7个0x00 + 0x01 的结束标记结尾
+
+const (
+	qoiHeaderSize = 14
+
+	qoiOpIndex = 0x00 // 00xxxxxx
+	qoiOpDiff  = 0x40 // 01xxxxxx
+	qoiOpLuma  = 0x80 // 10xxxxxx
+	qoiOpRun   = 0xC0 // 11xxxxxx
+	qoiOpRGB   = 0xFE
+	qoiOpRGBA  = 0xFF
+	qoiMask2   = 0xC0
+)
+
+// qoiMagic QOI文件魔数
+var qoiMagic = []byte("qoif")
+
+// qoiEndMarker QOI结束标记
+var qoiEndMarker = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+// qoiImage 解码后的QOI图像
+type qoiImage struct {
+	img        *image.NRGBA // 像素数据（3通道图像的alpha固定为255）
+	channels   byte         // 通道数
+	colorspace byte         // 色彩空间（0=sRGB+线性alpha，1=全线性）
+	trailer    []byte       // 结束标记之后的附加数据
+}
+
+// isQOI 判断数据是否为QOI图像
+func (m *ImageModifier) isQOI(data []byte) bool {
+	return len(data) >= qoiHeaderSize && bytes.Equal(data[:4], qoiMagic)
+}
+
+// qoiHash QOI颜色索引哈希
+func qoiHash(c color.NRGBA) int {
+	return (int(c.R)*3 + int(c.G)*5 + int(c.B)*7 + int(c.A)*11) % 64
+}
+
+// decodeQOI 解码QOI图像
+func (m *ImageModifier) decodeQOI(data []byte) (*qoiImage, error) {
+	if !m.isQOI(data) {
+		return nil, fmt.Errorf("不是有效的QOI文件")
+	}
+
+	width := int(binary.BigEndian.Uint32(data[4:8]))
+	height := int(binary.BigEndian.Uint32(data[8:12]))
+	channels := data[12]
+	colorspace := data[13]
+	if width <= 0 || height <= 0 || (channels != 3 && channels != 4) || colorspace > 1 {
+		return nil, fmt.Errorf("QOI文件头参数无效")
+	}
+	// 每个像素至少占用编码数据中的1/62字节，据此拒绝声明尺寸与数据量明显不符的文件
+	if int64(width)*int64(height) > int64(len(data))*62 {
+		return nil, fmt.Errorf("QOI图像尺寸与数据大小不符")
+	}
+	if err := m.checkPixels(width, height); err != nil {
+		return nil, err
+	}
+
+	result := &qoiImage{
+		img:        image.NewNRGBA(image.Rect(0, 0, width, height)),
+		channels:   channels,
+		colorspace: colorspace,
+	}
+
+	var index [64]color.NRGBA
+	px := color.NRGBA{A: 255}
+	pos := qoiHeaderSize
+	run := 0
+	pix := result.img.Pix
+
+	for i := 0; i < len(pix); i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			if pos >= len(data) {
+				return nil, fmt.Errorf("QOI像素数据不完整")
+			}
+			b1 := data[pos]
+			pos++
+
+			switch {
+			case b1 == qoiOpRGB:
+				if pos+3 > len(data) {
+					return nil, fmt.Errorf("QOI像素数据不完整")
+				}
+				px.R, px.G, px.B = data[pos], data[pos+1], data[pos+2]
+				pos += 3
+			case b1 == qoiOpRGBA:
+				if pos+4 > len(data) {
+					return nil, fmt.Errorf("QOI像素数据不完整")
+				}
+				px.R, px.G, px.B, px.A = data[pos], data[pos+1], data[pos+2], data[pos+3]
+				pos += 4
+			case b1&qoiMask2 == qoiOpIndex:
+				px = index[b1]
+			case b1&qoiMask2 == qoiOpDiff:
+				px.R += (b1>>4)&0x03 - 2
+				px.G += (b1>>2)&0x03 - 2
+				px.B += b1&0x03 - 2
+			case b1&qoiMask2 == qoiOpLuma:
+				if pos >= len(data) {
+					return nil, fmt.Errorf("QOI像素数据不完整")
+				}
+				b2 := data[pos]
+				pos++
+				vg := (b1 & 0x3F) - 32
+				px.R += vg - 8 + (b2>>4)&0x0F
+				px.G += vg
+				px.B += vg - 8 + b2&0x0F
+			case b1&qoiMask2 == qoiOpRun:
+				run = int(b1 & 0x3F)
+			}
+
+			index[qoiHash(px)] = px
+		}
+
+		pix[i], pix[i+1], pix[i+2], pix[i+3] = px.R, px.G, px.B, px.A
+	}
+
+	if pos+len(qoiEndMarker) > len(data) || !bytes.Equal(data[pos:pos+len(qoiEndMarker)], qoiEndMarker) {
+		return nil, fmt.Errorf("QOI文件缺少结束标记")
+	}
+	result.trailer = data[pos+len(qoiEndMarker):]
+
+	return result, nil
+}
+
+// encode 将图像编码为QOI，并保留原有的附加数据
+func (q *qoiImage) encode() []byte {
+	bounds := q.img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	var buf bytes.Buffer
+	header := make([]byte, qoiHeaderSize)
+	copy(header, qoiMagic)
+	binary.BigEndian.PutUint32(header[4:8], uint32(width))
+	binary.BigEndian.PutUint32(header[8:12], uint32(height))
+	header[12] = q.channels
+	header[13] = q.colorspace
+	buf.Write(header)
+
+	var index [64]color.NRGBA
+	prev := color.NRGBA{A: 255}
+	run := 0
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			px := q.img.NRGBAAt(x, y)
+			if q.channels == 3 {
+				px.A = 255
+			}
+
+			if px == prev {
+				run++
+				if run == 62 {
+					buf.WriteByte(qoiOpRun | byte(run-1))
+					run = 0
+				}
+				continue
+			}
+
+			if run > 0 {
+				buf.WriteByte(qoiOpRun | byte(run-1))
+				run = 0
+			}
+
+			hash := qoiHash(px)
+			switch {
+			case index[hash] == px:
+				buf.WriteByte(qoiOpIndex | byte(hash))
+			case px.A == prev.A:
+				index[hash] = px
+				vr := int(int8(px.R - prev.R))
+				vg := int(int8(px.G - prev.G))
+				vb := int(int8(px.B - prev.B))
+				vgr := vr - vg
+				vgb := vb - vg
+
+				if vr >= -2 && vr <= 1 && vg >= -2 && vg <= 1 && vb >= -2 && vb <= 1 {
+					buf.WriteByte(qoiOpDiff | byte(vr+2)<<4 | byte(vg+2)<<2 | byte(vb+2))
+				} else if vgr >= -8 && vgr <= 7 && vg >= -32 && vg <= 31 && vgb >= -8 && vgb <= 7 {
+					buf.WriteByte(qoiOpLuma | byte(vg+32))
+					buf.WriteByte(byte(vgr+8)<<4 | byte(vgb+8))
+				} else {
+					buf.Write([]byte{qoiOpRGB, px.R, px.G, px.B})
+				}
+			default:
+				index[hash] = px
+				buf.Write([]byte{qoiOpRGBA, px.R, px.G, px.B, px.A})
+			}
+
+			prev = px
+		}
+	}
+
+	if run > 0 {
+		buf.WriteByte(qoiOpRun | byte(run-1))
+	}
+
+	buf.Write(qoiEndMarker)
+	buf.Write(q.trailer)
+	return buf.Bytes()
+}
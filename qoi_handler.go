@@ -0,0 +1,67 @@
+package imagemodify
+
+import (
+	"fmt"
+)
+
+// appendQOITrailer 在QOI结束标记之后追加随机字节
+// 解码器在读完全部像素和结束标记后停止，附加数据不影响显示
+func (m *ImageModifier) appendQOITrailer(data []byte, randomData []byte) ([]byte, error) {
+	if !m.isQOI(data) {
+		return nil, fmt.Errorf("不是有效的QOI文件")
+	}
+
+	result := make([]byte, 0, len(data)+len(randomData))
+	result = append(result, data...)
+	result = append(result, randomData...)
+	return result, nil
+}
+
+// modifyQOIPixel 通过无损微调边缘像素修改QOI图像，设置了 MaxDeltaE 时在ΔE2000约束下调整
+// 被修改像素的纹理强度记录在 result 中
+func (m *ImageModifier) modifyQOIPixel(data []byte, result *PixelModifyResult) ([]byte, error) {
+	img, err := m.decodeQOI(data)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.img.Bounds()
+	texture := m.imageTexture(img.img)
+	candidates, err := m.pixelCandidates(m.pixelRand(), bounds.Dx(), bounds.Dy(), 1, pixelProbe{
+		texture: texture,
+		uniform: imageUniform(img.img),
+	})
+	if err != nil {
+		return nil, err
+	}
+	selectedPixel := candidates[0]
+	result.TextureScore = texture(selectedPixel.X, selectedPixel.Y)
+
+	adjustment := 1
+	if m.generateRandomBytes(1)[0]&0x80 != 0 {
+		adjustment = -1
+	}
+
+	// QOI是无损格式，RGB各 ±1 的修改会被精确保存
+	c := img.img.NRGBAAt(selectedPixel.X, selectedPixel.Y)
+	if m.MaxDeltaE > 0 {
+		adjusted, err := m.perceptualAdjust([]int{int(c.R), int(c.G), int(c.B)}, 255, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.R, c.G, c.B = uint8(adjusted[0]), uint8(adjusted[1]), uint8(adjusted[2])
+		img.img.SetNRGBA(selectedPixel.X, selectedPixel.Y, c)
+		return img.encode(), nil
+	}
+	adjust := func(v uint8) uint8 {
+		n := int(v) + adjustment
+		if n < 0 || n > 255 {
+			n = int(v) - adjustment
+		}
+		return uint8(n)
+	}
+	c.R, c.G, c.B = adjust(c.R), adjust(c.G), adjust(c.B)
+	img.img.SetNRGBA(selectedPixel.X, selectedPixel.Y, c)
+
+	return img.encode(), nil
+}
@@ -0,0 +1,92 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestQOI 创建测试用的QOI图像数据
+func createTestQOI(channels byte) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			switch {
+			case x < 10:
+				img.SetNRGBA(x, y, color.NRGBA{200, 10, 10, 255}) // 连续相同像素
+			case x < 20:
+				img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x + y), 255}) // 小差值
+			default:
+				img.SetNRGBA(x, y, color.NRGBA{uint8(x * 37), uint8(y * 91), uint8(x * y), uint8(255 - x)})
+			}
+		}
+	}
+	q := &qoiImage{img: img, channels: channels}
+	return q.encode()
+}
+
+// TestQOIRoundTrip 测试QOI编解码的一致性
+func TestQOIRoundTrip(t *testing.T) {
+	modifier := NewImageModifier()
+	for _, channels := range []byte{3, 4} {
+		data := createTestQOI(channels)
+		img, err := modifier.decodeQOI(data)
+		if err != nil {
+			t.Fatalf("QOI解码失败: %v", err)
+		}
+		if !bytes.Equal(img.encode(), data) {
+			t.Errorf("QOI重新编码后数据不一致 (channels=%d)", channels)
+		}
+		if channels == 4 && img.img.NRGBAAt(30, 5) != (color.NRGBA{30 * 37 % 256, 5 * 91 % 256, 150, 225}) {
+			t.Errorf("QOI像素解码错误: %v", img.img.NRGBAAt(30, 5))
+		}
+	}
+}
+
+// TestModifyQOI 测试QOI格式的随机数据和像素微调修改
+func TestModifyQOI(t *testing.T) {
+	for _, pixel := range []bool{false, true} {
+		testFile := filepath.Join(t.TempDir(), "test.qoi")
+		original := createTestQOI(4)
+		if err := os.WriteFile(testFile, original, 0644); err != nil {
+			t.Fatalf("创建测试QOI失败: %v", err)
+		}
+
+		modifier := NewImageModifier()
+		var err error
+		if pixel {
+			_, err = modifier.ModifyImageSHA1ByPixel(testFile)
+		} else {
+			_, err = modifier.ModifyImageSHA1(testFile)
+		}
+		if err != nil {
+			t.Fatalf("修改QOI失败 (pixel=%v): %v", pixel, err)
+		}
+
+		data, err := os.ReadFile(testFile)
+		if err != nil {
+			t.Fatalf("读取修改后的文件失败: %v", err)
+		}
+		before, _ := modifier.decodeQOI(original)
+		after, err := modifier.decodeQOI(data)
+		if err != nil {
+			t.Fatalf("修改后的文件不是有效的QOI: %v", err)
+		}
+
+		changedPixels := 0
+		for i := 0; i < len(before.img.Pix); i += 4 {
+			if !bytes.Equal(before.img.Pix[i:i+4], after.img.Pix[i:i+4]) {
+				changedPixels++
+			}
+		}
+		if pixel && changedPixels != 1 {
+			t.Errorf("像素模式应修改1个像素，实际修改了%d个", changedPixels)
+		}
+		if !pixel && changedPixels != 0 {
+			t.Error("随机模式不应修改像素")
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// svgNamespace SVG元素的命名空间
+const svgNamespace = "http://www.w3.org/2000/svg"
+
+// svgElementRange 元素在原始文档中的字节范围 [Start, End)
+type svgElementRange struct {
+	Start, End int
+}
+
+// svgDocument 记录SVG文档中与修改相关的字节位置
+// 所有修改都基于这些位置对原始字节做局部替换，其余内容保持逐字节不变
+type svgDocument struct {
+	rootStart   int    // 根元素开始标签的起始位置
+	rootEnd     int    // 根元素开始标签的结束位置
+	rootName    string // 根元素在文档中的原始标签名（可能带前缀，如 svg:svg）
+	selfClosing bool   // 根元素是否为自闭合标签 <svg/>
+
+	// 根元素上的命名空间声明（子元素中的前缀可能在这里定义）
+	rootNamespaces []xml.Attr
+
+	// 根元素下第一个 title/desc/metadata 子元素的位置
+	children map[string]svgElementRange
+
+	// 从文档中读取到的元数据
+	metadata ImageMetadata
+}
+
+// parseSVGDocument 扫描SVG文档，定位根元素及title/desc/metadata子元素
+func (m *ImageModifier) parseSVGDocument(data []byte) (*svgDocument, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// 非严格模式可以容忍Illustrator等工具导出的自定义实体
+	decoder.Strict = false
+
+	doc := &svgDocument{children: make(map[string]svgElementRange)}
+	depth := 0
+	rootFound := false
+
+	// 正在读取的子元素
+	var childName string
+	var childStart int
+	var childText bytes.Buffer
+	var rdf *rdfCollector
+	childDepth := 0
+
+	for {
+		start := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析SVG失败: %v", err)
+		}
+		end := int(decoder.InputOffset())
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if !rootFound {
+				if t.Name.Local != "svg" {
+					return nil, fmt.Errorf("不是有效的SVG文件")
+				}
+				rootFound = true
+				doc.rootStart = start
+				doc.rootEnd = end
+				doc.rootName = m.rawSVGTagName(data[start:end])
+				doc.selfClosing = bytes.HasSuffix(data[start:end], []byte("/>"))
+				for _, attr := range t.Attr {
+					if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+						doc.rootNamespaces = append(doc.rootNamespaces, attr)
+					}
+				}
+				depth = 1
+				continue
+			}
+
+			depth++
+			if childName != "" {
+				childDepth++
+				if rdf != nil {
+					rdf.handle(t)
+				}
+				continue
+			}
+
+			// 只处理根元素的直接子元素，且每种只取第一个
+			if depth == 2 && (t.Name.Space == svgNamespace || t.Name.Space == "") {
+				switch t.Name.Local {
+				case "title", "desc", "metadata":
+					if _, exists := doc.children[t.Name.Local]; !exists {
+						childName = t.Name.Local
+						childStart = start
+						childText.Reset()
+						childDepth = 0
+						rdf = nil
+						if childName == "metadata" {
+							rdf = &rdfCollector{modifier: m, metadata: &doc.metadata}
+						}
+					}
+				}
+			}
+		case xml.CharData:
+			if childName != "" {
+				childText.Write(t)
+				if rdf != nil {
+					rdf.handle(t)
+				}
+			}
+		case xml.EndElement:
+			depth--
+			if childName == "" {
+				continue
+			}
+			if childDepth > 0 {
+				childDepth--
+				if rdf != nil {
+					rdf.handle(t)
+				}
+				continue
+			}
+
+			// 子元素结束
+			doc.children[childName] = svgElementRange{Start: childStart, End: end}
+			text := string(bytes.TrimSpace(childText.Bytes()))
+			switch childName {
+			case "title":
+				doc.metadata.Title = text
+			case "desc":
+				doc.metadata.Description = text
+			}
+			childName = ""
+			rdf = nil
+		}
+
+		if rootFound && depth == 0 {
+			break
+		}
+	}
+
+	if !rootFound {
+		return nil, fmt.Errorf("不是有效的SVG文件")
+	}
+
+	return doc, nil
+}
+
+// rawSVGTagName 从开始标签的原始字节中取出标签名（保留命名空间前缀）
+func (m *ImageModifier) rawSVGTagName(tag []byte) string {
+	name := bytes.TrimPrefix(tag, []byte("<"))
+	if i := bytes.IndexAny(name, " \t\r\n/>"); i >= 0 {
+		name = name[:i]
+	}
+	return string(name)
+}
+
+// svgChildName 生成与根元素使用相同命名空间前缀的子元素名
+func (m *ImageModifier) svgChildName(doc *svgDocument, local string) string {
+	if i := strings.IndexByte(doc.rootName, ':'); i >= 0 {
+		return doc.rootName[:i+1] + local
+	}
+	return local
+}
+
+// insertSVGComment 在SVG根元素之前插入XML注释
+// 注释不参与渲染，插入位置之外的内容保持逐字节不变
+func (m *ImageModifier) insertSVGComment(data []byte, randomData []byte) ([]byte, error) {
+	doc, err := m.parseSVGDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// 随机数据使用十六进制编码，避免出现 "--" 等注释中非法的字符序列
+	comment := []byte("<!-- imagemodify:" + hex.EncodeToString(randomData) + " -->\n")
+
+	result := make([]byte, 0, len(data)+len(comment))
+	result = append(result, data[:doc.rootStart]...) // 根元素之前的内容（XML声明、DOCTYPE等）
+	result = append(result, comment...)              // 注释
+	result = append(result, data[doc.rootStart:]...) // 根元素及之后的内容
+
+	return result, nil
+}
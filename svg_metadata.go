@@ -0,0 +1,313 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// svgEdit 对原始文档的一次局部替换
+type svgEdit struct {
+	start, end  int
+	replacement []byte
+}
+
+// modifySVGMetadata 修改SVG图片的 <title>、<desc> 和 <metadata> 元素
+// 已存在的 title/desc 原地替换，已存在的 metadata 只替换其中的相关RDF属性，
+// 不存在的元素插入到根元素开始标签之后，文档其余部分保持不变
+func (m *ImageModifier) modifySVGMetadata(data []byte, metadata *ImageMetadata) ([]byte, error) {
+	doc, err := m.parseSVGDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按SVG规范，title 和 desc 应位于最前面
+	elements := []struct {
+		name    string
+		content []byte
+	}{
+		{"title", m.buildSVGTextElement(doc, "title", metadata.Title)},
+		{"desc", m.buildSVGTextElement(doc, "desc", metadata.Description)},
+		{"metadata", m.buildSVGMetadataElement(doc, metadata)},
+	}
+
+	var edits []svgEdit
+	var inserted bytes.Buffer
+
+	for _, element := range elements {
+		if existing, ok := doc.children[element.name]; ok {
+			if element.name == "metadata" {
+				// 已有的RDF描述只替换元数据模式写入的属性
+				metadataEdits, err := m.buildSVGMetadataEdits(data, doc, existing, metadata)
+				if err != nil {
+					return nil, err
+				}
+				edits = append(edits, metadataEdits...)
+				continue
+			}
+			// 原地替换（内容为空时即删除该元素）
+			edits = append(edits, svgEdit{start: existing.Start, end: existing.End, replacement: element.content})
+			continue
+		}
+		if len(element.content) > 0 {
+			inserted.WriteString("\n")
+			inserted.Write(element.content)
+		}
+	}
+
+	if inserted.Len() > 0 {
+		if doc.selfClosing {
+			// <svg .../> 需要展开为 <svg ...>子元素</svg>
+			tag := data[doc.rootStart:doc.rootEnd]
+			replacement := append([]byte{}, bytes.TrimSuffix(tag, []byte("/>"))...)
+			replacement = append(replacement, '>')
+			replacement = append(replacement, inserted.Bytes()...)
+			replacement = append(replacement, []byte("\n</"+doc.rootName+">")...)
+			edits = append(edits, svgEdit{start: doc.rootStart, end: doc.rootEnd, replacement: replacement})
+		} else {
+			edits = append(edits, svgEdit{start: doc.rootEnd, end: doc.rootEnd, replacement: inserted.Bytes()})
+		}
+	}
+
+	return m.applySVGEdits(data, edits), nil
+}
+
+// buildSVGTextElement 构造 <title> 或 <desc> 元素，值为空时返回nil
+func (m *ImageModifier) buildSVGTextElement(doc *svgDocument, local, value string) []byte {
+	if value == "" {
+		return nil
+	}
+
+	name := m.svgChildName(doc, local)
+	var buf bytes.Buffer
+	buf.WriteString("<" + name + ">")
+	xml.EscapeText(&buf, []byte(value))
+	buf.WriteString("</" + name + ">")
+	return buf.Bytes()
+}
+
+// buildSVGMetadataElement 构造包含RDF/Dublin Core描述的 <metadata> 元素
+func (m *ImageModifier) buildSVGMetadataElement(doc *svgDocument, metadata *ImageMetadata) []byte {
+	name := m.svgChildName(doc, "metadata")
+	var buf bytes.Buffer
+	buf.WriteString("<" + name + ">")
+	buf.Write(m.buildRDFMetadata(metadata))
+	buf.WriteString("</" + name + ">")
+	return buf.Bytes()
+}
+
+// svgRDFLayout 已有 <metadata> 元素中RDF描述的位置（均为原始文档中的偏移量，-1表示不存在）
+type svgRDFLayout struct {
+	metadataClose  int               // </metadata> 的起始位置
+	rdfClose       int               // 第一个 rdf:RDF 结束标签的起始位置
+	containerClose int               // rdf:RDF 下第一个非自闭合属性容器（rdf:Description 或 cc:Work 等类型节点）结束标签的起始位置
+	namespaces     map[string]string // 插入位置（属性容器，没有时为 rdf:RDF）处有效的前缀 -> 命名空间
+	properties     []svgElementRange // 需要替换的已有属性元素
+}
+
+// RDF描述中元素的角色
+const (
+	svgRDFOther = iota
+	svgRDFRoot
+	svgRDFContainer
+	svgRDFProperty
+)
+
+// parseSVGRDFLayout 扫描已有的 <metadata> 元素
+// 元素片段包在带有根元素命名空间声明的临时元素中解析，这样才能识别在根元素上定义的前缀
+func (m *ImageModifier) parseSVGRDFLayout(data []byte, doc *svgDocument, element svgElementRange) (*svgRDFLayout, error) {
+	var wrapped bytes.Buffer
+	wrapped.WriteString("<svg")
+	for _, attr := range doc.rootNamespaces {
+		name := "xmlns"
+		if attr.Name.Space == "xmlns" {
+			name += ":" + attr.Name.Local
+		}
+		wrapped.WriteString(" " + name + `="`)
+		xml.EscapeText(&wrapped, []byte(attr.Value))
+		wrapped.WriteString(`"`)
+	}
+	wrapped.WriteString(">")
+	offset := element.Start - wrapped.Len()
+	wrapped.Write(data[element.Start:element.End])
+	wrapped.WriteString("</svg>")
+
+	decoder := xml.NewDecoder(bytes.NewReader(wrapped.Bytes()))
+	decoder.Strict = false
+
+	type frame struct {
+		start      int
+		role       int
+		namespaces map[string]string
+	}
+	layout := &svgRDFLayout{metadataClose: -1, rdfClose: -1, containerClose: -1}
+	stack := []frame{{namespaces: map[string]string{}}}
+	rdfFound := false
+
+	for {
+		start := offset + int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析SVG元数据失败: %v", err)
+		}
+		end := offset + int(decoder.InputOffset())
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			parent := stack[len(stack)-1]
+			current := frame{start: start, namespaces: parent.namespaces}
+			copied := false
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" {
+					if !copied {
+						current.namespaces, copied = copyNamespaces(parent.namespaces), true
+					}
+					current.namespaces[attr.Name.Local] = attr.Value
+				}
+			}
+			switch {
+			case !rdfFound && len(stack) >= 2 && t.Name.Space == nsRDF && t.Name.Local == "RDF":
+				rdfFound = true
+				current.role = svgRDFRoot
+			case parent.role == svgRDFRoot:
+				current.role = svgRDFContainer
+			case parent.role == svgRDFContainer && rdfManagedProperty(t.Name):
+				current.role = svgRDFProperty
+			}
+			stack = append(stack, current)
+		case xml.EndElement:
+			current := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			// 自闭合标签的结束记号不占用字节
+			selfClosing := start == end
+			switch current.role {
+			case svgRDFRoot:
+				if !selfClosing {
+					layout.rdfClose = start
+				}
+				if layout.containerClose < 0 {
+					layout.namespaces = current.namespaces
+				}
+			case svgRDFContainer:
+				if !selfClosing && layout.containerClose < 0 {
+					layout.containerClose = start
+					layout.namespaces = current.namespaces
+				}
+			case svgRDFProperty:
+				layout.properties = append(layout.properties, svgElementRange{Start: current.start, End: end})
+			}
+			if len(stack) == 2 && !selfClosing {
+				layout.metadataClose = start
+			}
+		}
+	}
+
+	return layout, nil
+}
+
+// copyNamespaces 复制命名空间作用域，子元素新增的声明不影响父元素
+func copyNamespaces(namespaces map[string]string) map[string]string {
+	result := make(map[string]string, len(namespaces)+1)
+	for prefix, uri := range namespaces {
+		result[prefix] = uri
+	}
+	return result
+}
+
+// buildSVGMetadataEdits 修改已有的 <metadata> 元素：删除RDF描述中元数据模式写入的属性，
+// 再把新的属性插入到第一个属性容器中，其他RDF内容（如 cc:Work 的许可证、其他命名空间的属性）保持不变
+func (m *ImageModifier) buildSVGMetadataEdits(data []byte, doc *svgDocument, element svgElementRange, metadata *ImageMetadata) ([]svgEdit, error) {
+	layout, err := m.parseSVGRDFLayout(data, doc, element)
+	if err != nil {
+		return nil, err
+	}
+	if layout.metadataClose < 0 {
+		// 自闭合的 <metadata/> 没有内容，直接替换
+		return []svgEdit{{start: element.Start, end: element.End, replacement: m.buildSVGMetadataElement(doc, metadata)}}, nil
+	}
+
+	var edits []svgEdit
+	for _, property := range layout.properties {
+		// 连同属性元素之前的缩进一起删除
+		start := property.Start
+		for start > element.Start && strings.IndexByte(" \t\r\n", data[start-1]) >= 0 {
+			start--
+		}
+		edits = append(edits, svgEdit{start: start, end: property.End})
+	}
+
+	var properties bytes.Buffer
+	var insert []byte
+	var pos int
+	switch {
+	case layout.containerClose >= 0:
+		m.writeRDFProperties(&properties, metadata, svgMissingNamespaces(layout.namespaces))
+		insert, pos = properties.Bytes(), layout.containerClose
+	case layout.rdfClose >= 0:
+		m.writeRDFProperties(&properties, metadata, "")
+		if properties.Len() > 0 {
+			description := `<rdf:Description` + svgMissingNamespaces(layout.namespaces) + ` rdf:about="">`
+			insert = append(append([]byte(description), properties.Bytes()...), "</rdf:Description>"...)
+		}
+		pos = layout.rdfClose
+	default:
+		m.writeRDFProperties(&properties, metadata, "")
+		if properties.Len() > 0 {
+			insert = m.buildRDFMetadata(metadata)
+		}
+		pos = layout.metadataClose
+	}
+	if len(insert) > 0 {
+		edits = append(edits, svgEdit{start: pos, end: pos, replacement: insert})
+	}
+	return edits, nil
+}
+
+// svgMissingNamespaces 为插入位置尚未定义（或定义为其他命名空间）的 rdf、dc、xmp、tiff 前缀生成命名空间声明
+func svgMissingNamespaces(namespaces map[string]string) string {
+	var declare strings.Builder
+	for _, ns := range []struct{ prefix, uri string }{{"rdf", nsRDF}, {"dc", nsDC}, {"xmp", nsXMP}, {"tiff", nsTIFF}} {
+		if namespaces[ns.prefix] != ns.uri {
+			declare.WriteString(" xmlns:" + ns.prefix + `="` + ns.uri + `"`)
+		}
+	}
+	return declare.String()
+}
+
+// applySVGEdits 按位置从后往前应用替换，保证前面的偏移量不受影响
+func (m *ImageModifier) applySVGEdits(data []byte, edits []svgEdit) []byte {
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+
+	result := append([]byte{}, data...)
+	for _, edit := range edits {
+		tail := append([]byte{}, result[edit.end:]...)
+		result = append(result[:edit.start], edit.replacement...)
+		result = append(result, tail...)
+	}
+	return result
+}
+
+// getSVGMetadata 获取SVG图片的元数据
+// <title>/<desc> 优先，其余字段从 <metadata> 中的RDF描述读取
+func (m *ImageModifier) getSVGMetadata(imagePath string) (*ImageMetadata, error) {
+	data, err := m.readImageFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+
+	doc, err := m.parseSVGDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := doc.metadata
+	return &metadata, nil
+}
@@ -0,0 +1,195 @@
+package imagemodify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSVG 测试用的SVG文档，包含已有的title和Inkscape风格的metadata
+const testSVG = `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:cc="http://creativecommons.org/ns#" xmlns:dc="http://purl.org/dc/elements/1.1/" width="10" height="10">
+  <title>旧标题</title>
+  <metadata>
+    <rdf:RDF>
+      <cc:Work rdf:about="">
+        <dc:creator><cc:Agent><dc:title>旧作者</dc:title></cc:Agent></dc:creator>
+      </cc:Work>
+    </rdf:RDF>
+  </metadata>
+  <rect x="0" y="0" width="10" height="10" fill="#ff0000"/>
+</svg>
+`
+
+// TestModifySVGSHA1 测试SVG格式随机数据修改
+func TestModifySVGSHA1(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.svg")
+	if err := os.WriteFile(testFile, []byte(testSVG), 0644); err != nil {
+		t.Fatalf("创建测试SVG失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+
+	originalSHA1, err := modifier.GetImageSHA1(testFile)
+	if err != nil {
+		t.Fatalf("获取原始SHA1失败: %v", err)
+	}
+
+	newSHA1, err := modifier.ModifyImageSHA1(testFile)
+	if err != nil {
+		t.Fatalf("修改SVG SHA1失败: %v", err)
+	}
+	if originalSHA1 == newSHA1 {
+		t.Error("SHA1值未发生变化")
+	}
+
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("读取修改后的文件失败: %v", err)
+	}
+
+	// 除插入的注释外，文档内容应保持不变
+	start := bytes.Index(data, []byte("<!-- imagemodify:"))
+	if start < 0 {
+		t.Fatal("没有找到插入的注释")
+	}
+	end := bytes.Index(data[start:], []byte("-->\n")) + start + 4
+	restored := append(append([]byte{}, data[:start]...), data[end:]...)
+	if !bytes.Equal(restored, []byte(testSVG)) {
+		t.Error("注释之外的内容发生了变化")
+	}
+
+	if _, err := modifier.parseSVGDocument(data); err != nil {
+		t.Errorf("修改后的文件不是有效的SVG: %v", err)
+	}
+}
+
+// TestModifySVGMetadata 测试SVG元数据的写入与读取
+func TestModifySVGMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.svg")
+	if err := os.WriteFile(testFile, []byte(testSVG), 0644); err != nil {
+		t.Fatalf("创建测试SVG失败: %v", err)
+	}
+
+	modifier := NewImageModifier()
+
+	// 读取已有的元数据
+	existing, err := modifier.GetImageMetadata(testFile)
+	if err != nil {
+		t.Fatalf("读取SVG元数据失败: %v", err)
+	}
+	if existing.Title != "旧标题" || existing.Artist != "旧作者" {
+		t.Errorf("读取到的元数据不正确: %+v", existing)
+	}
+
+	dateTime := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	metadata := &ImageMetadata{
+		Title:       "新标题 <测试>",
+		Artist:      "张三",
+		Copyright:   "© 2024 张三",
+		Description: "一张测试图片",
+		DateTime:    &dateTime,
+		Location:    "北京",
+		Software:    "imagemodify",
+	}
+
+	if _, err := modifier.ModifyImageMetadata(testFile, metadata); err != nil {
+		t.Fatalf("修改SVG元数据失败: %v", err)
+	}
+
+	result, err := modifier.GetImageMetadata(testFile)
+	if err != nil {
+		t.Fatalf("读取修改后的元数据失败: %v", err)
+	}
+	if result.Title != metadata.Title || result.Artist != metadata.Artist ||
+		result.Copyright != metadata.Copyright || result.Description != metadata.Description ||
+		result.Location != metadata.Location || result.Software != metadata.Software {
+		t.Errorf("元数据不一致: %+v", result)
+	}
+	if result.DateTime == nil || !result.DateTime.Equal(dateTime) {
+		t.Errorf("拍摄时间不一致: %v", result.DateTime)
+	}
+
+	// 图形内容保持不变
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("读取修改后的文件失败: %v", err)
+	}
+	if !strings.Contains(string(data), `<rect x="0" y="0" width="10" height="10" fill="#ff0000"/>`) {
+		t.Error("图形内容被修改")
+	}
+	if strings.Count(string(data), "<metadata>") != 1 || strings.Count(string(data), "<title>") != 1 {
+		t.Error("title/metadata 元素应被原地替换")
+	}
+}
+
+// TestModifySVGMetadataKeepsOtherRDF 测试只替换Dublin Core等相关属性，其他RDF内容保持不变
+func TestModifySVGMetadataKeepsOtherRDF(t *testing.T) {
+	modifier := NewImageModifier()
+	kept := []string{
+		`<dc:format>image/svg+xml</dc:format>`,
+		`<cc:license rdf:resource="http://creativecommons.org/licenses/by/4.0/"/>`,
+		`<cc:License rdf:about="http://creativecommons.org/licenses/by/4.0/"><cc:permits rdf:resource="http://creativecommons.org/ns#Reproduction"/></cc:License>`,
+		`<foo:extra xmlns:foo="urn:example">保留</foo:extra>`,
+	}
+
+	for name, rdf := range map[string]string{
+		// 前缀定义在根元素上
+		"root namespaces": `<rdf:RDF><cc:Work rdf:about="">` + kept[0] + `<dc:title><rdf:Alt><rdf:li>旧标题</rdf:li></rdf:Alt></dc:title>` + kept[1] +
+			`</cc:Work>` + kept[2] + `</rdf:RDF>` + kept[3],
+		// dc前缀只在属性元素上定义，插入的属性需要自己声明
+		"undeclared dc": `<r:RDF xmlns:r="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><r:Description r:about=""><d:creator xmlns:d="http://purl.org/dc/elements/1.1/">旧作者</d:creator></r:Description></r:RDF>`,
+	} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:cc="http://creativecommons.org/ns#"`
+		if name == "root namespaces" {
+			svg += ` xmlns:dc="http://purl.org/dc/elements/1.1/"`
+		}
+		svg += `><metadata>` + rdf + `</metadata></svg>`
+
+		modified, err := modifier.modifySVGMetadata([]byte(svg), &ImageMetadata{Title: "新标题", Artist: "作者"})
+		if err != nil {
+			t.Fatalf("%s: 修改SVG元数据失败: %v", name, err)
+		}
+		doc, err := modifier.parseSVGDocument(modified)
+		if err != nil {
+			t.Fatalf("%s: 修改后的文件不是有效的SVG: %v", name, err)
+		}
+		if doc.metadata.Title != "新标题" || doc.metadata.Artist != "作者" {
+			t.Errorf("%s: 元数据不一致: %+v", name, doc.metadata)
+		}
+		if strings.Contains(string(modified), "旧标题") || strings.Contains(string(modified), "旧作者") {
+			t.Errorf("%s: 旧的属性没有被替换", name)
+		}
+		if name == "root namespaces" {
+			for _, fragment := range kept {
+				if !strings.Contains(string(modified), fragment) {
+					t.Errorf("%s: 其他RDF内容被修改: %s", name, fragment)
+				}
+			}
+		}
+	}
+}
+
+// TestModifySVGMetadataSelfClosing 测试为自闭合的根元素添加元数据
+func TestModifySVGMetadataSelfClosing(t *testing.T) {
+	modifier := NewImageModifier()
+	data := []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)
+
+	modified, err := modifier.modifySVGMetadata(data, &ImageMetadata{Description: "描述"})
+	if err != nil {
+		t.Fatalf("修改SVG元数据失败: %v", err)
+	}
+
+	doc, err := modifier.parseSVGDocument(modified)
+	if err != nil {
+		t.Fatalf("修改后的文件不是有效的SVG: %v", err)
+	}
+	if doc.metadata.Description != "描述" {
+		t.Errorf("描述不一致: %q", doc.metadata.Description)
+	}
+}
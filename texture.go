@@ -0,0 +1,67 @@
+package imagemodify
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// PixelTextureMetric 衡量局部纹理强度的方式，用于 PixelDistributionTexture 选择像素
+// 以及 PixelModifyResult.TextureScore。两种方式都按8位精度的亮度计算
+type PixelTextureMetric int
+
+const (
+	// TextureGradient 默认：3x3 Sobel算子得到的亮度梯度幅值，对边缘和细节敏感
+	TextureGradient PixelTextureMetric = iota
+
+	// TextureVariance 邻域内亮度的方差（PNG、QOI为5x5窗口，JPEG为所在的8x8块），对整体的杂乱程度敏感
+	TextureVariance
+)
+
+// textureVarianceRadius 计算方差的窗口半径
+const textureVarianceRadius = 2
+
+// sobelMagnitude 用 at 读取亮度，计算 (x, y) 处的Sobel梯度幅值
+func sobelMagnitude(at func(x, y int) float64, x, y int) float64 {
+	gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+	gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+	return math.Hypot(gx, gy)
+}
+
+// imageLuma 返回读取图像亮度（0~255）的函数，坐标相对于图像左上角，超出范围时取最近的像素
+func imageLuma(img image.Image) func(x, y int) float64 {
+	bounds := img.Bounds()
+	return func(x, y int) float64 {
+		x = clampInt(x+bounds.Min.X, bounds.Min.X, bounds.Max.X-1)
+		y = clampInt(y+bounds.Min.Y, bounds.Min.Y, bounds.Max.Y-1)
+		return float64(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y) / 257
+	}
+}
+
+// imageTexture 按 TextureMetric 返回图像在某个像素处的纹理强度
+func (m *ImageModifier) imageTexture(img image.Image) func(x, y int) float64 {
+	luma := imageLuma(img)
+	if m.TextureMetric == TextureVariance {
+		bounds := img.Bounds()
+		return func(x, y int) float64 {
+			var sum, sumSq, n float64
+			for dy := -textureVarianceRadius; dy <= textureVarianceRadius; dy++ {
+				for dx := -textureVarianceRadius; dx <= textureVarianceRadius; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= bounds.Dx() || ny >= bounds.Dy() {
+						continue
+					}
+					v := luma(nx, ny)
+					sum += v
+					sumSq += v * v
+					n++
+				}
+			}
+			mean := sum / n
+			return math.Max(sumSq/n-mean*mean, 0)
+		}
+	}
+	return func(x, y int) float64 {
+		return sobelMagnitude(luma, x, y)
+	}
+}
@@ -0,0 +1,124 @@
+package imagemodify
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageTexture(t *testing.T) {
+	// 左边平坦，中间为棋盘格，右边为竖直的阶跃边缘
+	img := image.NewGray(image.Rect(0, 0, 30, 10))
+	for y := 0; y < 10; y++ {
+		for x := 10; x < 30; x++ {
+			v := uint8(0)
+			if (x < 20 && (x+y)%2 == 0) || x >= 25 {
+				v = 255
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+
+	gradient := (&ImageModifier{}).imageTexture(img)
+	variance := (&ImageModifier{TextureMetric: TextureVariance}).imageTexture(img)
+
+	if gradient(4, 5) != 0 || variance(4, 5) != 0 {
+		t.Errorf("平坦区域的纹理强度应为0: 梯度 %.1f，方差 %.1f", gradient(4, 5), variance(4, 5))
+	}
+	// 棋盘格的Sobel梯度为0，但方差很大（5x5窗口中13个与12个像素取值不同）
+	if got := gradient(15, 5); got != 0 {
+		t.Errorf("棋盘格的梯度应为0，实际为 %.1f", got)
+	}
+	if got, want := variance(15, 5), 13.0*12.0/625*255*255; math.Abs(got-want) > 1e-6 {
+		t.Errorf("棋盘格的方差为 %.1f，期望 %.1f", got, want)
+	}
+	// 阶跃边缘处的梯度为 4*255
+	if got := gradient(25, 5); math.Abs(got-4*255) > 1e-6 {
+		t.Errorf("阶跃边缘的梯度为 %.1f，期望 %d", got, 4*255)
+	}
+}
+
+func TestJPEGBlockVariance(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 32, 32))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(80 + (i*37)%97)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("编码JPEG失败: %v", err)
+	}
+	modifier := NewImageModifier()
+	img, err := modifier.decodeJPEG(buf.Bytes())
+	if err != nil {
+		t.Fatalf("解码JPEG失败: %v", err)
+	}
+
+	// 正交DCT：由系数得到的方差与逆DCT后采样的方差一致（只差采样值的取整）
+	c := img.components[0]
+	for i := range c.blocks {
+		samples := img.blockSamples(c, &c.blocks[i])
+		var sum, sumSq float64
+		for _, s := range samples {
+			sum += s
+			sumSq += s * s
+		}
+		want := sumSq/64 - (sum/64)*(sum/64)
+		if got := img.blockVariance(c, &c.blocks[i]); math.Abs(got-want) > 0.05*want+1 {
+			t.Errorf("块 %d: 方差为 %.1f，期望 %.1f", i, got, want)
+		}
+	}
+}
+
+func TestModifyImageSHA1ByPixelTextureScore(t *testing.T) {
+	// 上半部分平坦，下半部分为噪声
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			v := uint8(128)
+			if y >= 20 {
+				v = uint8((x*73 + y*151) % 256)
+			}
+			img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码PNG失败: %v", err)
+	}
+	dir := t.TempDir()
+	pngFile := filepath.Join(dir, "test.png")
+	jpegFile := filepath.Join(dir, "test.jpg")
+	if err := createTestJPEG(jpegFile); err != nil {
+		t.Fatalf("创建测试图片失败: %v", err)
+	}
+
+	for _, metric := range []PixelTextureMetric{TextureGradient, TextureVariance} {
+		modifier := &ImageModifier{PixelDistribution: PixelDistributionTexture, TextureMetric: metric}
+		for _, path := range []string{pngFile, jpegFile} {
+			if path == pngFile {
+				os.WriteFile(pngFile, buf.Bytes(), 0644)
+			}
+			result, err := modifier.ModifyImageSHA1ByPixelResult(path)
+			if err != nil {
+				t.Fatalf("%s: 像素微调失败: %v", filepath.Base(path), err)
+			}
+			if result.TextureScore <= 0 {
+				t.Errorf("%s: 纹理分布应选中纹理丰富的像素，纹理强度为 %.1f", filepath.Base(path), result.TextureScore)
+			}
+		}
+
+		// 只修改了噪声区域中的像素
+		modified, _ := os.ReadFile(pngFile)
+		for _, p := range changedPixels(t, buf.Bytes(), modified) {
+			if p.Y < 19 {
+				t.Errorf("修改了平坦区域中的像素 %v", p)
+			}
+		}
+	}
+}
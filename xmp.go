@@ -0,0 +1,245 @@
+package imagemodify
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMP/RDF 使用的命名空间
+const (
+	nsRDF  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC   = "http://purl.org/dc/elements/1.1/"
+	nsXMP  = "http://ns.adobe.com/xap/1.0/"
+	nsTIFF = "http://ns.adobe.com/tiff/1.0/"
+)
+
+// buildRDFMetadata 将元数据构造成 RDF/Dublin Core 描述块（rdf:RDF 元素）
+// 该块既可以嵌入SVG的<metadata>元素，也可以作为XMP数据包的主体
+func (m *ImageModifier) buildRDFMetadata(metadata *ImageMetadata) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(`<rdf:RDF xmlns:rdf="` + nsRDF + `"`)
+	buf.WriteString(` xmlns:dc="` + nsDC + `"`)
+	buf.WriteString(` xmlns:xmp="` + nsXMP + `"`)
+	buf.WriteString(` xmlns:tiff="` + nsTIFF + `">`)
+	buf.WriteString(`<rdf:Description rdf:about="">`)
+	m.writeRDFProperties(&buf, metadata, "")
+	buf.WriteString("</rdf:Description></rdf:RDF>")
+	return buf.Bytes()
+}
+
+// writeRDFProperties 写入元数据对应的RDF属性元素（使用 rdf、dc、xmp、tiff 前缀）
+// declare 写在每个属性元素的开始标签中，用于声明插入位置尚未定义的命名空间前缀
+func (m *ImageModifier) writeRDFProperties(buf *bytes.Buffer, metadata *ImageMetadata, declare string) {
+	// Dublin Core 中的文本属性按XMP规范使用 rdf:Alt / rdf:Seq 包装
+	writeAlt := func(name, value string) {
+		if value == "" {
+			return
+		}
+		buf.WriteString("<" + name + declare + `><rdf:Alt><rdf:li xml:lang="x-default">`)
+		xml.EscapeText(buf, []byte(value))
+		buf.WriteString("</rdf:li></rdf:Alt></" + name + ">")
+	}
+	writeSimple := func(name, value string) {
+		if value == "" {
+			return
+		}
+		buf.WriteString("<" + name + declare + ">")
+		xml.EscapeText(buf, []byte(value))
+		buf.WriteString("</" + name + ">")
+	}
+
+	writeAlt("dc:title", metadata.Title)
+	if metadata.Artist != "" {
+		buf.WriteString("<dc:creator" + declare + "><rdf:Seq><rdf:li>")
+		xml.EscapeText(buf, []byte(metadata.Artist))
+		buf.WriteString("</rdf:li></rdf:Seq></dc:creator>")
+	}
+	writeAlt("dc:rights", metadata.Copyright)
+	writeAlt("dc:description", metadata.Description)
+	writeSimple("dc:coverage", metadata.Location)
+	if metadata.DateTime != nil {
+		writeSimple("xmp:CreateDate", metadata.DateTime.Format(time.RFC3339))
+	}
+	writeSimple("xmp:CreatorTool", metadata.Software)
+	writeSimple("tiff:Make", metadata.CameraMake)
+	writeSimple("tiff:Model", metadata.CameraModel)
+	if metadata.ImageWidth > 0 {
+		writeSimple("tiff:ImageWidth", strconv.Itoa(metadata.ImageWidth))
+	}
+	if metadata.ImageHeight > 0 {
+		writeSimple("tiff:ImageLength", strconv.Itoa(metadata.ImageHeight))
+	}
+}
+
+// buildXMPPacket 构造完整的XMP数据包（带 xpacket 包装）
+func (m *ImageModifier) buildXMPPacket(metadata *ImageMetadata) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<?xpacket begin=\"\xEF\xBB\xBF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>")
+	buf.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">`)
+	buf.Write(m.buildRDFMetadata(metadata))
+	buf.WriteString(`</x:xmpmeta>`)
+	buf.WriteString(`<?xpacket end="w"?>`)
+	return buf.Bytes()
+}
+
+// parseRDFMetadata 从包含 rdf:RDF 的XML片段中解析元数据
+// 只填充 metadata 中仍为空的字段，便于调用方按优先级合并多个来源
+func (m *ImageModifier) parseRDFMetadata(data []byte, metadata *ImageMetadata) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+
+	collector := &rdfCollector{modifier: m, metadata: metadata}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		collector.handle(token)
+	}
+}
+
+// rdfCollector 逐个接收XML记号并提取RDF属性
+// 由调用方提供已解析命名空间的记号，因此也可以用于嵌在其他文档（如SVG）中的RDF
+type rdfCollector struct {
+	modifier *ImageModifier
+	metadata *ImageMetadata
+
+	// stack 记录当前元素路径，propertyDepth 为正在收集文本的属性所在深度
+	stack         []xml.Name
+	text          string
+	propertyDepth int
+	started       bool
+}
+
+// handle 处理单个XML记号
+func (c *rdfCollector) handle(token xml.Token) {
+	if !c.started {
+		c.propertyDepth = -1
+		c.started = true
+	}
+
+	switch t := token.(type) {
+	case xml.StartElement:
+		if c.propertyDepth < 0 && len(c.stack) > 0 {
+			// 属性元素的父节点是 rdf:Description 或 rdf:RDF 下的类型节点（如 cc:Work）
+			parent := c.stack[len(c.stack)-1]
+			typedNode := len(c.stack) >= 2 && c.stack[len(c.stack)-2].Space == nsRDF && c.stack[len(c.stack)-2].Local == "RDF"
+			if (parent.Space == nsRDF && parent.Local == "Description") || typedNode {
+				c.propertyDepth = len(c.stack)
+				c.text = ""
+			}
+		}
+		if t.Name.Space == nsRDF && t.Name.Local == "Description" {
+			// 属性也可以以简写形式写在 rdf:Description 上
+			for _, attr := range t.Attr {
+				c.modifier.setRDFProperty(attr.Name, attr.Value, c.metadata)
+			}
+		}
+		c.stack = append(c.stack, t.Name)
+	case xml.CharData:
+		// 只取属性内第一段非空文本（rdf:Seq/rdf:Alt 中的第一个 rdf:li）
+		if c.propertyDepth >= 0 && c.text == "" {
+			c.text = strings.TrimSpace(string(t))
+		}
+	case xml.EndElement:
+		if len(c.stack) == 0 {
+			return
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+		if len(c.stack) == c.propertyDepth {
+			c.modifier.setRDFProperty(t.Name, c.text, c.metadata)
+			c.propertyDepth = -1
+		}
+	}
+}
+
+// setRDFProperty 将单个RDF属性值写入元数据结构（已有值的字段不覆盖）
+func (m *ImageModifier) setRDFProperty(name xml.Name, value string, metadata *ImageMetadata) {
+	if value == "" {
+		return
+	}
+
+	setString := func(field *string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	setInt := func(field *int) {
+		if n, err := strconv.Atoi(value); err == nil && *field == 0 {
+			*field = n
+		}
+	}
+
+	switch name.Space {
+	case nsDC:
+		switch name.Local {
+		case "title":
+			setString(&metadata.Title)
+		case "creator":
+			setString(&metadata.Artist)
+		case "rights":
+			setString(&metadata.Copyright)
+		case "description":
+			setString(&metadata.Description)
+		case "coverage":
+			setString(&metadata.Location)
+		case "date":
+			m.setRDFDate(value, metadata)
+		}
+	case nsXMP:
+		switch name.Local {
+		case "CreateDate":
+			m.setRDFDate(value, metadata)
+		case "CreatorTool":
+			setString(&metadata.Software)
+		}
+	case nsTIFF:
+		switch name.Local {
+		case "Make":
+			setString(&metadata.CameraMake)
+		case "Model":
+			setString(&metadata.CameraModel)
+		case "ImageWidth":
+			setInt(&metadata.ImageWidth)
+		case "ImageLength":
+			setInt(&metadata.ImageHeight)
+		}
+	}
+}
+
+// rdfManagedProperty 是否为元数据模式写入的RDF属性（setRDFProperty 读取的属性）
+// 修改已有RDF描述时只替换这些属性，其余属性保持不变
+func rdfManagedProperty(name xml.Name) bool {
+	switch name.Space {
+	case nsDC:
+		switch name.Local {
+		case "title", "creator", "rights", "description", "coverage", "date":
+			return true
+		}
+	case nsXMP:
+		return name.Local == "CreateDate" || name.Local == "CreatorTool"
+	case nsTIFF:
+		switch name.Local {
+		case "Make", "Model", "ImageWidth", "ImageLength":
+			return true
+		}
+	}
+	return false
+}
+
+// setRDFDate 解析XMP日期（支持完整时间戳和仅日期两种形式）
+func (m *ImageModifier) setRDFDate(value string, metadata *ImageMetadata) {
+	if metadata.DateTime != nil {
+		return
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			metadata.DateTime = &t
+			return
+		}
+	}
+}